@@ -1,62 +1,238 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"go-gateway/pkg/admin"
+	"go-gateway/pkg/cache"
 	"go-gateway/pkg/common"
 	"go-gateway/pkg/route"
 
 	"go-gateway/pkg/config"
+	"go-gateway/pkg/egress"
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/grpc"
+	"go-gateway/pkg/ipfilter"
+	"go-gateway/pkg/l4"
+	"go-gateway/pkg/listener"
 	"go-gateway/pkg/loadbalancer"
 	"go-gateway/pkg/middleware"
 	"go-gateway/pkg/monitoring"
+	"go-gateway/pkg/proxy"
+	"go-gateway/pkg/streaming"
+	"go-gateway/pkg/websocket"
+
+	"github.com/quic-go/quic-go/http3"
+
+	// Register route filters
+	_ "go-gateway/pkg/auth"
+	_ "go-gateway/pkg/compression"
+	_ "go-gateway/pkg/cors"
+	_ "go-gateway/pkg/ratelimit"
 )
 
 // Gateway represents gateway instance
 type Gateway struct {
 	configManager *config.ViperConfigManager
+	// current is the routing snapshot new requests start on
+	current      atomic.Pointer[snapshot]
+	loadBalancer loadbalancer.LoadBalancer
+	middlewares  []middleware.Middleware
+	reloadMutex  sync.Mutex
+	// configMutex serializes changes of the config, from loading or editing
+	// it to the reload or the rollback
+	configMutex  sync.Mutex
+	serversMutex sync.Mutex
+	servers      []*http.Server
+	tcpProxies   []*l4.TCPProxy
+	udpProxies   []*l4.UDPProxy
+	http3Servers []*http3.Server
+	egress       *egress.Proxy
+}
+
+// snapshot is the routing state built from one config generation. It is
+// never changed once published: a reload builds a new one and swaps it in, and
+// each request finishes on the snapshot it started with.
+type snapshot struct {
+	generation    int64
 	router        *route.Router
-	loadBalancer  loadbalancer.LoadBalancer
-	middlewares   []middleware.Middleware
-	mutex         sync.RWMutex
+	routeFilters  map[string][]middleware.Middleware
+	globalFilters []middleware.Middleware
+	clientIP      *ipfilter.Resolver
+	timeouts      streaming.Timeouts
+	pools         map[string]*loadbalancer.Pool
 }
 
 // NewGateway creates new gateway instance
 func NewGateway() *Gateway {
-	return &Gateway{
+	g := &Gateway{
 		configManager: config.NewViperConfigManager(),
-		router:        route.NewRouter(),
 		loadBalancer:  loadbalancer.NewRoundRobinBalancer(),
 		middlewares:   make([]middleware.Middleware, 0),
 	}
+	// Nothing is routed until the first config is loaded
+	resolver, _ := ipfilter.NewResolver(nil)
+	g.current.Store(&snapshot{
+		router:       route.NewRouter(),
+		routeFilters: make(map[string][]middleware.Middleware),
+		clientIP:     resolver,
+		pools:        make(map[string]*loadbalancer.Pool),
+	})
+	return g
 }
 
-// LoadConfig loads config from config file
+// LoadConfig loads config from config file. An invalid config is rejected
+// and the current one keeps serving.
 func (g *Gateway) LoadConfig(configPath string) error {
+	// The admin API must not change the config between the load and the
+	// rollback
+	g.configMutex.Lock()
+	defer g.configMutex.Unlock()
+
+	previous := g.configManager.GetConfig()
 	err := g.configManager.Load(configPath)
 	if err != nil {
+		monitoring.ConfigReloadsTotal.WithLabelValues("failure").Inc()
 		return err
 	}
 
 	// Reload routes
-	g.reloadRoutes()
+	if err := g.reloadRoutes(); err != nil {
+		g.configManager.SetConfig(previous)
+		return err
+	}
 
 	return nil
 }
 
-// reloadRoutes reloads routes
-func (g *Gateway) reloadRoutes() {
-	// Clear existing routes
-	g.router = route.NewRouter()
+// reloadRoutes applies the config of the manager. The next snapshot is built
+// aside while the current one keeps serving, and published with a single
+// swap; if any part of it fails the whole config is rejected.
+func (g *Gateway) reloadRoutes() error {
+	// The file watcher and the admin API may reload at the same time
+	g.reloadMutex.Lock()
+	defer g.reloadMutex.Unlock()
+
+	cfg := g.configManager.GetConfig()
+	previous := g.current.Load()
+	next, err := buildSnapshot(cfg, previous.generation+1, previous.pools)
+	if err != nil {
+		monitoring.ConfigReloadsTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	g.current.Store(next)
+
+	// Requests still on the previous snapshot can use its pools, which only
+	// stop their health checks
+	for _, pool := range previous.pools {
+		pool.Close()
+	}
+
+	g.serversMutex.Lock()
+	egressProxy := g.egress
+	g.serversMutex.Unlock()
+	if egressProxy != nil {
+		var allow []common.Route
+		if cfg.Egress != nil {
+			allow = cfg.Egress.Allow
+		}
+		egressProxy.SetAllow(allow)
+	}
+
+	monitoring.ConfigReloadsTotal.WithLabelValues("success").Inc()
+	monitoring.ConfigGeneration.Set(float64(next.generation))
+	log.Printf("Serving config generation %d with %d routes", next.generation, len(cfg.Routes))
+	return nil
+}
+
+// buildSnapshot validates cfg and builds its routing state. The pools take
+// over the server health of previousPools.
+func buildSnapshot(cfg config.Config, generation int64, previousPools map[string]*loadbalancer.Pool) (*snapshot, error) {
+	if err := config.Validate(cfg); err != nil {
+		return nil, err
+	}
+	router, routeFilters, globalFilters, err := buildRoutes(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, err := ipfilter.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	var timeouts streaming.Timeouts
+	if cfg.Timeouts != nil {
+		timeouts = streaming.Timeouts{Request: cfg.Timeouts.Request, Idle: cfg.Timeouts.Idle}
+	}
+
+	// Pools start their health checks, so they are built last
+	pools, err := buildPools(cfg.Services, previousPools)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot{
+		generation:    generation,
+		router:        router,
+		routeFilters:  routeFilters,
+		globalFilters: globalFilters,
+		clientIP:      resolver,
+		timeouts:      timeouts,
+		pools:         pools,
+	}, nil
+}
+
+// buildRoutes builds the router and the filter chains of cfg. Every filter
+// that fails to build is reported with its path.
+func buildRoutes(cfg config.Config) (*route.Router, map[string][]middleware.Middleware, []middleware.Middleware, error) {
+	var errs []config.FieldError
+
+	// Names that are not registered filters are skipped with a warning, since
+	// global filters used to be free-form entries
+	globalFilters := make([]middleware.Middleware, 0, len(cfg.GlobalFilters))
+	for i, gf := range cfg.GlobalFilters {
+		if _, ok := filter.Lookup(gf.Name); !ok {
+			log.Printf("Ignoring unknown global filter %s", gf.Name)
+			continue
+		}
+		m, err := filter.Build(common.Filter{Name: gf.Name, Args: gf.Args})
+		if err != nil {
+			errs = append(errs, config.FieldError{Path: fmt.Sprintf("global_filters[%d]", i), Message: err.Error()})
+			continue
+		}
+		globalFilters = append(globalFilters, m)
+	}
+
+	router := route.NewRouter()
+	routeFilters := make(map[string][]middleware.Middleware, len(cfg.Routes))
+	for i, routeConfig := range cfg.Routes {
+		filters := make([]middleware.Middleware, 0, len(routeConfig.Filters))
+		for j, f := range routeConfig.Filters {
+			m, err := filter.Build(f)
+			if err != nil {
+				errs = append(errs, config.FieldError{Path: fmt.Sprintf("routes[%d].filters[%d]", i, j), Message: err.Error()})
+				continue
+			}
+			filters = append(filters, m)
+		}
+		routeFilters[routeConfig.ID] = filters
 
-	// Load routes from config
-	for _, routeConfig := range g.configManager.GetRoutes() {
 		// Need to convert config.Route to common.Route
 		internalRoute := &common.Route{
 			ID:         routeConfig.ID,
@@ -66,14 +242,70 @@ func (g *Gateway) reloadRoutes() {
 			Order:      routeConfig.Order,
 			Metadata:   routeConfig.Metadata,
 		}
-		g.router.AddRoute(internalRoute)
+		if err := router.AddRoute(internalRoute); err != nil {
+			errs = append(errs, config.FieldError{Path: fmt.Sprintf("routes[%d]", i), Message: err.Error()})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, nil, &config.ValidationError{Errors: errs}
+	}
+	return router, routeFilters, globalFilters, nil
+}
+
+// buildPools builds and starts the pools of the services, carrying over the
+// health of the servers of the previous pool of the same service
+func buildPools(services []config.ServiceConfig, previous map[string]*loadbalancer.Pool) (map[string]*loadbalancer.Pool, error) {
+	pools := make(map[string]*loadbalancer.Pool, len(services))
+	for i, service := range services {
+		balancer, err := loadbalancer.NewBalancer(service.Strategy)
+		if err != nil {
+			for _, pool := range pools {
+				pool.Close()
+			}
+			return nil, &config.ValidationError{Errors: []config.FieldError{
+				{Path: fmt.Sprintf("services[%d].strategy", i), Message: err.Error()},
+			}}
+		}
+		servers := make([]loadbalancer.Server, 0, len(service.Servers))
+		for _, server := range service.Servers {
+			servers = append(servers, loadbalancer.Server{URL: server.URL, Weight: server.Weight})
+		}
+		var check *loadbalancer.HealthCheck
+		if hc := service.HealthCheck; hc != nil {
+			check = &loadbalancer.HealthCheck{
+				Interval:           hc.Interval,
+				Timeout:            hc.Timeout,
+				Path:               hc.Path,
+				HealthyThreshold:   hc.HealthyThreshold,
+				UnhealthyThreshold: hc.UnhealthyThreshold,
+			}
+		}
+		pool := loadbalancer.NewPool(service.Name, balancer, servers, check)
+		pool.InheritHealth(previous[service.Name])
+		pool.Start()
+		pools[service.Name] = pool
 	}
+	return pools, nil
+}
+
+// pool returns the pool of a service in the current snapshot, or nil when it
+// is not configured
+func (g *Gateway) pool(service string) *loadbalancer.Pool {
+	return g.current.Load().pools[service]
 }
 
 // ServeHTTP implements HTTP handler interface
 func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// gRPC clients get gateway errors as gRPC status
+	w, finish := grpc.WrapErrors(w, r)
+	defer finish()
+
+	// The request runs on this snapshot even if a reload swaps in another
+	snap := g.current.Load()
+
 	// Match route
-	matchedRoute := g.router.Match(r.URL.Path)
+	matchedRoute := snap.router.MatchRequest(r)
 	if matchedRoute == nil {
 		// Increment error counter for unmatched routes
 		monitoring.ErrorTotal.WithLabelValues("route_not_found", "unknown").Inc()
@@ -81,25 +313,54 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Global middlewares and filters run before the route's own filters
+	routeFilters := snap.routeFilters[matchedRoute.ID]
+	handlers := make([]middleware.Middleware, 0, len(g.middlewares)+len(snap.globalFilters)+len(routeFilters))
+	handlers = append(handlers, g.middlewares...)
+	handlers = append(handlers, snap.globalFilters...)
+	handlers = append(handlers, routeFilters...)
+
 	// Create gateway context
 	gatewayCtx := &middleware.GatewayContext{
 		Request:     r,
 		Response:    w,
 		Route:       matchedRoute, // Now this is compatible with common.Route
-		Attributes:  make(map[string]interface{}),
+		Attributes:  map[string]interface{}{middleware.ClientIPAttribute: snap.clientIP.ClientIP(r)},
 		StartTime:   0, // Should set current time in actual use
 		OriginalURL: r.URL.String(),
-		Handlers:    g.middlewares,
+		Handlers:    handlers,
 		Index:       0,
 	}
 
-	// Execute middleware chain
-	chain := middleware.NewMiddlewareChain(g.middlewares)
-	chain.Execute(gatewayCtx)
+	// Total and idle timeouts apply to the whole chain
+	release := streaming.Guard(gatewayCtx, snap.timeouts)
+	defer release()
+
+	// Execute middleware chain around the proxy call
+	chain := middleware.NewMiddlewareChain(handlers)
+	chain.Handle(gatewayCtx, func(ctx *middleware.GatewayContext) {
+		g.forward(ctx, snap)
+	})
+}
+
+// forward proxies the request of the context to the route's backend
+func (g *Gateway) forward(ctx *middleware.GatewayContext, snap *snapshot) {
+	matchedRoute := ctx.Route
 
 	// Determine target URL based on route URI
 	targetURL := matchedRoute.URI
-	if strings.HasPrefix(targetURL, "lb://") {
+	if pool := snap.pools[strings.TrimPrefix(targetURL, "lb://")]; strings.HasPrefix(targetURL, "lb://") && pool != nil {
+		// Configured services choose among their healthy servers;
+		// consistent_hash keeps each client on one server
+		chosenServer := pool.ChooseFor(ctx.ClientIP())
+		if chosenServer == nil {
+			monitoring.ErrorTotal.WithLabelValues("no_healthy_upstream", matchedRoute.ID).Inc()
+			http.Error(ctx.Response, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		targetURL = chosenServer.URL
+		monitoring.BackendRequestTotal.WithLabelValues(chosenServer.URL, matchedRoute.ID).Inc()
+	} else if strings.HasPrefix(targetURL, "lb://") {
 		// If it's load balancer identifier, select a backend server
 		_ = strings.TrimPrefix(targetURL, "lb://") // Service name, temporarily unused
 		// Simplified processing here, should get server list by service name in reality
@@ -119,22 +380,238 @@ func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		// Increment error counter for invalid target URL
 		monitoring.ErrorTotal.WithLabelValues("invalid_target_url", matchedRoute.ID).Inc()
-		http.Error(w, "Invalid target URL", http.StatusInternalServerError)
+		http.Error(ctx.Response, "Invalid target URL", http.StatusInternalServerError)
 		return
 	}
 
-	// Create reverse proxy
-	proxy := httputil.NewSingleHostReverseProxy(target)
+	// grpc:// and grpcs:// upstreams are reached over HTTP/2
+	target, transport := grpc.Upstream(target)
+
+	// Create reverse proxy applying the route's forwarding header policy
+	trustedPeer := snap.clientIP.IsTrusted(ipfilter.RemoteIP(ctx.Request))
+	reverseProxy := proxy.NewReverseProxy(target, proxy.PolicyFromContext(ctx), trustedPeer)
+	reverseProxy.Transport = transport
+	reverseProxy.ModifyResponse = func(resp *http.Response) error {
+		// Streams are flushed as they arrive
+		if streaming.IsStream(ctx, resp.Header) {
+			reverseProxy.FlushInterval = -1
+		}
+		return nil
+	}
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if errors.Is(context.Cause(r.Context()), streaming.ErrRequestTimeout) {
+			http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+			return
+		}
+		log.Printf("http: proxy error: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	// WebSocket upgrades are tracked for metrics, limits and graceful shutdown
+	if websocket.IsUpgrade(ctx.Request) {
+		websocket.Track(ctx)
+	}
 
 	// Forward request
-	proxy.ServeHTTP(w, r)
+	reverseProxy.ServeHTTP(ctx.Response, ctx.Request)
+
+	if grpc.IsGrpc(ctx.Request) {
+		grpc.Observe(ctx)
+	}
 }
 
 // Run starts gateway service
 func (g *Gateway) Run(port int) error {
-	addr := fmt.Sprintf(":%d", port)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: g,
+	}
+	if g.configManager.GetConfig().H2C {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+	}
+	ln, err := g.listen(server.Addr)
+	if err != nil {
+		return err
+	}
+	g.addServer(server)
+	return server.Serve(ln)
+}
+
+// RunTLS starts the gateway TLS listener
+func (g *Gateway) RunTLS(tlsCfg config.TLSConfig) error {
+	tlsConfig, err := listener.NewServerTLSConfig(tlsCfg)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", tlsCfg.Port),
+		Handler:   g,
+		TLSConfig: tlsConfig,
+	}
+	ln, err := g.listen(server.Addr)
+	if err != nil {
+		return err
+	}
+	if tlsCfg.HTTP3 {
+		// HTTP/3 requests take the same path through the gateway
+		h3 := listener.NewHTTP3Server(tlsCfg.Port, tlsConfig, g)
+		conn, err := net.ListenPacket("udp", h3.Addr)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		g.serversMutex.Lock()
+		g.http3Servers = append(g.http3Servers, h3)
+		g.serversMutex.Unlock()
+		go func() {
+			if err := h3.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("HTTP/3 gateway stopped: %v", err)
+			}
+		}()
+		server.Handler = listener.AdvertiseHTTP3(h3, g)
+	}
+	g.addServer(server)
+	// Certificates are already part of TLSConfig
+	return server.ServeTLS(ln, "", "")
+}
 
-	return http.ListenAndServe(addr, g)
+// listen listens on addr, reading the PROXY protocol header of trusted load
+// balancers when configured so that requests carry the real client address
+func (g *Gateway) listen(addr string) (net.Listener, error) {
+	var pp *listener.ProxyProtocol
+	if cfg := g.configManager.GetConfig().ProxyProtocol; cfg != nil {
+		var err error
+		if pp, err = listener.NewProxyProtocol(*cfg); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if pp != nil {
+		ln = pp.Listen(ln)
+	}
+	return ln, nil
+}
+
+func (g *Gateway) addServer(server *http.Server) {
+	g.serversMutex.Lock()
+	defer g.serversMutex.Unlock()
+	g.servers = append(g.servers, server)
+}
+
+// RunEgress starts the egress forward proxy
+func (g *Gateway) RunEgress(cfg config.EgressConfig) error {
+	proxy := egress.NewProxy(cfg)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: proxy,
+	}
+	g.serversMutex.Lock()
+	g.egress = proxy
+	g.serversMutex.Unlock()
+	g.addServer(server)
+	return server.ListenAndServe()
+}
+
+// RunAdmin starts the admin API. Changes are saved to configPath when the
+// admin config asks for it.
+func (g *Gateway) RunAdmin(cfg config.AdminConfig, configPath string) error {
+	api, err := g.newAdminAPI(cfg, configPath)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: api,
+	}
+	g.addServer(server)
+	return server.ListenAndServe()
+}
+
+// newAdminAPI creates the admin API. Its changes take the lock of LoadConfig,
+// so that a rollback of the config file never undoes them.
+func (g *Gateway) newAdminAPI(cfg config.AdminConfig, configPath string) (*admin.Server, error) {
+	api, err := admin.NewServer(cfg, g.configManager, configPath, admin.Hooks{
+		Reload: g.reloadRoutes,
+		Lock:   &g.configMutex,
+	})
+	if err != nil {
+		return nil, err
+	}
+	api.Handle("/admin/cache/purge", cache.PurgeHandler())
+	return api, nil
+}
+
+// RunTCP starts a TCP proxy listener
+func (g *Gateway) RunTCP(cfg config.TCPListenerConfig) error {
+	proxy, err := l4.NewTCPProxy(cfg, g.pool)
+	if err != nil {
+		return err
+	}
+	g.serversMutex.Lock()
+	g.tcpProxies = append(g.tcpProxies, proxy)
+	g.serversMutex.Unlock()
+	return proxy.ListenAndServe(cfg.Port)
+}
+
+// RunUDP starts a UDP proxy listener
+func (g *Gateway) RunUDP(cfg config.UDPListenerConfig) error {
+	proxy, err := l4.NewUDPProxy(cfg, g.pool)
+	if err != nil {
+		return err
+	}
+	g.serversMutex.Lock()
+	g.udpProxies = append(g.udpProxies, proxy)
+	g.serversMutex.Unlock()
+	return proxy.ListenAndServe(cfg.Port)
+}
+
+// Shutdown stops the listeners, waits for in-flight requests and then closes
+// open WebSocket connections with a close frame
+func (g *Gateway) Shutdown(ctx context.Context) error {
+	g.serversMutex.Lock()
+	servers := g.servers
+	tcpProxies := g.tcpProxies
+	udpProxies := g.udpProxies
+	http3Servers := g.http3Servers
+	egressProxy := g.egress
+	g.serversMutex.Unlock()
+
+	var firstErr error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, server := range http3Servers {
+		if err := server.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, proxy := range tcpProxies {
+		if err := proxy.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, proxy := range udpProxies {
+		if err := proxy.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if egressProxy != nil {
+		if err := egressProxy.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := websocket.Shutdown(ctx); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
 }
 
 // convertPredicates converts predicates
@@ -162,6 +639,19 @@ func convertFilters(filters []common.Filter) []common.Filter {
 }
 
 func main() {
+	configPath := flag.String("config", "", "path to the gateway config file")
+	printSchema := flag.Bool("schema", false, "print the JSON Schema of the config file and exit")
+	flag.Parse()
+
+	if *printSchema {
+		schema, err := json.MarshalIndent(config.Schema(), "", "  ")
+		if err != nil {
+			log.Fatal("Failed to generate schema: ", err)
+		}
+		fmt.Println(string(schema))
+		return
+	}
+
 	gateway := NewGateway()
 
 	// Initialize default config
@@ -183,19 +673,33 @@ func main() {
 		Port: 8080,
 	}
 	gateway.configManager.SetConfig(defaultConfig)
-	gateway.reloadRoutes()
+	if err := gateway.reloadRoutes(); err != nil {
+		log.Fatal("Invalid default config: ", err)
+	}
+
+	if *configPath != "" {
+		if err := gateway.LoadConfig(*configPath); err != nil {
+			log.Fatal("Failed to load config: ", err)
+		}
+	}
+	cfg := gateway.configManager.GetConfig()
 
 	// Add metrics middleware
 	metricsMiddleware := monitoring.NewMetricsMiddleware()
 	gateway.middlewares = append(gateway.middlewares, metricsMiddleware)
 
 	// Enable config watching for hot updates
-	go func() {
-		gateway.configManager.WatchConfig(func() {
-			log.Println("Configuration reloaded due to changes")
-			gateway.reloadRoutes()
-		})
-	}()
+	if *configPath != "" {
+		go func() {
+			gateway.configManager.WatchConfig(func() {
+				if err := gateway.LoadConfig(*configPath); err != nil {
+					log.Printf("Keeping the current configuration: %v", err)
+					return
+				}
+				log.Println("Configuration reloaded due to changes")
+			})
+		}()
+	}
 
 	// Start monitoring service on port 9090
 	monitoringService := monitoring.NewMonitoringService(9090)
@@ -205,9 +709,69 @@ func main() {
 		}
 	}()
 
-	log.Println("Starting gateway on :8080")
-	log.Println("Monitoring endpoint available at :9090/metrics")
-	if err := gateway.Run(8080); err != nil {
-		log.Fatal("Gateway failed to start: ", err)
+	if cfg.TLS != nil {
+		go func() {
+			log.Printf("Starting TLS gateway on :%d", cfg.TLS.Port)
+			if err := gateway.RunTLS(*cfg.TLS); err != nil && err != http.ErrServerClosed {
+				log.Fatal("TLS gateway failed to start: ", err)
+			}
+		}()
+	}
+
+	// The egress port is fixed at startup; its allow-list follows reloads
+	if cfg.Egress != nil {
+		go func() {
+			log.Printf("Starting egress proxy on :%d", cfg.Egress.Port)
+			if err := gateway.RunEgress(*cfg.Egress); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Egress proxy failed to start: ", err)
+			}
+		}()
+	}
+
+	if cfg.Admin != nil {
+		go func() {
+			log.Printf("Starting admin API on :%d", cfg.Admin.Port)
+			if err := gateway.RunAdmin(*cfg.Admin, *configPath); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Admin API failed to start: ", err)
+			}
+		}()
+	}
+
+	// TCP and UDP listeners are fixed at startup; their services follow reloads
+	for _, tcpCfg := range cfg.TCPListeners {
+		go func(tcpCfg config.TCPListenerConfig) {
+			log.Printf("Starting TCP listener %s on :%d", tcpCfg.Name, tcpCfg.Port)
+			if err := gateway.RunTCP(tcpCfg); err != nil && !errors.Is(err, l4.ErrClosed) {
+				log.Fatal("TCP listener failed to start: ", err)
+			}
+		}(tcpCfg)
+	}
+	for _, udpCfg := range cfg.UDPListeners {
+		go func(udpCfg config.UDPListenerConfig) {
+			log.Printf("Starting UDP listener %s on :%d", udpCfg.Name, udpCfg.Port)
+			if err := gateway.RunUDP(udpCfg); err != nil && !errors.Is(err, l4.ErrClosed) {
+				log.Fatal("UDP listener failed to start: ", err)
+			}
+		}(udpCfg)
+	}
+
+	go func() {
+		log.Printf("Starting gateway on :%d", cfg.Port)
+		log.Println("Monitoring endpoint available at :9090/metrics")
+		if err := gateway.Run(cfg.Port); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Gateway failed to start: ", err)
+		}
+	}()
+
+	// Shut down gracefully on SIGINT/SIGTERM
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	log.Println("Shutting down gateway")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := gateway.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown error: %v", err)
 	}
 }
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+)
+
+// tagFilter sets the X-Tag request header to its tag arg. The hold arg
+// names a hold registered by the test, which keeps requests in the filter.
+type tagFilter struct {
+	tag  string
+	hold *hold
+}
+
+// hold reports requests on entered and keeps them until release is closed
+type hold struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+// tagArgs are the args of the Tag filter
+type tagArgs struct {
+	Tag  string `mapstructure:"tag"`
+	Hold string `mapstructure:"hold"`
+}
+
+var holds sync.Map
+
+func init() {
+	filter.Register("Tag", func(args interface{}) (middleware.Middleware, error) {
+		var cfg tagArgs
+		if err := filter.DecodeArgs(args, &cfg); err != nil {
+			return nil, err
+		}
+		f := &tagFilter{tag: cfg.Tag}
+		if h, ok := holds.Load(cfg.Hold); ok {
+			f.hold = h.(*hold)
+		}
+		return f, nil
+	}, tagArgs{})
+}
+
+func (f *tagFilter) Name() string { return "Tag" }
+
+func (f *tagFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	if f.hold != nil {
+		f.hold.entered <- struct{}{}
+		<-f.hold.release
+	}
+	ctx.Request.Header.Set("X-Tag", f.tag)
+	return true
+}
+
+func (f *tagFilter) PostHandle(ctx *middleware.GatewayContext) error { return nil }
+
+func (f *tagFilter) HandleError(ctx *middleware.GatewayContext, err error) {}
+
+// tagBackend answers with its name and the X-Tag header it received
+func tagBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.Header.Get("X-Tag"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// tagConfig routes everything to uri through a Tag filter
+func tagConfig(uri string, args map[string]interface{}, services ...config.ServiceConfig) config.Config {
+	return config.Config{
+		Port: 8080,
+		Routes: []common.Route{{
+			ID:         "all",
+			URI:        uri,
+			Predicates: []common.Predicate{{Name: "Path", Args: map[string]string{"pattern": "/**"}}},
+			Filters:    []common.Filter{{Name: "Tag", Args: args}},
+		}},
+		Services: services,
+	}
+}
+
+func get(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d %s", resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+// TestReloadUnderTraffic 测试持续重载配置时请求始终使用同一份路由快照
+func TestReloadUnderTraffic(t *testing.T) {
+	backendA, backendB := tagBackend(t, "a"), tagBackend(t, "b")
+	configs := []config.Config{
+		tagConfig(backendA.URL, map[string]interface{}{"tag": "a"}),
+		tagConfig("lb://b", map[string]interface{}{"tag": "b"}, config.ServiceConfig{
+			Name: "b", Servers: []config.ServerConfig{{URL: backendB.URL}},
+		}),
+	}
+
+	g := NewGateway()
+	g.configManager.SetConfig(configs[0])
+	if err := g.reloadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	gateway := httptest.NewServer(g)
+	defer gateway.Close()
+
+	stop := make(chan struct{})
+	var reloads atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			g.configManager.SetConfig(configs[i%2])
+			if err := g.reloadRoutes(); err != nil {
+				t.Error(err)
+				return
+			}
+			reloads.Add(1)
+		}
+	}()
+
+	var seen sync.Map
+	var clients sync.WaitGroup
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for c := 0; c < 8; c++ {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			for time.Now().Before(deadline) {
+				body := get(t, gateway.URL+"/")
+				// Filters and upstream always come from the same snapshot
+				if body != "a a" && body != "b b" {
+					t.Errorf("Expected a response of one config, got %q", body)
+					return
+				}
+				seen.Store(body, true)
+			}
+		}()
+	}
+	clients.Wait()
+	close(stop)
+	wg.Wait()
+
+	if reloads.Load() < 2 {
+		t.Errorf("Expected constant reloads, got %d", reloads.Load())
+	}
+	for _, body := range []string{"a a", "b b"} {
+		if _, ok := seen.Load(body); !ok {
+			t.Errorf("Expected responses from both configs, missing %q", body)
+		}
+	}
+}
+
+// TestInFlightRequestKeepsSnapshot 测试进行中的请求在重载后仍使用开始时的快照
+func TestInFlightRequestKeepsSnapshot(t *testing.T) {
+	backendA, backendB := tagBackend(t, "a"), tagBackend(t, "b")
+	h := &hold{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	holds.Store(t.Name(), h)
+	defer holds.Delete(t.Name())
+
+	g := NewGateway()
+	g.configManager.SetConfig(tagConfig(backendA.URL, map[string]interface{}{"tag": "a", "hold": t.Name()}))
+	if err := g.reloadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	gateway := httptest.NewServer(g)
+	defer gateway.Close()
+
+	result := make(chan string)
+	go func() { result <- get(t, gateway.URL+"/") }()
+
+	// Reload while the request is held by the filter of the first snapshot
+	<-h.entered
+	g.configManager.SetConfig(tagConfig(backendB.URL, map[string]interface{}{"tag": "b"}))
+	if err := g.reloadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	if body := get(t, gateway.URL+"/"); body != "b b" {
+		t.Errorf("Expected new requests on the new snapshot, got %q", body)
+	}
+
+	close(h.release)
+	if body := <-result; body != "a a" {
+		t.Errorf("Expected the in-flight request to finish on its snapshot, got %q", body)
+	}
+}
+
+// TestRejectedReload 测试无效配置被拒绝时继续使用当前快照
+func TestRejectedReload(t *testing.T) {
+	backendA := tagBackend(t, "a")
+	g := NewGateway()
+	g.configManager.SetConfig(tagConfig(backendA.URL, map[string]interface{}{"tag": "a"}))
+	if err := g.reloadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	current := g.current.Load()
+
+	g.configManager.SetConfig(tagConfig(backendA.URL, map[string]interface{}{"unknown": "b"}))
+	err := g.reloadRoutes()
+	if err == nil {
+		t.Fatal("Expected invalid filter args to be rejected")
+	}
+	if expected := "routes[0].filters[0]"; !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error at %s, got %v", expected, err)
+	}
+	if g.current.Load() != current {
+		t.Error("Expected the current snapshot to keep serving")
+	}
+}
+
+// TestLoadedConfigMatches 测试从配置文件加载的路由能够匹配请求
+func TestLoadedConfigMatches(t *testing.T) {
+	backend := tagBackend(t, "a")
+	configPath := filepath.Join(t.TempDir(), "gateway.json")
+	loaded := fmt.Sprintf(`{"port": 8080, "routes": [{
+		"id": "api", "uri": %q,
+		"predicates": [{"name": "Path", "args": {"pattern": "/api/**"}}, {"name": "Host", "args": {"pattern": "127.0.0.1"}}],
+		"filters": [{"name": "Tag", "args": {"tag": "a"}}]
+	}]}`, backend.URL)
+	if err := os.WriteFile(configPath, []byte(loaded), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := NewGateway()
+	if err := g.LoadConfig(configPath); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	gateway := httptest.NewServer(g)
+	defer gateway.Close()
+
+	if body := get(t, gateway.URL+"/api/users"); body != "a a" {
+		t.Errorf("Expected the loaded route to match, got %q", body)
+	}
+}
+
+// TestAdminChangeWaitsForLoad 测试管理接口的修改等待正在进行的配置文件加载
+func TestAdminChangeWaitsForLoad(t *testing.T) {
+	backendA := tagBackend(t, "a")
+	g := NewGateway()
+	g.configManager.SetConfig(tagConfig(backendA.URL, map[string]interface{}{"tag": "a"}))
+	if err := g.reloadRoutes(); err != nil {
+		t.Fatal(err)
+	}
+	api, err := g.newAdminAPI(config.AdminConfig{Tokens: []string{"secret"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stands in for a LoadConfig between the load and the rollback
+	g.configMutex.Lock()
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest("PUT", "/admin/global_filters", strings.NewReader(`[{"name":"Tag","args":{"tag":"b"}}]`))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("If-Match", "*")
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		done <- rec.Code
+	}()
+	select {
+	case code := <-done:
+		g.configMutex.Unlock()
+		t.Fatalf("Expected the change to wait for the load, got %d", code)
+	case <-time.After(50 * time.Millisecond):
+	}
+	g.configMutex.Unlock()
+
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("Expected the change to be applied after the load, got %d", code)
+	}
+	if filters := g.configManager.GetConfig().GlobalFilters; len(filters) != 1 {
+		t.Errorf("Expected the global filter to be added, got %v", filters)
+	}
+}
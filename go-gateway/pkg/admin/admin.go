@@ -0,0 +1,472 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-viper/mapstructure/v2"
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/monitoring"
+)
+
+// maxBodySize limits the size of request bodies
+const maxBodySize = 1 << 20
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("already exists")
+)
+
+// Hooks connect the admin API to the gateway
+type Hooks struct {
+	// Reload applies the config of the manager to the live traffic. It fails
+	// when the config cannot be built, leaving the previous one serving.
+	Reload func() error
+	// Lock serializes the changes with the other writers of the manager's
+	// config, such as reloads of the config file. The server has a lock of
+	// its own when it is nil.
+	Lock sync.Locker
+}
+
+// Server is the admin REST API managing routes, services and global filters.
+//
+//	GET    /admin/routes           GET    /admin/services
+//	POST   /admin/routes           POST   /admin/services
+//	GET    /admin/routes/{id}      GET    /admin/services/{name}
+//	PUT    /admin/routes/{id}      PUT    /admin/services/{name}
+//	DELETE /admin/routes/{id}      DELETE /admin/services/{name}
+//	GET    /admin/global_filters   PUT    /admin/global_filters
+//
+// Other admin endpoints of the gateway are mounted with Handle and share its
+// authentication.
+//
+// Every response carries the ETag of the config. Changes must send it back in
+// If-Match, or "*" to skip the check, and are refused with 412 when the config
+// was changed in between. A change is validated on a copy of the config and
+// rolled back when the gateway cannot apply it, so that traffic never runs on
+// an invalid config.
+type Server struct {
+	manager    config.ConfigManager
+	hooks      Hooks
+	tokens     [][sha256.Size]byte
+	configPath string
+	mux        *http.ServeMux
+
+	// lock serializes changes, from the ETag check to the reload
+	lock sync.Locker
+}
+
+// NewServer creates the admin API. With cfg.Save every change is also
+// written to configPath.
+func NewServer(cfg config.AdminConfig, manager config.ConfigManager, configPath string, hooks Hooks) (*Server, error) {
+	if len(cfg.Tokens) == 0 {
+		return nil, errors.New("admin API requires at least one token")
+	}
+	if cfg.Save && configPath == "" {
+		return nil, errors.New("saving admin changes requires a config file")
+	}
+	s := &Server{
+		manager: manager,
+		hooks:   hooks,
+		mux:     http.NewServeMux(),
+		lock:    hooks.Lock,
+	}
+	if s.lock == nil {
+		s.lock = &sync.Mutex{}
+	}
+	for _, token := range cfg.Tokens {
+		if token == "" {
+			return nil, errors.New("admin tokens must not be empty")
+		}
+		// Hashing first makes the comparison independent of token lengths
+		s.tokens = append(s.tokens, sha256.Sum256([]byte(token)))
+	}
+	if cfg.Save {
+		s.configPath = configPath
+	}
+
+	s.mux.HandleFunc("GET /admin/routes", s.listRoutes)
+	s.mux.HandleFunc("POST /admin/routes", s.createRoute)
+	s.mux.HandleFunc("GET /admin/routes/{id}", s.getRoute)
+	s.mux.HandleFunc("PUT /admin/routes/{id}", s.updateRoute)
+	s.mux.HandleFunc("DELETE /admin/routes/{id}", s.deleteRoute)
+	s.mux.HandleFunc("GET /admin/services", s.listServices)
+	s.mux.HandleFunc("POST /admin/services", s.createService)
+	s.mux.HandleFunc("GET /admin/services/{name}", s.getService)
+	s.mux.HandleFunc("PUT /admin/services/{name}", s.updateService)
+	s.mux.HandleFunc("DELETE /admin/services/{name}", s.deleteService)
+	s.mux.HandleFunc("GET /admin/global_filters", s.getGlobalFilters)
+	s.mux.HandleFunc("PUT /admin/global_filters", s.putGlobalFilters)
+	return s, nil
+}
+
+// Handle registers an additional admin endpoint behind the bearer token
+// check. It must be called before the server is started.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// ServeHTTP authenticates the operator and serves the API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		log.Printf("Admin %s %s from %s unauthorized", r.Method, r.URL.Path, r.RemoteAddr)
+		monitoring.ErrorTotal.WithLabelValues("admin_unauthorized", "unknown").Inc()
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized checks the bearer token of the request
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare(sum[:], s.tokens[i][:]) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// ETag returns the version of the part of cfg managed by the admin API
+func ETag(cfg config.Config) string {
+	data, _ := json.Marshal(struct {
+		Routes        []common.Route
+		Services      []config.ServiceConfig
+		GlobalFilters []config.GlobalFilter
+	}{cfg.Routes, cfg.Services, cfg.GlobalFilters})
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func (s *Server) listRoutes(w http.ResponseWriter, r *http.Request) {
+	cfg := s.manager.GetConfig()
+	writeJSON(w, http.StatusOK, ETag(cfg), cfg.Routes)
+}
+
+func (s *Server) getRoute(w http.ResponseWriter, r *http.Request) {
+	cfg := s.manager.GetConfig()
+	i := findRoute(cfg.Routes, r.PathValue("id"))
+	if i < 0 {
+		http.Error(w, "route not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, ETag(cfg), cfg.Routes[i])
+}
+
+func (s *Server) createRoute(w http.ResponseWriter, r *http.Request) {
+	var route common.Route
+	if !decodeBody(w, r, &route) {
+		return
+	}
+	s.change(w, r, "route", "create", func(cfg *config.Config) error {
+		if findRoute(cfg.Routes, route.ID) >= 0 {
+			return fmt.Errorf("route %s %w", route.ID, errConflict)
+		}
+		cfg.Routes = append(cfg.Routes, route)
+		return nil
+	}, func(config.Config) error {
+		s.manager.AddRoute(route)
+		return nil
+	}, http.StatusCreated, route)
+}
+
+func (s *Server) updateRoute(w http.ResponseWriter, r *http.Request) {
+	var route common.Route
+	if !decodeBody(w, r, &route) {
+		return
+	}
+	id := r.PathValue("id")
+	if route.ID == "" {
+		route.ID = id
+	}
+	s.change(w, r, "route", "update", func(cfg *config.Config) error {
+		if route.ID != id {
+			return fmt.Errorf("route id %s does not match the path", route.ID)
+		}
+		i := findRoute(cfg.Routes, id)
+		if i < 0 {
+			return fmt.Errorf("route %s %w", id, errNotFound)
+		}
+		cfg.Routes[i] = route
+		return nil
+	}, func(config.Config) error {
+		return s.manager.UpdateRoute(route)
+	}, http.StatusOK, route)
+}
+
+func (s *Server) deleteRoute(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.change(w, r, "route", "delete", func(cfg *config.Config) error {
+		i := findRoute(cfg.Routes, id)
+		if i < 0 {
+			return fmt.Errorf("route %s %w", id, errNotFound)
+		}
+		cfg.Routes = append(cfg.Routes[:i], cfg.Routes[i+1:]...)
+		return nil
+	}, func(config.Config) error {
+		return s.manager.DeleteRoute(id)
+	}, http.StatusNoContent, nil)
+}
+
+func (s *Server) listServices(w http.ResponseWriter, r *http.Request) {
+	cfg := s.manager.GetConfig()
+	services := cfg.Services
+	if services == nil {
+		services = []config.ServiceConfig{}
+	}
+	writeJSON(w, http.StatusOK, ETag(cfg), services)
+}
+
+func (s *Server) getService(w http.ResponseWriter, r *http.Request) {
+	cfg := s.manager.GetConfig()
+	i := findService(cfg.Services, r.PathValue("name"))
+	if i < 0 {
+		http.Error(w, "service not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, ETag(cfg), cfg.Services[i])
+}
+
+func (s *Server) createService(w http.ResponseWriter, r *http.Request) {
+	var service config.ServiceConfig
+	if !decodeBody(w, r, &service) {
+		return
+	}
+	s.change(w, r, "service", "create", func(cfg *config.Config) error {
+		if findService(cfg.Services, service.Name) >= 0 {
+			return fmt.Errorf("service %s %w", service.Name, errConflict)
+		}
+		cfg.Services = append(cfg.Services, service)
+		return nil
+	}, s.setConfig, http.StatusCreated, service)
+}
+
+func (s *Server) updateService(w http.ResponseWriter, r *http.Request) {
+	var service config.ServiceConfig
+	if !decodeBody(w, r, &service) {
+		return
+	}
+	name := r.PathValue("name")
+	if service.Name == "" {
+		service.Name = name
+	}
+	s.change(w, r, "service", "update", func(cfg *config.Config) error {
+		if service.Name != name {
+			return fmt.Errorf("service name %s does not match the path", service.Name)
+		}
+		i := findService(cfg.Services, name)
+		if i < 0 {
+			return fmt.Errorf("service %s %w", name, errNotFound)
+		}
+		cfg.Services[i] = service
+		return nil
+	}, s.setConfig, http.StatusOK, service)
+}
+
+func (s *Server) deleteService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	s.change(w, r, "service", "delete", func(cfg *config.Config) error {
+		i := findService(cfg.Services, name)
+		if i < 0 {
+			return fmt.Errorf("service %s %w", name, errNotFound)
+		}
+		cfg.Services = append(cfg.Services[:i], cfg.Services[i+1:]...)
+		return nil
+	}, s.setConfig, http.StatusNoContent, nil)
+}
+
+func (s *Server) getGlobalFilters(w http.ResponseWriter, r *http.Request) {
+	cfg := s.manager.GetConfig()
+	writeJSON(w, http.StatusOK, ETag(cfg), cfg.GlobalFilters)
+}
+
+// putGlobalFilters replaces the global filters as a whole, since they have
+// no identity besides their order
+func (s *Server) putGlobalFilters(w http.ResponseWriter, r *http.Request) {
+	var filters []config.GlobalFilter
+	if !decodeBody(w, r, &filters) {
+		return
+	}
+	if filters == nil {
+		filters = []config.GlobalFilter{}
+	}
+	s.change(w, r, "global_filters", "update", func(cfg *config.Config) error {
+		cfg.GlobalFilters = filters
+		return nil
+	}, s.setConfig, http.StatusOK, filters)
+}
+
+func (s *Server) setConfig(cfg config.Config) error {
+	s.manager.SetConfig(cfg)
+	return nil
+}
+
+// change applies one change of the config. edit changes a copy of the config,
+// which is validated before commit changes the manager. The gateway is then
+// reloaded and the config saved. When either fails the change is rolled
+// back, so that the gateway never serves a config that is lost on restart.
+func (s *Server) change(w http.ResponseWriter, r *http.Request, resource, action string,
+	edit func(*config.Config) error, commit func(config.Config) error, status int, body interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := "ok"
+	defer func() {
+		monitoring.AdminChangesTotal.WithLabelValues(resource, action, result).Inc()
+	}()
+
+	previous := s.manager.GetConfig()
+	if code, err := checkPrecondition(r, ETag(previous)); err != nil {
+		result = "precondition_failed"
+		w.Header().Set("ETag", ETag(previous))
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	candidate := s.manager.GetConfig()
+	if err := edit(&candidate); err != nil {
+		switch {
+		case errors.Is(err, errNotFound):
+			result = "not_found"
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, errConflict):
+			result = "conflict"
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			result = "invalid"
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		}
+		return
+	}
+	if err := config.Validate(candidate); err != nil {
+		result = "invalid"
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := commit(candidate); err != nil {
+		result = "error"
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.reload(); err != nil {
+		// E.g. filter args only their filter can check
+		s.rollback(previous, resource, action, err)
+		result = "invalid"
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if s.configPath != "" {
+		if err := s.manager.Save(s.configPath); err != nil {
+			s.rollback(previous, resource, action, err)
+			result = "error"
+			http.Error(w, "Failed to save config, change rolled back", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	log.Printf("Admin %s %s from %s applied", action, resource, r.RemoteAddr)
+	writeJSON(w, status, ETag(s.manager.GetConfig()), body)
+}
+
+func (s *Server) reload() error {
+	if s.hooks.Reload == nil {
+		return nil
+	}
+	return s.hooks.Reload()
+}
+
+// rollback restores the config from before a failed change
+func (s *Server) rollback(previous config.Config, resource, action string, cause error) {
+	log.Printf("Rolling back admin %s of %s: %v", action, resource, cause)
+	s.manager.SetConfig(previous)
+	if err := s.reload(); err != nil {
+		log.Printf("Failed to reload config after rollback: %v", err)
+	}
+}
+
+// checkPrecondition checks the If-Match header of a change against the
+// current ETag
+func checkPrecondition(r *http.Request, etag string) (int, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return http.StatusPreconditionRequired, errors.New("If-Match header with the ETag of the config is required")
+	}
+	if ifMatch == "*" {
+		return 0, nil
+	}
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return 0, nil
+		}
+	}
+	return http.StatusPreconditionFailed, errors.New("config was changed since it was read")
+}
+
+// decodeBody decodes the JSON body of a request the way config files are
+// decoded, e.g. durations may be given as "10s"
+func decodeBody(w http.ResponseWriter, r *http.Request, out interface{}) bool {
+	var raw interface{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&raw); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON body: %v", err), http.StatusBadRequest)
+		return false
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		Result:           out,
+	})
+	if err == nil {
+		err = decoder.Decode(raw)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, etag string, body interface{}) {
+	w.Header().Set("ETag", etag)
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func findRoute(routes []common.Route, id string) int {
+	for i := range routes {
+		if routes[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func findService(services []config.ServiceConfig, name string) int {
+	for i := range services {
+		if services[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
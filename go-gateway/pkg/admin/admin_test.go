@@ -0,0 +1,395 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/config"
+)
+
+const token = "s3cret"
+
+// fixture is an admin API on a config manager counting reloads. check
+// stands in for the gateway building the reloaded config.
+type fixture struct {
+	server  *Server
+	manager *config.ViperConfigManager
+	reloads int
+}
+
+func newFixture(t *testing.T, cfg config.AdminConfig, configPath string, check func(config.Config) error) *fixture {
+	t.Helper()
+	f := &fixture{manager: config.NewViperConfigManager()}
+	f.manager.SetConfig(config.Config{
+		Routes: []common.Route{{ID: "users", URI: "http://users:8080"}},
+		Port:   8080,
+	})
+	if cfg.Tokens == nil {
+		cfg.Tokens = []string{token}
+	}
+	server, err := NewServer(cfg, f.manager, configPath, Hooks{
+		Reload: func() error {
+			f.reloads++
+			if check != nil {
+				return check(f.manager.GetConfig())
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.server = server
+	return f
+}
+
+// do sends an authenticated request; ifMatch is omitted when empty
+func (f *fixture) do(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	f.server.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestNewServer 测试管理接口配置校验
+func TestNewServer(t *testing.T) {
+	manager := config.NewViperConfigManager()
+	for _, tt := range []struct {
+		name       string
+		cfg        config.AdminConfig
+		configPath string
+	}{
+		{"NoTokens", config.AdminConfig{Port: 9091}, ""},
+		{"EmptyToken", config.AdminConfig{Port: 9091, Tokens: []string{""}}, ""},
+		{"SaveWithoutFile", config.AdminConfig{Port: 9091, Tokens: []string{token}, Save: true}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewServer(tt.cfg, manager, tt.configPath, Hooks{}); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+// TestAuthentication 测试管理接口令牌认证
+func TestAuthentication(t *testing.T) {
+	f := newFixture(t, config.AdminConfig{Tokens: []string{"first", token}}, "", nil)
+	// Endpoints mounted with Handle share the token check
+	f.server.Handle("/admin/cache/purge", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, path := range []string{"/admin/routes", "/admin/cache/purge"} {
+		for _, tt := range []struct {
+			authorization string
+			expected      int
+		}{
+			{"", http.StatusUnauthorized},
+			{"Bearer wrong", http.StatusUnauthorized},
+			{"Basic " + token, http.StatusUnauthorized},
+			{"Bearer first", http.StatusOK},
+			{"Bearer " + token, http.StatusOK},
+		} {
+			req := httptest.NewRequest("GET", path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			f.server.ServeHTTP(rec, req)
+			if rec.Code != tt.expected {
+				t.Errorf("%s with authorization %q: expected %d, got %d", path, tt.authorization, tt.expected, rec.Code)
+			}
+		}
+	}
+}
+
+// TestRoutes 测试路由的增删改查和ETag并发控制
+func TestRoutes(t *testing.T) {
+	f := newFixture(t, config.AdminConfig{}, "", nil)
+	rec := f.do("GET", "/admin/routes", "", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || !strings.Contains(rec.Body.String(), `"id":"users"`) {
+		t.Fatalf("Expected routes with an ETag, got %d %q %s", rec.Code, etag, rec.Body)
+	}
+	orders := `{"id":"orders","uri":"http://orders:8080","predicates":[{"name":"Path","args":{"pattern":"/orders/**"}}]}`
+
+	t.Run("IfMatchRequired", func(t *testing.T) {
+		if rec := f.do("POST", "/admin/routes", "", orders); rec.Code != http.StatusPreconditionRequired {
+			t.Errorf("Expected 428, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		rec := f.do("POST", "/admin/routes", etag, orders)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d %s", rec.Code, rec.Body)
+		}
+		if rec.Header().Get("ETag") == etag {
+			t.Error("Expected the ETag to change")
+		}
+		routes := f.manager.GetRoutes()
+		if len(routes) != 2 || routes[1].ID != "orders" || f.reloads != 1 {
+			t.Fatalf("Expected route to be added and reloaded, got %+v after %d reloads", routes, f.reloads)
+		}
+		args, _ := routes[1].Predicates[0].Args.(map[string]interface{})
+		if args["pattern"] != "/orders/**" {
+			t.Errorf("Expected predicate args to be kept, got %#v", routes[1].Predicates[0].Args)
+		}
+	})
+
+	t.Run("StaleETag", func(t *testing.T) {
+		// A second operator still holding the first ETag cannot overwrite
+		rec := f.do("PUT", "/admin/routes/users", etag, `{"uri":"http://other:8080"}`)
+		if rec.Code != http.StatusPreconditionFailed {
+			t.Fatalf("Expected 412, got %d", rec.Code)
+		}
+		if rec.Header().Get("ETag") == etag || f.manager.GetRoutes()[0].URI != "http://users:8080" {
+			t.Error("Expected the route to be unchanged and the current ETag to be returned")
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		rec := f.do("GET", "/admin/routes/orders", "", "")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"uri":"http://orders:8080"`) {
+			t.Errorf("Expected route, got %d %s", rec.Code, rec.Body)
+		}
+		etag = rec.Header().Get("ETag")
+		if rec := f.do("GET", "/admin/routes/missing", "", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		rec := f.do("PUT", "/admin/routes/orders", etag, `{"uri":"http://orders-v2:8080","order":5}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body)
+		}
+		etag = rec.Header().Get("ETag")
+		if route := f.manager.GetRoutes()[1]; route.URI != "http://orders-v2:8080" || route.Order != 5 {
+			t.Errorf("Expected route to be updated, got %+v", route)
+		}
+		if rec := f.do("PUT", "/admin/routes/orders", etag, `{"id":"other","uri":"http://orders:8080"}`); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for mismatching id, got %d", rec.Code)
+		}
+		if rec := f.do("PUT", "/admin/routes/missing", etag, `{"uri":"http://orders:8080"}`); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, tt := range []struct {
+			body     string
+			expected int
+		}{
+			{`{"id":"users","uri":"http://users:8080"}`, http.StatusConflict},
+			{`{"id":"nouri"}`, http.StatusUnprocessableEntity},
+			{`{"id":"typo","uri":"http://x","filterz":[]}`, http.StatusBadRequest},
+			{`not json`, http.StatusBadRequest},
+		} {
+			if rec := f.do("POST", "/admin/routes", "*", tt.body); rec.Code != tt.expected {
+				t.Errorf("Body %s: expected %d, got %d", tt.body, tt.expected, rec.Code)
+			}
+		}
+		if len(f.manager.GetRoutes()) != 2 {
+			t.Errorf("Expected invalid changes to be refused, got %+v", f.manager.GetRoutes())
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		rec := f.do("DELETE", "/admin/routes/orders", etag, "")
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d", rec.Code)
+		}
+		if routes := f.manager.GetRoutes(); len(routes) != 1 || routes[0].ID != "users" {
+			t.Errorf("Expected route to be deleted, got %+v", routes)
+		}
+		if rec := f.do("DELETE", "/admin/routes/orders", "*", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		if rec := f.do("PATCH", "/admin/routes/users", "*", "{}"); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d", rec.Code)
+		}
+	})
+}
+
+// TestServices 测试服务和全局过滤器的管理
+func TestServices(t *testing.T) {
+	f := newFixture(t, config.AdminConfig{}, "", nil)
+
+	t.Run("Create", func(t *testing.T) {
+		body := `{"name":"users","strategy":"consistent_hash","servers":[{"url":"http://10.0.0.1:8080","weight":2}],
+			"health_check":{"interval":"10s","path":"/health"}}`
+		rec := f.do("POST", "/admin/services", "*", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d %s", rec.Code, rec.Body)
+		}
+		services := f.manager.GetConfig().Services
+		if len(services) != 1 || services[0].Servers[0].Weight != 2 || services[0].HealthCheck.Interval != 10*time.Second {
+			t.Errorf("Expected service to be decoded like the config file, got %+v", services)
+		}
+		if rec := f.do("POST", "/admin/services", "*", `{"name":"empty"}`); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for a service without servers, got %d", rec.Code)
+		}
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		rec := f.do("PUT", "/admin/services/users", "*", `{"servers":[{"url":"http://10.0.0.2:8080"}]}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body)
+		}
+		if services := f.manager.GetConfig().Services; services[0].Servers[0].URL != "http://10.0.0.2:8080" {
+			t.Errorf("Expected service to be replaced, got %+v", services)
+		}
+		if rec := f.do("GET", "/admin/services/users", "", ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", rec.Code)
+		}
+		if rec := f.do("DELETE", "/admin/services/users", "*", ""); rec.Code != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", rec.Code)
+		}
+		if rec := f.do("GET", "/admin/services", "", ""); rec.Body.String() != "[]\n" {
+			t.Errorf("Expected no services, got %s", rec.Body)
+		}
+	})
+
+	t.Run("GlobalFilters", func(t *testing.T) {
+		rec := f.do("PUT", "/admin/global_filters", "*", `[{"name":"IPFilter","args":{"deny":"10.0.0.0/8"}}]`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body)
+		}
+		if filters := f.manager.GetConfig().GlobalFilters; len(filters) != 1 || filters[0].Name != "IPFilter" {
+			t.Errorf("Expected global filters to be replaced, got %+v", filters)
+		}
+		if rec := f.do("GET", "/admin/global_filters", "", ""); !strings.Contains(rec.Body.String(), `"deny":"10.0.0.0/8"`) {
+			t.Errorf("Expected global filters, got %s", rec.Body)
+		}
+		if rec := f.do("PUT", "/admin/global_filters", "*", `[{"args":{}}]`); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected 422 for a filter without name, got %d", rec.Code)
+		}
+	})
+}
+
+// TestValidateAndSave 测试变更的校验、保存和保存失败时的回滚
+func TestValidateAndSave(t *testing.T) {
+	route := `{"id":"orders","uri":"http://orders:8080"}`
+
+	t.Run("Invalid", func(t *testing.T) {
+		f := newFixture(t, config.AdminConfig{}, "", nil)
+		body := `{"id":"bad","uri":"orders:8080","predicates":[{"name":"Path","args":{"pattern":"orders"}},{"name":"Nope"}]}`
+		rec := f.do("POST", "/admin/routes", "*", body)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("Expected 422, got %d", rec.Code)
+		}
+		for _, expected := range []string{"routes[1].uri", "routes[1].predicates[0]", `routes[1].predicates[1]: unknown predicate "Nope"`} {
+			if !strings.Contains(rec.Body.String(), expected) {
+				t.Errorf("Expected error at %s, got %s", expected, rec.Body)
+			}
+		}
+		if len(f.manager.GetRoutes()) != 1 || f.reloads != 0 {
+			t.Error("Expected nothing to be applied")
+		}
+	})
+
+	t.Run("ReloadFailure", func(t *testing.T) {
+		f := newFixture(t, config.AdminConfig{}, "", func(cfg config.Config) error {
+			if len(cfg.Routes) > 1 {
+				return errors.New("routes[1].filters[0]: unknown filter")
+			}
+			return nil
+		})
+		rec := f.do("POST", "/admin/routes", "*", route)
+		if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "unknown filter") {
+			t.Errorf("Expected 422 with the reload error, got %d %s", rec.Code, rec.Body)
+		}
+		if len(f.manager.GetRoutes()) != 1 || f.reloads != 2 {
+			t.Errorf("Expected change to be rolled back, got %+v after %d reloads", f.manager.GetRoutes(), f.reloads)
+		}
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "gateway.yaml")
+		f := newFixture(t, config.AdminConfig{Save: true}, configPath, nil)
+		if rec := f.do("POST", "/admin/routes", "*", route); rec.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d %s", rec.Code, rec.Body)
+		}
+		loaded := config.NewViperConfigManager()
+		if err := loaded.Load(configPath); err != nil {
+			t.Fatal(err)
+		}
+		if routes := loaded.GetRoutes(); len(routes) != 2 || routes[1].ID != "orders" {
+			t.Errorf("Expected change to be saved, got %+v", routes)
+		}
+	})
+
+	t.Run("SaveThenEdit", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "gateway.yaml")
+		write := func(content string) {
+			t.Helper()
+			if err := os.WriteFile(configPath, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		write(`port: 8080
+routes:
+  - id: users
+    uri: http://users:8080
+services:
+  - name: orders
+    servers:
+      - url: http://orders:8080
+`)
+		f := newFixture(t, config.AdminConfig{Save: true}, configPath, nil)
+		if err := f.manager.Load(configPath); err != nil {
+			t.Fatal(err)
+		}
+		if rec := f.do("DELETE", "/admin/services/orders", "*", ""); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d %s", rec.Code, rec.Body)
+		}
+		if err := f.manager.Load(configPath); err != nil {
+			t.Fatal(err)
+		}
+		if services := f.manager.GetConfig().Services; len(services) != 0 {
+			t.Errorf("Expected the deleted service to stay deleted, got %+v", services)
+		}
+
+		// 保存之后手工修改的配置文件仍然生效
+		write(`port: 9080
+routes:
+  - id: accounts
+    uri: http://accounts:8080
+global_filters:
+  - name: GlobalLogFilter
+`)
+		if err := f.manager.Load(configPath); err != nil {
+			t.Fatal(err)
+		}
+		cfg := f.manager.GetConfig()
+		if cfg.Port != 9080 || len(cfg.Routes) != 1 || cfg.Routes[0].ID != "accounts" || len(cfg.GlobalFilters) != 1 {
+			t.Errorf("Expected the edited file to be loaded, got %+v", cfg)
+		}
+	})
+
+	t.Run("SaveFailure", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "missing", "gateway.yaml")
+		f := newFixture(t, config.AdminConfig{Save: true}, configPath, nil)
+		rec := f.do("POST", "/admin/routes", "*", route)
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", rec.Code)
+		}
+		if len(f.manager.GetRoutes()) != 1 || f.reloads != 2 {
+			t.Errorf("Expected change to be rolled back, got %+v after %d reloads", f.manager.GetRoutes(), f.reloads)
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("ApiKeyAuth", NewApiKeyAuthFilter, ApiKeyAuthArgs{})
+}
+
+// Default settings of the ApiKeyAuth filter
+const (
+	DefaultApiKeyHeader   = "X-API-Key"
+	DefaultConsumerHeader = "X-Consumer-Id"
+	DefaultApiKeyTable    = "api_keys"
+)
+
+// ApiKeyAuthArgs configures the ApiKeyAuth filter
+type ApiKeyAuthArgs struct {
+	// Header and QueryParam name where the key is read from; the header wins
+	Header     string `mapstructure:"header" json:"header,omitempty"`
+	QueryParam string `mapstructure:"queryParam" json:"queryParam,omitempty"`
+	// Store is "file" or "sql"
+	Store string `mapstructure:"store" json:"store,omitempty"`
+	// File is the key file of the file store
+	File string `mapstructure:"file" json:"file,omitempty"`
+	// Driver, DSN and Table configure the sql store; Driver defaults to the embedded sqlite
+	Driver string `mapstructure:"driver" json:"driver,omitempty"`
+	DSN    string `mapstructure:"dsn" json:"dsn,omitempty"`
+	Table  string `mapstructure:"table" json:"table,omitempty"`
+	// ConsumerHeader forwards the consumer identity upstream
+	ConsumerHeader string `mapstructure:"consumerHeader" json:"consumerHeader,omitempty"`
+	// ForwardKey keeps the key in the upstream request; it is stripped by default
+	ForwardKey bool `mapstructure:"forwardKey" json:"forwardKey,omitempty"`
+}
+
+// ApiKeyAuthFilter authenticates consumers by API key
+type ApiKeyAuthFilter struct {
+	store          KeyStore
+	header         string
+	queryParam     string
+	consumerHeader string
+	forwardKey     bool
+	now            func() time.Time
+}
+
+// NewApiKeyAuthFilter creates an ApiKeyAuth filter from its args
+func NewApiKeyAuthFilter(args interface{}) (middleware.Middleware, error) {
+	cfg := ApiKeyAuthArgs{
+		Header:         DefaultApiKeyHeader,
+		Store:          "file",
+		Driver:         "sqlite",
+		Table:          DefaultApiKeyTable,
+		ConsumerHeader: DefaultConsumerHeader,
+	}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+
+	var store KeyStore
+	switch cfg.Store {
+	case "file":
+		if cfg.File == "" {
+			return nil, fmt.Errorf("file is required for the file store")
+		}
+		fileStore, err := NewFileKeyStore(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	case "sql":
+		if cfg.DSN == "" {
+			return nil, fmt.Errorf("dsn is required for the sql store")
+		}
+		db, err := openKeyDatabase(cfg.Driver, cfg.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("error opening key database: %w", err)
+		}
+		sqlStore, err := NewSQLKeyStore(db, cfg.Table)
+		if err != nil {
+			return nil, err
+		}
+		store = sqlStore
+	default:
+		return nil, fmt.Errorf("unknown store %q", cfg.Store)
+	}
+
+	return NewApiKeyAuthFilterWithStore(store, cfg), nil
+}
+
+var (
+	keyDatabasesMutex sync.Mutex
+	// keyDatabases are the connection pools of the sql stores by driver and
+	// DSN; they are kept across config reloads, which rebuild every filter
+	keyDatabases = make(map[[2]string]*sql.DB)
+)
+
+// openKeyDatabase returns the shared connection pool of a key database
+func openKeyDatabase(driver, dsn string) (*sql.DB, error) {
+	keyDatabasesMutex.Lock()
+	defer keyDatabasesMutex.Unlock()
+	if db, ok := keyDatabases[[2]string{driver, dsn}]; ok {
+		return db, nil
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	keyDatabases[[2]string{driver, dsn}] = db
+	return db, nil
+}
+
+// NewApiKeyAuthFilterWithStore creates an ApiKeyAuth filter on a custom KeyStore.
+// The store settings of cfg are ignored.
+func NewApiKeyAuthFilterWithStore(store KeyStore, cfg ApiKeyAuthArgs) *ApiKeyAuthFilter {
+	return &ApiKeyAuthFilter{
+		store:          store,
+		header:         cfg.Header,
+		queryParam:     cfg.QueryParam,
+		consumerHeader: cfg.ConsumerHeader,
+		forwardKey:     cfg.ForwardKey,
+		now:            time.Now,
+	}
+}
+
+// Name returns the filter name
+func (f *ApiKeyAuthFilter) Name() string {
+	return "ApiKeyAuth"
+}
+
+// PreHandle looks up the API key of the request and identifies its consumer
+func (f *ApiKeyAuthFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	if f.consumerHeader != "" {
+		ctx.Request.Header.Del(f.consumerHeader)
+	}
+
+	key := f.extractKey(ctx.Request)
+	if key == "" {
+		f.reject(ctx, http.StatusUnauthorized, "api key required")
+		return false
+	}
+
+	stored, err := f.store.Lookup(HashAPIKey(key))
+	if err != nil {
+		if errors.Is(err, ErrKeyNotFound) {
+			f.reject(ctx, http.StatusUnauthorized, "invalid api key")
+		} else {
+			f.reject(ctx, http.StatusServiceUnavailable, "api key store unavailable")
+		}
+		return false
+	}
+	if !stored.Valid(f.now()) {
+		f.reject(ctx, http.StatusUnauthorized, "api key expired or revoked")
+		return false
+	}
+
+	if f.consumerHeader != "" {
+		ctx.Request.Header.Set(f.consumerHeader, stored.Consumer)
+	}
+	ctx.Attributes[middleware.ConsumerAttribute] = stored.Consumer
+	SetIdentity(ctx, &Identity{Subject: stored.Consumer, Method: "apikey"})
+	return true
+}
+
+// PostHandle does nothing
+func (f *ApiKeyAuthFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *ApiKeyAuthFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// extractKey reads the key from the header or query parameter and strips it
+// from the upstream request unless forwardKey is set
+func (f *ApiKeyAuthFilter) extractKey(r *http.Request) string {
+	if f.header != "" {
+		if key := r.Header.Get(f.header); key != "" {
+			if !f.forwardKey {
+				r.Header.Del(f.header)
+			}
+			return key
+		}
+	}
+
+	if f.queryParam != "" {
+		query := r.URL.Query()
+		if key := query.Get(f.queryParam); key != "" {
+			if !f.forwardKey {
+				query.Del(f.queryParam)
+				r.URL.RawQuery = query.Encode()
+			}
+			return key
+		}
+	}
+	return ""
+}
+
+func (f *ApiKeyAuthFilter) reject(ctx *middleware.GatewayContext, status int, message string) {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.ErrorTotal.WithLabelValues("api_key_rejected", routeID).Inc()
+	http.Error(ctx.Response, message, status)
+}
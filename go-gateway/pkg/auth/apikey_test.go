@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+func newApiKeyContext(target string, key string) *middleware.GatewayContext {
+	req := httptest.NewRequest("GET", target, nil)
+	if key != "" {
+		req.Header.Set(DefaultApiKeyHeader, key)
+	}
+	req.Header.Set(DefaultConsumerHeader, "spoofed")
+	return &middleware.GatewayContext{
+		Request:    req,
+		Response:   httptest.NewRecorder(),
+		Route:      &common.Route{ID: "api"},
+		Attributes: make(map[string]interface{}),
+	}
+}
+
+func writeKeyFile(t *testing.T, path string, keys []APIKey) {
+	t.Helper()
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestApiKeyAuthFileStore tests API key authentication with the file store
+func TestApiKeyAuthFileStore(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, keyFile, []APIKey{
+		{Hash: HashAPIKey("acme-secret"), Consumer: "acme"},
+		{Hash: "sha256:" + HashAPIKey("old-secret"), Consumer: "old", ExpiresAt: time.Now().Add(-time.Hour)},
+	})
+
+	f, err := NewApiKeyAuthFilter(map[string]interface{}{"file": keyFile, "queryParam": "apikey"})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	t.Run("TestValidKey", func(t *testing.T) {
+		ctx := newApiKeyContext("http://gateway/api", "acme-secret")
+		if !f.PreHandle(ctx) {
+			t.Fatal("Expected key to be accepted")
+		}
+		if consumer, _ := ctx.Consumer(); consumer != "acme" {
+			t.Errorf("Expected consumer 'acme', got %q", consumer)
+		}
+		if got := ctx.Request.Header.Get(DefaultConsumerHeader); got != "acme" {
+			t.Errorf("Expected consumer header 'acme', got %q", got)
+		}
+		if ctx.Request.Header.Get(DefaultApiKeyHeader) != "" {
+			t.Error("Expected api key to be stripped from upstream request")
+		}
+	})
+
+	t.Run("TestQueryParam", func(t *testing.T) {
+		ctx := newApiKeyContext("http://gateway/api?apikey=acme-secret&page=2", "")
+		if !f.PreHandle(ctx) {
+			t.Fatal("Expected key from query parameter to be accepted")
+		}
+		if ctx.Request.URL.RawQuery != "page=2" {
+			t.Errorf("Expected api key to be removed from query, got %q", ctx.Request.URL.RawQuery)
+		}
+	})
+
+	t.Run("TestRejectedKeys", func(t *testing.T) {
+		for _, key := range []string{"", "unknown", "old-secret"} {
+			ctx := newApiKeyContext("http://gateway/api", key)
+			if f.PreHandle(ctx) {
+				t.Errorf("Expected key %q to be rejected", key)
+			}
+			if code := ctx.Response.(*httptest.ResponseRecorder).Code; code != http.StatusUnauthorized {
+				t.Errorf("Expected status 401 for key %q, got %d", key, code)
+			}
+		}
+	})
+
+	t.Run("TestRevocationWithoutRestart", func(t *testing.T) {
+		writeKeyFile(t, keyFile, []APIKey{{Hash: HashAPIKey("acme-secret"), Consumer: "acme", Revoked: true}})
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(keyFile, future, future)
+		f.(*ApiKeyAuthFilter).store.(*FileKeyStore).checkedAt = time.Time{}
+
+		if f.PreHandle(newApiKeyContext("http://gateway/api", "acme-secret")) {
+			t.Error("Expected revoked key to be rejected")
+		}
+	})
+}
+
+// TestApiKeyAuthSQLStore tests API key authentication with the embedded SQL store
+func TestApiKeyAuthSQLStore(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "keys.db")
+	f, err := NewApiKeyAuthFilter(map[string]interface{}{"store": "sql", "dsn": dsn})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	store := f.(*ApiKeyAuthFilter).store.(*SQLKeyStore)
+
+	if err := store.AddKey(APIKey{Hash: HashAPIKey("globex-secret"), Consumer: "globex", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+
+	ctx := newApiKeyContext("http://gateway/api", "globex-secret")
+	if !f.PreHandle(ctx) {
+		t.Fatalf("Expected key to be accepted, got %d", ctx.Response.(*httptest.ResponseRecorder).Code)
+	}
+	if identity, _ := GetIdentity(ctx); identity == nil || identity.Subject != "globex" {
+		t.Errorf("Expected identity globex, got %+v", identity)
+	}
+
+	if err := store.RevokeKey(HashAPIKey("globex-secret")); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if f.PreHandle(newApiKeyContext("http://gateway/api", "globex-secret")) {
+		t.Error("Expected revoked key to be rejected")
+	}
+	if err := store.RevokeKey(HashAPIKey("unknown")); err != ErrKeyNotFound {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	// Rebuilding the filter on a config reload reuses the connection pool
+	rebuilt, err := NewApiKeyAuthFilter(map[string]interface{}{"store": "sql", "dsn": dsn})
+	if err != nil {
+		t.Fatalf("Failed to rebuild filter: %v", err)
+	}
+	if rebuilt.(*ApiKeyAuthFilter).store.(*SQLKeyStore).db != store.db {
+		t.Error("Expected the rebuilt filter to share the key database")
+	}
+}
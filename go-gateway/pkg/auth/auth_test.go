@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+// testCA is a throwaway certificate authority used to issue client certificates
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, dnsNames ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func newCertContext(cert *x509.Certificate, verified bool) *middleware.GatewayContext {
+	req := httptest.NewRequest("GET", "https://gateway/billing", nil)
+	req.Header.Set(DefaultClientCertSubjectHeader, "CN=spoofed")
+	if cert != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+	}
+	return &middleware.GatewayContext{
+		Request:    req,
+		Response:   httptest.NewRecorder(),
+		Route:      &common.Route{ID: "billing"},
+		Attributes: make(map[string]interface{}),
+	}
+}
+
+// TestClientCertFilter tests client certificate authentication
+func TestClientCertFilter(t *testing.T) {
+	ca := newTestCA(t)
+	billing := ca.issue(t, pkix.Name{CommonName: "billing-service", Organization: []string{"Acme"}}, "billing.internal")
+	other := ca.issue(t, pkix.Name{CommonName: "orders-service"}, "orders.internal")
+
+	f, err := NewClientCertFilter(map[string]interface{}{
+		"allowedSubjects": []interface{}{"CN=billing-service,O=Acme"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	t.Run("TestAllowedSubject", func(t *testing.T) {
+		ctx := newCertContext(billing, true)
+		if !f.PreHandle(ctx) {
+			t.Fatal("Expected certificate to be allowed")
+		}
+		if got := ctx.Request.Header.Get(DefaultClientCertSubjectHeader); got != billing.Subject.String() {
+			t.Errorf("Expected forwarded subject %q, got %q", billing.Subject.String(), got)
+		}
+		if got := ctx.Request.Header.Get(DefaultClientCertSANHeader); got != "billing.internal" {
+			t.Errorf("Expected forwarded SAN 'billing.internal', got %q", got)
+		}
+		identity, ok := GetIdentity(ctx)
+		if !ok || identity.Method != "mtls" {
+			t.Errorf("Expected mtls identity, got %+v", identity)
+		}
+	})
+
+	t.Run("TestSubjectMismatch", func(t *testing.T) {
+		ctx := newCertContext(other, true)
+		if f.PreHandle(ctx) {
+			t.Fatal("Expected certificate to be rejected")
+		}
+		if code := ctx.Response.(*httptest.ResponseRecorder).Code; code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", code)
+		}
+		if got := ctx.Request.Header.Get(DefaultClientCertSubjectHeader); got != "" {
+			t.Errorf("Expected spoofed header to be removed, got %q", got)
+		}
+	})
+
+	t.Run("TestMissingCertificate", func(t *testing.T) {
+		for _, ctx := range []*middleware.GatewayContext{newCertContext(nil, false), newCertContext(billing, false)} {
+			if f.PreHandle(ctx) {
+				t.Fatal("Expected request without verified certificate to be rejected")
+			}
+			if code := ctx.Response.(*httptest.ResponseRecorder).Code; code != http.StatusUnauthorized {
+				t.Errorf("Expected status 401, got %d", code)
+			}
+		}
+	})
+
+	t.Run("TestAllowedSANAndCustomHeaders", func(t *testing.T) {
+		f, err := NewClientCertFilter(map[string]interface{}{
+			"allowedSANs":    []interface{}{"*.internal"},
+			"forwardHeaders": map[string]interface{}{"subject": "X-Identity", "cert": "X-Client-Cert"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create filter: %v", err)
+		}
+		ctx := newCertContext(other, true)
+		if !f.PreHandle(ctx) {
+			t.Fatal("Expected SAN to be allowed")
+		}
+		if got := ctx.Request.Header.Get("X-Identity"); got != "CN=orders-service" {
+			t.Errorf("Expected X-Identity 'CN=orders-service', got %q", got)
+		}
+		certPEM, _ := url.QueryUnescape(ctx.Request.Header.Get("X-Client-Cert"))
+		if block, _ := pem.Decode([]byte(certPEM)); block == nil {
+			t.Error("Expected PEM encoded certificate in X-Client-Cert")
+		}
+	})
+
+	t.Run("TestRouteCAFile", func(t *testing.T) {
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+		if err := os.WriteFile(caFile, pemData, 0600); err != nil {
+			t.Fatal(err)
+		}
+		f, err := NewClientCertFilter(map[string]interface{}{"caFile": caFile})
+		if err != nil {
+			t.Fatalf("Failed to create filter: %v", err)
+		}
+
+		if !f.PreHandle(newCertContext(billing, false)) {
+			t.Error("Expected certificate issued by caFile to be allowed")
+		}
+		foreign := newTestCA(t).issue(t, pkix.Name{CommonName: "billing-service"})
+		if f.PreHandle(newCertContext(foreign, false)) {
+			t.Error("Expected certificate from another CA to be rejected")
+		}
+	})
+
+	t.Run("TestInvalidArgs", func(t *testing.T) {
+		if _, err := NewClientCertFilter(map[string]interface{}{"allowedSubjects": []interface{}{"billing"}}); err == nil {
+			t.Error("Expected error for malformed subject pattern")
+		}
+		if _, err := NewClientCertFilter(map[string]interface{}{"allowedSubject": "CN=a"}); err == nil {
+			t.Error("Expected error for unknown arg")
+		}
+	})
+}
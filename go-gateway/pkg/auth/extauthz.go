@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("ExtAuthz", NewExtAuthzFilter, ExtAuthzArgs{})
+}
+
+// Defaults of the ExtAuthz filter
+const (
+	DefaultExtAuthzTimeout   = time.Second
+	DefaultExtAuthzCacheSize = 10000
+)
+
+// ExtAuthzArgs configures the ExtAuthz filter
+type ExtAuthzArgs struct {
+	// URL of the authorization service; check requests are POSTed as JSON
+	URL     string        `mapstructure:"url" json:"url"`
+	Timeout time.Duration `mapstructure:"timeout" json:"timeout,omitempty"`
+	// FailOpen allows requests when the service is unreachable or answers
+	// garbage; by default they are denied with 503
+	FailOpen bool `mapstructure:"failOpen" json:"failOpen,omitempty"`
+	// IncludeHeaders limits the request headers sent to the service; all
+	// headers are sent when empty
+	IncludeHeaders []string `mapstructure:"includeHeaders" json:"includeHeaders,omitempty"`
+	// CacheTTL caches decisions by route, method, host, path, query, client
+	// and IncludeHeaders, which it requires; zero disables caching
+	CacheTTL time.Duration `mapstructure:"cacheTtl" json:"cacheTtl,omitempty"`
+	// CacheSize limits the number of cached decisions
+	CacheSize int `mapstructure:"cacheSize" json:"cacheSize,omitempty"`
+}
+
+// CheckRequest is the metadata sent to the authorization service
+type CheckRequest struct {
+	RouteID string            `json:"route_id"`
+	Method  string            `json:"method"`
+	Host    string            `json:"host"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Headers map[string]string `json:"headers"`
+	Client  CheckClient       `json:"client"`
+}
+
+// CheckClient describes the caller in a CheckRequest
+type CheckClient struct {
+	Address  string `json:"address"`
+	Subject  string `json:"subject,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Consumer string `json:"consumer,omitempty"`
+}
+
+// CheckResponse is the decision returned by the authorization service
+type CheckResponse struct {
+	Allow bool `json:"allow"`
+	// HeadersToAdd and HeadersToRemove modify the upstream request when allowed
+	HeadersToAdd    map[string]string `json:"headers_to_add,omitempty"`
+	HeadersToRemove []string          `json:"headers_to_remove,omitempty"`
+	// Status, Body and ResponseHeaders make up the denial response
+	Status          int               `json:"status,omitempty"`
+	Body            string            `json:"body,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+}
+
+// ExtAuthzFilter delegates authorization decisions to an external service
+type ExtAuthzFilter struct {
+	url            string
+	client         *http.Client
+	failOpen       bool
+	includeHeaders []string
+	cacheTTL       time.Duration
+	now            func() time.Time
+	cache          *decisionCache
+}
+
+// decisionCache holds the decisions of a service by cache key
+type decisionCache struct {
+	mutex     sync.Mutex
+	decisions map[[sha256.Size]byte]cachedDecision
+	size      int
+	lastSweep time.Time
+}
+
+// decisionCacheKey identifies the cache of a service and cache settings
+type decisionCacheKey struct {
+	url            string
+	includeHeaders string
+	cacheTTL       time.Duration
+	cacheSize      int
+}
+
+var (
+	decisionCachesMutex sync.Mutex
+	// decisionCaches are kept across config reloads, which rebuild every
+	// filter, so that reloads do not send every request to the service again
+	decisionCaches = make(map[decisionCacheKey]*decisionCache)
+)
+
+// getDecisionCache returns the shared cache for key
+func getDecisionCache(key decisionCacheKey) *decisionCache {
+	decisionCachesMutex.Lock()
+	defer decisionCachesMutex.Unlock()
+	c, ok := decisionCaches[key]
+	if !ok {
+		c = &decisionCache{decisions: make(map[[sha256.Size]byte]cachedDecision), size: key.cacheSize}
+		decisionCaches[key] = c
+	}
+	return c
+}
+
+type cachedDecision struct {
+	response CheckResponse
+	expires  time.Time
+}
+
+// NewExtAuthzFilter creates an ExtAuthz filter from its args
+func NewExtAuthzFilter(args interface{}) (middleware.Middleware, error) {
+	cfg := ExtAuthzArgs{Timeout: DefaultExtAuthzTimeout, CacheSize: DefaultExtAuthzCacheSize}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if _, err := url.ParseRequestURI(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+	if cfg.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive")
+	}
+	// Without a header list every request header would be part of the cache
+	// key, and per-request headers would make every key unique
+	if cfg.CacheTTL > 0 && len(cfg.IncludeHeaders) == 0 {
+		return nil, fmt.Errorf("cacheTtl requires includeHeaders")
+	}
+	if cfg.CacheSize <= 0 {
+		return nil, fmt.Errorf("cacheSize must be positive")
+	}
+
+	f := &ExtAuthzFilter{
+		url:            cfg.URL,
+		client:         &http.Client{Timeout: cfg.Timeout},
+		failOpen:       cfg.FailOpen,
+		includeHeaders: cfg.IncludeHeaders,
+		cacheTTL:       cfg.CacheTTL,
+		now:            time.Now,
+	}
+	if cfg.CacheTTL > 0 {
+		headers := make([]string, len(cfg.IncludeHeaders))
+		for i, name := range cfg.IncludeHeaders {
+			headers[i] = http.CanonicalHeaderKey(name)
+		}
+		f.cache = getDecisionCache(decisionCacheKey{
+			url:            cfg.URL,
+			includeHeaders: strings.Join(headers, ","),
+			cacheTTL:       cfg.CacheTTL,
+			cacheSize:      cfg.CacheSize,
+		})
+	}
+	return f, nil
+}
+
+// Name returns the filter name
+func (f *ExtAuthzFilter) Name() string {
+	return "ExtAuthz"
+}
+
+// PreHandle asks the authorization service whether the request may proceed
+func (f *ExtAuthzFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+
+	decision, err := f.check(f.checkRequest(ctx, routeID))
+	if err != nil {
+		monitoring.AuthzDecisionTotal.WithLabelValues(routeID, "error").Inc()
+		log.Printf("External authorization failed on route %s: %v", routeID, err)
+		if f.failOpen {
+			return true
+		}
+		http.Error(ctx.Response, "authorization service unavailable", http.StatusServiceUnavailable)
+		return false
+	}
+
+	if !decision.Allow {
+		monitoring.AuthzDecisionTotal.WithLabelValues(routeID, "deny").Inc()
+		for name, value := range decision.ResponseHeaders {
+			ctx.Response.Header().Set(name, value)
+		}
+		status := decision.Status
+		if status < 400 || status > 599 {
+			status = http.StatusForbidden
+		}
+		body := decision.Body
+		if body == "" {
+			body = http.StatusText(status)
+		}
+		http.Error(ctx.Response, body, status)
+		return false
+	}
+
+	monitoring.AuthzDecisionTotal.WithLabelValues(routeID, "allow").Inc()
+	for _, name := range decision.HeadersToRemove {
+		ctx.Request.Header.Del(name)
+	}
+	for name, value := range decision.HeadersToAdd {
+		ctx.Request.Header.Set(name, value)
+	}
+	return true
+}
+
+// PostHandle does nothing
+func (f *ExtAuthzFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *ExtAuthzFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// checkRequest collects the request metadata sent to the service
+func (f *ExtAuthzFilter) checkRequest(ctx *middleware.GatewayContext, routeID string) CheckRequest {
+	r := ctx.Request
+	check := CheckRequest{
+		RouteID: routeID,
+		Method:  r.Method,
+		Host:    r.Host,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Headers: make(map[string]string),
+	}
+
+	if len(f.includeHeaders) == 0 {
+		for name, values := range r.Header {
+			check.Headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+		}
+	} else {
+		for _, name := range f.includeHeaders {
+			if values := r.Header.Values(name); len(values) > 0 {
+				check.Headers[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+			}
+		}
+	}
+
+	check.Client.Address = ctx.ClientIP()
+	if identity, ok := GetIdentity(ctx); ok {
+		check.Client.Subject = identity.Subject
+		check.Client.Method = identity.Method
+	}
+	if consumer, ok := ctx.Consumer(); ok {
+		check.Client.Consumer = consumer
+	}
+	return check
+}
+
+// check returns the cached or freshly requested decision
+func (f *ExtAuthzFilter) check(check CheckRequest) (CheckResponse, error) {
+	payload, err := json.Marshal(check)
+	if err != nil {
+		return CheckResponse{}, err
+	}
+	key := f.cacheKey(check)
+	now := f.now()
+
+	if f.cache != nil {
+		f.cache.mutex.Lock()
+		cached, ok := f.cache.decisions[key]
+		f.cache.mutex.Unlock()
+		if ok && now.Before(cached.expires) {
+			return cached.response, nil
+		}
+	}
+
+	resp, err := f.client.Post(f.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return CheckResponse{}, fmt.Errorf("error calling authorization service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return CheckResponse{}, fmt.Errorf("authorization service returned status %d", resp.StatusCode)
+	}
+	var decision CheckResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&decision); err != nil {
+		return CheckResponse{}, fmt.Errorf("error decoding authorization decision: %w", err)
+	}
+
+	if f.cache != nil {
+		f.cache.put(key, cachedDecision{response: decision, expires: now.Add(f.cacheTTL)}, now)
+	}
+	return decision, nil
+}
+
+// cacheKey returns the cache key of a check request. Only the configured
+// headers are part of it, so that per-request headers such as X-Request-Id
+// do not make every key unique.
+func (f *ExtAuthzFilter) cacheKey(check CheckRequest) [sha256.Size]byte {
+	parts := []string{
+		check.RouteID, check.Method, check.Host, check.Path, check.Query,
+		check.Client.Address, check.Client.Subject, check.Client.Method, check.Client.Consumer,
+	}
+	for _, name := range f.includeHeaders {
+		parts = append(parts, check.Headers[http.CanonicalHeaderKey(name)])
+	}
+	data, _ := json.Marshal(parts)
+	return sha256.Sum256(data)
+}
+
+// put caches a decision, evicting an arbitrary one rather than growing past
+// the size limit
+func (c *decisionCache) put(key [sha256.Size]byte, decision cachedDecision, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sweep(now)
+	if len(c.decisions) >= c.size {
+		for old := range c.decisions {
+			delete(c.decisions, old)
+			break
+		}
+	}
+	c.decisions[key] = decision
+}
+
+// sweep drops expired decisions at most once a minute; c.mutex must be held
+func (c *decisionCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	for key, decision := range c.decisions {
+		if !now.Before(decision.expires) {
+			delete(c.decisions, key)
+		}
+	}
+}
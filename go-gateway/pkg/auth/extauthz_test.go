@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+func newExtAuthzContext(method string, path string, user string) *middleware.GatewayContext {
+	req := httptest.NewRequest(method, "http://gateway"+path, nil)
+	req.Header.Set("X-User", user)
+	req.Header.Set("X-Internal-Debug", "1")
+	return &middleware.GatewayContext{
+		Request:    req,
+		Response:   httptest.NewRecorder(),
+		Route:      &common.Route{ID: "policy"},
+		Attributes: make(map[string]interface{}),
+	}
+}
+
+// TestExtAuthzFilter tests decisions of an external authorization service
+func TestExtAuthzFilter(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var check CheckRequest
+		json.NewDecoder(r.Body).Decode(&check)
+
+		if check.Headers["X-User"] == "admin" && check.Client.Subject == "alice" {
+			json.NewEncoder(w).Encode(CheckResponse{
+				Allow:           true,
+				HeadersToAdd:    map[string]string{"X-Policy": "admin"},
+				HeadersToRemove: []string{"X-Internal-Debug"},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(CheckResponse{
+			Status:          http.StatusUnauthorized,
+			Body:            "denied by policy",
+			ResponseHeaders: map[string]string{"X-Policy-Reason": "not-admin"},
+		})
+	}))
+	defer server.Close()
+
+	args := map[string]interface{}{
+		"url":            server.URL,
+		"includeHeaders": []interface{}{"X-User"},
+		"cacheTtl":       "1m",
+	}
+	f, err := NewExtAuthzFilter(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	t.Run("TestAllow", func(t *testing.T) {
+		ctx := newExtAuthzContext("GET", "/admin", "admin")
+		SetIdentity(ctx, &Identity{Subject: "alice", Method: "jwt"})
+		if !f.PreHandle(ctx) {
+			t.Fatalf("Expected request to be allowed, got %d", ctx.Response.(*httptest.ResponseRecorder).Code)
+		}
+		if ctx.Request.Header.Get("X-Policy") != "admin" {
+			t.Error("Expected X-Policy header to be added")
+		}
+		if ctx.Request.Header.Get("X-Internal-Debug") != "" {
+			t.Error("Expected X-Internal-Debug header to be removed")
+		}
+	})
+
+	t.Run("TestDeny", func(t *testing.T) {
+		ctx := newExtAuthzContext("GET", "/admin", "guest")
+		if f.PreHandle(ctx) {
+			t.Fatal("Expected request to be denied")
+		}
+		recorder := ctx.Response.(*httptest.ResponseRecorder)
+		if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("X-Policy-Reason") != "not-admin" {
+			t.Errorf("Expected 401 with X-Policy-Reason, got %d", recorder.Code)
+		}
+	})
+
+	t.Run("TestDecisionCache", func(t *testing.T) {
+		before := atomic.LoadInt32(&calls)
+		for i := 0; i < 3; i++ {
+			f.PreHandle(newExtAuthzContext("GET", "/admin", "guest"))
+		}
+		if after := atomic.LoadInt32(&calls); after != before {
+			t.Errorf("Expected cached decisions, got %d additional calls", after-before)
+		}
+	})
+
+	t.Run("TestCacheKeyIgnoresOtherHeaders", func(t *testing.T) {
+		before := atomic.LoadInt32(&calls)
+		for i := 0; i < 3; i++ {
+			ctx := newExtAuthzContext("GET", "/admin", "guest")
+			ctx.Request.Header.Set("X-Request-Id", fmt.Sprint(i))
+			ctx.Request.Header.Set("Traceparent", fmt.Sprintf("00-%032d-%016d-01", i, i))
+			f.PreHandle(ctx)
+		}
+		if after := atomic.LoadInt32(&calls); after != before {
+			t.Errorf("Expected per-request headers not to defeat the cache, got %d additional calls", after-before)
+		}
+	})
+
+	t.Run("TestCacheSurvivesReload", func(t *testing.T) {
+		reloaded, err := NewExtAuthzFilter(args)
+		if err != nil {
+			t.Fatalf("Failed to create filter: %v", err)
+		}
+		before := atomic.LoadInt32(&calls)
+		reloaded.PreHandle(newExtAuthzContext("GET", "/admin", "guest"))
+		if after := atomic.LoadInt32(&calls); after != before {
+			t.Errorf("Expected the rebuilt filter to use the cached decision, got %d additional calls", after-before)
+		}
+	})
+}
+
+// TestExtAuthzArgs tests the validation of the cache settings and the
+// headers sent to the service
+func TestExtAuthzArgs(t *testing.T) {
+	var mutex sync.Mutex
+	var headers map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var check CheckRequest
+		json.NewDecoder(r.Body).Decode(&check)
+		mutex.Lock()
+		headers = check.Headers
+		mutex.Unlock()
+		json.NewEncoder(w).Encode(CheckResponse{Allow: true})
+	}))
+	defer server.Close()
+
+	if _, err := NewExtAuthzFilter(map[string]interface{}{"url": server.URL, "cacheTtl": "1m"}); err == nil {
+		t.Error("Expected cacheTtl without includeHeaders to be rejected")
+	}
+
+	f, err := NewExtAuthzFilter(map[string]interface{}{"url": server.URL})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	ctx := newExtAuthzContext("GET", "/", "admin")
+	ctx.Request.Header.Add("X-Group", "ops")
+	ctx.Request.Header.Add("X-Group", "dev")
+	f.PreHandle(ctx)
+	mutex.Lock()
+	if headers["X-Group"] != "ops, dev" {
+		t.Errorf("Expected repeated header values to be joined, got %q", headers["X-Group"])
+	}
+	mutex.Unlock()
+
+	limited, err := NewExtAuthzFilter(map[string]interface{}{
+		"url": server.URL, "includeHeaders": []string{"X-User"}, "cacheTtl": "1m", "cacheSize": 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		limited.PreHandle(newExtAuthzContext("GET", fmt.Sprintf("/%d", i), "admin"))
+	}
+	if n := len(limited.(*ExtAuthzFilter).cache.decisions); n > 2 {
+		t.Errorf("Expected at most 2 cached decisions, got %d", n)
+	}
+}
+
+// TestExtAuthzFailureModes tests fail-open and fail-closed behaviour
+func TestExtAuthzFailureModes(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		json.NewEncoder(w).Encode(CheckResponse{Allow: true})
+	}))
+	defer slow.Close()
+
+	closed, err := NewExtAuthzFilter(map[string]interface{}{"url": slow.URL, "timeout": "20ms"})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	ctx := newExtAuthzContext("GET", "/", "admin")
+	if closed.PreHandle(ctx) {
+		t.Error("Expected fail-closed filter to deny on timeout")
+	}
+	if code := ctx.Response.(*httptest.ResponseRecorder).Code; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", code)
+	}
+
+	open, err := NewExtAuthzFilter(map[string]interface{}{"url": slow.URL, "timeout": "20ms", "failOpen": true})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	if !open.PreHandle(newExtAuthzContext("GET", "/", "admin")) {
+		t.Error("Expected fail-open filter to allow on timeout")
+	}
+}
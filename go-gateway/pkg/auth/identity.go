@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"go-gateway/pkg/middleware"
+)
+
+// IdentityAttribute is the GatewayContext attribute holding the authenticated identity
+const IdentityAttribute = "auth.identity"
+
+// Identity describes an authenticated caller
+type Identity struct {
+	// Subject identifies the caller, e.g. a certificate subject or a JWT "sub" claim
+	Subject string
+	// Method names the mechanism that authenticated the caller
+	Method string
+	// Claims holds additional attributes of the caller
+	Claims map[string]interface{}
+}
+
+// SetIdentity stores the identity in the gateway context
+func SetIdentity(ctx *middleware.GatewayContext, identity *Identity) {
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	ctx.Attributes[IdentityAttribute] = identity
+}
+
+// GetIdentity returns the identity stored in the gateway context, if any
+func GetIdentity(ctx *middleware.GatewayContext) (*Identity, bool) {
+	identity, ok := ctx.Attributes[IdentityAttribute].(*Identity)
+	return identity, ok && identity != nil
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("TokenIntrospection", NewIntrospectionFilter, IntrospectionArgs{})
+}
+
+// Default settings of the TokenIntrospection filter
+const (
+	DefaultIntrospectionTimeout  = 5 * time.Second
+	DefaultIntrospectionCacheTTL = 5 * time.Minute
+)
+
+// IntrospectionArgs configures the TokenIntrospection filter
+type IntrospectionArgs struct {
+	// IntrospectionURL is the RFC 7662 endpoint
+	IntrospectionURL string `mapstructure:"introspectionUrl" json:"introspectionUrl"`
+	// ClientID and ClientSecret authenticate the gateway at the endpoint
+	ClientID     string        `mapstructure:"clientId" json:"clientId,omitempty"`
+	ClientSecret string        `mapstructure:"clientSecret" json:"clientSecret,omitempty"`
+	Timeout      time.Duration `mapstructure:"timeout" json:"timeout,omitempty"`
+	// CacheTTL caps how long an active token is cached; tokens are never
+	// cached past their "exp"
+	CacheTTL time.Duration `mapstructure:"cacheTtl" json:"cacheTtl,omitempty"`
+	// NegativeCacheTTL caches inactive tokens; zero disables negative caching
+	NegativeCacheTTL time.Duration     `mapstructure:"negativeCacheTtl" json:"negativeCacheTtl,omitempty"`
+	RequiredScopes   []string          `mapstructure:"requiredScopes" json:"requiredScopes,omitempty"`
+	ForwardClaims    map[string]string `mapstructure:"forwardClaims" json:"forwardClaims,omitempty"`
+	Realm            string            `mapstructure:"realm" json:"realm,omitempty"`
+}
+
+// IntrospectionFilter authenticates opaque bearer tokens by introspection
+type IntrospectionFilter struct {
+	endpoint       string
+	clientID       string
+	clientSecret   string
+	client         *http.Client
+	cacheTTL       time.Duration
+	negativeTTL    time.Duration
+	requiredScopes []string
+	forwardClaims  map[string]string
+	realm          string
+	now            func() time.Time
+	cache          *introspectionCache
+}
+
+// introspectionCache holds the introspection results of an endpoint by token
+// hash
+type introspectionCache struct {
+	mutex     sync.Mutex
+	results   map[[sha256.Size]byte]introspectionResult
+	lastSweep time.Time
+}
+
+// introspectionCacheKey identifies the cache of an endpoint and cache settings
+type introspectionCacheKey struct {
+	endpoint    string
+	clientID    string
+	cacheTTL    time.Duration
+	negativeTTL time.Duration
+}
+
+var (
+	introspectionCachesMutex sync.Mutex
+	// introspectionCaches are kept across config reloads, which rebuild every
+	// filter, so that reloads do not send every token to the endpoint again
+	introspectionCaches = make(map[introspectionCacheKey]*introspectionCache)
+)
+
+// getIntrospectionCache returns the shared cache for key
+func getIntrospectionCache(key introspectionCacheKey) *introspectionCache {
+	introspectionCachesMutex.Lock()
+	defer introspectionCachesMutex.Unlock()
+	c, ok := introspectionCaches[key]
+	if !ok {
+		c = &introspectionCache{results: make(map[[sha256.Size]byte]introspectionResult)}
+		introspectionCaches[key] = c
+	}
+	return c
+}
+
+type introspectionResult struct {
+	claims  map[string]interface{}
+	active  bool
+	expires time.Time
+}
+
+// NewIntrospectionFilter creates a TokenIntrospection filter from its args
+func NewIntrospectionFilter(args interface{}) (middleware.Middleware, error) {
+	cfg := IntrospectionArgs{
+		Timeout:  DefaultIntrospectionTimeout,
+		CacheTTL: DefaultIntrospectionCacheTTL,
+		Realm:    DefaultAuthRealm,
+	}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if _, err := url.ParseRequestURI(cfg.IntrospectionURL); err != nil {
+		return nil, fmt.Errorf("invalid introspectionUrl: %w", err)
+	}
+
+	return &IntrospectionFilter{
+		endpoint:       cfg.IntrospectionURL,
+		clientID:       cfg.ClientID,
+		clientSecret:   cfg.ClientSecret,
+		client:         &http.Client{Timeout: cfg.Timeout},
+		cacheTTL:       cfg.CacheTTL,
+		negativeTTL:    cfg.NegativeCacheTTL,
+		requiredScopes: cfg.RequiredScopes,
+		forwardClaims:  cfg.ForwardClaims,
+		realm:          cfg.Realm,
+		now:            time.Now,
+		cache: getIntrospectionCache(introspectionCacheKey{
+			endpoint:    cfg.IntrospectionURL,
+			clientID:    cfg.ClientID,
+			cacheTTL:    cfg.CacheTTL,
+			negativeTTL: cfg.NegativeCacheTTL,
+		}),
+	}, nil
+}
+
+// Name returns the filter name
+func (f *IntrospectionFilter) Name() string {
+	return "TokenIntrospection"
+}
+
+// PreHandle introspects the bearer token of the request
+func (f *IntrospectionFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	for _, header := range f.forwardClaims {
+		ctx.Request.Header.Del(header)
+	}
+
+	token, ok := BearerToken(ctx.Request)
+	if !ok {
+		f.reject(ctx, http.StatusUnauthorized, "", "")
+		return false
+	}
+
+	result, err := f.introspect(token)
+	if err != nil {
+		routeID := "unknown"
+		if ctx.Route != nil {
+			routeID = ctx.Route.ID
+		}
+		monitoring.ErrorTotal.WithLabelValues("introspection_failed", routeID).Inc()
+		http.Error(ctx.Response, "token introspection failed", http.StatusServiceUnavailable)
+		return false
+	}
+	if !result.active {
+		f.reject(ctx, http.StatusUnauthorized, "invalid_token", "token is not active")
+		return false
+	}
+
+	if missing := MissingScopes(result.claims, f.requiredScopes); len(missing) > 0 {
+		f.reject(ctx, http.StatusForbidden, "insufficient_scope", "missing scope "+strings.Join(missing, " "))
+		return false
+	}
+
+	for name, header := range f.forwardClaims {
+		if value := LookupClaim(result.claims, name); value != nil {
+			ctx.Request.Header.Set(header, FormatClaim(value))
+		}
+	}
+
+	subject, _ := result.claims["sub"].(string)
+	if subject == "" {
+		subject, _ = result.claims["client_id"].(string)
+	}
+	SetIdentity(ctx, &Identity{Subject: subject, Method: "introspection", Claims: result.claims})
+	return true
+}
+
+// PostHandle does nothing
+func (f *IntrospectionFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *IntrospectionFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// introspect returns the cached or freshly fetched introspection result.
+// Tokens are cached by hash so that the cache never holds them in clear text.
+func (f *IntrospectionFilter) introspect(token string) (introspectionResult, error) {
+	key := sha256.Sum256([]byte(token))
+	now := f.now()
+
+	f.cache.mutex.Lock()
+	cached, ok := f.cache.results[key]
+	f.cache.mutex.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached, nil
+	}
+
+	result, err := f.fetch(token)
+	if err != nil {
+		return introspectionResult{}, err
+	}
+
+	if result.active {
+		result.expires = now.Add(f.cacheTTL)
+		if exp, ok := numericClaim(result.claims, "exp"); ok {
+			expiry := time.Unix(exp, 0)
+			if !now.Before(expiry) {
+				result.active = false
+			}
+			if expiry.Before(result.expires) {
+				result.expires = expiry
+			}
+		}
+	} else {
+		result.expires = now.Add(f.negativeTTL)
+	}
+
+	f.cache.mutex.Lock()
+	defer f.cache.mutex.Unlock()
+	f.cache.sweep(now)
+	if now.Before(result.expires) {
+		f.cache.results[key] = result
+	}
+	return result, nil
+}
+
+// sweep drops expired results at most once a minute; c.mutex must be held
+func (c *introspectionCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	for key, result := range c.results {
+		if !now.Before(result.expires) {
+			delete(c.results, key)
+		}
+	}
+}
+
+// fetch calls the introspection endpoint
+func (f *IntrospectionFilter) fetch(token string) (introspectionResult, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, f.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return introspectionResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if f.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(f.clientID), url.QueryEscape(f.clientSecret))
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return introspectionResult{}, fmt.Errorf("error calling introspection endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return introspectionResult{}, fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&claims); err != nil {
+		return introspectionResult{}, fmt.Errorf("error decoding introspection response: %w", err)
+	}
+	active, _ := claims["active"].(bool)
+	return introspectionResult{claims: claims, active: active}, nil
+}
+
+func (f *IntrospectionFilter) reject(ctx *middleware.GatewayContext, status int, errorCode string, description string) {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.ErrorTotal.WithLabelValues("token_rejected", routeID).Inc()
+
+	ctx.Response.Header().Set("WWW-Authenticate", BearerChallenge(f.realm, errorCode, description))
+	http.Error(ctx.Response, http.StatusText(status), status)
+}
@@ -0,0 +1,304 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// minKeyRefreshInterval bounds how often an unknown key id can trigger a refresh
+const minKeyRefreshInterval = 10 * time.Second
+
+// JSONWebKey is a verification key parsed from a JWKS document
+type JSONWebKey struct {
+	KeyID     string
+	Algorithm string
+	// Key is an *rsa.PublicKey, *ecdsa.PublicKey or []byte for symmetric keys
+	Key interface{}
+}
+
+// rawJSONWebKey is the wire format of a JWK
+type rawJSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// KeySet is a cached JWKS loaded from a file or URL.
+// Files are reloaded when they change, URLs are refetched after the refresh
+// interval or when a token references an unknown key id, so that signing
+// keys can be rotated without restarting the gateway.
+type KeySet struct {
+	file            string
+	url             string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	mutex       sync.RWMutex
+	keys        []JSONWebKey
+	loadedAt    time.Time
+	lastAttempt time.Time
+	fileModTime time.Time
+	// inFlight is the JWKS fetch in progress, which runs without the lock
+	inFlight *keyFetch
+}
+
+// keyFetch is a JWKS fetch that callers without keys can wait for
+type keyFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewFileKeySet creates a key set backed by a JWKS file
+func NewFileKeySet(path string) (*KeySet, error) {
+	ks := &KeySet{file: path}
+	if err := ks.reloadFile(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// NewRemoteKeySet creates a key set backed by a JWKS URL.
+// Keys are fetched lazily so that the gateway starts even if the issuer is down.
+func NewRemoteKeySet(url string, refreshInterval time.Duration, client *http.Client) *KeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &KeySet{
+		url:             url,
+		client:          client,
+		refreshInterval: refreshInterval,
+	}
+}
+
+// Keys returns the keys matching kid; an empty kid matches every key
+func (ks *KeySet) Keys(kid string) ([]JSONWebKey, error) {
+	if err := ks.refreshIfStale(); err != nil && !ks.hasKeys() {
+		return nil, err
+	}
+
+	matches := ks.find(kid)
+	if len(matches) == 0 && kid != "" && ks.url != "" {
+		// Possibly a freshly rotated key
+		if ks.refresh() == nil {
+			matches = ks.find(kid)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no key found for kid %q", kid)
+	}
+	return matches, nil
+}
+
+func (ks *KeySet) hasKeys() bool {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return len(ks.keys) > 0
+}
+
+func (ks *KeySet) find(kid string) []JSONWebKey {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+
+	var matches []JSONWebKey
+	for _, key := range ks.keys {
+		if kid == "" || key.KeyID == kid {
+			matches = append(matches, key)
+		}
+	}
+	return matches
+}
+
+func (ks *KeySet) refreshIfStale() error {
+	if ks.file != "" {
+		return ks.reloadFile()
+	}
+
+	ks.mutex.RLock()
+	stale := ks.loadedAt.IsZero() || (ks.refreshInterval > 0 && time.Since(ks.loadedAt) > ks.refreshInterval)
+	ks.mutex.RUnlock()
+	if !stale {
+		return nil
+	}
+	return ks.refresh()
+}
+
+// refresh refetches the remote JWKS. Fetches are limited to one per
+// minKeyRefreshInterval so that an unavailable issuer or a flood of unknown
+// key ids cannot turn every request into a JWKS fetch. The fetch runs without
+// the lock, so that verifications with the cached keys go on meanwhile; only
+// callers that have no keys yet wait for it.
+func (ks *KeySet) refresh() error {
+	ks.mutex.Lock()
+	if f := ks.inFlight; f != nil {
+		hasKeys := len(ks.keys) > 0
+		ks.mutex.Unlock()
+		if hasKeys {
+			return fmt.Errorf("jwks refresh in progress")
+		}
+		<-f.done
+		return f.err
+	}
+	if time.Since(ks.lastAttempt) < minKeyRefreshInterval {
+		ks.mutex.Unlock()
+		return fmt.Errorf("jwks refresh rate limited")
+	}
+	ks.lastAttempt = time.Now()
+	f := &keyFetch{done: make(chan struct{})}
+	ks.inFlight = f
+	ks.mutex.Unlock()
+
+	keys, err := ks.fetch()
+
+	ks.mutex.Lock()
+	if err == nil {
+		ks.keys = keys
+		ks.loadedAt = time.Now()
+	}
+	ks.inFlight = nil
+	f.err = err
+	ks.mutex.Unlock()
+	close(f.done)
+	return err
+}
+
+// fetch downloads and parses the remote JWKS
+func (ks *KeySet) fetch() ([]JSONWebKey, error) {
+	resp, err := ks.client.Get(ks.url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching jwks: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading jwks: %w", err)
+	}
+	return ParseJWKS(data)
+}
+
+func (ks *KeySet) reloadFile() error {
+	info, err := os.Stat(ks.file)
+	if err != nil {
+		return fmt.Errorf("error reading jwks file: %w", err)
+	}
+
+	ks.mutex.RLock()
+	unchanged := !ks.loadedAt.IsZero() && info.ModTime().Equal(ks.fileModTime)
+	ks.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(ks.file)
+	if err != nil {
+		return fmt.Errorf("error reading jwks file: %w", err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.keys = keys
+	ks.loadedAt = time.Now()
+	ks.fileModTime = info.ModTime()
+	return nil
+}
+
+// ParseJWKS parses a JWKS document. Keys of unsupported types or intended
+// for encryption are skipped.
+func ParseJWKS(data []byte) ([]JSONWebKey, error) {
+	var doc struct {
+		Keys []rawJSONWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing jwks: %w", err)
+	}
+
+	keys := make([]JSONWebKey, 0, len(doc.Keys))
+	for _, raw := range doc.Keys {
+		if raw.Use != "" && raw.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", raw.Kid, err)
+		}
+		if key != nil {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func parseJSONWebKey(raw rawJSONWebKey) (*JSONWebKey, error) {
+	key := &JSONWebKey{KeyID: raw.Kid, Algorithm: raw.Alg}
+
+	switch raw.Kty {
+	case "RSA":
+		n, err := decodeBigInt(raw.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(raw.E)
+		if err != nil {
+			return nil, err
+		}
+		key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if raw.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(raw.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(raw.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve")
+		}
+		key.Key = pub
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(raw.K)
+		if err != nil {
+			return nil, fmt.Errorf("invalid k: %w", err)
+		}
+		key.Key = secret
+	default:
+		return nil, nil
+	}
+	return key, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,470 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("JwtAuth", NewJwtAuthFilter, JwtAuthArgs{})
+}
+
+// Default settings of the JwtAuth filter
+const (
+	DefaultJwksRefreshInterval = 5 * time.Minute
+	DefaultJwtClockSkew        = 30 * time.Second
+	DefaultAuthRealm           = "gateway"
+)
+
+// supportedJwtAlgorithms lists the signature algorithms JwtAuth understands
+var supportedJwtAlgorithms = []string{"RS256", "ES256", "HS256"}
+
+// JwtAuthArgs configures the JwtAuth filter
+type JwtAuthArgs struct {
+	// JwksFile or JwksURL provides the verification keys; exactly one is required
+	JwksFile        string        `mapstructure:"jwksFile" json:"jwksFile,omitempty"`
+	JwksURL         string        `mapstructure:"jwksUrl" json:"jwksUrl,omitempty"`
+	RefreshInterval time.Duration `mapstructure:"refreshInterval" json:"refreshInterval,omitempty"`
+	// Issuer is the required "iss" claim
+	Issuer string `mapstructure:"issuer" json:"issuer,omitempty"`
+	// Audiences accepted in the "aud" claim; any one of them must be present
+	Audiences []string `mapstructure:"audiences" json:"audiences,omitempty"`
+	// Algorithms restricts the accepted signature algorithms
+	Algorithms []string      `mapstructure:"algorithms" json:"algorithms,omitempty"`
+	ClockSkew  time.Duration `mapstructure:"clockSkew" json:"clockSkew,omitempty"`
+	// RequiredScopes must all be granted by the "scope" or "scp" claim
+	RequiredScopes []string `mapstructure:"requiredScopes" json:"requiredScopes,omitempty"`
+	// RequiredClaims maps claim names (dotted paths for nested claims) to the
+	// value they must have or, for array claims, contain
+	RequiredClaims map[string]string `mapstructure:"requiredClaims" json:"requiredClaims,omitempty"`
+	// ForwardClaims maps claim names to the header they are forwarded upstream in
+	ForwardClaims map[string]string `mapstructure:"forwardClaims" json:"forwardClaims,omitempty"`
+	Realm         string            `mapstructure:"realm" json:"realm,omitempty"`
+}
+
+// remoteKeySetKey identifies the key set of a JWKS URL and refresh interval
+type remoteKeySetKey struct {
+	url             string
+	refreshInterval time.Duration
+}
+
+var (
+	remoteKeySetsMutex sync.Mutex
+	// remoteKeySets are kept across config reloads, which rebuild every
+	// filter, so that reloads do not refetch every JWKS
+	remoteKeySets = make(map[remoteKeySetKey]*KeySet)
+)
+
+// getRemoteKeySet returns the shared key set of a JWKS URL
+func getRemoteKeySet(url string, refreshInterval time.Duration) *KeySet {
+	key := remoteKeySetKey{url: url, refreshInterval: refreshInterval}
+	remoteKeySetsMutex.Lock()
+	defer remoteKeySetsMutex.Unlock()
+	ks, ok := remoteKeySets[key]
+	if !ok {
+		ks = NewRemoteKeySet(url, refreshInterval, nil)
+		remoteKeySets[key] = ks
+	}
+	return ks
+}
+
+// jwtVerifier verifies signed JWTs and their registered claims
+type jwtVerifier struct {
+	keys       *KeySet
+	issuer     string
+	audiences  []string
+	algorithms map[string]bool
+	clockSkew  time.Duration
+	now        func() time.Time
+}
+
+// JwtAuthFilter authenticates bearer tokens signed with keys from a JWKS
+type JwtAuthFilter struct {
+	verifier       *jwtVerifier
+	requiredScopes []string
+	requiredClaims map[string]string
+	forwardClaims  map[string]string
+	realm          string
+}
+
+// NewJwtAuthFilter creates a JwtAuth filter from its args
+func NewJwtAuthFilter(args interface{}) (middleware.Middleware, error) {
+	cfg := JwtAuthArgs{
+		RefreshInterval: DefaultJwksRefreshInterval,
+		ClockSkew:       DefaultJwtClockSkew,
+		Algorithms:      supportedJwtAlgorithms,
+		Realm:           DefaultAuthRealm,
+	}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+
+	var keys *KeySet
+	switch {
+	case cfg.JwksFile != "" && cfg.JwksURL != "":
+		return nil, fmt.Errorf("jwksFile and jwksUrl are mutually exclusive")
+	case cfg.JwksFile != "":
+		fileKeys, err := NewFileKeySet(cfg.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = fileKeys
+	case cfg.JwksURL != "":
+		keys = getRemoteKeySet(cfg.JwksURL, cfg.RefreshInterval)
+	default:
+		return nil, fmt.Errorf("jwksFile or jwksUrl is required")
+	}
+
+	verifier, err := newJwtVerifier(keys, cfg.Issuer, cfg.Audiences, cfg.Algorithms, cfg.ClockSkew)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JwtAuthFilter{
+		verifier:       verifier,
+		requiredScopes: cfg.RequiredScopes,
+		requiredClaims: cfg.RequiredClaims,
+		forwardClaims:  cfg.ForwardClaims,
+		realm:          cfg.Realm,
+	}, nil
+}
+
+// newJwtVerifier creates a verifier accepting the given algorithms
+func newJwtVerifier(keys *KeySet, issuer string, audiences []string, algorithms []string, clockSkew time.Duration) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		keys:       keys,
+		issuer:     issuer,
+		audiences:  audiences,
+		algorithms: make(map[string]bool),
+		clockSkew:  clockSkew,
+		now:        time.Now,
+	}
+
+	for _, alg := range algorithms {
+		supported := false
+		for _, s := range supportedJwtAlgorithms {
+			supported = supported || s == alg
+		}
+		if !supported {
+			return nil, fmt.Errorf("unsupported algorithm %q", alg)
+		}
+		v.algorithms[alg] = true
+	}
+	return v, nil
+}
+
+// Name returns the filter name
+func (f *JwtAuthFilter) Name() string {
+	return "JwtAuth"
+}
+
+// PreHandle validates the bearer token of the request
+func (f *JwtAuthFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	for _, header := range f.forwardClaims {
+		ctx.Request.Header.Del(header)
+	}
+
+	token, ok := BearerToken(ctx.Request)
+	if !ok {
+		f.reject(ctx, http.StatusUnauthorized, "", "")
+		return false
+	}
+
+	claims, err := f.verifier.Verify(token)
+	if err != nil {
+		f.reject(ctx, http.StatusUnauthorized, "invalid_token", err.Error())
+		return false
+	}
+
+	if missing := MissingScopes(claims, f.requiredScopes); len(missing) > 0 {
+		f.reject(ctx, http.StatusForbidden, "insufficient_scope", "missing scope "+strings.Join(missing, " "))
+		return false
+	}
+	for name, want := range f.requiredClaims {
+		if !ClaimMatches(LookupClaim(claims, name), want) {
+			f.reject(ctx, http.StatusForbidden, "insufficient_scope", "claim "+name+" not allowed")
+			return false
+		}
+	}
+
+	for name, header := range f.forwardClaims {
+		if value := LookupClaim(claims, name); value != nil {
+			ctx.Request.Header.Set(header, FormatClaim(value))
+		}
+	}
+
+	subject, _ := claims["sub"].(string)
+	SetIdentity(ctx, &Identity{Subject: subject, Method: "jwt", Claims: claims})
+	return true
+}
+
+// PostHandle does nothing
+func (f *JwtAuthFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *JwtAuthFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// Verify checks the signature and registered claims of a compact JWS
+func (v *jwtVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	if !v.algorithms[header.Alg] {
+		return nil, fmt.Errorf("algorithm %q not allowed", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+
+	keys, err := v.keys.Keys(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if key.Algorithm != "" && key.Algorithm != header.Alg {
+			continue
+		}
+		if verifySignature(header.Alg, key.Key, signingInput, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// validateClaims checks exp, nbf, iss and aud
+func (v *jwtVerifier) validateClaims(claims map[string]interface{}) error {
+	now := v.now()
+
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.After(time.Unix(exp, 0).Add(v.clockSkew)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(v.clockSkew).Before(time.Unix(nbf, 0)) {
+		return fmt.Errorf("token not yet valid")
+	}
+
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("invalid issuer")
+		}
+	}
+
+	if len(v.audiences) > 0 {
+		accepted := false
+		for _, aud := range v.audiences {
+			accepted = accepted || ClaimMatches(claims["aud"], aud)
+		}
+		if !accepted {
+			return fmt.Errorf("invalid audience")
+		}
+	}
+	return nil
+}
+
+func (f *JwtAuthFilter) reject(ctx *middleware.GatewayContext, status int, errorCode string, description string) {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.ErrorTotal.WithLabelValues("jwt_rejected", routeID).Inc()
+
+	challenge := BearerChallenge(f.realm, errorCode, description)
+	if status == http.StatusForbidden && len(f.requiredScopes) > 0 {
+		challenge += fmt.Sprintf(`, scope="%s"`, strings.Join(f.requiredScopes, " "))
+	}
+	ctx.Response.Header().Set("WWW-Authenticate", challenge)
+	http.Error(ctx.Response, http.StatusText(status), status)
+}
+
+// BearerToken extracts the bearer token of the Authorization header
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// BearerChallenge formats a RFC 6750 WWW-Authenticate challenge
+func BearerChallenge(realm string, errorCode string, description string) string {
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, realm)
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error="%s"`, errorCode)
+	}
+	if description != "" {
+		challenge += fmt.Sprintf(`, error_description="%s"`, strings.ReplaceAll(description, `"`, `'`))
+	}
+	return challenge
+}
+
+// MissingScopes returns the required scopes not granted by the "scope"
+// (space separated) or "scp" (array) claim
+func MissingScopes(claims map[string]interface{}, required []string) []string {
+	granted := make(map[string]bool)
+	if scope, ok := claims["scope"].(string); ok {
+		for _, s := range strings.Fields(scope) {
+			granted[s] = true
+		}
+	}
+	if scp, ok := claims["scp"].([]interface{}); ok {
+		for _, s := range scp {
+			if str, ok := s.(string); ok {
+				granted[str] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, s := range required {
+		if !granted[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// LookupClaim resolves a dotted claim path such as "realm_access.roles"
+func LookupClaim(claims map[string]interface{}, name string) interface{} {
+	if value, ok := claims[name]; ok {
+		return value
+	}
+
+	var current interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+// ClaimMatches reports whether a claim equals want or, for arrays, contains it
+func ClaimMatches(value interface{}, want string) bool {
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if ClaimMatches(v, want) {
+				return true
+			}
+		}
+		return false
+	}
+	return value != nil && FormatClaim(value) == want
+}
+
+// FormatClaim renders a claim value as a header value
+func FormatClaim(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = FormatClaim(item)
+		}
+		return strings.Join(parts, ",")
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	switch v := claims[name].(type) {
+	case float64:
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// verifySignature verifies a JWS signature; the key type must match the algorithm
+func verifySignature(alg string, key interface{}, signingInput []byte, signature []byte) bool {
+	digest := sha256.Sum256(signingInput)
+
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(pub, digest[:], r, s)
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// signJWT creates a compact JWS for the given key
+func signJWT(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = sig
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	}
+	return signingInput + "." + b64(signature)
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]interface{} {
+	return map[string]interface{}{
+		"kty": "RSA", "kid": kid, "alg": "RS256", "use": "sig",
+		"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]interface{} {
+	return map[string]interface{}{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": b64(key.X.FillBytes(make([]byte, 32))), "y": b64(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func jwksDocument(keys ...map[string]interface{}) []byte {
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return data
+}
+
+func newJwtContext(token string) *middleware.GatewayContext {
+	req := httptest.NewRequest("GET", "http://gateway/api/orders", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("X-User", "spoofed")
+	return &middleware.GatewayContext{
+		Request:    req,
+		Response:   httptest.NewRecorder(),
+		Route:      &common.Route{ID: "orders"},
+		Attributes: make(map[string]interface{}),
+	}
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "alice",
+		"iss":   "https://issuer.example",
+		"aud":   []interface{}{"orders-api"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nbf":   time.Now().Add(-time.Minute).Unix(),
+		"scope": "orders:read orders:write",
+		"tenant": map[string]interface{}{
+			"id": "acme",
+		},
+	}
+}
+
+// TestJwtAuthFilter tests JWT validation against a local JWKS file
+func TestJwtAuthFilter(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	doc := jwksDocument(rsaJWK("rsa-1", rsaKey), ecJWK("ec-1", ecKey),
+		map[string]interface{}{"kty": "oct", "kid": "hmac-1", "k": b64(secret)})
+	if err := os.WriteFile(jwksFile, doc, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewJwtAuthFilter(map[string]interface{}{
+		"jwksFile":       jwksFile,
+		"issuer":         "https://issuer.example",
+		"audiences":      []interface{}{"orders-api"},
+		"requiredScopes": []interface{}{"orders:read"},
+		"requiredClaims": map[string]interface{}{"tenant.id": "acme"},
+		"forwardClaims":  map[string]interface{}{"sub": "X-User", "scope": "X-Scopes"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	t.Run("TestValidTokens", func(t *testing.T) {
+		tokens := map[string]string{
+			"RS256": signJWT(t, "RS256", "rsa-1", rsaKey, validClaims()),
+			"ES256": signJWT(t, "ES256", "ec-1", ecKey, validClaims()),
+			"HS256": signJWT(t, "HS256", "hmac-1", secret, validClaims()),
+		}
+		for alg, token := range tokens {
+			ctx := newJwtContext(token)
+			if !f.PreHandle(ctx) {
+				t.Errorf("%s: expected token to be accepted, got %d %s", alg, ctx.Response.(*httptest.ResponseRecorder).Code,
+					ctx.Response.Header().Get("WWW-Authenticate"))
+				continue
+			}
+			if got := ctx.Request.Header.Get("X-User"); got != "alice" {
+				t.Errorf("%s: expected X-User 'alice', got %q", alg, got)
+			}
+			if identity, ok := GetIdentity(ctx); !ok || identity.Subject != "alice" {
+				t.Errorf("%s: expected identity alice, got %+v", alg, identity)
+			}
+		}
+	})
+
+	t.Run("TestRejectedTokens", func(t *testing.T) {
+		expired := validClaims()
+		expired["exp"] = time.Now().Add(-time.Hour).Unix()
+		notYet := validClaims()
+		notYet["nbf"] = time.Now().Add(time.Hour).Unix()
+		wrongIssuer := validClaims()
+		wrongIssuer["iss"] = "https://evil.example"
+		wrongAudience := validClaims()
+		wrongAudience["aud"] = "billing-api"
+		otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+		tokens := map[string]string{
+			"missing":        "",
+			"malformed":      "not-a-jwt",
+			"expired":        signJWT(t, "RS256", "rsa-1", rsaKey, expired),
+			"not yet valid":  signJWT(t, "RS256", "rsa-1", rsaKey, notYet),
+			"wrong issuer":   signJWT(t, "RS256", "rsa-1", rsaKey, wrongIssuer),
+			"wrong audience": signJWT(t, "RS256", "rsa-1", rsaKey, wrongAudience),
+			"bad signature":  signJWT(t, "RS256", "rsa-1", otherKey, validClaims()),
+			// An HMAC token must not verify with the RSA key id
+			"alg confusion": signJWT(t, "HS256", "rsa-1", secret, validClaims()),
+		}
+		for name, token := range tokens {
+			ctx := newJwtContext(token)
+			if f.PreHandle(ctx) {
+				t.Errorf("%s: expected token to be rejected", name)
+				continue
+			}
+			if code := ctx.Response.(*httptest.ResponseRecorder).Code; code != http.StatusUnauthorized {
+				t.Errorf("%s: expected status 401, got %d", name, code)
+			}
+			if !strings.HasPrefix(ctx.Response.Header().Get("WWW-Authenticate"), `Bearer realm="gateway"`) {
+				t.Errorf("%s: expected Bearer challenge, got %q", name, ctx.Response.Header().Get("WWW-Authenticate"))
+			}
+			if ctx.Request.Header.Get("X-User") != "" {
+				t.Errorf("%s: expected client supplied X-User to be removed", name)
+			}
+		}
+	})
+
+	t.Run("TestInsufficientScope", func(t *testing.T) {
+		claims := validClaims()
+		claims["scope"] = "orders:write"
+		ctx := newJwtContext(signJWT(t, "RS256", "rsa-1", rsaKey, claims))
+		if f.PreHandle(ctx) {
+			t.Fatal("Expected token without required scope to be rejected")
+		}
+		if code := ctx.Response.(*httptest.ResponseRecorder).Code; code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", code)
+		}
+		if challenge := ctx.Response.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, `error="insufficient_scope"`) {
+			t.Errorf("Expected insufficient_scope challenge, got %q", challenge)
+		}
+
+		claims = validClaims()
+		claims["tenant"] = map[string]interface{}{"id": "globex"}
+		ctx = newJwtContext(signJWT(t, "RS256", "rsa-1", rsaKey, claims))
+		if f.PreHandle(ctx) || ctx.Response.(*httptest.ResponseRecorder).Code != http.StatusForbidden {
+			t.Error("Expected token with wrong tenant to be rejected with 403")
+		}
+	})
+
+	t.Run("TestInvalidArgs", func(t *testing.T) {
+		if _, err := NewJwtAuthFilter(map[string]interface{}{}); err == nil {
+			t.Error("Expected error without key source")
+		}
+		if _, err := NewJwtAuthFilter(map[string]interface{}{"jwksFile": jwksFile, "algorithms": "none"}); err == nil {
+			t.Error("Expected error for unsupported algorithm")
+		}
+	})
+}
+
+// TestRemoteKeySetRotation tests that a rotated signing key is picked up
+func TestRemoteKeySetRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var mutex sync.Mutex
+	current := jwksDocument(rsaJWK("key-1", oldKey))
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		fetches++
+		w.Write(current)
+	}))
+	defer server.Close()
+
+	f, err := NewJwtAuthFilter(map[string]interface{}{"jwksUrl": server.URL, "refreshInterval": "1h"})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	if !f.PreHandle(newJwtContext(signJWT(t, "RS256", "key-1", oldKey, validClaims()))) {
+		t.Fatal("Expected token signed with initial key to be accepted")
+	}
+	if !f.PreHandle(newJwtContext(signJWT(t, "RS256", "key-1", oldKey, validClaims()))) {
+		t.Fatal("Expected cached key to be used")
+	}
+
+	// A reload rebuilds the filter but keeps the fetched keys
+	reloaded, err := NewJwtAuthFilter(map[string]interface{}{"jwksUrl": server.URL, "refreshInterval": "1h"})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	if !reloaded.PreHandle(newJwtContext(signJWT(t, "RS256", "key-1", oldKey, validClaims()))) {
+		t.Fatal("Expected the rebuilt filter to use the cached key")
+	}
+
+	mutex.Lock()
+	current = jwksDocument(rsaJWK("key-1", oldKey), rsaJWK("key-2", newKey))
+	mutex.Unlock()
+
+	// Allow the unknown kid to trigger a refresh
+	f.(*JwtAuthFilter).verifier.keys.lastAttempt = time.Time{}
+	if !f.PreHandle(newJwtContext(signJWT(t, "RS256", "key-2", newKey, validClaims()))) {
+		t.Fatal("Expected token signed with rotated key to be accepted")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fetches != 2 {
+		t.Errorf("Expected 2 JWKS fetches, got %d", fetches)
+	}
+}
+
+// TestRemoteKeySetSlowRefresh tests that a slow JWKS fetch does not hold up
+// verifications with the cached keys
+func TestRemoteKeySetSlowRefresh(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	fetching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			fetching <- struct{}{}
+			<-release
+		}
+		w.Write(jwksDocument(rsaJWK("key-1", key)))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	f, err := NewJwtAuthFilter(map[string]interface{}{"jwksUrl": server.URL, "refreshInterval": "1h"})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	token := signJWT(t, "RS256", "key-1", key, validClaims())
+	if !f.PreHandle(newJwtContext(token)) {
+		t.Fatal("Expected token to be accepted")
+	}
+
+	// Make the keys stale so that the next request refetches them
+	keys := f.(*JwtAuthFilter).verifier.keys
+	keys.mutex.Lock()
+	keys.lastAttempt = time.Time{}
+	keys.loadedAt = time.Now().Add(-2 * time.Hour)
+	keys.mutex.Unlock()
+	go f.PreHandle(newJwtContext(token))
+	<-fetching
+
+	done := make(chan bool, 1)
+	go func() { done <- f.PreHandle(newJwtContext(token)) }()
+	select {
+	case accepted := <-done:
+		if !accepted {
+			t.Error("Expected token to be accepted with the cached keys")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected verification not to wait for the JWKS fetch")
+	}
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	// Embedded SQL driver for the "sqlite" key store
+	_ "modernc.org/sqlite"
+)
+
+// ErrKeyNotFound is returned by a KeyStore for unknown keys
+var ErrKeyNotFound = errors.New("api key not found")
+
+// fileKeyStoreCheckInterval bounds how often the key file is checked for changes
+const fileKeyStoreCheckInterval = time.Second
+
+// APIKey is a stored API key. Only the hash of the key is ever stored.
+type APIKey struct {
+	Hash      string    `json:"hash"`
+	Consumer  string    `json:"consumer"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Revoked   bool      `json:"revoked,omitempty"`
+}
+
+// Valid reports whether the key is neither revoked nor expired
+func (k *APIKey) Valid(now time.Time) bool {
+	return !k.Revoked && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
+
+// KeyStore looks up API keys by hash
+type KeyStore interface {
+	// Lookup returns the key with the given hash or ErrKeyNotFound
+	Lookup(hash string) (*APIKey, error)
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash under which a key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// FileKeyStore is a KeyStore backed by a JSON file of the form
+// {"keys": [{"hash": "...", "consumer": "...", "expires_at": "...", "revoked": false}]}.
+// The file is reloaded when it changes, so keys can be added and revoked
+// without restarting the gateway.
+type FileKeyStore struct {
+	path string
+
+	mutex     sync.RWMutex
+	keys      map[string]APIKey
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewFileKeyStore creates a file backed key store
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	store := &FileKeyStore{path: path}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Lookup returns the key with the given hash
+func (s *FileKeyStore) Lookup(hash string) (*APIKey, error) {
+	s.mutex.RLock()
+	stale := time.Since(s.checkedAt) > fileKeyStoreCheckInterval
+	s.mutex.RUnlock()
+	if stale {
+		if err := s.reload(); err != nil {
+			// Keep serving the last good key set
+			log.Printf("Error reloading api key file: %v", err)
+		}
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	key, ok := s.keys[strings.ToLower(hash)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &key, nil
+}
+
+func (s *FileKeyStore) reload() error {
+	s.mutex.Lock()
+	s.checkedAt = time.Now()
+	s.mutex.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("error reading api key file: %w", err)
+	}
+
+	s.mutex.RLock()
+	unchanged := s.keys != nil && info.ModTime().Equal(s.modTime)
+	s.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("error reading api key file: %w", err)
+	}
+	var doc struct {
+		Keys []APIKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("error parsing api key file: %w", err)
+	}
+
+	keys := make(map[string]APIKey, len(doc.Keys))
+	for _, key := range doc.Keys {
+		if key.Hash == "" || key.Consumer == "" {
+			return fmt.Errorf("api key entries require hash and consumer")
+		}
+		key.Hash = strings.ToLower(strings.TrimPrefix(key.Hash, "sha256:"))
+		keys[key.Hash] = key
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// tableNamePattern restricts SQL key store table names to plain identifiers
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLKeyStore is a KeyStore backed by a SQL table. Every lookup queries the
+// table, so revocations take effect immediately.
+type SQLKeyStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLKeyStore creates a key store on the given table and creates the table if needed.
+// Queries use "?" placeholders (SQLite, MySQL).
+func NewSQLKeyStore(db *sql.DB, table string) (*SQLKeyStore, error) {
+	if !tableNamePattern.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+
+	store := &SQLKeyStore{db: db, table: table}
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key_hash   VARCHAR(64) PRIMARY KEY,
+		consumer   VARCHAR(255) NOT NULL,
+		expires_at TIMESTAMP NULL,
+		revoked    BOOLEAN NOT NULL DEFAULT FALSE
+	)`, table))
+	if err != nil {
+		return nil, fmt.Errorf("error creating api key table: %w", err)
+	}
+	return store, nil
+}
+
+// Lookup returns the key with the given hash
+func (s *SQLKeyStore) Lookup(hash string) (*APIKey, error) {
+	var (
+		key       = APIKey{Hash: strings.ToLower(hash)}
+		expiresAt sql.NullTime
+	)
+	row := s.db.QueryRow(fmt.Sprintf("SELECT consumer, expires_at, revoked FROM %s WHERE key_hash = ?", s.table), key.Hash)
+	if err := row.Scan(&key.Consumer, &expiresAt, &key.Revoked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = expiresAt.Time
+	}
+	return &key, nil
+}
+
+// AddKey stores the hash of a new key
+func (s *SQLKeyStore) AddKey(key APIKey) error {
+	var expiresAt sql.NullTime
+	if !key.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: key.ExpiresAt, Valid: true}
+	}
+	_, err := s.db.Exec(fmt.Sprintf("INSERT INTO %s (key_hash, consumer, expires_at, revoked) VALUES (?, ?, ?, ?)", s.table),
+		strings.ToLower(key.Hash), key.Consumer, expiresAt, key.Revoked)
+	return err
+}
+
+// RevokeKey revokes the key with the given hash
+func (s *SQLKeyStore) RevokeKey(hash string) error {
+	result, err := s.db.Exec(fmt.Sprintf("UPDATE %s SET revoked = TRUE WHERE key_hash = ?", s.table), strings.ToLower(hash))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("ClientCertAuth", NewClientCertFilter, ClientCertArgs{})
+}
+
+// Default headers used to forward the verified certificate identity upstream
+const (
+	DefaultClientCertSubjectHeader = "X-Client-Cert-Subject"
+	DefaultClientCertSANHeader     = "X-Client-Cert-SAN"
+)
+
+// ClientCertArgs configures the ClientCertAuth filter
+type ClientCertArgs struct {
+	// CAFile optionally restricts the route to certificates issued by this CA bundle.
+	// Without it the chain verified by the TLS listener is used.
+	CAFile string `mapstructure:"caFile" json:"caFile,omitempty"`
+	// AllowedSubjects lists subject patterns such as "CN=billing-service,O=Acme".
+	// Every attribute of a pattern must match; values may use glob wildcards.
+	AllowedSubjects []string `mapstructure:"allowedSubjects" json:"allowedSubjects,omitempty"`
+	// AllowedSANs lists DNS, URI, email or IP SAN patterns; values may use glob wildcards
+	AllowedSANs []string `mapstructure:"allowedSANs" json:"allowedSANs,omitempty"`
+	// ForwardHeaders maps identity fields (subject, san, fingerprint, serial, cert)
+	// to the request header they are forwarded in
+	ForwardHeaders map[string]string `mapstructure:"forwardHeaders" json:"forwardHeaders,omitempty"`
+}
+
+// ClientCertFilter authenticates callers by their TLS client certificate
+type ClientCertFilter struct {
+	roots          *x509.CertPool
+	subjects       []map[string]string
+	sans           []string
+	forwardHeaders map[string]string
+}
+
+// NewClientCertFilter creates a ClientCertAuth filter from its args
+func NewClientCertFilter(args interface{}) (middleware.Middleware, error) {
+	var cfg ClientCertArgs
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+
+	f := &ClientCertFilter{
+		sans:           cfg.AllowedSANs,
+		forwardHeaders: cfg.ForwardHeaders,
+	}
+
+	if cfg.CAFile != "" {
+		pemData, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading caFile: %w", err)
+		}
+		f.roots = x509.NewCertPool()
+		if !f.roots.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("caFile %s contains no certificates", cfg.CAFile)
+		}
+	}
+
+	for _, pattern := range cfg.AllowedSubjects {
+		attrs, err := parseSubjectPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.subjects = append(f.subjects, attrs)
+	}
+
+	if f.forwardHeaders == nil {
+		f.forwardHeaders = map[string]string{
+			"subject": DefaultClientCertSubjectHeader,
+			"san":     DefaultClientCertSANHeader,
+		}
+	}
+	for field := range f.forwardHeaders {
+		switch field {
+		case "subject", "san", "fingerprint", "serial", "cert":
+		default:
+			return nil, fmt.Errorf("unknown forwardHeaders field %q", field)
+		}
+	}
+
+	return f, nil
+}
+
+// Name returns the filter name
+func (f *ClientCertFilter) Name() string {
+	return "ClientCertAuth"
+}
+
+// PreHandle verifies the client certificate and forwards its identity
+func (f *ClientCertFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	// Never trust identity headers supplied by the client itself
+	for _, header := range f.forwardHeaders {
+		ctx.Request.Header.Del(header)
+	}
+
+	cert, err := f.verifiedCertificate(ctx.Request)
+	if err != nil {
+		f.reject(ctx, http.StatusUnauthorized, err.Error())
+		return false
+	}
+
+	if !f.allowed(cert) {
+		f.reject(ctx, http.StatusForbidden, "client certificate not allowed")
+		return false
+	}
+
+	sans := certificateSANs(cert)
+	for field, header := range f.forwardHeaders {
+		switch field {
+		case "subject":
+			ctx.Request.Header.Set(header, cert.Subject.String())
+		case "san":
+			ctx.Request.Header.Set(header, strings.Join(sans, ","))
+		case "fingerprint":
+			sum := sha256.Sum256(cert.Raw)
+			ctx.Request.Header.Set(header, hex.EncodeToString(sum[:]))
+		case "serial":
+			ctx.Request.Header.Set(header, cert.SerialNumber.String())
+		case "cert":
+			block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			ctx.Request.Header.Set(header, url.QueryEscape(string(block)))
+		}
+	}
+
+	SetIdentity(ctx, &Identity{
+		Subject: cert.Subject.String(),
+		Method:  "mtls",
+		Claims: map[string]interface{}{
+			"san": sans,
+		},
+	})
+	return true
+}
+
+// PostHandle does nothing
+func (f *ClientCertFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *ClientCertFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// verifiedCertificate returns the verified leaf certificate of the request
+func (f *ClientCertFilter) verifiedCertificate(r *http.Request) (*x509.Certificate, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("client certificate required")
+	}
+
+	if f.roots == nil {
+		if len(r.TLS.VerifiedChains) == 0 {
+			return nil, fmt.Errorf("client certificate not verified")
+		}
+		return r.TLS.VerifiedChains[0][0], nil
+	}
+
+	leaf := r.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         f.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("client certificate not verified")
+	}
+	return leaf, nil
+}
+
+// allowed checks the certificate against the configured subject and SAN patterns.
+// With no patterns configured any verified certificate is allowed.
+func (f *ClientCertFilter) allowed(cert *x509.Certificate) bool {
+	if len(f.subjects) == 0 && len(f.sans) == 0 {
+		return true
+	}
+
+	attrs := subjectAttributes(cert.Subject)
+	for _, pattern := range f.subjects {
+		if matchSubject(pattern, attrs) {
+			return true
+		}
+	}
+
+	for _, san := range certificateSANs(cert) {
+		for _, pattern := range f.sans {
+			if matched, _ := path.Match(pattern, san); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *ClientCertFilter) reject(ctx *middleware.GatewayContext, status int, message string) {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.ErrorTotal.WithLabelValues("client_cert_rejected", routeID).Inc()
+	http.Error(ctx.Response, message, status)
+}
+
+// parseSubjectPattern parses "CN=a,O=b" into attribute pairs
+func parseSubjectPattern(pattern string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(pattern, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid subject pattern %q", pattern)
+		}
+		attrs[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return attrs, nil
+}
+
+// subjectAttributes flattens a certificate subject into attribute values
+func subjectAttributes(name pkix.Name) map[string][]string {
+	attrs := map[string][]string{
+		"C":          name.Country,
+		"O":          name.Organization,
+		"OU":         name.OrganizationalUnit,
+		"L":          name.Locality,
+		"ST":         name.Province,
+		"STREET":     name.StreetAddress,
+		"POSTALCODE": name.PostalCode,
+	}
+	if name.CommonName != "" {
+		attrs["CN"] = []string{name.CommonName}
+	}
+	if name.SerialNumber != "" {
+		attrs["SERIALNUMBER"] = []string{name.SerialNumber}
+	}
+	return attrs
+}
+
+func matchSubject(pattern map[string]string, attrs map[string][]string) bool {
+	for key, want := range pattern {
+		found := false
+		for _, value := range attrs[key] {
+			if matched, _ := path.Match(want, value); matched {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// certificateSANs returns all subject alternative names of the certificate
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := make([]string, 0, len(cert.DNSNames)+len(cert.URIs)+len(cert.EmailAddresses)+len(cert.IPAddresses))
+	sans = append(sans, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return sans
+}
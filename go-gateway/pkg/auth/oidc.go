@@ -0,0 +1,460 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("OidcLogin", NewOidcLoginFilter, OidcLoginArgs{})
+}
+
+// Default settings of the OidcLogin filter
+const (
+	DefaultOidcCookieName = "gw_session"
+	DefaultOidcSessionTTL = 8 * time.Hour
+	oidcStateTTL          = 10 * time.Minute
+	// minDiscoveryRetryInterval is how long a failed discovery is not retried
+	minDiscoveryRetryInterval = 5 * time.Second
+)
+
+// OidcLoginArgs configures the OidcLogin filter
+type OidcLoginArgs struct {
+	// Issuer is the OpenID provider; its discovery document is fetched from
+	// <issuer>/.well-known/openid-configuration
+	Issuer       string `mapstructure:"issuer" json:"issuer"`
+	ClientID     string `mapstructure:"clientId" json:"clientId"`
+	ClientSecret string `mapstructure:"clientSecret" json:"clientSecret,omitempty"`
+	// RedirectURL is the callback URL registered at the provider. Its path must
+	// be matched by the route the filter is attached to.
+	RedirectURL string   `mapstructure:"redirectUrl" json:"redirectUrl"`
+	Scopes      []string `mapstructure:"scopes" json:"scopes,omitempty"`
+	// CookieSecret encrypts the session cookie; at least 32 characters
+	CookieSecret string        `mapstructure:"cookieSecret" json:"cookieSecret"`
+	CookieName   string        `mapstructure:"cookieName" json:"cookieName,omitempty"`
+	CookieSecure bool          `mapstructure:"cookieSecure" json:"cookieSecure,omitempty"`
+	SessionTTL   time.Duration `mapstructure:"sessionTtl" json:"sessionTtl,omitempty"`
+	// LogoutPath clears the session cookie when requested
+	LogoutPath string `mapstructure:"logoutPath" json:"logoutPath,omitempty"`
+	// ForwardClaims maps ID token claims to the header they are forwarded in
+	ForwardClaims map[string]string `mapstructure:"forwardClaims" json:"forwardClaims,omitempty"`
+}
+
+// OidcLoginFilter authenticates browser sessions with the OpenID Connect
+// authorization code flow and keeps them in an encrypted cookie
+type OidcLoginFilter struct {
+	issuer        string
+	clientID      string
+	clientSecret  string
+	redirectURL   string
+	callbackPath  string
+	scopes        []string
+	codec         *sessionCodec
+	cookieName    string
+	stateCookie   string
+	cookieSecure  bool
+	sessionTTL    time.Duration
+	logoutPath    string
+	forwardClaims map[string]string
+	client        *http.Client
+	now           func() time.Time
+
+	mutex    sync.Mutex
+	provider *oidcProvider
+	// discovering is the discovery in progress, which runs without the lock
+	discovering *providerFetch
+	// failedAt and failure hold the last failed discovery
+	failedAt time.Time
+	failure  error
+}
+
+// providerFetch is a discovery that concurrent logins wait for
+type providerFetch struct {
+	done     chan struct{}
+	provider *oidcProvider
+	err      error
+}
+
+// oidcProvider holds the endpoints of the discovery document
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	verifier              *jwtVerifier
+}
+
+// oidcSession is stored in the session cookie
+type oidcSession struct {
+	Subject string                 `json:"sub"`
+	Claims  map[string]interface{} `json:"claims,omitempty"`
+	Expires int64                  `json:"exp"`
+}
+
+// oidcState is stored in the state cookie during login
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Redirect string `json:"redirect"`
+	Expires  int64  `json:"exp"`
+}
+
+// NewOidcLoginFilter creates an OidcLogin filter from its args
+func NewOidcLoginFilter(args interface{}) (middleware.Middleware, error) {
+	cfg := OidcLoginArgs{
+		Scopes:       []string{"openid", "profile", "email"},
+		CookieName:   DefaultOidcCookieName,
+		CookieSecure: true,
+		SessionTTL:   DefaultOidcSessionTTL,
+	}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("issuer and clientId are required")
+	}
+	redirect, err := url.Parse(cfg.RedirectURL)
+	if err != nil || !redirect.IsAbs() {
+		return nil, fmt.Errorf("redirectUrl must be an absolute URL")
+	}
+	codec, err := newSessionCodec(cfg.CookieSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OidcLoginFilter{
+		issuer:        strings.TrimSuffix(cfg.Issuer, "/"),
+		clientID:      cfg.ClientID,
+		clientSecret:  cfg.ClientSecret,
+		redirectURL:   cfg.RedirectURL,
+		callbackPath:  redirect.Path,
+		scopes:        cfg.Scopes,
+		codec:         codec,
+		cookieName:    cfg.CookieName,
+		stateCookie:   cfg.CookieName + "_state",
+		cookieSecure:  cfg.CookieSecure,
+		sessionTTL:    cfg.SessionTTL,
+		logoutPath:    cfg.LogoutPath,
+		forwardClaims: cfg.ForwardClaims,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		now:           time.Now,
+	}, nil
+}
+
+// Name returns the filter name
+func (f *OidcLoginFilter) Name() string {
+	return "OidcLogin"
+}
+
+// PreHandle handles the login callback, validates the session cookie or
+// starts the login flow
+func (f *OidcLoginFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	for _, header := range f.forwardClaims {
+		ctx.Request.Header.Del(header)
+	}
+
+	switch ctx.Request.URL.Path {
+	case f.callbackPath:
+		f.handleCallback(ctx)
+		return false
+	case f.logoutPath:
+		if f.logoutPath != "" {
+			f.setCookie(ctx.Response, f.cookieName, "", -1)
+			http.Redirect(ctx.Response, ctx.Request, "/", http.StatusFound)
+			return false
+		}
+	}
+
+	if cookie, err := ctx.Request.Cookie(f.cookieName); err == nil {
+		var session oidcSession
+		if err := f.codec.Open(f.cookieName, cookie.Value, &session); err == nil && f.now().Unix() < session.Expires {
+			for name, header := range f.forwardClaims {
+				if value := LookupClaim(session.Claims, name); value != nil {
+					ctx.Request.Header.Set(header, FormatClaim(value))
+				}
+			}
+			removeCookies(ctx.Request, f.cookieName, f.stateCookie)
+			SetIdentity(ctx, &Identity{Subject: session.Subject, Method: "oidc", Claims: session.Claims})
+			return true
+		}
+	}
+
+	f.startLogin(ctx)
+	return false
+}
+
+// PostHandle does nothing
+func (f *OidcLoginFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *OidcLoginFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// startLogin redirects browsers to the authorization endpoint
+func (f *OidcLoginFilter) startLogin(ctx *middleware.GatewayContext) {
+	r := ctx.Request
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(ctx.Response, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	provider, err := f.discover()
+	if err != nil {
+		f.fail(ctx, "oidc_discovery_failed", err)
+		return
+	}
+
+	state := oidcState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Redirect: r.URL.RequestURI(),
+		Expires:  f.now().Add(oidcStateTTL).Unix(),
+	}
+	sealed, err := f.codec.Seal(f.stateCookie, state)
+	if err != nil {
+		f.fail(ctx, "oidc_login_failed", err)
+		return
+	}
+	f.setCookie(ctx.Response, f.stateCookie, sealed, int(oidcStateTTL.Seconds()))
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {f.clientID},
+		"redirect_uri":  {f.redirectURL},
+		"scope":         {strings.Join(f.scopes, " ")},
+		"state":         {state.State},
+		"nonce":         {state.Nonce},
+	}
+	target := provider.AuthorizationEndpoint
+	if strings.Contains(target, "?") {
+		target += "&" + query.Encode()
+	} else {
+		target += "?" + query.Encode()
+	}
+	http.Redirect(ctx.Response, r, target, http.StatusFound)
+}
+
+// handleCallback exchanges the authorization code and creates the session
+func (f *OidcLoginFilter) handleCallback(ctx *middleware.GatewayContext) {
+	r := ctx.Request
+
+	stateCookie, err := r.Cookie(f.stateCookie)
+	if err != nil {
+		http.Error(ctx.Response, "missing login state", http.StatusBadRequest)
+		return
+	}
+	var state oidcState
+	if err := f.codec.Open(f.stateCookie, stateCookie.Value, &state); err != nil ||
+		f.now().Unix() >= state.Expires || r.URL.Query().Get("state") != state.State {
+		http.Error(ctx.Response, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		http.Error(ctx.Response, "login failed: "+errCode, http.StatusUnauthorized)
+		return
+	}
+
+	provider, err := f.discover()
+	if err != nil {
+		f.fail(ctx, "oidc_discovery_failed", err)
+		return
+	}
+
+	idToken, err := f.exchange(provider, r.URL.Query().Get("code"))
+	if err != nil {
+		f.fail(ctx, "oidc_token_exchange_failed", err)
+		return
+	}
+	claims, err := provider.verifier.Verify(idToken)
+	if err != nil {
+		f.fail(ctx, "oidc_invalid_id_token", err)
+		return
+	}
+	if nonce, _ := claims["nonce"].(string); nonce != state.Nonce {
+		f.fail(ctx, "oidc_invalid_id_token", fmt.Errorf("nonce mismatch"))
+		return
+	}
+
+	// Only keep the claims that are forwarded to keep the cookie small
+	subject, _ := claims["sub"].(string)
+	session := oidcSession{
+		Subject: subject,
+		Claims:  make(map[string]interface{}),
+		Expires: f.now().Add(f.sessionTTL).Unix(),
+	}
+	for name := range f.forwardClaims {
+		if value := LookupClaim(claims, name); value != nil {
+			session.Claims[name] = value
+		}
+	}
+	sealed, err := f.codec.Seal(f.cookieName, session)
+	if err != nil {
+		f.fail(ctx, "oidc_login_failed", err)
+		return
+	}
+
+	f.setCookie(ctx.Response, f.stateCookie, "", -1)
+	f.setCookie(ctx.Response, f.cookieName, sealed, int(f.sessionTTL.Seconds()))
+
+	redirect := state.Redirect
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+	http.Redirect(ctx.Response, r, redirect, http.StatusFound)
+}
+
+// discover returns the provider of the cached discovery document, fetching
+// it on first use. The fetch runs without the lock and concurrent logins wait
+// for it; after a failure logins fail fast for minDiscoveryRetryInterval so
+// that an unavailable provider is not fetched by every login in turn.
+func (f *OidcLoginFilter) discover() (*oidcProvider, error) {
+	f.mutex.Lock()
+	if f.provider != nil {
+		defer f.mutex.Unlock()
+		return f.provider, nil
+	}
+	if fetch := f.discovering; fetch != nil {
+		f.mutex.Unlock()
+		<-fetch.done
+		return fetch.provider, fetch.err
+	}
+	if f.failure != nil && time.Since(f.failedAt) < minDiscoveryRetryInterval {
+		defer f.mutex.Unlock()
+		return nil, f.failure
+	}
+	fetch := &providerFetch{done: make(chan struct{})}
+	f.discovering = fetch
+	f.mutex.Unlock()
+
+	fetch.provider, fetch.err = f.fetchProvider()
+
+	f.mutex.Lock()
+	if fetch.err == nil {
+		f.provider = fetch.provider
+	} else {
+		f.failedAt = time.Now()
+		f.failure = fetch.err
+	}
+	f.discovering = nil
+	f.mutex.Unlock()
+	close(fetch.done)
+	return fetch.provider, fetch.err
+}
+
+// fetchProvider fetches the discovery document and builds the verifier of
+// its ID tokens
+func (f *OidcLoginFilter) fetchProvider() (*oidcProvider, error) {
+	resp, err := f.client.Get(f.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery document returned status %d", resp.StatusCode)
+	}
+
+	var provider oidcProvider
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&provider); err != nil {
+		return nil, fmt.Errorf("error decoding discovery document: %w", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != f.issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", provider.Issuer, f.issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JwksURI == "" {
+		return nil, fmt.Errorf("discovery document is incomplete")
+	}
+
+	keys := NewRemoteKeySet(provider.JwksURI, DefaultJwksRefreshInterval, f.client)
+	verifier, err := newJwtVerifier(keys, provider.Issuer, []string{f.clientID}, []string{"RS256", "ES256"}, DefaultJwtClockSkew)
+	if err != nil {
+		return nil, err
+	}
+	verifier.now = f.now
+	provider.verifier = verifier
+	return &provider, nil
+}
+
+// exchange redeems the authorization code and returns the ID token
+func (f *OidcLoginFilter) exchange(provider *oidcProvider, code string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("missing authorization code")
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {f.redirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(f.clientID), url.QueryEscape(f.clientSecret))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error calling token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("error decoding token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+func (f *OidcLoginFilter) setCookie(w http.ResponseWriter, name string, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   f.cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (f *OidcLoginFilter) fail(ctx *middleware.GatewayContext, errorType string, err error) {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.ErrorTotal.WithLabelValues(errorType, routeID).Inc()
+	log.Printf("OIDC login failed on route %s: %v", routeID, err)
+	http.Error(ctx.Response, "login failed", http.StatusBadGateway)
+}
+
+// randomToken returns a random URL safe token
+func randomToken() string {
+	data := make([]byte, 24)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
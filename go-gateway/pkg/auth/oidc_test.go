@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+// oidcProviderStub is a minimal local OpenID provider with an RFC 7662 endpoint
+type oidcProviderStub struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mutex          sync.Mutex
+	nonces         map[string]string // code -> nonce
+	activeTokens   map[string]map[string]interface{}
+	introspections int
+}
+
+func newOidcProviderStub(t *testing.T) *oidcProviderStub {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	stub := &oidcProviderStub{
+		key:          key,
+		nonces:       make(map[string]string),
+		activeTokens: make(map[string]map[string]interface{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 stub.server.URL,
+			"authorization_endpoint": stub.server.URL + "/authorize",
+			"token_endpoint":         stub.server.URL + "/token",
+			"jwks_uri":               stub.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwksDocument(rsaJWK("stub", key)))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "web-app" || secret != "web-secret" {
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		stub.mutex.Lock()
+		nonce, ok := stub.nonces[r.Form.Get("code")]
+		stub.mutex.Unlock()
+		if !ok {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		idToken := signJWT(t, "RS256", "stub", key, map[string]interface{}{
+			"iss":   stub.server.URL,
+			"aud":   "web-app",
+			"sub":   "alice",
+			"email": "alice@example.com",
+			"nonce": nonce,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		})
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "opaque"})
+	})
+	mux.HandleFunc("/introspect", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		stub.mutex.Lock()
+		defer stub.mutex.Unlock()
+		stub.introspections++
+		claims, ok := stub.activeTokens[r.Form.Get("token")]
+		if !ok {
+			claims = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(w).Encode(claims)
+	})
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// authorize simulates the user logging in at the provider and returns the code
+func (s *oidcProviderStub) authorize(nonce string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	code := randomToken()
+	s.nonces[code] = nonce
+	return code
+}
+
+func newBrowserContext(method string, target string, cookies ...*http.Cookie) *middleware.GatewayContext {
+	req := httptest.NewRequest(method, target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	req.Header.Set("X-User-Email", "spoofed")
+	return &middleware.GatewayContext{
+		Request:    req,
+		Response:   httptest.NewRecorder(),
+		Route:      &common.Route{ID: "web"},
+		Attributes: make(map[string]interface{}),
+	}
+}
+
+func responseCookie(ctx *middleware.GatewayContext, name string) *http.Cookie {
+	for _, cookie := range ctx.Response.(*httptest.ResponseRecorder).Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// TestOidcLoginFlow tests the authorization code flow against the provider stub
+func TestOidcLoginFlow(t *testing.T) {
+	stub := newOidcProviderStub(t)
+	f, err := NewOidcLoginFilter(map[string]interface{}{
+		"issuer":        stub.server.URL,
+		"clientId":      "web-app",
+		"clientSecret":  "web-secret",
+		"redirectUrl":   "https://gateway.example/oauth2/callback",
+		"cookieSecret":  strings.Repeat("s", 32),
+		"forwardClaims": map[string]interface{}{"email": "X-User-Email"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	// 1. Unauthenticated browser request is redirected to the provider
+	ctx := newBrowserContext("GET", "http://gateway/app/orders?page=2")
+	if f.PreHandle(ctx) {
+		t.Fatal("Expected unauthenticated request to be stopped")
+	}
+	recorder := ctx.Response.(*httptest.ResponseRecorder)
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to provider, got %d", recorder.Code)
+	}
+	location, _ := url.Parse(recorder.Header().Get("Location"))
+	if !strings.HasPrefix(location.String(), stub.server.URL+"/authorize") {
+		t.Fatalf("Expected redirect to authorization endpoint, got %s", location)
+	}
+	stateCookie := responseCookie(ctx, "gw_session_state")
+	if stateCookie == nil || !stateCookie.HttpOnly {
+		t.Fatal("Expected HttpOnly state cookie")
+	}
+
+	// 2. Provider redirects back with code and state
+	code := stub.authorize(location.Query().Get("nonce"))
+	callback := "http://gateway/oauth2/callback?code=" + code + "&state=" + location.Query().Get("state")
+	ctx = newBrowserContext("GET", callback, stateCookie)
+	if f.PreHandle(ctx) {
+		t.Fatal("Expected callback to be handled by the filter")
+	}
+	recorder = ctx.Response.(*httptest.ResponseRecorder)
+	if recorder.Code != http.StatusFound || recorder.Header().Get("Location") != "/app/orders?page=2" {
+		t.Fatalf("Expected redirect to original URL, got %d %s: %s", recorder.Code, recorder.Header().Get("Location"), recorder.Body.String())
+	}
+	session := responseCookie(ctx, "gw_session")
+	if session == nil || strings.Contains(session.Value, "alice") {
+		t.Fatal("Expected encrypted session cookie")
+	}
+
+	// 3. Requests with the session cookie are forwarded with claims
+	ctx = newBrowserContext("GET", "http://gateway/app/orders", session, &http.Cookie{Name: "theme", Value: "dark"})
+	if !f.PreHandle(ctx) {
+		t.Fatalf("Expected session to be accepted, got %d", ctx.Response.(*httptest.ResponseRecorder).Code)
+	}
+	if got := ctx.Request.Header.Get("X-User-Email"); got != "alice@example.com" {
+		t.Errorf("Expected X-User-Email 'alice@example.com', got %q", got)
+	}
+	if got := ctx.Request.Header.Get("Cookie"); got != "theme=dark" {
+		t.Errorf("Expected session cookie to be removed upstream, got %q", got)
+	}
+	if identity, _ := GetIdentity(ctx); identity == nil || identity.Subject != "alice" {
+		t.Errorf("Expected identity alice, got %+v", identity)
+	}
+
+	t.Run("TestForgedState", func(t *testing.T) {
+		code := stub.authorize("whatever")
+		ctx := newBrowserContext("GET", "http://gateway/oauth2/callback?code="+code+"&state=forged", stateCookie)
+		if f.PreHandle(ctx) || ctx.Response.(*httptest.ResponseRecorder).Code != http.StatusBadRequest {
+			t.Error("Expected forged state to be rejected")
+		}
+	})
+
+	t.Run("TestTamperedSession", func(t *testing.T) {
+		tampered := &http.Cookie{Name: "gw_session", Value: session.Value[:len(session.Value)-2] + "AA"}
+		ctx := newBrowserContext("POST", "http://gateway/app/orders", tampered)
+		if f.PreHandle(ctx) || ctx.Response.(*httptest.ResponseRecorder).Code != http.StatusUnauthorized {
+			t.Error("Expected tampered session to be rejected with 401 for non GET requests")
+		}
+	})
+}
+
+// TestIntrospectionFilter tests RFC 7662 introspection with caching
+func TestIntrospectionFilter(t *testing.T) {
+	stub := newOidcProviderStub(t)
+	stub.activeTokens["good-token"] = map[string]interface{}{
+		"active": true,
+		"sub":    "svc-reporting",
+		"scope":  "reports:read",
+		"exp":    float64(time.Now().Add(time.Hour).Unix()),
+	}
+	stub.activeTokens["read-only"] = map[string]interface{}{"active": true, "scope": "other"}
+
+	args := map[string]interface{}{
+		"introspectionUrl": stub.server.URL + "/introspect",
+		"clientId":         "gateway",
+		"clientSecret":     "secret",
+		"requiredScopes":   []interface{}{"reports:read"},
+		"forwardClaims":    map[string]interface{}{"sub": "X-User"},
+	}
+	f, err := NewIntrospectionFilter(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ctx := newJwtContext("good-token")
+		if !f.PreHandle(ctx) {
+			t.Fatalf("Expected active token to be accepted, got %d", ctx.Response.(*httptest.ResponseRecorder).Code)
+		}
+		if got := ctx.Request.Header.Get("X-User"); got != "svc-reporting" {
+			t.Errorf("Expected X-User 'svc-reporting', got %q", got)
+		}
+	}
+	if stub.introspections != 1 {
+		t.Errorf("Expected cached introspection result, got %d calls", stub.introspections)
+	}
+
+	// A reload rebuilds the filter but keeps the cache
+	reloaded, err := NewIntrospectionFilter(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	if !reloaded.PreHandle(newJwtContext("good-token")) || stub.introspections != 1 {
+		t.Errorf("Expected the cache to survive a reload, got %d calls", stub.introspections)
+	}
+
+	ctx := newJwtContext("revoked-token")
+	if f.PreHandle(ctx) || ctx.Response.(*httptest.ResponseRecorder).Code != http.StatusUnauthorized {
+		t.Error("Expected inactive token to be rejected with 401")
+	}
+	ctx = newJwtContext("read-only")
+	if f.PreHandle(ctx) || ctx.Response.(*httptest.ResponseRecorder).Code != http.StatusForbidden {
+		t.Error("Expected token without scope to be rejected with 403")
+	}
+
+	// Cached entries expire with the token
+	f.(*IntrospectionFilter).now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if f.PreHandle(newJwtContext("good-token")) {
+		t.Error("Expected expired token to be rejected")
+	}
+}
+
+// TestOidcDiscoveryFailure tests that concurrent logins share one discovery
+// and that a failed discovery is not retried at once
+func TestOidcDiscoveryFailure(t *testing.T) {
+	var mutex sync.Mutex
+	fetches := 0
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		fetches++
+		mutex.Unlock()
+		<-release
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	m, err := NewOidcLoginFilter(map[string]interface{}{
+		"issuer":       server.URL,
+		"clientId":     "web-app",
+		"clientSecret": "web-secret",
+		"redirectUrl":  "https://gateway.example/oauth2/callback",
+		"cookieSecret": strings.Repeat("s", 32),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	f := m.(*OidcLoginFilter)
+
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := f.discover()
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err == nil {
+			t.Error("Expected discovery to fail")
+		}
+	}
+	if _, err := f.discover(); err == nil {
+		t.Error("Expected the failure to be returned during the back-off")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if fetches != 1 {
+		t.Errorf("Expected 1 discovery fetch, got %d", fetches)
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// minCookieSecretLength is the minimum length of a session cookie secret
+const minCookieSecretLength = 32
+
+// sessionCodec encrypts and authenticates cookie values with AES-256-GCM
+type sessionCodec struct {
+	aead cipher.AEAD
+}
+
+// newSessionCodec derives the cookie encryption key from secret
+func newSessionCodec(secret string) (*sessionCodec, error) {
+	if len(secret) < minCookieSecretLength {
+		return nil, fmt.Errorf("cookie secret must be at least %d characters", minCookieSecretLength)
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionCodec{aead: aead}, nil
+}
+
+// Seal encrypts v for the cookie with the given name. The name is bound as
+// additional data so that one cookie cannot be replayed as another.
+func (c *sessionCodec) Seal(name string, v interface{}) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts the value of the cookie with the given name into v
+func (c *sessionCodec) Open(name string, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return fmt.Errorf("malformed cookie")
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return fmt.Errorf("invalid cookie")
+	}
+	return json.Unmarshal(plaintext, v)
+}
+
+// removeCookies drops the named cookies from the request before it is proxied
+func removeCookies(r *http.Request, names ...string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+
+	kept := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		drop := false
+		for _, name := range names {
+			drop = drop || cookie.Name == name
+		}
+		if !drop {
+			kept = append(kept, cookie.String())
+		}
+	}
+	if len(kept) > 0 {
+		r.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// PurgeHandler returns the admin handler that removes cached responses.
+//
+//	POST /admin/cache/purge?store=default&host=api.example.com&path=/products/*
+//
+// All parameters are optional: store limits the purge to one store, host to
+// one host and path to one path, or to a prefix when it ends with "*".
+// Without parameters every entry of every store is removed.
+func PurgeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		host := strings.ToLower(query.Get("host"))
+		path := query.Get("path")
+		match := func(h, p string) bool {
+			if host != "" && h != host {
+				return false
+			}
+			if prefix, ok := strings.CutSuffix(path, "*"); ok {
+				return strings.HasPrefix(p, prefix)
+			}
+			return path == "" || p == path
+		}
+
+		storeName := query.Get("store")
+		storesMutex.Lock()
+		selected := make([]*Store, 0, len(stores))
+		for name, s := range stores {
+			if storeName == "" || name == storeName {
+				selected = append(selected, s)
+			}
+		}
+		storesMutex.Unlock()
+
+		purged := 0
+		for _, s := range selected {
+			purged += s.Purge(match)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"purged": purged})
+	})
+}
@@ -0,0 +1,313 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("Cache", NewCache, CacheArgs{})
+}
+
+// writerAttribute holds the cacheWriter of a request until PostHandle
+const writerAttribute = "cache.writer"
+
+// invalidateAttribute holds the response of an unsafe request, whose success
+// invalidates the cached responses of its URI
+const invalidateAttribute = "cache.invalidate"
+
+// CacheArgs configures the Cache filter
+type CacheArgs struct {
+	// Store names the cache; filters with the same store share entries
+	Store string `mapstructure:"store" json:"store,omitempty"`
+	// MaxSize is the memory tier size in bytes
+	MaxSize int64 `mapstructure:"maxSize" json:"maxSize,omitempty"`
+	// MaxEntrySize is the largest response body in bytes that is cached
+	MaxEntrySize int64 `mapstructure:"maxEntrySize" json:"maxEntrySize,omitempty"`
+	// DiskDir enables the disk tier, which keeps entries evicted from memory
+	DiskDir string `mapstructure:"diskDir" json:"diskDir,omitempty"`
+	// DiskMaxSize is the disk tier size in bytes
+	DiskMaxSize int64 `mapstructure:"diskMaxSize" json:"diskMaxSize,omitempty"`
+	// Key lists the request parts the cache key is built from: host, path,
+	// query, route, consumer, header:<name>, cookie:<name>, query:<name>
+	Key []string `mapstructure:"key" json:"key,omitempty"`
+	// DefaultTTL is the freshness of responses without Cache-Control or
+	// Expires; such responses are not cached when it is zero
+	DefaultTTL time.Duration `mapstructure:"defaultTtl" json:"defaultTtl,omitempty"`
+	// StaleWhileRevalidate and StaleIfError apply when the response does
+	// not set the directives itself
+	StaleWhileRevalidate time.Duration `mapstructure:"staleWhileRevalidate" json:"staleWhileRevalidate,omitempty"`
+	StaleIfError         time.Duration `mapstructure:"staleIfError" json:"staleIfError,omitempty"`
+}
+
+// Cache serves GET and HEAD requests from stored responses
+type Cache struct {
+	store                *Store
+	maxEntrySize         int64
+	key                  keyBuilder
+	defaultTTL           time.Duration
+	staleWhileRevalidate time.Duration
+	staleIfError         time.Duration
+}
+
+// NewCache creates a Cache filter from its args
+func NewCache(args interface{}) (middleware.Middleware, error) {
+	cfg := CacheArgs{
+		Store:        "default",
+		MaxSize:      64 << 20,
+		MaxEntrySize: 1 << 20,
+		DiskMaxSize:  1 << 30,
+		Key:          []string{"host", "path", "query"},
+	}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxSize <= 0 || cfg.MaxEntrySize <= 0 || cfg.DiskMaxSize <= 0 {
+		return nil, fmt.Errorf("sizes must be positive")
+	}
+	key, err := newKeyBuilder(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := getStore(cfg.Store, storeSettings{
+		maxSize:     cfg.MaxSize,
+		diskDir:     cfg.DiskDir,
+		diskMaxSize: cfg.DiskMaxSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		store:                store,
+		maxEntrySize:         cfg.MaxEntrySize,
+		key:                  key,
+		defaultTTL:           cfg.DefaultTTL,
+		staleWhileRevalidate: cfg.StaleWhileRevalidate,
+		staleIfError:         cfg.StaleIfError,
+	}, nil
+}
+
+// Name returns the filter name
+func (c *Cache) Name() string {
+	return "Cache"
+}
+
+// PreHandle answers from the cache when it can, otherwise lets the request
+// through with a writer that stores or revalidates the response
+func (c *Cache) PreHandle(ctx *middleware.GatewayContext) bool {
+	r := ctx.Request
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		// A successful unsafe request invalidates the stored responses of its URI
+		if r.Method != http.MethodOptions && r.Method != http.MethodTrace {
+			ctx.Attributes[invalidateAttribute] = middleware.WrapResponse(ctx)
+		}
+		return true
+	}
+	if r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+		return true
+	}
+
+	reqCC := parseCacheControl(r.Header)
+	if reqCC.has("no-store") {
+		c.count(ctx, "bypass")
+		return true
+	}
+
+	primary := c.key.build(ctx)
+	t := now()
+	e := c.store.Lookup(primary, r.Header)
+	ifNoneMatch, ifModifiedSince := r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since")
+	revalidate := reqCC.has("no-cache")
+
+	if e != nil && !revalidate {
+		if e.Fresh(t) {
+			c.count(ctx, "hit")
+			serveEntry(ctx.Response, r.Method, ifNoneMatch, ifModifiedSince, e, "HIT")
+			return false
+		}
+		if e.servableStale(t, e.StaleWhileRevalidate) {
+			c.count(ctx, "stale")
+			serveEntry(ctx.Response, r.Method, ifNoneMatch, ifModifiedSince, e, "STALE")
+			if r.Method == http.MethodHead || !c.store.startRevalidation(e.Key) {
+				return false
+			}
+			// The client has its response; this request goes on to refresh the
+			// entry and its upstream response is not sent anywhere
+			http.NewResponseController(ctx.Response).Flush()
+			setValidators(r, e)
+			c.attach(ctx, &cacheWriter{header: make(http.Header), filter: c, primary: primary, entry: e, background: true})
+			return true
+		}
+	}
+
+	if r.Method == http.MethodHead {
+		c.count(ctx, "miss")
+		return true
+	}
+
+	w := &cacheWriter{
+		client:          ctx.Response,
+		header:          make(http.Header),
+		filter:          c,
+		primary:         primary,
+		ifNoneMatch:     ifNoneMatch,
+		ifModifiedSince: ifModifiedSince,
+	}
+	if e != nil {
+		// Revalidate with our own validators; the client's are checked
+		// against the refreshed entry
+		c.count(ctx, "revalidate")
+		w.entry = e
+		setValidators(r, e)
+	} else {
+		c.count(ctx, "miss")
+	}
+	c.attach(ctx, w)
+	return true
+}
+
+// PostHandle stores, refreshes or serves the cached response
+func (c *Cache) PostHandle(ctx *middleware.GatewayContext) error {
+	if rw, ok := ctx.Attributes[invalidateAttribute].(*middleware.ResponseWriter); ok {
+		if rw.Status() >= 200 && rw.Status() < 400 {
+			host, path := strings.ToLower(ctx.Request.Host), ctx.Request.URL.Path
+			c.store.Purge(func(h, p string) bool { return h == host && p == path })
+		}
+		return nil
+	}
+
+	w, ok := ctx.Attributes[writerAttribute].(*cacheWriter)
+	if !ok {
+		return nil
+	}
+	if w.background {
+		defer c.store.finishRevalidation(w.entry.Key)
+	}
+	w.finish(ctx)
+	return nil
+}
+
+// HandleError does nothing
+func (c *Cache) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+func (c *Cache) attach(ctx *middleware.GatewayContext, w *cacheWriter) {
+	w.ctx = ctx
+	w.request = ctx.Request
+	ctx.Response = w
+	ctx.Attributes[writerAttribute] = w
+}
+
+func (c *Cache) count(ctx *middleware.GatewayContext, result string) {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.CacheRequestTotal.WithLabelValues(routeID, result).Inc()
+}
+
+// storable reports whether a response may be stored and how long it is fresh
+func (c *Cache) storable(r *http.Request, status int, header http.Header) (time.Duration, bool) {
+	if !cacheableStatus[status] || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	cc := parseCacheControl(header)
+	if cc.has("no-store") || cc.has("private") {
+		return 0, false
+	}
+	for _, name := range varyHeaders(header) {
+		if name == "*" {
+			return 0, false
+		}
+	}
+	if r.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return 0, false
+	}
+
+	if cc.has("no-cache") {
+		// Stored, but revalidated before every use
+		return 0, header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	}
+	if lifetime, ok := freshnessLifetime(header, cc); ok {
+		return lifetime, true
+	}
+	return c.defaultTTL, c.defaultTTL > 0
+}
+
+// newEntry builds an entry from a response received at t
+func (c *Cache) newEntry(primary string, r *http.Request, status int, header http.Header, body []byte, lifetime time.Duration, t time.Time) *Entry {
+	stored := header.Clone()
+	stored.Del("Age")
+	stored.Del("X-Cache")
+
+	cc := parseCacheControl(header)
+	initialAge := ageHeader(header)
+	e := &Entry{
+		PrimaryKey:           primary,
+		Vary:                 varyHeaders(header),
+		Host:                 strings.ToLower(r.Host),
+		Path:                 r.URL.Path,
+		Status:               status,
+		Header:               stored,
+		Body:                 body,
+		Stored:               t,
+		InitialAge:           initialAge,
+		Expires:              t.Add(lifetime - initialAge),
+		StaleWhileRevalidate: c.staleWhileRevalidate,
+		StaleIfError:         c.staleIfError,
+		MustRevalidate:       cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("no-cache"),
+	}
+	if swr, ok := cc.seconds("stale-while-revalidate"); ok {
+		e.StaleWhileRevalidate = swr
+	}
+	if sie, ok := cc.seconds("stale-if-error"); ok {
+		e.StaleIfError = sie
+	}
+	e.Key = varyKey(primary, e.Vary, r.Header)
+	return e
+}
+
+// setValidators turns the request into a conditional request for e
+func setValidators(r *http.Request, e *Entry) {
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+	if etag := e.Header.Get("ETag"); etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	} else if modified := e.Header.Get("Last-Modified"); modified != "" {
+		r.Header.Set("If-Modified-Since", modified)
+	}
+}
+
+// serveEntry writes a stored response, or 304 when the client's validators match
+func serveEntry(w http.ResponseWriter, method, ifNoneMatch, ifModifiedSince string, e *Entry, result string) {
+	header := w.Header()
+	for name, values := range e.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.FormatInt(int64(e.Age(now())/time.Second), 10))
+	header.Set("X-Cache", result)
+
+	if notModified(ifNoneMatch, ifModifiedSince, e) {
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if method != http.MethodHead {
+		w.Write(e.Body)
+	}
+}
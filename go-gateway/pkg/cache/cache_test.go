@@ -0,0 +1,341 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+// clock lets tests move the cache's notion of time
+type clock struct{ t time.Time }
+
+func useClock(t *testing.T) *clock {
+	c := &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	now = func() time.Time { return c.t }
+	t.Cleanup(func() { now = time.Now })
+	return c
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// origin counts the requests reaching the backend
+type origin struct {
+	calls   int
+	handler func(w http.ResponseWriter, r *http.Request)
+}
+
+func newFilter(t *testing.T, args map[string]interface{}) *Cache {
+	t.Helper()
+	if _, ok := args["store"]; !ok {
+		args["store"] = t.Name()
+	}
+	// 具名存储是包级的，测试重复运行时不能复用上一次的条目
+	t.Cleanup(func() {
+		storesMutex.Lock()
+		delete(stores, args["store"].(string))
+		storesMutex.Unlock()
+	})
+	m, err := NewCache(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	return m.(*Cache)
+}
+
+func (o *origin) do(f *Cache, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ctx := &middleware.GatewayContext{Request: req, Response: rec, Route: &common.Route{ID: "test"}}
+	middleware.NewMiddlewareChain([]middleware.Middleware{f}).Handle(ctx, func(ctx *middleware.GatewayContext) {
+		o.calls++
+		o.handler(ctx.Response, ctx.Request)
+	})
+	return rec
+}
+
+func (o *origin) get(f *Cache, target string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	return o.do(f, req)
+}
+
+// TestHitAndMiss tests that fresh responses are served from the cache
+func TestHitAndMiss(t *testing.T) {
+	clk := useClock(t)
+	f := newFilter(t, map[string]interface{}{})
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, "body of %s", r.URL.RawQuery)
+	}}
+
+	if rec := o.get(f, "http://gateway/items?b=2&a=1"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected MISS, got %q", rec.Header().Get("X-Cache"))
+	}
+	clk.advance(10 * time.Second)
+	rec := o.get(f, "http://gateway/items?a=1&b=2")
+	if rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != "body of b=2&a=1" {
+		t.Errorf("Expected HIT with stored body, got %q %q", rec.Header().Get("X-Cache"), rec.Body.String())
+	}
+	if got := rec.Header().Get("Age"); got != "10" {
+		t.Errorf("Expected Age 10, got %q", got)
+	}
+	if o.calls != 1 {
+		t.Errorf("Expected 1 backend call, got %d", o.calls)
+	}
+
+	o.get(f, "http://gateway/items?a=1&b=2", "Cache-Control", "no-store")
+	o.get(f, "http://gateway/other")
+	if o.calls != 3 {
+		t.Errorf("Expected no-store and other paths to reach the backend, got %d calls", o.calls)
+	}
+
+	clk.advance(time.Minute)
+	o.get(f, "http://gateway/items?a=1&b=2")
+	if o.calls != 4 {
+		t.Errorf("Expected expired entry to be fetched again, got %d calls", o.calls)
+	}
+
+	if rec := o.get(f, "http://gateway/items?a=1&b=2", "If-None-Match", "*"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 without ETag, got %d", rec.Code)
+	}
+}
+
+// TestNotStored tests responses that must not be cached
+func TestNotStored(t *testing.T) {
+	useClock(t)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		request []string
+	}{
+		{"NoFreshness", map[string]string{}, nil},
+		{"NoStore", map[string]string{"Cache-Control": "no-store, max-age=60"}, nil},
+		{"Private", map[string]string{"Cache-Control": "private, max-age=60"}, nil},
+		{"SetCookie", map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "a=b"}, nil},
+		{"VaryStar", map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, nil},
+		{"EventStream", map[string]string{"Cache-Control": "max-age=60", "Content-Type": "text/event-stream"}, nil},
+		{"Authorization", map[string]string{"Cache-Control": "max-age=60"}, []string{"Authorization", "Bearer x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFilter(t, map[string]interface{}{})
+			o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+				for name, value := range tt.headers {
+					w.Header().Set(name, value)
+				}
+				w.Write([]byte("ok"))
+			}}
+			o.get(f, "http://gateway/x", tt.request...)
+			o.get(f, "http://gateway/x", tt.request...)
+			if o.calls != 2 {
+				t.Errorf("Expected response not to be cached, got %d calls", o.calls)
+			}
+		})
+	}
+
+	t.Run("TooLarge", func(t *testing.T) {
+		f := newFilter(t, map[string]interface{}{"maxEntrySize": 4})
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("too large"))
+		}}
+		o.get(f, "http://gateway/x")
+		if rec := o.get(f, "http://gateway/x"); rec.Body.String() != "too large" || o.calls != 2 {
+			t.Errorf("Expected large response to pass through uncached, got %d calls", o.calls)
+		}
+	})
+
+	t.Run("DefaultTTL", func(t *testing.T) {
+		f := newFilter(t, map[string]interface{}{"defaultTtl": "30s"})
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }}
+		o.get(f, "http://gateway/x")
+		o.get(f, "http://gateway/x")
+		if o.calls != 1 {
+			t.Errorf("Expected defaultTtl to make the response cacheable, got %d calls", o.calls)
+		}
+	})
+}
+
+// TestVaryAndKey tests Vary handling and configured keys
+func TestVaryAndKey(t *testing.T) {
+	useClock(t)
+	f := newFilter(t, map[string]interface{}{"key": "path,header:X-Tenant"})
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprintf(w, "%s/%s", r.Header.Get("X-Tenant"), r.Header.Get("Accept-Language"))
+	}}
+
+	o.get(f, "http://gateway/x", "X-Tenant", "a", "Accept-Language", "en")
+	o.get(f, "http://gateway/x", "X-Tenant", "a", "Accept-Language", "de")
+	o.get(f, "http://gateway/x", "X-Tenant", "b", "Accept-Language", "en")
+	if o.calls != 3 {
+		t.Fatalf("Expected each variant to be fetched, got %d calls", o.calls)
+	}
+	if rec := o.get(f, "http://other-host/x?q=1", "X-Tenant", "a", "Accept-Language", "de"); rec.Body.String() != "a/de" {
+		t.Errorf("Expected stored variant a/de, got %q", rec.Body.String())
+	}
+	if o.calls != 3 {
+		t.Errorf("Expected host and query to be ignored by the key, got %d calls", o.calls)
+	}
+
+	if _, err := NewCache(map[string]interface{}{"key": "method"}); err == nil {
+		t.Error("Expected error for unsupported key")
+	}
+}
+
+// TestRevalidation tests conditional revalidation and stale serving
+func TestRevalidation(t *testing.T) {
+	t.Run("NotModified", func(t *testing.T) {
+		clk := useClock(t)
+		f := newFilter(t, map[string]interface{}{})
+		var conditional string
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=10")
+			w.Header().Set("ETag", `"v1"`)
+			if conditional = r.Header.Get("If-None-Match"); conditional == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("v1"))
+		}}
+
+		o.get(f, "http://gateway/x")
+		clk.advance(time.Minute)
+		rec := o.get(f, "http://gateway/x")
+		if conditional != `"v1"` || rec.Header().Get("X-Cache") != "REVALIDATED" || rec.Body.String() != "v1" {
+			t.Errorf("Expected revalidated stored body, got %q %q", rec.Header().Get("X-Cache"), rec.Body.String())
+		}
+
+		// Refreshed entry is fresh again and honours client validators
+		rec = o.get(f, "http://gateway/x", "If-None-Match", `W/"v1"`)
+		if rec.Code != http.StatusNotModified || rec.Header().Get("X-Cache") != "HIT" || o.calls != 2 {
+			t.Errorf("Expected 304 HIT, got %d %q after %d calls", rec.Code, rec.Header().Get("X-Cache"), o.calls)
+		}
+	})
+
+	t.Run("StaleWhileRevalidate", func(t *testing.T) {
+		clk := useClock(t)
+		f := newFilter(t, map[string]interface{}{})
+		version := "v1"
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+			w.Write([]byte(version))
+		}}
+
+		o.get(f, "http://gateway/x")
+		version = "v2"
+		clk.advance(30 * time.Second)
+		rec := o.get(f, "http://gateway/x")
+		if rec.Header().Get("X-Cache") != "STALE" || rec.Body.String() != "v1" {
+			t.Errorf("Expected stale v1, got %q %q", rec.Header().Get("X-Cache"), rec.Body.String())
+		}
+		if o.calls != 2 {
+			t.Errorf("Expected a background refresh, got %d calls", o.calls)
+		}
+		if rec := o.get(f, "http://gateway/x"); rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != "v2" {
+			t.Errorf("Expected refreshed v2, got %q %q", rec.Header().Get("X-Cache"), rec.Body.String())
+		}
+	})
+
+	t.Run("StaleIfError", func(t *testing.T) {
+		clk := useClock(t)
+		f := newFilter(t, map[string]interface{}{"staleIfError": "5m"})
+		failing := false
+		o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+			if failing {
+				http.Error(w, "down", http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Cache-Control", "max-age=10")
+			w.Write([]byte("good"))
+		}}
+
+		o.get(f, "http://gateway/x")
+		failing = true
+		clk.advance(time.Minute)
+		if rec := o.get(f, "http://gateway/x"); rec.Code != http.StatusOK || rec.Body.String() != "good" {
+			t.Errorf("Expected stale response on error, got %d %q", rec.Code, rec.Body.String())
+		}
+		clk.advance(10 * time.Minute)
+		if rec := o.get(f, "http://gateway/x"); rec.Code != http.StatusBadGateway {
+			t.Errorf("Expected error after stale-if-error window, got %d", rec.Code)
+		}
+	})
+}
+
+// TestEvictionAndDisk tests LRU eviction into the disk tier
+func TestEvictionAndDisk(t *testing.T) {
+	useClock(t)
+	dir := t.TempDir()
+	args := func() map[string]interface{} {
+		return map[string]interface{}{"maxSize": 400, "diskDir": dir, "key": "path"}
+	}
+	f := newFilter(t, args())
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(strings.Repeat(r.URL.Path[1:], 100)))
+	}}
+
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		o.get(f, "http://gateway"+path)
+	}
+	if f.store.lru.Len() >= 4 || f.store.disk.lru.Len() == 0 {
+		t.Fatalf("Expected entries to spill to disk, memory %d disk %d", f.store.lru.Len(), f.store.disk.lru.Len())
+	}
+	if rec := o.get(f, "http://gateway/a"); rec.Header().Get("X-Cache") != "HIT" || o.calls != 4 {
+		t.Errorf("Expected /a from the disk tier, got %q after %d calls", rec.Header().Get("X-Cache"), o.calls)
+	}
+
+	// A new store on the same directory finds the spilled entries
+	storesMutex.Lock()
+	delete(stores, t.Name())
+	storesMutex.Unlock()
+	f = newFilter(t, args())
+	if f.store.disk.lru.Len() == 0 {
+		t.Error("Expected disk entries to be indexed on open")
+	}
+}
+
+// TestPurge tests the admin purge endpoint and invalidation by unsafe methods
+func TestPurge(t *testing.T) {
+	useClock(t)
+	f := newFilter(t, map[string]interface{}{})
+	o := &origin{handler: func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("ok"))
+	}}
+
+	for _, path := range []string{"/products/1", "/products/2", "/orders/1"} {
+		o.get(f, "http://shop"+path)
+	}
+
+	rec := httptest.NewRecorder()
+	PurgeHandler().ServeHTTP(rec, httptest.NewRequest("POST", "/admin/cache/purge?store="+t.Name()+"&host=shop&path=/products/*", nil))
+	if rec.Body.String() != "{\"purged\":2}\n" {
+		t.Errorf("Expected 2 purged entries, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	PurgeHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/cache/purge", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", rec.Code)
+	}
+
+	o.do(f, httptest.NewRequest("DELETE", "http://shop/orders/1", nil))
+	calls := o.calls
+	o.get(f, "http://shop/orders/1")
+	if o.calls != calls+1 {
+		t.Error("Expected successful DELETE to invalidate the cached response")
+	}
+}
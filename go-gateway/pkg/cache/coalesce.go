@@ -0,0 +1,376 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+	"go-gateway/pkg/streaming"
+)
+
+func init() {
+	filter.Register("Coalesce", NewCoalesce, CoalesceArgs{})
+}
+
+// flightAttribute holds the flight led by a request until PostHandle
+const flightAttribute = "cache.flight"
+
+// CoalesceArgs configures the Coalesce filter
+type CoalesceArgs struct {
+	// Key lists the request parts identical requests share, as for Cache
+	Key []string `mapstructure:"key" json:"key,omitempty"`
+	// MaxBodySize is the largest response body in bytes kept for the other
+	// clients; larger responses are not shared
+	MaxBodySize int64 `mapstructure:"maxBodySize" json:"maxBodySize,omitempty"`
+}
+
+// Coalesce merges identical concurrent GET and HEAD requests into a single
+// upstream call whose response is streamed to every waiting client
+type Coalesce struct {
+	key         keyBuilder
+	maxBodySize int64
+	mutex       sync.Mutex
+	flights     map[string]*flight
+}
+
+// NewCoalesce creates a Coalesce filter from its args
+func NewCoalesce(args interface{}) (middleware.Middleware, error) {
+	cfg := CoalesceArgs{Key: []string{"host", "path", "query"}, MaxBodySize: 1 << 20}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxBodySize <= 0 {
+		return nil, fmt.Errorf("maxBodySize must be positive")
+	}
+	key, err := newKeyBuilder(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &Coalesce{key: key, maxBodySize: cfg.MaxBodySize, flights: make(map[string]*flight)}, nil
+}
+
+// Name returns the filter name
+func (c *Coalesce) Name() string {
+	return "Coalesce"
+}
+
+// PreHandle lets the first request of a key through and streams its response
+// to the identical requests arriving while it is in flight
+func (c *Coalesce) PreHandle(ctx *middleware.GatewayContext) bool {
+	r := ctx.Request
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	if r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	// Never share one caller's response with another
+	if !c.key.identifiesCaller(ctx) {
+		return true
+	}
+
+	key := r.Method + "\n" + c.key.build(ctx)
+	c.mutex.Lock()
+	f, ok := c.flights[key]
+	if !ok {
+		f = newFlight(r, c.maxBodySize)
+		c.flights[key] = f
+	}
+	stop := f.join(r.Context())
+	c.mutex.Unlock()
+
+	if !ok {
+		c.lead(ctx, key, f)
+		return true
+	}
+	defer func() {
+		if stop() {
+			f.leave()
+		}
+	}()
+	return !c.follow(ctx, f)
+}
+
+// lead sends the request upstream on a context that lives as long as any of
+// the clients waiting for the response
+func (c *Coalesce) lead(ctx *middleware.GatewayContext, key string, f *flight) {
+	f.key = key
+	ctx.Request = ctx.Request.WithContext(f.ctx)
+	ctx.Response = &flightWriter{client: ctx.Response, ctx: ctx, coalesce: c, flight: f}
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	ctx.Attributes[flightAttribute] = f
+}
+
+// follow streams the response of f to the client. It reports false when the
+// response cannot be shared and the request has to go upstream itself.
+func (c *Coalesce) follow(ctx *middleware.GatewayContext, f *flight) bool {
+	status, header, ok := f.waitHeader(ctx.Request.Context())
+	if !ok || !f.shareable(ctx.Request, header) {
+		return ctx.Request.Context().Err() != nil
+	}
+
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.CoalescedRequestTotal.WithLabelValues(routeID).Inc()
+
+	dst := ctx.Response.Header()
+	for name, values := range header {
+		dst[name] = values
+	}
+	ctx.Response.WriteHeader(status)
+
+	for offset := 0; ; {
+		chunk, done, aborted := f.next(ctx.Request.Context(), offset)
+		if len(chunk) > 0 {
+			if _, err := ctx.Response.Write(chunk); err != nil {
+				return true
+			}
+			http.NewResponseController(ctx.Response).Flush()
+			offset += len(chunk)
+		}
+		if aborted {
+			// Break the client's response the way the leader's broke
+			panic(http.ErrAbortHandler)
+		}
+		if done {
+			return true
+		}
+	}
+}
+
+// PostHandle completes the flight led by the request
+func (c *Coalesce) PostHandle(ctx *middleware.GatewayContext) error {
+	f, ok := ctx.Attributes[flightAttribute].(*flight)
+	if !ok {
+		return nil
+	}
+	c.forget(f)
+	f.finish(ctx.Aborted())
+	return nil
+}
+
+// forget stops admitting requests to f; identical requests arriving later
+// start a flight of their own
+func (c *Coalesce) forget(f *flight) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.flights[f.key] == f {
+		delete(c.flights, f.key)
+	}
+}
+
+// HandleError does nothing
+func (c *Coalesce) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// flight is an upstream call shared by identical requests
+type flight struct {
+	key    string
+	leader *http.Request
+	ctx    context.Context
+	cancel context.CancelFunc
+	// maxBody caps the body kept for the other clients
+	maxBody int64
+
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	listeners int
+	status    int
+	header    http.Header
+	unshared  bool
+	body      []byte
+	// overflowed is set when the body outgrew maxBody and was dropped
+	overflowed bool
+	done       bool
+	aborted    bool
+}
+
+func newFlight(leader *http.Request, maxBody int64) *flight {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(leader.Context()))
+	f := &flight{leader: leader, ctx: ctx, cancel: cancel, maxBody: maxBody}
+	f.cond = sync.NewCond(&f.mutex)
+	return f
+}
+
+// join adds a client; the upstream call is cancelled once every client is
+// gone. The client leaves when ctx is done or, if the returned stop function
+// reports true, when the caller calls leave.
+func (f *flight) join(ctx context.Context) (stop func() bool) {
+	f.mutex.Lock()
+	f.listeners++
+	f.mutex.Unlock()
+
+	return context.AfterFunc(ctx, func() {
+		f.mutex.Lock()
+		f.cond.Broadcast()
+		f.mutex.Unlock()
+		f.leave()
+	})
+}
+
+func (f *flight) leave() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.listeners--
+	if f.listeners <= 0 && !f.done {
+		f.cancel()
+	}
+}
+
+func (f *flight) setHeader(status int, header http.Header, shared bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.status = status
+	f.header = header
+	f.unshared = !shared
+	f.cond.Broadcast()
+}
+
+// write keeps data for the other clients. It reports false when the body
+// outgrew maxBody; the flight is then unshared, its body dropped and the
+// responses of the clients already streaming it are aborted.
+func (f *flight) write(data []byte) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if int64(len(f.body)+len(data)) > f.maxBody {
+		f.unshared = true
+		f.overflowed = true
+		f.body = nil
+		f.cond.Broadcast()
+		return false
+	}
+	f.body = append(f.body, data...)
+	f.cond.Broadcast()
+	return true
+}
+
+func (f *flight) finish(aborted bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.done = true
+	f.aborted = aborted || f.status == 0
+	f.cancel()
+	f.cond.Broadcast()
+}
+
+// waitHeader waits for the response header. It returns false when the flight
+// ended without one or the client went away.
+func (f *flight) waitHeader(ctx context.Context) (int, http.Header, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for f.status == 0 && !f.done && ctx.Err() == nil {
+		f.cond.Wait()
+	}
+	if f.status == 0 {
+		return 0, nil, false
+	}
+	return f.status, f.header, true
+}
+
+// next waits for body data past offset
+func (f *flight) next(ctx context.Context, offset int) ([]byte, bool, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for len(f.body) <= offset && !f.done && !f.overflowed && ctx.Err() == nil {
+		f.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return nil, true, false
+	}
+	if f.overflowed {
+		return nil, true, true
+	}
+	// body only grows, so the returned slice stays valid
+	return f.body[offset:len(f.body):len(f.body)], f.done, f.done && f.aborted
+}
+
+// shareable reports whether the leader's response may be sent to r
+func (f *flight) shareable(r *http.Request, header http.Header) bool {
+	if f.unshared || header.Get("Set-Cookie") != "" || parseCacheControl(header).has("private") {
+		return false
+	}
+	for _, name := range varyHeaders(header) {
+		if name == "*" || strings.Join(r.Header.Values(name), ",") != strings.Join(f.leader.Header.Values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// flightWriter passes the leader's response to its client and the flight
+type flightWriter struct {
+	client   http.ResponseWriter
+	ctx      *middleware.GatewayContext
+	coalesce *Coalesce
+	flight   *flight
+	status   int
+	// unshared responses (streams) are not kept for the other clients
+	unshared bool
+	gone     bool
+}
+
+// Header returns the client's response header
+func (w *flightWriter) Header() http.Header {
+	return w.client.Header()
+}
+
+// WriteHeader publishes the header to the waiting clients
+func (w *flightWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < 200 {
+		w.client.WriteHeader(status)
+		return
+	}
+	w.status = status
+	w.unshared = streaming.IsStream(w.ctx, w.client.Header())
+	if size, err := strconv.ParseInt(w.client.Header().Get("Content-Length"), 10, 64); err == nil && size > w.flight.maxBody {
+		w.unshared = true
+	}
+	w.flight.setHeader(status, w.client.Header().Clone(), !w.unshared)
+	w.client.WriteHeader(status)
+}
+
+// Write publishes the body. Errors of the leader's client are not returned
+// so the upstream response keeps flowing to the other clients.
+func (w *flightWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.unshared && !w.flight.write(data) {
+		w.unshared = true
+		w.coalesce.forget(w.flight)
+	}
+	if !w.gone {
+		if _, err := w.client.Write(data); err != nil {
+			w.gone = true
+		}
+	}
+	return len(data), nil
+}
+
+// Flush flushes the leader's client
+func (w *flightWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.gone {
+		http.NewResponseController(w.client).Flush()
+	}
+}
+
+// Unwrap returns the client writer for http.ResponseController
+func (w *flightWriter) Unwrap() http.ResponseWriter {
+	return w.client
+}
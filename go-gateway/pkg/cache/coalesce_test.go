@@ -0,0 +1,346 @@
+package cache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/middleware"
+)
+
+// gatewayFor serves requests through the filter and a reverse proxy to backend
+func gatewayFor(t *testing.T, m middleware.Middleware, backend *httptest.Server) *httptest.Server {
+	t.Helper()
+	target, _ := url.Parse(backend.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := &middleware.GatewayContext{Request: r, Response: w, Attributes: map[string]interface{}{}}
+		middleware.NewMiddlewareChain([]middleware.Middleware{m}).Handle(ctx, func(ctx *middleware.GatewayContext) {
+			proxy.ServeHTTP(ctx.Response, ctx.Request)
+		})
+	}))
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+// waitFor polls cond until it holds or fails the test after 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+// waitFlight waits until n clients wait on the single flight of c
+func waitFlight(t *testing.T, c *Coalesce, n int) {
+	t.Helper()
+	waitFor(t, "clients to join the flight", func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		listeners := 0
+		for _, f := range c.flights {
+			f.mutex.Lock()
+			listeners += f.listeners
+			f.mutex.Unlock()
+		}
+		return listeners >= n
+	})
+}
+
+// TestCoalesce tests that identical concurrent requests share one upstream call
+func TestCoalesce(t *testing.T) {
+	const clients = 10
+
+	run := func(t *testing.T, shared bool, header func(h http.Header), requestHeader http.Header) (int32, []string) {
+		var calls int32
+		release := make(chan struct{})
+		var releaseOnce sync.Once
+		// 失败时也要放行后端，否则 backend.Close 会一直等待
+		defer releaseOnce.Do(func() { close(release) })
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			header(w.Header())
+			w.Write([]byte("first "))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte("second"))
+		}))
+		defer backend.Close()
+
+		m, err := NewCoalesce(nil)
+		if err != nil {
+			t.Fatalf("Failed to create filter: %v", err)
+		}
+		gateway := gatewayFor(t, m, backend)
+
+		bodies := make([]string, clients)
+		var wg sync.WaitGroup
+		for i := 0; i < clients; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				req, _ := http.NewRequest("GET", gateway.URL+"/popular?x=1", nil)
+				req.Header = requestHeader.Clone()
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Errorf("Request failed: %v", err)
+					return
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				bodies[i] = string(body)
+			}(i)
+		}
+
+		if shared {
+			waitFlight(t, m.(*Coalesce), clients)
+		} else {
+			waitFor(t, "every client to reach upstream", func() bool {
+				return atomic.LoadInt32(&calls) >= clients
+			})
+		}
+		releaseOnce.Do(func() { close(release) })
+		wg.Wait()
+		return atomic.LoadInt32(&calls), bodies
+	}
+
+	t.Run("Shared", func(t *testing.T) {
+		calls, bodies := run(t, true, func(h http.Header) {}, http.Header{})
+		if calls != 1 {
+			t.Errorf("Expected 1 upstream call, got %d", calls)
+		}
+		for i, body := range bodies {
+			if body != "first second" {
+				t.Errorf("Client %d: expected full body, got %q", i, body)
+			}
+		}
+	})
+
+	t.Run("SetCookieNotShared", func(t *testing.T) {
+		calls, _ := run(t, false, func(h http.Header) { h.Set("Set-Cookie", "session=1") }, http.Header{})
+		if calls != clients {
+			t.Errorf("Expected every client to call upstream, got %d", calls)
+		}
+	})
+
+	t.Run("CredentialsNotShared", func(t *testing.T) {
+		calls, _ := run(t, false, func(h http.Header) {}, http.Header{"Authorization": {"Bearer x"}})
+		if calls != clients {
+			t.Errorf("Expected every client to call upstream, got %d", calls)
+		}
+	})
+}
+
+// TestCoalesceAbort tests that followers see a broken upstream response as broken
+func TestCoalesceAbort(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-release
+		panic(http.ErrAbortHandler)
+	}))
+	defer backend.Close()
+
+	m, _ := NewCoalesce(nil)
+	gateway := gatewayFor(t, m, backend)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := http.Get(gateway.URL + "/broken")
+			if err == nil {
+				_, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+	waitFlight(t, m.(*Coalesce), 2)
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Error("Expected the broken response to fail for every client")
+		}
+	}
+}
+
+// TestCoalesceBearers tests that requests of different bearers are not merged,
+// also when the key has a consumer no auth filter set
+func TestCoalesceBearers(t *testing.T) {
+	for name, args := range map[string]interface{}{
+		"DefaultKey":  nil,
+		"ConsumerKey": map[string]interface{}{"key": []string{"host", "path", "consumer"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			testCoalesceBearers(t, args)
+		})
+	}
+}
+
+func testCoalesceBearers(t *testing.T, args interface{}) {
+	var calls int32
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer backend.Close()
+	// 失败时先放行后端，backend.Close 才不会一直等待
+	defer releaseOnce.Do(func() { close(release) })
+
+	m, err := NewCoalesce(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	gateway := gatewayFor(t, m, backend)
+
+	bearers := []string{"Bearer eyJhbGciOiJSUzI1NiJ9.alice.sig", "Bearer eyJhbGciOiJSUzI1NiJ9.bob.sig"}
+	bodies := make([]string, len(bearers))
+	var wg sync.WaitGroup
+	for i, bearer := range bearers {
+		wg.Add(1)
+		go func(i int, bearer string) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", gateway.URL+"/me", nil)
+			req.Header.Set("Authorization", bearer)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Request failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(body)
+		}(i, bearer)
+	}
+
+	waitFor(t, "every bearer to reach upstream", func() bool {
+		return atomic.LoadInt32(&calls) >= int32(len(bearers))
+	})
+	releaseOnce.Do(func() { close(release) })
+	wg.Wait()
+
+	for i, bearer := range bearers {
+		if bodies[i] != bearer {
+			t.Errorf("Client %d: expected its own response %q, got %q", i, bearer, bodies[i])
+		}
+	}
+}
+
+// TestCoalesceMaxBodySize tests that bodies larger than maxBodySize are not
+// kept for the other clients
+func TestCoalesceMaxBodySize(t *testing.T) {
+	t.Run("ContentLength", func(t *testing.T) {
+		var calls int32
+		release := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Content-Length", "20")
+			w.Write([]byte("0123456789"))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte("0123456789"))
+		}))
+		defer backend.Close()
+		var releaseOnce sync.Once
+		defer releaseOnce.Do(func() { close(release) })
+
+		m, err := NewCoalesce(map[string]interface{}{"maxBodySize": 15})
+		if err != nil {
+			t.Fatalf("Failed to create filter: %v", err)
+		}
+		gateway := gatewayFor(t, m, backend)
+
+		bodies := make(chan string, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				resp, err := http.Get(gateway.URL + "/large")
+				if err != nil {
+					bodies <- err.Error()
+					return
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				bodies <- string(body)
+			}()
+		}
+		waitFor(t, "every client to reach upstream", func() bool {
+			return atomic.LoadInt32(&calls) >= 2
+		})
+		releaseOnce.Do(func() { close(release) })
+
+		for i := 0; i < 2; i++ {
+			if body := <-bodies; body != "01234567890123456789" {
+				t.Errorf("Expected full body, got %q", body)
+			}
+		}
+	})
+
+	t.Run("Streamed", func(t *testing.T) {
+		var calls int32
+		release := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Write([]byte("0123456789"))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte("0123456789"))
+		}))
+		defer backend.Close()
+
+		m, _ := NewCoalesce(map[string]interface{}{"maxBodySize": 15})
+		c := m.(*Coalesce)
+		gateway := gatewayFor(t, m, backend)
+
+		type result struct {
+			body string
+			err  error
+		}
+		results := make(chan result, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				resp, err := http.Get(gateway.URL + "/large")
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				results <- result{string(body), err}
+			}()
+		}
+		waitFlight(t, c, 2)
+		close(release)
+
+		var complete, aborted int
+		for i := 0; i < 2; i++ {
+			if r := <-results; r.err != nil {
+				aborted++
+			} else if r.body == "01234567890123456789" {
+				complete++
+			}
+		}
+		if complete != 1 || aborted != 1 {
+			t.Errorf("Expected the leader to complete and the follower to abort, got %d complete and %d aborted", complete, aborted)
+		}
+		if calls != 1 {
+			t.Errorf("Expected 1 upstream call, got %d", calls)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go-gateway/pkg/monitoring"
+)
+
+const entrySuffix = ".entry"
+
+// diskItem is the in-memory index record of an entry stored on disk
+type diskItem struct {
+	key     string
+	primary string
+	vary    []string
+	host    string
+	path    string
+	size    int64
+}
+
+// diskTier stores entries as files, one per key, evicting the least recently
+// used ones beyond maxSize. It is guarded by the mutex of its Store.
+type diskTier struct {
+	dir     string
+	maxSize int64
+	size    int64
+	lru     *list.List
+	items   map[string]*list.Element
+}
+
+// openDiskTier opens dir and indexes the entries left there by a previous run
+func openDiskTier(dir string, maxSize int64) (*diskTier, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cache dir: %w", err)
+	}
+	d := &diskTier{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		items:   make(map[string]*list.Element),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cache dir: %w", err)
+	}
+	type indexed struct {
+		item    *diskItem
+		modTime int64
+	}
+	var found []indexed
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), entrySuffix) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		e, err := readEntry(path)
+		info, statErr := file.Info()
+		if err != nil || statErr != nil {
+			os.Remove(path)
+			continue
+		}
+		found = append(found, indexed{item: newDiskItem(e, info.Size()), modTime: info.ModTime().UnixNano()})
+	}
+	// Oldest files end up at the back of the LRU
+	sort.Slice(found, func(i, j int) bool { return found[i].modTime > found[j].modTime })
+	for _, f := range found {
+		d.items[f.item.key] = d.lru.PushBack(f.item)
+		d.size += f.item.size
+	}
+	return d, nil
+}
+
+func newDiskItem(e *Entry, size int64) *diskItem {
+	return &diskItem{key: e.Key, primary: e.PrimaryKey, vary: e.Vary, host: e.Host, path: e.Path, size: size}
+}
+
+func (d *diskTier) filename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+entrySuffix)
+}
+
+// varies returns the Vary headers known per primary key
+func (d *diskTier) varies() map[string][]string {
+	varies := make(map[string][]string)
+	for elem := d.lru.Back(); elem != nil; elem = elem.Prev() {
+		item := elem.Value.(*diskItem)
+		varies[item.primary] = item.vary
+	}
+	return varies
+}
+
+// put writes an entry evicted from memory
+func (d *diskTier) put(e *Entry, store string) {
+	d.remove(e.Key)
+
+	path := d.filename(e.Key)
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		log.Printf("Cache: failed to write %s: %v", tmp, err)
+		return
+	}
+	err = gob.NewEncoder(file).Encode(e)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("Cache: failed to write %s: %v", path, err)
+		os.Remove(tmp)
+		return
+	}
+
+	size := int64(len(e.Body))
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	item := newDiskItem(e, size)
+	d.items[item.key] = d.lru.PushFront(item)
+	d.size += item.size
+
+	for d.size > d.maxSize && d.lru.Len() > 0 {
+		d.removeElem(d.lru.Back())
+		monitoring.CacheEvictionTotal.WithLabelValues(store, "disk").Inc()
+	}
+}
+
+// take reads and removes the entry stored for key
+func (d *diskTier) take(key string) *Entry {
+	elem, ok := d.items[key]
+	if !ok {
+		return nil
+	}
+	e, err := readEntry(d.filename(key))
+	d.removeElem(elem)
+	if err != nil {
+		return nil
+	}
+	return e
+}
+
+func (d *diskTier) remove(key string) {
+	if elem, ok := d.items[key]; ok {
+		d.removeElem(elem)
+	}
+}
+
+func (d *diskTier) removeElem(elem *list.Element) {
+	item := elem.Value.(*diskItem)
+	d.lru.Remove(elem)
+	delete(d.items, item.key)
+	d.size -= item.size
+	os.Remove(d.filename(item.key))
+}
+
+func (d *diskTier) purge(match func(host, path string) bool) int {
+	purged := 0
+	for elem := d.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if item := elem.Value.(*diskItem); match(item.host, item.path) {
+			d.removeElem(elem)
+			purged++
+		}
+		elem = next
+	}
+	return purged
+}
+
+func readEntry(path string) (*Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var e Entry
+	if err := gob.NewDecoder(file).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go-gateway/pkg/middleware"
+)
+
+// keyBuilder builds request keys from the configured parts: host, path,
+// query, route, consumer, header:<name>, cookie:<name> and query:<name>
+type keyBuilder []string
+
+func newKeyBuilder(parts []string) (keyBuilder, error) {
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("key must not be empty")
+	}
+	key := make(keyBuilder, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "host", part == "path", part == "query", part == "route", part == "consumer":
+		case strings.HasPrefix(part, "header:"), strings.HasPrefix(part, "cookie:"), strings.HasPrefix(part, "query:"):
+			if _, name, _ := strings.Cut(part, ":"); name == "" {
+				return nil, fmt.Errorf("key %q is missing a name", part)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported key %q", part)
+		}
+		key = append(key, part)
+	}
+	return key, nil
+}
+
+// build returns the key of a request
+func (k keyBuilder) build(ctx *middleware.GatewayContext) string {
+	r := ctx.Request
+	var b strings.Builder
+	for _, part := range k {
+		b.WriteString(part)
+		b.WriteString("=")
+		kind, name, _ := strings.Cut(part, ":")
+		switch kind {
+		case "host":
+			b.WriteString(strings.ToLower(r.Host))
+		case "path":
+			b.WriteString(r.URL.Path)
+		case "query":
+			if name == "" {
+				// Encode sorts by parameter name
+				b.WriteString(r.URL.Query().Encode())
+			} else {
+				b.WriteString(strings.Join(r.URL.Query()[name], ","))
+			}
+		case "route":
+			if ctx.Route != nil {
+				b.WriteString(ctx.Route.ID)
+			}
+		case "consumer":
+			consumer, _ := ctx.Consumer()
+			b.WriteString(consumer)
+		case "header":
+			b.WriteString(strings.Join(r.Header.Values(name), ","))
+		case "cookie":
+			if cookie, err := r.Cookie(name); err == nil {
+				b.WriteString(url.QueryEscape(cookie.Value))
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// identifiesCaller reports whether the key of the request tells apart the
+// callers of its credentials. A credential header is told apart when the key
+// includes it or when the key includes a consumer an auth filter has set.
+func (k keyBuilder) identifiesCaller(ctx *middleware.GatewayContext) bool {
+	r := ctx.Request
+	var consumer, authorization, cookie bool
+	for _, part := range k {
+		kind, name, _ := strings.Cut(part, ":")
+		switch {
+		case kind == "consumer":
+			_, consumer = ctx.Consumer()
+		case kind == "header" && http.CanonicalHeaderKey(name) == "Authorization":
+			authorization = true
+		case kind == "header" && http.CanonicalHeaderKey(name) == "Cookie":
+			cookie = true
+		}
+	}
+	if consumer {
+		return true
+	}
+	if r.Header.Get("Authorization") != "" && !authorization {
+		return false
+	}
+	if r.Header.Get("Cookie") != "" && !cookie {
+		return false
+	}
+	return true
+}
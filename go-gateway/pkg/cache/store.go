@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/monitoring"
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// Entry is a stored response
+type Entry struct {
+	// Key is the full key including the Vary part, PrimaryKey the configured key
+	Key        string
+	PrimaryKey string
+	// Vary lists the request headers the response varies on
+	Vary   []string
+	Host   string
+	Path   string
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stored is when the response was received and InitialAge its Age then
+	Stored     time.Time
+	InitialAge time.Duration
+	// Expires is the end of the freshness lifetime
+	Expires              time.Time
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	// MustRevalidate forbids serving the entry stale
+	MustRevalidate bool
+}
+
+// Age returns the age of the entry at t
+func (e *Entry) Age(t time.Time) time.Duration {
+	return e.InitialAge + t.Sub(e.Stored)
+}
+
+// Fresh reports whether the entry may be served without revalidation at t
+func (e *Entry) Fresh(t time.Time) bool {
+	return t.Before(e.Expires)
+}
+
+// servableStale reports whether the entry may be served within a stale window
+func (e *Entry) servableStale(t time.Time, window time.Duration) bool {
+	return !e.MustRevalidate && window > 0 && t.Before(e.Expires.Add(window))
+}
+
+func (e *Entry) size() int64 {
+	size := int64(len(e.Key) + len(e.Body))
+	for name, values := range e.Header {
+		for _, value := range values {
+			size += int64(len(name) + len(value))
+		}
+	}
+	return size
+}
+
+// varyKey builds the full key of primary for the request headers
+func varyKey(primary string, vary []string, header http.Header) string {
+	if len(vary) == 0 {
+		return primary
+	}
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range vary {
+		b.WriteString("\x00")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// varyHeaders returns the sorted canonical names listed in Vary
+func varyHeaders(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// storeSettings configures a Store
+type storeSettings struct {
+	maxSize     int64
+	diskDir     string
+	diskMaxSize int64
+}
+
+// Store is an LRU of responses in memory, optionally backed by a disk tier
+// that takes the entries evicted from memory
+type Store struct {
+	name     string
+	settings storeSettings
+
+	mutex        sync.Mutex
+	size         int64
+	lru          *list.List
+	items        map[string]*list.Element
+	vary         map[string][]string
+	disk         *diskTier
+	revalidating map[string]bool
+}
+
+func newStore(name string, settings storeSettings) (*Store, error) {
+	s := &Store{
+		name:         name,
+		settings:     settings,
+		lru:          list.New(),
+		items:        make(map[string]*list.Element),
+		vary:         make(map[string][]string),
+		revalidating: make(map[string]bool),
+	}
+	if settings.diskDir != "" {
+		disk, err := openDiskTier(settings.diskDir, settings.diskMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		s.disk = disk
+		for primary, vary := range disk.varies() {
+			s.vary[primary] = vary
+		}
+	}
+	return s, nil
+}
+
+var (
+	storesMutex sync.Mutex
+	stores      = make(map[string]*Store)
+)
+
+// getStore returns the store registered under name, creating it when it does
+// not exist yet or was created with other settings. Stores outlive filters so
+// that cached responses survive config reloads.
+func getStore(name string, settings storeSettings) (*Store, error) {
+	storesMutex.Lock()
+	defer storesMutex.Unlock()
+
+	if s, ok := stores[name]; ok && s.settings == settings {
+		return s, nil
+	}
+	s, err := newStore(name, settings)
+	if err != nil {
+		return nil, err
+	}
+	stores[name] = s
+	return s, nil
+}
+
+// Lookup returns the entry stored for primary that matches the request headers
+func (s *Store) Lookup(primary string, header http.Header) *Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key := varyKey(primary, s.vary[primary], header)
+	if elem, ok := s.items[key]; ok {
+		s.lru.MoveToFront(elem)
+		return elem.Value.(*Entry)
+	}
+	if s.disk == nil {
+		return nil
+	}
+	e := s.disk.take(key)
+	if e == nil {
+		return nil
+	}
+	// Promote to memory; it returns to disk when evicted again
+	s.put(e)
+	return e
+}
+
+// Put stores an entry, replacing the one with the same key
+func (s *Store) Put(e *Entry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.vary[e.PrimaryKey] = e.Vary
+	if s.disk != nil {
+		s.disk.remove(e.Key)
+	}
+	s.put(e)
+}
+
+func (s *Store) put(e *Entry) {
+	if elem, ok := s.items[e.Key]; ok {
+		s.size -= elem.Value.(*Entry).size()
+		s.lru.Remove(elem)
+		delete(s.items, e.Key)
+	}
+	if e.size() > s.settings.maxSize {
+		return
+	}
+
+	s.items[e.Key] = s.lru.PushFront(e)
+	s.size += e.size()
+	for s.size > s.settings.maxSize {
+		oldest := s.lru.Back()
+		evicted := oldest.Value.(*Entry)
+		s.remove(oldest)
+		monitoring.CacheEvictionTotal.WithLabelValues(s.name, "memory").Inc()
+		if s.disk != nil {
+			s.disk.put(evicted, s.name)
+		}
+	}
+}
+
+func (s *Store) remove(elem *list.Element) {
+	e := elem.Value.(*Entry)
+	s.size -= e.size()
+	s.lru.Remove(elem)
+	delete(s.items, e.Key)
+}
+
+// Purge removes the entries for which match returns true and reports how
+// many were removed
+func (s *Store) Purge(match func(host, path string) bool) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	purged := 0
+	for elem := s.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if e := elem.Value.(*Entry); match(e.Host, e.Path) {
+			s.remove(elem)
+			purged++
+		}
+		elem = next
+	}
+	if s.disk != nil {
+		purged += s.disk.purge(match)
+	}
+	return purged
+}
+
+// startRevalidation reports whether the caller should revalidate key; only
+// one request revalidates a key in the background at a time
+func (s *Store) startRevalidation(key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.revalidating[key] {
+		return false
+	}
+	s.revalidating[key] = true
+	return true
+}
+
+func (s *Store) finishRevalidation(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.revalidating, key)
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/streaming"
+)
+
+// cacheWriter sits between the proxy and the client. It keeps a copy of
+// storable responses and holds back the upstream response when a stored
+// one is served instead: after a 304 revalidation or, within stale-if-error,
+// after a server error.
+type cacheWriter struct {
+	// client is nil for background revalidations
+	client  http.ResponseWriter
+	header  http.Header
+	ctx     *middleware.GatewayContext
+	filter  *Cache
+	request *http.Request
+	primary string
+	// entry is the stored response being revalidated, if any
+	entry      *Entry
+	background bool
+	// The client's own validators, checked against the final response
+	ifNoneMatch     string
+	ifModifiedSince string
+
+	status     int
+	suppressed bool
+	storable   bool
+	lifetime   time.Duration
+	body       []byte
+	overflow   bool
+}
+
+// Header returns the upstream response header
+func (w *cacheWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader decides whether the upstream response reaches the client and
+// whether it is stored
+func (w *cacheWriter) WriteHeader(status int) {
+	if w.status != 0 || status < 200 {
+		// Informational responses are dropped
+		return
+	}
+	w.status = status
+
+	if w.entry != nil {
+		if status == http.StatusNotModified {
+			w.suppressed = true
+			return
+		}
+		if status >= 500 && w.entry.servableStale(now(), w.entry.StaleIfError) {
+			w.suppressed = true
+			return
+		}
+	}
+
+	w.lifetime, w.storable = w.filter.storable(w.request, status, w.header)
+	if streaming.IsStream(w.ctx, w.header) {
+		w.storable = false
+	}
+	if w.client == nil {
+		return
+	}
+	header := w.client.Header()
+	for name, values := range w.header {
+		header[name] = values
+	}
+	header.Set("X-Cache", "MISS")
+	w.client.WriteHeader(status)
+}
+
+// Write passes the body to the client, keeping a copy if it is storable
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.suppressed {
+		return len(data), nil
+	}
+	if w.storable && !w.overflow {
+		if int64(len(w.body)+len(data)) > w.filter.maxEntrySize {
+			w.overflow = true
+			w.body = nil
+		} else {
+			w.body = append(w.body, data...)
+		}
+	}
+	if w.client == nil {
+		return len(data), nil
+	}
+	return w.client.Write(data)
+}
+
+// Flush flushes the client unless the upstream response is held back
+func (w *cacheWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.client != nil && !w.suppressed {
+		http.NewResponseController(w.client).Flush()
+	}
+}
+
+// Unwrap returns the client writer for http.ResponseController
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.client
+}
+
+// finish stores the response or serves the stored one
+func (w *cacheWriter) finish(ctx *middleware.GatewayContext) {
+	t := now()
+	switch {
+	case w.suppressed && w.status == http.StatusNotModified:
+		e := w.refreshed(t)
+		w.filter.store.Put(e)
+		if w.client != nil {
+			serveEntry(w.client, w.request.Method, w.ifNoneMatch, w.ifModifiedSince, e, "REVALIDATED")
+		}
+	case w.suppressed:
+		w.filter.count(ctx, "stale")
+		if w.client != nil {
+			serveEntry(w.client, w.request.Method, w.ifNoneMatch, w.ifModifiedSince, w.entry, "STALE")
+		}
+	case w.storable && !w.overflow && !ctx.Aborted():
+		// A body shorter than announced means the upstream response broke off
+		if length := w.header.Get("Content-Length"); length != "" && length != strconv.Itoa(len(w.body)) {
+			return
+		}
+		w.filter.store.Put(w.filter.newEntry(w.primary, w.request, w.status, w.header, w.body, w.lifetime, t))
+	}
+}
+
+// refreshed returns the revalidated entry updated with the 304 header
+func (w *cacheWriter) refreshed(t time.Time) *Entry {
+	header := w.entry.Header.Clone()
+	for name, values := range w.header {
+		if name != "Content-Length" {
+			header[name] = values
+		}
+	}
+	lifetime, ok := w.filter.storable(w.request, w.entry.Status, header)
+	if !ok {
+		lifetime = 0
+	}
+	return w.filter.newEntry(w.entry.PrimaryKey, w.request, w.entry.Status, header, w.entry.Body, lifetime, t)
+}
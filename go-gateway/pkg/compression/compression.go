@@ -0,0 +1,174 @@
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+)
+
+func init() {
+	filter.Register("Compression", NewCompression, CompressionArgs{})
+}
+
+// writerAttribute holds the compressWriter of a request until PostHandle
+const writerAttribute = "compression.writer"
+
+// DefaultContentTypes are the content types compressed when none are configured
+var DefaultContentTypes = []string{
+	"text/html", "text/plain", "text/css", "text/javascript", "text/xml", "text/csv",
+	"application/json", "application/javascript", "application/xml", "application/wasm",
+	"image/svg+xml",
+}
+
+// CompressionArgs configures the Compression filter
+type CompressionArgs struct {
+	// Algorithms lists the codings to offer in order of preference: br, zstd, gzip
+	Algorithms []string `mapstructure:"algorithms" json:"algorithms,omitempty"`
+	// MinSize is the smallest body size in bytes worth compressing
+	MinSize int `mapstructure:"minSize" json:"minSize,omitempty"`
+	// ContentTypes lists the media types to compress, "text/*" matches a whole type
+	ContentTypes []string `mapstructure:"contentTypes" json:"contentTypes,omitempty"`
+	// DecompressRequests decompresses gzip request bodies before proxying
+	DecompressRequests bool `mapstructure:"decompressRequests" json:"decompressRequests,omitempty"`
+	// MaxDecompressedSize limits the size of decompressed request bodies in bytes
+	MaxDecompressedSize int64 `mapstructure:"maxDecompressedSize" json:"maxDecompressedSize,omitempty"`
+}
+
+// Compression compresses responses and optionally decompresses requests
+type Compression struct {
+	algorithms          []string
+	minSize             int
+	contentTypes        map[string]bool
+	decompressRequests  bool
+	maxDecompressedSize int64
+}
+
+// NewCompression creates a Compression filter from its args
+func NewCompression(args interface{}) (middleware.Middleware, error) {
+	cfg := CompressionArgs{
+		Algorithms:          []string{"br", "zstd", "gzip"},
+		MinSize:             1024,
+		ContentTypes:        DefaultContentTypes,
+		MaxDecompressedSize: 10 << 20,
+	}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MinSize < 0 {
+		return nil, fmt.Errorf("minSize must not be negative")
+	}
+	if cfg.MaxDecompressedSize <= 0 {
+		return nil, fmt.Errorf("maxDecompressedSize must be positive")
+	}
+
+	c := &Compression{
+		minSize:             cfg.MinSize,
+		contentTypes:        make(map[string]bool),
+		decompressRequests:  cfg.DecompressRequests,
+		maxDecompressedSize: cfg.MaxDecompressedSize,
+	}
+	for _, algorithm := range cfg.Algorithms {
+		algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+		if _, ok := encoders[algorithm]; !ok {
+			return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+		}
+		c.algorithms = append(c.algorithms, algorithm)
+	}
+	if len(c.algorithms) == 0 {
+		return nil, fmt.Errorf("algorithms must not be empty")
+	}
+	for _, contentType := range cfg.ContentTypes {
+		c.contentTypes[strings.ToLower(strings.TrimSpace(contentType))] = true
+	}
+	return c, nil
+}
+
+// Name returns the filter name
+func (c *Compression) Name() string {
+	return "Compression"
+}
+
+// PreHandle decompresses the request body and wraps the response
+func (c *Compression) PreHandle(ctx *middleware.GatewayContext) bool {
+	r := ctx.Request
+	if c.decompressRequests && r.Body != nil && strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(ctx.Response, "Invalid gzip request body", http.StatusBadRequest)
+			return false
+		}
+		r.Body = http.MaxBytesReader(ctx.Response, reader, c.maxDecompressedSize)
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+	}
+
+	// Compressing partial or body-less responses would corrupt them
+	if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+		return true
+	}
+
+	w := &compressWriter{
+		ResponseWriter: ctx.Response,
+		ctx:            ctx,
+		filter:         c,
+		coding:         negotiate(r.Header.Get("Accept-Encoding"), c.algorithms),
+	}
+	ctx.Response = w
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	ctx.Attributes[writerAttribute] = w
+	return true
+}
+
+// PostHandle finishes the compressed response
+func (c *Compression) PostHandle(ctx *middleware.GatewayContext) error {
+	w, ok := ctx.Attributes[writerAttribute].(*compressWriter)
+	if !ok {
+		return nil
+	}
+	return w.Close()
+}
+
+// HandleError does nothing; the client has already received the response
+func (c *Compression) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// compressible reports whether a response with this status and header may be
+// compressed
+func (c *Compression) compressible(status int, header http.Header) bool {
+	switch {
+	case status < 200, status == http.StatusNoContent, status == http.StatusPartialContent, status == http.StatusNotModified:
+		return false
+	case header.Get("Content-Encoding") != "" && !strings.EqualFold(header.Get("Content-Encoding"), "identity"):
+		return false
+	case strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform"):
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	if c.contentTypes[mediaType] {
+		return true
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	return c.contentTypes[major+"/*"]
+}
+
+// contentLength returns the declared Content-Length, or -1 when unknown
+func contentLength(header http.Header) int {
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return -1
+	}
+	return n
+}
@@ -0,0 +1,225 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"go-gateway/pkg/middleware"
+)
+
+// TestNegotiate tests Accept-Encoding negotiation
+func TestNegotiate(t *testing.T) {
+	supported := []string{"br", "zstd", "gzip"}
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, *", "zstd"},
+		{"identity", ""},
+		{"GZIP;q=0.8, zstd;q=0.9", "zstd"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiate(tt.accept, supported); got != tt.expected {
+			t.Errorf("negotiate(%q) = %q, expected %q", tt.accept, got, tt.expected)
+		}
+	}
+}
+
+func serve(t *testing.T, args map[string]interface{}, req *http.Request, backend func(w http.ResponseWriter)) *httptest.ResponseRecorder {
+	t.Helper()
+	f, err := NewCompression(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	ctx := &middleware.GatewayContext{Request: req, Response: rec}
+	middleware.NewMiddlewareChain([]middleware.Middleware{f}).Handle(ctx, func(ctx *middleware.GatewayContext) {
+		backend(ctx.Response)
+	})
+	return rec
+}
+
+func decode(t *testing.T, coding string, body []byte) string {
+	t.Helper()
+	var reader io.Reader
+	switch coding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Invalid gzip body: %v", err)
+		}
+		reader = zr
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("Invalid zstd body: %v", err)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return string(body)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to decode %s body: %v", coding, err)
+	}
+	return string(data)
+}
+
+// TestResponseCompression tests which responses get compressed
+func TestResponseCompression(t *testing.T) {
+	large := strings.Repeat(`{"hello":"world"}`, 200)
+
+	for _, coding := range []string{"gzip", "br", "zstd"} {
+		t.Run(coding, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://gateway/data", nil)
+			req.Header.Set("Accept-Encoding", coding)
+			rec := serve(t, nil, req, func(w http.ResponseWriter) {
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.Header().Set("Content-Length", "3400")
+				w.Header().Set("ETag", `"v1"`)
+				w.Write([]byte(large[:1000]))
+				w.Write([]byte(large[1000:]))
+			})
+
+			if got := rec.Header().Get("Content-Encoding"); got != coding {
+				t.Fatalf("Expected Content-Encoding %s, got %q", coding, got)
+			}
+			if rec.Header().Get("Content-Length") != "" {
+				t.Error("Expected Content-Length to be removed")
+			}
+			if got := rec.Header().Get("ETag"); got != `W/"v1"` {
+				t.Errorf("Expected weak ETag, got %q", got)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Expected Vary: Accept-Encoding, got %q", got)
+			}
+			if got := decode(t, coding, rec.Body.Bytes()); got != large {
+				t.Error("Decompressed body does not match")
+			}
+		})
+	}
+
+	skipped := []struct {
+		name    string
+		accept  string
+		header  map[string]string
+		body    string
+		expVary bool
+	}{
+		{"NotAccepted", "", map[string]string{"Content-Type": "text/html"}, large, true},
+		{"TooSmall", "gzip", map[string]string{"Content-Type": "text/html"}, "<p>hi</p>", true},
+		{"ImageType", "gzip", map[string]string{"Content-Type": "image/png"}, large, false},
+		{"AlreadyCompressed", "gzip", map[string]string{"Content-Type": "text/html", "Content-Encoding": "br"}, large, false},
+		{"NoTransform", "gzip", map[string]string{"Content-Type": "text/html", "Cache-Control": "no-transform"}, large, false},
+	}
+	for _, tt := range skipped {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://gateway/data", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := serve(t, nil, req, func(w http.ResponseWriter) {
+				for name, value := range tt.header {
+					w.Header().Set(name, value)
+				}
+				w.Write([]byte(tt.body))
+			})
+			if got := rec.Header().Get("Content-Encoding"); got != tt.header["Content-Encoding"] {
+				t.Errorf("Expected Content-Encoding %q, got %q", tt.header["Content-Encoding"], got)
+			}
+			if rec.Body.String() != tt.body {
+				t.Error("Expected body to pass through unchanged")
+			}
+			if got := rec.Header().Get("Vary") == "Accept-Encoding"; got != tt.expVary {
+				t.Errorf("Expected Vary: Accept-Encoding %v, got %v", tt.expVary, got)
+			}
+		})
+	}
+}
+
+// TestStreaming tests that flushed responses reach the client immediately
+func TestStreaming(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://gateway/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	var flushed string
+	rec := serve(t, map[string]interface{}{"contentTypes": "text/*"}, req, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: 1\n\n"))
+		w.(http.Flusher).Flush()
+		flushed = recorder(w).Body.String()
+		w.Write([]byte("data: 2\n\n"))
+	})
+
+	if flushed != "data: 1\n\n" {
+		t.Errorf("Expected first event to be flushed, got %q", flushed)
+	}
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("Expected uncompressed stream, got %q", rec.Body.String())
+	}
+
+	// Streams are never buffered, even when no flush follows a write
+	rec = serve(t, map[string]interface{}{"contentTypes": "application/*", "minSize": 1}, req, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{\"n\":1}\n"))
+		flushed = recorder(w).Body.String()
+	})
+	if flushed != "{\"n\":1}\n" || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Expected NDJSON stream to pass through, got %q", flushed)
+	}
+}
+
+// recorder returns the recorder underneath the compression writer
+func recorder(w http.ResponseWriter) *httptest.ResponseRecorder {
+	return w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder)
+}
+
+// TestRequestDecompression tests gzip request bodies are decompressed
+func TestRequestDecompression(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("payload"))
+	zw.Close()
+
+	f, err := NewCompression(map[string]interface{}{"decompressRequests": true})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "http://gateway/upload", bytes.NewReader(compressed.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	ctx := &middleware.GatewayContext{Request: req, Response: httptest.NewRecorder()}
+	if !f.PreHandle(ctx) {
+		t.Fatal("Expected request to pass")
+	}
+	body, _ := io.ReadAll(ctx.Request.Body)
+	if string(body) != "payload" || ctx.Request.Header.Get("Content-Encoding") != "" || ctx.Request.ContentLength != -1 {
+		t.Errorf("Expected decompressed body, got %q", body)
+	}
+
+	req = httptest.NewRequest("POST", "http://gateway/upload", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	if f.PreHandle(&middleware.GatewayContext{Request: req, Response: rec}) || rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid gzip body, got %d", rec.Code)
+	}
+
+	if _, err := NewCompression(map[string]interface{}{"algorithms": "deflate"}); err == nil {
+		t.Error("Expected error for unsupported algorithm")
+	}
+}
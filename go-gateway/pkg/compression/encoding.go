@@ -0,0 +1,98 @@
+package compression
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is a reusable streaming compressor
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders pools compressors per content coding; zstd encoders in particular
+// are expensive to create
+var encoders = map[string]*sync.Pool{
+	"gzip": {New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}},
+	"br": {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	"zstd": {New: func() interface{} {
+		// Browsers only accept windows up to 8MB (RFC 8878)
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(8<<20))
+		return enc
+	}},
+}
+
+func getEncoder(coding string, w io.Writer) encoder {
+	enc := encoders[coding].Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func putEncoder(coding string, enc encoder) {
+	enc.Reset(nil)
+	encoders[coding].Put(enc)
+}
+
+// negotiate picks the coding from supported (in preference order) with the
+// highest quality in the Accept-Encoding header. It returns "" when none
+// of them is acceptable.
+func negotiate(acceptEncoding string, supported []string) string {
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		if coding == "*" {
+			wildcard = q
+		} else {
+			qualities[coding] = q
+		}
+	}
+
+	candidates := make([]string, 0, len(supported))
+	for _, coding := range supported {
+		q, ok := qualities[coding]
+		if !ok {
+			q = wildcard
+		}
+		if q > 0 {
+			candidates = append(candidates, coding)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	quality := func(coding string) float64 {
+		if q, ok := qualities[coding]; ok {
+			return q
+		}
+		return wildcard
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return quality(candidates[i]) > quality(candidates[j])
+	})
+	return candidates[0]
+}
@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/streaming"
+)
+
+// compressWriter buffers the start of a response until it knows whether the
+// response is worth compressing, then either compresses or passes it through
+type compressWriter struct {
+	http.ResponseWriter
+	ctx     *middleware.GatewayContext
+	filter  *Compression
+	coding  string
+	status  int
+	decided bool
+	buf     bytes.Buffer
+	enc     encoder
+}
+
+// WriteHeader holds the header back until the body shows whether to compress
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+
+	// Streams are passed through as they arrive
+	if !w.filter.compressible(status, w.Header()) || streaming.IsStream(w.ctx, w.Header()) {
+		w.passThrough()
+		return
+	}
+	addVary(w.Header(), "Accept-Encoding")
+	if w.coding == "" || contentLength(w.Header()) >= 0 && contentLength(w.Header()) < w.filter.minSize {
+		w.passThrough()
+	}
+}
+
+// Write buffers up to minSize bytes before deciding
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(data)
+	}
+	if w.decided {
+		return w.ResponseWriter.Write(data)
+	}
+
+	n, _ := w.buf.Write(data)
+	if w.buf.Len() >= w.filter.minSize {
+		if err := w.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush sends what is buffered so far so streaming responses keep flowing.
+// A response flushed before reaching minSize is not compressed.
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		if err := w.finish(); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish settles a response that is still buffered
+func (w *compressWriter) finish() error {
+	if w.buf.Len() >= w.filter.minSize {
+		return w.startCompression()
+	}
+	w.passThrough()
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// Close completes the response
+func (w *compressWriter) Close() error {
+	if w.status == 0 {
+		// Nothing was written, leave the response untouched
+		return nil
+	}
+	if !w.decided {
+		if err := w.finish(); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	putEncoder(w.coding, w.enc)
+	w.enc = nil
+	return err
+}
+
+func (w *compressWriter) passThrough() {
+	w.decided = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *compressWriter) startCompression() error {
+	w.decided = true
+	header := w.Header()
+	header.Set("Content-Encoding", w.coding)
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	// The compressed body is a different representation
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	w.enc = getEncoder(w.coding, w.ResponseWriter)
+	_, err := w.enc.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// addVary adds value to the Vary header unless it is already listed
+func addVary(header http.Header, value string) {
+	for _, existing := range header.Values("Vary") {
+		for _, v := range strings.Split(existing, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.EqualFold(v, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
@@ -14,6 +15,156 @@ type Config struct {
 	Routes        []common.Route `json:"routes" mapstructure:"routes"`
 	GlobalFilters []GlobalFilter `json:"global_filters" mapstructure:"global_filters"`
 	Port          int            `json:"port" mapstructure:"port"`
+	// H2C accepts HTTP/2 without TLS (prior knowledge) on the plain port,
+	// as used by gRPC clients
+	H2C bool       `json:"h2c,omitempty" mapstructure:"h2c"`
+	TLS *TLSConfig `json:"tls,omitempty" mapstructure:"tls"`
+	// ProxyProtocol reads the PROXY protocol header on the HTTP and TLS
+	// listeners
+	ProxyProtocol *ProxyProtocolConfig `json:"proxy_protocol,omitempty" mapstructure:"proxy_protocol"`
+	// TrustedProxies lists the proxy CIDRs whose forwarding headers are trusted
+	// to carry the real client address
+	TrustedProxies []string `json:"trusted_proxies,omitempty" mapstructure:"trusted_proxies"`
+	// Timeouts bound the time spent proxying a request
+	Timeouts *TimeoutsConfig `json:"timeouts,omitempty" mapstructure:"timeouts"`
+	// Services are the backend pools addressed by lb://<name> URIs
+	Services []ServiceConfig `json:"services,omitempty" mapstructure:"services"`
+	// TCPListeners proxy raw TCP connections to services
+	TCPListeners []TCPListenerConfig `json:"tcp_listeners,omitempty" mapstructure:"tcp_listeners"`
+	// UDPListeners proxy UDP datagrams to services
+	UDPListeners []UDPListenerConfig `json:"udp_listeners,omitempty" mapstructure:"udp_listeners"`
+	// Egress runs a forward proxy controlling outbound traffic
+	Egress *EgressConfig `json:"egress,omitempty" mapstructure:"egress"`
+	// Admin serves the REST API managing routes, services and global
+	// filters at runtime
+	Admin *AdminConfig `json:"admin,omitempty" mapstructure:"admin"`
+}
+
+// ServiceConfig defines a pool of backend servers
+type ServiceConfig struct {
+	Name string `json:"name" mapstructure:"name"`
+	// Strategy is one of round_robin (default), random, weighted_round_robin
+	// or consistent_hash
+	Strategy string         `json:"strategy,omitempty" mapstructure:"strategy"`
+	Servers  []ServerConfig `json:"servers" mapstructure:"servers"`
+	// HealthCheck takes failing servers out of the pool until they recover
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty" mapstructure:"health_check"`
+}
+
+// ServerConfig defines a backend server, e.g. http://10.0.0.1:8080 or
+// tcp://10.0.0.1:5432
+type ServerConfig struct {
+	URL    string `json:"url" mapstructure:"url"`
+	Weight int    `json:"weight,omitempty" mapstructure:"weight"`
+}
+
+// HealthCheckConfig defines active health checks of a service
+type HealthCheckConfig struct {
+	Interval time.Duration `json:"interval,omitempty" mapstructure:"interval"`
+	Timeout  time.Duration `json:"timeout,omitempty" mapstructure:"timeout"`
+	// Path makes the check an HTTP GET of http(s) servers; without it the
+	// check only opens a TCP connection
+	Path string `json:"path,omitempty" mapstructure:"path"`
+	// HealthyThreshold and UnhealthyThreshold are the consecutive results
+	// needed to change the state of a server
+	HealthyThreshold   int `json:"healthy_threshold,omitempty" mapstructure:"healthy_threshold"`
+	UnhealthyThreshold int `json:"unhealthy_threshold,omitempty" mapstructure:"unhealthy_threshold"`
+}
+
+// TCPListenerConfig defines a listener proxying TCP connections
+type TCPListenerConfig struct {
+	Name string `json:"name" mapstructure:"name"`
+	Port int    `json:"port" mapstructure:"port"`
+	// URI is the upstream of connections not routed by SNI: lb://<service>
+	// or tcp://host:port
+	URI string `json:"uri,omitempty" mapstructure:"uri"`
+	// IdleTimeout closes connections without traffic in either direction
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty" mapstructure:"idle_timeout"`
+	// TLS terminates TLS on the listener; its port is ignored
+	TLS *TLSConfig `json:"tls,omitempty" mapstructure:"tls"`
+	// SNI routes connections by the server name of the TLS handshake.
+	// Without TLS the connections are passed through still encrypted.
+	SNI []SNIRouteConfig `json:"sni,omitempty" mapstructure:"sni"`
+	// ProxyProtocol reads the PROXY protocol header of connections
+	ProxyProtocol *ProxyProtocolConfig `json:"proxy_protocol,omitempty" mapstructure:"proxy_protocol"`
+	// SendProxyProtocol sends the client address to upstreams in a PROXY
+	// protocol header: v1 or v2
+	SendProxyProtocol string `json:"send_proxy_protocol,omitempty" mapstructure:"send_proxy_protocol"`
+}
+
+// SNIRouteConfig routes the TLS connections for some server names
+type SNIRouteConfig struct {
+	// ServerNames are exact names or wildcards such as *.example.com
+	ServerNames []string `json:"server_names" mapstructure:"server_names"`
+	URI         string   `json:"uri" mapstructure:"uri"`
+}
+
+// UDPListenerConfig defines a listener proxying UDP datagrams. Each client
+// address gets a session with its own upstream socket, so that replies go
+// back to the right client.
+type UDPListenerConfig struct {
+	Name string `json:"name" mapstructure:"name"`
+	Port int    `json:"port" mapstructure:"port"`
+	// URI is the upstream: lb://<service> or udp://host:port
+	URI string `json:"uri" mapstructure:"uri"`
+	// IdleTimeout ends sessions without datagrams in either direction
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty" mapstructure:"idle_timeout"`
+	// MaxSessions limits the concurrent sessions; datagrams of new clients
+	// beyond it are dropped
+	MaxSessions int `json:"max_sessions,omitempty" mapstructure:"max_sessions"`
+}
+
+// EgressConfig defines the forward proxy for outbound traffic. It serves
+// absolute-URI requests and CONNECT tunnels on its own port.
+type EgressConfig struct {
+	Port int `json:"port" mapstructure:"port"`
+	// Allow lists the reachable destinations as routes of predicates, e.g.
+	// Host. Requests matching none of them are refused.
+	Allow []common.Route `json:"allow" mapstructure:"allow"`
+	// IdleTimeout closes tunnels without traffic in either direction
+	IdleTimeout time.Duration `json:"idle_timeout,omitempty" mapstructure:"idle_timeout"`
+}
+
+// AdminConfig defines the admin API server
+type AdminConfig struct {
+	Port int `json:"port" mapstructure:"port"`
+	// Tokens are the bearer tokens accepted from operators
+	Tokens []string `json:"tokens" mapstructure:"tokens"`
+	// Save writes every change back to the config file
+	Save bool `json:"save,omitempty" mapstructure:"save"`
+}
+
+// ProxyProtocolConfig defines the PROXY protocol header of a listener, which
+// load balancers in front of the gateway use to pass on the client address
+type ProxyProtocolConfig struct {
+	// Trusted are the CIDRs of the load balancers. Their connections must
+	// start with a header; others are taken as is, so that clients cannot
+	// claim another address.
+	Trusted []string `json:"trusted" mapstructure:"trusted"`
+}
+
+// TLSConfig defines the TLS listener
+type TLSConfig struct {
+	Port     int    `json:"port" mapstructure:"port"`
+	CertFile string `json:"cert_file" mapstructure:"cert_file"`
+	KeyFile  string `json:"key_file" mapstructure:"key_file"`
+	// ClientCAFile is the CA bundle used to verify client certificates
+	ClientCAFile string `json:"client_ca_file,omitempty" mapstructure:"client_ca_file"`
+	// ClientAuth is one of none, request, verify_if_given or require.
+	// It defaults to verify_if_given when ClientCAFile is set, so that routes
+	// can decide individually whether a certificate is required.
+	ClientAuth string `json:"client_auth,omitempty" mapstructure:"client_auth"`
+	// HTTP3 serves HTTP/3 on the same port over UDP and advertises it to
+	// HTTPS clients with Alt-Svc. It only applies to the gateway listener.
+	HTTP3 bool `json:"http3,omitempty" mapstructure:"http3"`
+}
+
+// TimeoutsConfig defines request timeouts, e.g. "30s"
+type TimeoutsConfig struct {
+	// Request limits the total time of a request; streaming responses are exempt
+	Request time.Duration `json:"request,omitempty" mapstructure:"request"`
+	// Idle limits the time a streaming response may go without data
+	Idle time.Duration `json:"idle,omitempty" mapstructure:"idle"`
 }
 
 // GlobalFilter defines global filter
@@ -67,6 +218,10 @@ func (vcm *ViperConfigManager) Load(configPath string) error {
 	if err := vcm.viper.Unmarshal(&config); err != nil {
 		return fmt.Errorf("error unmarshaling config: %w", err)
 	}
+	// 无效的配置不替换当前配置
+	if err := Validate(config); err != nil {
+		return err
+	}
 
 	vcm.mutex.Lock()
 	defer vcm.mutex.Unlock()
@@ -75,20 +230,30 @@ func (vcm *ViperConfigManager) Load(configPath string) error {
 	return nil
 }
 
-// Save saves config to file using viper
+// Save saves config to file using viper. The file is written from the
+// current config, so that later edits of the file are picked up by Load;
+// keys the config does not know are kept.
 func (vcm *ViperConfigManager) Save(configPath string) error {
 	vcm.mutex.RLock()
 	defer vcm.mutex.RUnlock()
 
-	// 设置配置值
-	vcm.viper.Set("routes", vcm.config.Routes)
-	vcm.viper.Set("global_filters", vcm.config.GlobalFilters)
-	vcm.viper.Set("port", vcm.config.Port)
+	settings := vcm.viper.AllSettings()
+	for key, value := range configSettings(vcm.config) {
+		if value == nil {
+			delete(settings, key)
+		} else {
+			settings[key] = value
+		}
+	}
 
-	// 写入文件
-	if err := vcm.viper.WriteConfigAs(configPath); err != nil {
+	// 使用单独的viper写文件，避免Set的值覆盖之后读取的配置文件
+	out := viper.New()
+	if err := out.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("error saving config file: %w", err)
+	}
+	if err := out.WriteConfigAs(configPath); err != nil {
 		// 如果配置文件不存在，使用SafeWriteConfigAs创建它
-		if err := vcm.viper.SafeWriteConfigAs(configPath); err != nil {
+		if err := out.SafeWriteConfigAs(configPath); err != nil {
 			return fmt.Errorf("error saving config file: %w", err)
 		}
 	}
@@ -96,6 +261,129 @@ func (vcm *ViperConfigManager) Save(configPath string) error {
 	return nil
 }
 
+// configSettings returns every key of config as written to the config file.
+// Empty lists are written too and unset sections are nil, so that removed
+// ones do not come back.
+func configSettings(config Config) map[string]interface{} {
+	settings := map[string]interface{}{
+		"routes":          nonNil(config.Routes),
+		"global_filters":  nonNil(config.GlobalFilters),
+		"port":            config.Port,
+		"h2c":             config.H2C,
+		"trusted_proxies": nonNil(config.TrustedProxies),
+		"services":        servicesSetting(config.Services),
+		"tcp_listeners":   tcpListenersSetting(config.TCPListeners),
+		"udp_listeners":   udpListenersSetting(config.UDPListeners),
+		"tls":             nil,
+		"proxy_protocol":  nil,
+		"timeouts":        nil,
+		"egress":          nil,
+		"admin":           nil,
+	}
+	if config.TLS != nil {
+		settings["tls"] = config.TLS
+	}
+	if config.ProxyProtocol != nil {
+		settings["proxy_protocol"] = config.ProxyProtocol
+	}
+	if config.Timeouts != nil {
+		settings["timeouts"] = map[string]string{
+			"request": config.Timeouts.Request.String(),
+			"idle":    config.Timeouts.Idle.String(),
+		}
+	}
+	if config.Egress != nil {
+		settings["egress"] = map[string]interface{}{
+			"port":         config.Egress.Port,
+			"allow":        nonNil(config.Egress.Allow),
+			"idle_timeout": config.Egress.IdleTimeout.String(),
+		}
+	}
+	if config.Admin != nil {
+		settings["admin"] = config.Admin
+	}
+	return settings
+}
+
+// nonNil returns an empty slice for nil, which is written as [] rather than null
+func nonNil[T any](values []T) []T {
+	if values == nil {
+		return []T{}
+	}
+	return values
+}
+
+// servicesSetting returns services as written to the config file, with
+// readable durations
+func servicesSetting(services []ServiceConfig) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(services))
+	for _, service := range services {
+		setting := map[string]interface{}{
+			"name":    service.Name,
+			"servers": service.Servers,
+		}
+		if service.Strategy != "" {
+			setting["strategy"] = service.Strategy
+		}
+		if hc := service.HealthCheck; hc != nil {
+			setting["health_check"] = map[string]interface{}{
+				"interval":            hc.Interval.String(),
+				"timeout":             hc.Timeout.String(),
+				"path":                hc.Path,
+				"healthy_threshold":   hc.HealthyThreshold,
+				"unhealthy_threshold": hc.UnhealthyThreshold,
+			}
+		}
+		result = append(result, setting)
+	}
+	return result
+}
+
+// tcpListenersSetting returns TCP listeners as written to the config file
+func tcpListenersSetting(listeners []TCPListenerConfig) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(listeners))
+	for _, l := range listeners {
+		setting := map[string]interface{}{
+			"name":         l.Name,
+			"port":         l.Port,
+			"uri":          l.URI,
+			"idle_timeout": l.IdleTimeout.String(),
+		}
+		if l.TLS != nil {
+			setting["tls"] = l.TLS
+		}
+		if len(l.SNI) > 0 {
+			setting["sni"] = l.SNI
+		}
+		if l.ProxyProtocol != nil {
+			setting["proxy_protocol"] = l.ProxyProtocol
+		}
+		if l.SendProxyProtocol != "" {
+			setting["send_proxy_protocol"] = l.SendProxyProtocol
+		}
+		result = append(result, setting)
+	}
+	return result
+}
+
+// udpListenersSetting returns UDP listeners as written to the config file
+func udpListenersSetting(listeners []UDPListenerConfig) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(listeners))
+	for _, l := range listeners {
+		setting := map[string]interface{}{
+			"name":         l.Name,
+			"port":         l.Port,
+			"uri":          l.URI,
+			"idle_timeout": l.IdleTimeout.String(),
+		}
+		if l.MaxSessions != 0 {
+			setting["max_sessions"] = l.MaxSessions
+		}
+		result = append(result, setting)
+	}
+	return result
+}
+
 // GetRoutes gets all routes
 func (vcm *ViperConfigManager) GetRoutes() []common.Route {
 	vcm.mutex.RLock()
@@ -172,6 +460,10 @@ func (vcm *ViperConfigManager) GetConfig() Config {
 	config.GlobalFilters = make([]GlobalFilter, len(vcm.config.GlobalFilters))
 	copy(config.GlobalFilters, vcm.config.GlobalFilters)
 
+	config.Services = append([]ServiceConfig(nil), vcm.config.Services...)
+	config.TCPListeners = append([]TCPListenerConfig(nil), vcm.config.TCPListeners...)
+	config.UDPListeners = append([]UDPListenerConfig(nil), vcm.config.UDPListeners...)
+
 	return config
 }
 
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	"go-gateway/pkg/common"
 )
@@ -58,6 +60,38 @@ func TestViperConfigManager(t *testing.T) {
 				},
 			},
 			Port: 8080,
+			TLS:  &TLSConfig{Port: 8443, CertFile: "server.pem", KeyFile: "server-key.pem", HTTP3: true},
+			Egress: &EgressConfig{
+				Port:        3128,
+				IdleTimeout: time.Minute,
+				Allow: []common.Route{
+					{ID: "github", Predicates: []common.Predicate{{Name: "Host", Args: map[string]string{"pattern": "**.github.com"}}}},
+				},
+			},
+			Admin:         &AdminConfig{Port: 9091, Tokens: []string{"s3cret"}, Save: true},
+			ProxyProtocol: &ProxyProtocolConfig{Trusted: []string{"10.0.0.1"}},
+			Timeouts:      &TimeoutsConfig{Request: 30 * time.Second, Idle: 5 * time.Minute},
+			Services: []ServiceConfig{
+				{
+					Name:     "postgres",
+					Strategy: "weighted_round_robin",
+					Servers:  []ServerConfig{{URL: "tcp://db1:5432", Weight: 3}, {URL: "tcp://db2:5432", Weight: 1}},
+					HealthCheck: &HealthCheckConfig{
+						Interval: 10 * time.Second, Timeout: 2 * time.Second, HealthyThreshold: 2, UnhealthyThreshold: 3,
+					},
+				},
+			},
+			TCPListeners: []TCPListenerConfig{
+				{
+					Name: "postgres", Port: 5432, URI: "lb://postgres", IdleTimeout: time.Hour,
+					SNI:               []SNIRouteConfig{{ServerNames: []string{"*.db.example.com"}, URI: "lb://postgres"}},
+					ProxyProtocol:     &ProxyProtocolConfig{Trusted: []string{"10.0.0.0/8"}},
+					SendProxyProtocol: "v2",
+				},
+			},
+			UDPListeners: []UDPListenerConfig{
+				{Name: "dns", Port: 53, URI: "lb://dns", IdleTimeout: 5 * time.Second, MaxSessions: 500},
+			},
 		}
 
 		// Create config manager and save config
@@ -95,6 +129,38 @@ func TestViperConfigManager(t *testing.T) {
 			t.Errorf("Expected 2 global filters, got %d", len(loadedConfig.GlobalFilters))
 		}
 
+		if e := loadedConfig.Egress; e == nil || e.Port != 3128 || e.IdleTimeout != time.Minute || len(e.Allow) != 1 || e.Allow[0].ID != "github" {
+			t.Errorf("Expected egress %+v, got %+v", testConfig.Egress, loadedConfig.Egress)
+		}
+
+		if !reflect.DeepEqual(loadedConfig.TLS, testConfig.TLS) {
+			t.Errorf("Expected tls %+v, got %+v", testConfig.TLS, loadedConfig.TLS)
+		}
+
+		if !reflect.DeepEqual(loadedConfig.Admin, testConfig.Admin) {
+			t.Errorf("Expected admin %+v, got %+v", testConfig.Admin, loadedConfig.Admin)
+		}
+
+		if !reflect.DeepEqual(loadedConfig.ProxyProtocol, testConfig.ProxyProtocol) {
+			t.Errorf("Expected proxy protocol %+v, got %+v", testConfig.ProxyProtocol, loadedConfig.ProxyProtocol)
+		}
+
+		if loadedConfig.Timeouts == nil || loadedConfig.Timeouts.Request != 30*time.Second || loadedConfig.Timeouts.Idle != 5*time.Minute {
+			t.Errorf("Expected timeouts 30s/5m, got %+v", loadedConfig.Timeouts)
+		}
+
+		if !reflect.DeepEqual(loadedConfig.Services, testConfig.Services) {
+			t.Errorf("Expected services %+v, got %+v", testConfig.Services, loadedConfig.Services)
+		}
+
+		if !reflect.DeepEqual(loadedConfig.TCPListeners, testConfig.TCPListeners) {
+			t.Errorf("Expected tcp listeners %+v, got %+v", testConfig.TCPListeners, loadedConfig.TCPListeners)
+		}
+
+		if !reflect.DeepEqual(loadedConfig.UDPListeners, testConfig.UDPListeners) {
+			t.Errorf("Expected udp listeners %+v, got %+v", testConfig.UDPListeners, loadedConfig.UDPListeners)
+		}
+
 		// Clean up temporary file
 		os.Remove(tempConfigFile)
 	})
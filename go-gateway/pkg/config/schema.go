@@ -0,0 +1,135 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/route"
+)
+
+// durationPattern matches the durations accepted by time.ParseDuration
+const durationPattern = `^[-+]?([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$`
+
+var (
+	durationType     = reflect.TypeOf(time.Duration(0))
+	predicateType    = reflect.TypeOf(common.Predicate{})
+	filterType       = reflect.TypeOf(common.Filter{})
+	globalFilterType = reflect.TypeOf(GlobalFilter{})
+)
+
+// Schema returns the JSON Schema (draft-07) of the config file, for editors
+// to complete and check configs. The args of predicates and filters are
+// described by the typed args they are registered with, so only those linked
+// into the binary are known.
+func Schema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "go-gateway config"
+	// Unknown top-level keys are ignored by the gateway, and configs use them
+	// for notes or to name their schema
+	schema["additionalProperties"] = true
+
+	// Unknown global filters are skipped with a warning, so their names are
+	// only suggested
+	globalFilter := namedArgsSchema(filter.Names(), filter.ArgsType)
+	properties := globalFilter["properties"].(map[string]interface{})
+	properties["name"] = map[string]interface{}{"anyOf": []interface{}{properties["name"], map[string]interface{}{"type": "string"}}}
+
+	schema["definitions"] = map[string]interface{}{
+		"predicate":     namedArgsSchema(route.PredicateNames(), route.PredicateArgsType),
+		"filter":        namedArgsSchema(filter.Names(), filter.ArgsType),
+		"global_filter": globalFilter,
+	}
+	return schema
+}
+
+// namedArgsSchema describes a predicate or a filter: a registered name and
+// the args of that name
+func namedArgsSchema(names []string, argsType func(name string) reflect.Type) map[string]interface{} {
+	cases := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		args := map[string]interface{}{"type": "object", "maxProperties": 0}
+		if t := argsType(name); t != nil {
+			args = typeSchema(t)
+		}
+		cases = append(cases, map[string]interface{}{
+			"if":   map[string]interface{}{"properties": map[string]interface{}{"name": map[string]interface{}{"const": name}}},
+			"then": map[string]interface{}{"properties": map[string]interface{}{"args": args}},
+		})
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{"type": "string", "enum": names},
+			"args": map[string]interface{}{},
+		},
+		"required":             []string{"name"},
+		"additionalProperties": false,
+		"allOf":                cases,
+	}
+}
+
+// typeSchema describes the values decoded into t. Like the decoder it
+// accepts durations as strings such as "10s" or as nanoseconds, and string
+// lists as comma separated strings.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case durationType:
+		return map[string]interface{}{"type": []string{"string", "integer"}, "pattern": durationPattern}
+	case predicateType:
+		return map[string]interface{}{"$ref": "#/definitions/predicate"}
+	case filterType:
+		return map[string]interface{}{"$ref": "#/definitions/filter"}
+	case globalFilterType:
+		return map[string]interface{}{"$ref": "#/definitions/global_filter"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		schema := map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+		if t.Elem().Kind() == reflect.String {
+			schema["type"] = []string{"array", "string"}
+		}
+		return schema
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := fieldName(field)
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			properties[name] = typeSchema(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+	// interface{} takes any value
+	return map[string]interface{}{}
+}
+
+// fieldName returns the key of a field, from its mapstructure tag or else
+// its json tag
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"mapstructure", "json"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
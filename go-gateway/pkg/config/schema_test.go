@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+)
+
+// limitArgs are the args of the Limit test filter
+type limitArgs struct {
+	Limit   int               `mapstructure:"limit" json:"limit"`
+	Timeout time.Duration     `mapstructure:"timeout" json:"timeout,omitempty"`
+	Methods []string          `mapstructure:"methods" json:"methods,omitempty"`
+	Headers map[string]string `mapstructure:"headers" json:"headers,omitempty"`
+}
+
+func init() {
+	filter.Register("Limit", func(args interface{}) (middleware.Middleware, error) {
+		var cfg limitArgs
+		if err := filter.DecodeArgs(args, &cfg); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}, limitArgs{})
+}
+
+// lookup returns the value at a path of nested JSON objects
+func lookup(t *testing.T, value interface{}, path ...string) interface{} {
+	t.Helper()
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expected an object at %s, got %v", key, value)
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// argsSchema returns the args schema of a predicate or filter definition
+func argsSchema(t *testing.T, schema map[string]interface{}, definition, name string) interface{} {
+	t.Helper()
+	for _, c := range lookup(t, schema, "definitions", definition, "allOf").([]interface{}) {
+		if lookup(t, c, "if", "properties", "name", "const") == name {
+			return lookup(t, c, "then", "properties", "args")
+		}
+	}
+	t.Fatalf("Expected a case for %s in %s", name, definition)
+	return nil
+}
+
+// TestSchema tests the JSON Schema generated from the config and the typed
+// args of predicates and filters
+func TestSchema(t *testing.T) {
+	// Round trip through JSON, as editors read it
+	data, err := json.Marshal(Schema())
+	if err != nil {
+		t.Fatalf("Failed to marshal schema: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Config", func(t *testing.T) {
+		if got := lookup(t, schema, "properties", "port", "type"); got != "integer" {
+			t.Errorf("Expected integer port, got %v", got)
+		}
+		if got := lookup(t, schema, "properties", "routes", "items", "properties", "predicates", "items", "$ref"); got != "#/definitions/predicate" {
+			t.Errorf("Expected predicates to refer to their definition, got %v", got)
+		}
+		if got := lookup(t, schema, "properties", "global_filters", "items", "$ref"); got != "#/definitions/global_filter" {
+			t.Errorf("Expected global filters to refer to their definition, got %v", got)
+		}
+		if got := lookup(t, schema, "properties", "services", "items", "additionalProperties"); got != false {
+			t.Errorf("Expected unknown service keys to be rejected, got %v", got)
+		}
+		if got := lookup(t, schema, "properties", "timeouts", "properties", "idle", "pattern"); got != durationPattern {
+			t.Errorf("Expected a duration pattern, got %v", got)
+		}
+	})
+
+	t.Run("Predicates", func(t *testing.T) {
+		names := lookup(t, schema, "definitions", "predicate", "properties", "name", "enum")
+		if !reflect.DeepEqual(names, []interface{}{"Host", "Path"}) {
+			t.Errorf("Expected the registered predicates, got %v", names)
+		}
+		args := argsSchema(t, schema, "predicate", "Path")
+		if got := lookup(t, args, "properties", "pattern", "type"); got != "string" {
+			t.Errorf("Expected a string pattern, got %v", got)
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		args := argsSchema(t, schema, "filter", "Limit")
+		expected := map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"limit":   map[string]interface{}{"type": "integer"},
+				"timeout": map[string]interface{}{"type": []interface{}{"string", "integer"}, "pattern": durationPattern},
+				"methods": map[string]interface{}{"type": []interface{}{"array", "string"}, "items": map[string]interface{}{"type": "string"}},
+				"headers": map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+			},
+			"additionalProperties": false,
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Errorf("Expected args schema\n%v\ngot\n%v", expected, args)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/ipfilter"
+	"go-gateway/pkg/loadbalancer"
+	"go-gateway/pkg/route"
+)
+
+// FieldError is an invalid value of the config at its JSON path, e.g.
+// routes[2].uri
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationError lists every invalid value of a config
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		lines = append(lines, fe.Error())
+	}
+	return fmt.Sprintf("invalid config (%d errors):\n  %s", len(e.Errors), strings.Join(lines, "\n  "))
+}
+
+// Validate checks cfg and reports every invalid value with its path in a
+// *ValidationError. The args of registered predicates and filters are decoded
+// into their typed args; the values of filter args are checked by the filters
+// themselves once they are built.
+func Validate(cfg Config) error {
+	v := &validator{}
+
+	v.port("port", cfg.Port)
+	v.routes("routes", cfg.Routes, true)
+	for i, gf := range cfg.GlobalFilters {
+		if gf.Name == "" {
+			v.add(fmt.Sprintf("global_filters[%d].name", i), "is required")
+			continue
+		}
+		v.filterArgs(fmt.Sprintf("global_filters[%d]", i), gf.Name, gf.Args)
+	}
+	if cfg.TLS != nil {
+		v.tls("tls", *cfg.TLS)
+	}
+	if cfg.ProxyProtocol != nil {
+		v.proxyProtocol("proxy_protocol", *cfg.ProxyProtocol)
+	}
+	v.cidrs("trusted_proxies", cfg.TrustedProxies)
+	if t := cfg.Timeouts; t != nil {
+		v.duration("timeouts.request", t.Request)
+		v.duration("timeouts.idle", t.Idle)
+	}
+
+	services := make(map[string]int, len(cfg.Services))
+	for i, service := range cfg.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		v.unique(path+".name", service.Name, "service", services, i)
+		if _, err := loadbalancer.NewBalancer(service.Strategy); err != nil {
+			v.add(path+".strategy", err.Error())
+		}
+		if len(service.Servers) == 0 {
+			v.add(path+".servers", "at least one server is required")
+		}
+		for j, server := range service.Servers {
+			v.uri(fmt.Sprintf("%s.servers[%d].url", path, j), server.URL)
+			if server.Weight < 0 {
+				v.add(fmt.Sprintf("%s.servers[%d].weight", path, j), "must not be negative")
+			}
+		}
+		if hc := service.HealthCheck; hc != nil {
+			v.duration(path+".health_check.interval", hc.Interval)
+			v.duration(path+".health_check.timeout", hc.Timeout)
+			if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+				v.add(path+".health_check.path", fmt.Sprintf("%q must start with /", hc.Path))
+			}
+			if hc.HealthyThreshold < 0 {
+				v.add(path+".health_check.healthy_threshold", "must not be negative")
+			}
+			if hc.UnhealthyThreshold < 0 {
+				v.add(path+".health_check.unhealthy_threshold", "must not be negative")
+			}
+		}
+	}
+
+	listeners := make(map[string]int, len(cfg.TCPListeners))
+	for i, l := range cfg.TCPListeners {
+		path := fmt.Sprintf("tcp_listeners[%d]", i)
+		v.unique(path+".name", l.Name, "TCP listener", listeners, i)
+		v.port(path+".port", l.Port)
+		if l.URI == "" && len(l.SNI) == 0 {
+			v.add(path+".uri", "is required without sni routes")
+		} else if l.URI != "" {
+			v.upstream(path+".uri", l.URI, "tcp")
+		}
+		v.duration(path+".idle_timeout", l.IdleTimeout)
+		if l.TLS != nil {
+			v.certificate(path+".tls", *l.TLS)
+		}
+		for j, sni := range l.SNI {
+			sniPath := fmt.Sprintf("%s.sni[%d]", path, j)
+			if len(sni.ServerNames) == 0 {
+				v.add(sniPath+".server_names", "at least one server name is required")
+			}
+			v.upstream(sniPath+".uri", sni.URI, "tcp")
+		}
+		if l.ProxyProtocol != nil {
+			v.proxyProtocol(path+".proxy_protocol", *l.ProxyProtocol)
+		}
+		if l.SendProxyProtocol != "" && l.SendProxyProtocol != "v1" && l.SendProxyProtocol != "v2" {
+			v.add(path+".send_proxy_protocol", fmt.Sprintf("unknown version %q, expected v1 or v2", l.SendProxyProtocol))
+		}
+	}
+
+	listeners = make(map[string]int, len(cfg.UDPListeners))
+	for i, l := range cfg.UDPListeners {
+		path := fmt.Sprintf("udp_listeners[%d]", i)
+		v.unique(path+".name", l.Name, "UDP listener", listeners, i)
+		v.port(path+".port", l.Port)
+		v.upstream(path+".uri", l.URI, "udp")
+		v.duration(path+".idle_timeout", l.IdleTimeout)
+		if l.MaxSessions < 0 {
+			v.add(path+".max_sessions", "must not be negative")
+		}
+	}
+
+	if e := cfg.Egress; e != nil {
+		v.port("egress.port", e.Port)
+		v.routes("egress.allow", e.Allow, false)
+		v.duration("egress.idle_timeout", e.IdleTimeout)
+	}
+
+	if a := cfg.Admin; a != nil {
+		v.port("admin.port", a.Port)
+		if len(a.Tokens) == 0 {
+			v.add("admin.tokens", "at least one token is required")
+		}
+		for i, token := range a.Tokens {
+			if token == "" {
+				v.add(fmt.Sprintf("admin.tokens[%d]", i), "must not be empty")
+			}
+		}
+	}
+
+	if len(v.errors) > 0 {
+		return &ValidationError{Errors: v.errors}
+	}
+	return nil
+}
+
+// validator collects the errors of a config
+type validator struct {
+	errors []FieldError
+}
+
+func (v *validator) add(path, message string) {
+	v.errors = append(v.errors, FieldError{Path: path, Message: message})
+}
+
+// routes checks gateway routes, or the routes of an egress allow-list,
+// which have no uri
+func (v *validator) routes(path string, routes []common.Route, withURI bool) {
+	ids := make(map[string]int, len(routes))
+	for i, r := range routes {
+		routePath := fmt.Sprintf("%s[%d]", path, i)
+		v.unique(routePath+".id", r.ID, "route", ids, i)
+		if withURI {
+			v.uri(routePath+".uri", r.URI)
+		}
+		for j, predicate := range r.Predicates {
+			predicatePath := fmt.Sprintf("%s.predicates[%d]", routePath, j)
+			if predicate.Name == "" {
+				v.add(predicatePath+".name", "is required")
+				continue
+			}
+			if err := route.CheckPredicate(predicate); err != nil {
+				v.add(predicatePath, err.Error())
+			}
+		}
+		for j, f := range r.Filters {
+			filterPath := fmt.Sprintf("%s.filters[%d]", routePath, j)
+			if f.Name == "" {
+				v.add(filterPath+".name", "is required")
+				continue
+			}
+			v.filterArgs(filterPath, f.Name, f.Args)
+		}
+	}
+}
+
+// filterArgs decodes the args of a registered filter into its typed args,
+// which reports unknown keys and values of the wrong type. Filters that are
+// not registered are reported when the routes are built.
+func (v *validator) filterArgs(path, name string, args interface{}) {
+	if _, ok := filter.Lookup(name); !ok {
+		return
+	}
+	var out interface{} = &struct{}{}
+	if t := filter.ArgsType(name); t != nil {
+		out = reflect.New(t).Interface()
+	}
+	if err := filter.DecodeArgs(args, out); err != nil {
+		v.add(path+".args", err.Error())
+	}
+}
+
+// unique checks that a name is set and not used by another entry. seen maps
+// the names to the index of their first entry.
+func (v *validator) unique(path, name, kind string, seen map[string]int, i int) {
+	if name == "" {
+		v.add(path, "is required")
+		return
+	}
+	if first, ok := seen[name]; ok {
+		v.add(path, fmt.Sprintf("duplicate %s %q, first used at index %d", kind, name, first))
+		return
+	}
+	seen[name] = i
+}
+
+// uri checks an absolute URI such as http://host:port or lb://service
+func (v *validator) uri(path, uri string) {
+	if uri == "" {
+		v.add(path, "is required")
+		return
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		v.add(path, fmt.Sprintf("invalid URI %q", uri))
+		return
+	}
+	if u.Scheme == "" || u.Host == "" {
+		v.add(path, fmt.Sprintf("%q must be an absolute URI with a scheme and host", uri))
+	}
+}
+
+// upstream checks the upstream of a TCP or UDP listener: lb://<service> or
+// scheme://host:port
+func (v *validator) upstream(path, uri, scheme string) {
+	if uri == "" {
+		v.add(path, "is required")
+		return
+	}
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "lb" && u.Scheme != scheme) || u.Host == "" {
+		v.add(path, fmt.Sprintf("%q must be lb://<service> or %s://host:port", uri, scheme))
+		return
+	}
+	if u.Scheme == scheme && u.Port() == "" {
+		v.add(path, fmt.Sprintf("%q has no port", uri))
+	}
+}
+
+func (v *validator) port(path string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(path, fmt.Sprintf("%d is not a port between 1 and 65535", port))
+	}
+}
+
+func (v *validator) duration(path string, d time.Duration) {
+	if d < 0 {
+		v.add(path, "must not be negative")
+	}
+}
+
+func (v *validator) cidrs(path string, cidrs []string) {
+	for i, cidr := range cidrs {
+		if _, err := ipfilter.ParseCIDRs([]string{cidr}); err != nil {
+			v.add(fmt.Sprintf("%s[%d]", path, i), err.Error())
+		}
+	}
+}
+
+func (v *validator) proxyProtocol(path string, pp ProxyProtocolConfig) {
+	if len(pp.Trusted) == 0 {
+		v.add(path+".trusted", "at least one trusted CIDR is required")
+	}
+	v.cidrs(path+".trusted", pp.Trusted)
+}
+
+func (v *validator) tls(path string, tls TLSConfig) {
+	v.port(path+".port", tls.Port)
+	v.certificate(path, tls)
+}
+
+// certificate checks the certificate and client authentication settings of
+// a TLS config; the files themselves are read when the listener starts
+func (v *validator) certificate(path string, tls TLSConfig) {
+	if tls.CertFile == "" {
+		v.add(path+".cert_file", "is required")
+	}
+	if tls.KeyFile == "" {
+		v.add(path+".key_file", "is required")
+	}
+	switch tls.ClientAuth {
+	case "", "none", "request", "verify_if_given", "require":
+	default:
+		v.add(path+".client_auth", fmt.Sprintf("unknown mode %q", tls.ClientAuth))
+	}
+}
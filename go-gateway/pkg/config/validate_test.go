@@ -0,0 +1,163 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+)
+
+func pathRoute(id, uri, pattern string) common.Route {
+	return common.Route{
+		ID:         id,
+		URI:        uri,
+		Predicates: []common.Predicate{{Name: "Path", Args: map[string]interface{}{"pattern": pattern}}},
+	}
+}
+
+// TestValidate tests that every invalid value is reported with its path
+func TestValidate(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		cfg := Config{
+			Port:          8080,
+			Routes:        []common.Route{pathRoute("users", "lb://users", "/users/**")},
+			GlobalFilters: []GlobalFilter{{Name: "IpFilter"}},
+			Services: []ServiceConfig{
+				{Name: "users", Strategy: "consistent_hash", Servers: []ServerConfig{{URL: "http://10.0.0.1:8080"}}},
+			},
+			TCPListeners: []TCPListenerConfig{{Name: "db", Port: 5432, URI: "tcp://db:5432", SendProxyProtocol: "v2"}},
+			UDPListeners: []UDPListenerConfig{{Name: "dns", Port: 53, URI: "lb://dns"}},
+			Admin:        &AdminConfig{Port: 9091, Tokens: []string{"secret"}},
+		}
+		if err := Validate(cfg); err != nil {
+			t.Errorf("Expected config to be valid, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		cfg := Config{
+			Port: 0,
+			Routes: []common.Route{
+				pathRoute("users", "http://users:8080", "/users/**"),
+				pathRoute("users", "users:8080", "/orders/**"),
+				{ID: "", URI: "http://x:80", Predicates: []common.Predicate{
+					{Name: "Path", Args: map[string]interface{}{"pattern": 5}},
+					{Name: "Unknown"},
+				}},
+			},
+			GlobalFilters: []GlobalFilter{{Args: map[string]interface{}{}}},
+			Services: []ServiceConfig{
+				{Name: "users", Strategy: "fastest", Servers: []ServerConfig{{URL: "10.0.0.1", Weight: -1}}},
+				{Name: "users"},
+			},
+			TCPListeners: []TCPListenerConfig{{Name: "db", Port: 70000, URI: "udp://db:5432", SendProxyProtocol: "v3"}},
+			UDPListeners: []UDPListenerConfig{{Name: "dns", Port: 53, URI: "udp://dns", MaxSessions: -1}},
+			Egress:       &EgressConfig{Port: 3128, Allow: []common.Route{{ID: "all", Predicates: []common.Predicate{{Name: "Host"}}}}},
+			Timeouts:     &TimeoutsConfig{Request: -time.Second},
+		}
+		err := Validate(cfg)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("Expected a validation error, got %v", err)
+		}
+		paths := make([]string, 0, len(validationErr.Errors))
+		for _, fe := range validationErr.Errors {
+			paths = append(paths, fe.Path)
+		}
+		expected := []string{
+			"port",
+			"routes[1].id",
+			"routes[1].uri",
+			"routes[2].id",
+			"routes[2].predicates[0]",
+			"routes[2].predicates[1]",
+			"global_filters[0].name",
+			"timeouts.request",
+			"services[0].strategy",
+			"services[0].servers[0].url",
+			"services[0].servers[0].weight",
+			"services[1].name",
+			"services[1].servers",
+			"tcp_listeners[0].port",
+			"tcp_listeners[0].uri",
+			"tcp_listeners[0].send_proxy_protocol",
+			"udp_listeners[0].uri",
+			"udp_listeners[0].max_sessions",
+			"egress.allow[0].predicates[0]",
+		}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Errorf("Expected errors at\n%v\ngot\n%v", expected, err)
+		}
+		for _, message := range []string{`duplicate route "users", first used at index 0`, `unknown predicate "Unknown"`} {
+			if !strings.Contains(err.Error(), message) {
+				t.Errorf("Expected %q in %v", message, err)
+			}
+		}
+	})
+}
+
+// TestValidateFilterArgs tests that the args of registered filters are
+// decoded into their typed args
+func TestValidateFilterArgs(t *testing.T) {
+	route := pathRoute("users", "lb://users", "/users/**")
+	route.Filters = []common.Filter{
+		{Name: "Limit", Args: map[string]interface{}{"limit": "10", "timeout": "1s", "methods": "GET,POST"}},
+		{Name: "Limit", Args: map[string]interface{}{"limit": "many"}},
+		{Name: "Limit", Args: map[string]interface{}{"limt": 10}},
+		{Name: "NotLinked", Args: map[string]interface{}{"any": 1}},
+	}
+	cfg := Config{
+		Port:          8080,
+		Routes:        []common.Route{route},
+		GlobalFilters: []GlobalFilter{{Name: "Limit", Args: []string{"10"}}},
+	}
+
+	err := Validate(cfg)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	paths := make([]string, 0, len(validationErr.Errors))
+	for _, fe := range validationErr.Errors {
+		paths = append(paths, fe.Path)
+	}
+	expected := []string{"routes[0].filters[1].args", "routes[0].filters[2].args", "global_filters[0].args"}
+	sort.Strings(paths)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Expected errors at\n%v\ngot\n%v", expected, err)
+	}
+	if !strings.Contains(err.Error(), "limt") {
+		t.Errorf("Expected the unknown key in %v", err)
+	}
+}
+
+// TestLoadInvalidConfig tests that an invalid file does not replace the
+// current config
+func TestLoadInvalidConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "gateway.json")
+	invalid := `{"port": 8080, "routes": [
+		{"id": "a", "uri": "http://a:80", "predicates": [{"name": "Path", "args": {"pattern": "/a"}}]},
+		{"id": "a", "uri": "http://b:80", "predicates": [{"name": "Path", "args": {"pattern": "b"}}]}
+	]}`
+	if err := os.WriteFile(configPath, []byte(invalid), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	configMgr := NewViperConfigManager()
+	current := Config{Port: 9000, Routes: []common.Route{pathRoute("current", "http://c:80", "/**")}}
+	configMgr.SetConfig(current)
+	err := configMgr.Load(configPath)
+	if err == nil || !strings.Contains(err.Error(), "routes[1].id") || !strings.Contains(err.Error(), "routes[1].predicates[0]") {
+		t.Fatalf("Expected load to fail with the paths of the errors, got %v", err)
+	}
+	if loaded := configMgr.GetConfig(); loaded.Port != 9000 || len(loaded.Routes) != 1 || loaded.Routes[0].ID != "current" {
+		t.Errorf("Expected current config to be kept, got %+v", loaded)
+	}
+}
@@ -0,0 +1,296 @@
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+)
+
+func init() {
+	filter.Register("Cors", NewCors, CorsArgs{})
+}
+
+// CorsArgs configures the Cors filter
+type CorsArgs struct {
+	// AllowedOrigins lists the allowed origins: exact ("https://app.example.com"),
+	// wildcard ("https://*.example.com", "*") or regex ("regex:^https://.*\.example\.org$")
+	AllowedOrigins []string `mapstructure:"allowedOrigins" json:"allowedOrigins,omitempty"`
+	// AllowedMethods lists the allowed methods, GET, HEAD and POST by default
+	AllowedMethods []string `mapstructure:"allowedMethods" json:"allowedMethods,omitempty"`
+	// AllowedHeaders lists the allowed request headers, "*" allows any
+	AllowedHeaders []string `mapstructure:"allowedHeaders" json:"allowedHeaders,omitempty"`
+	// ExposedHeaders lists the response headers exposed to scripts
+	ExposedHeaders []string `mapstructure:"exposedHeaders" json:"exposedHeaders,omitempty"`
+	// AllowCredentials allows cookies and authorization headers
+	AllowCredentials bool `mapstructure:"allowCredentials" json:"allowCredentials,omitempty"`
+	// MaxAge is how long in seconds browsers may cache a preflight result
+	MaxAge int `mapstructure:"maxAge" json:"maxAge,omitempty"`
+}
+
+// Cors answers CORS preflight requests and adds CORS headers to responses
+type Cors struct {
+	anyOrigin        bool
+	origins          map[string]bool
+	wildcards        []*regexp.Regexp
+	patterns         []*regexp.Regexp
+	methods          map[string]bool
+	allowedMethods   string
+	anyHeader        bool
+	headers          map[string]bool
+	allowedHeaders   string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+// NewCors creates a Cors filter from its args
+func NewCors(args interface{}) (middleware.Middleware, error) {
+	cfg := CorsArgs{AllowedMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost}}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		return nil, fmt.Errorf("allowedOrigins is required")
+	}
+	if cfg.MaxAge < 0 {
+		return nil, fmt.Errorf("maxAge must not be negative")
+	}
+
+	c := &Cors{
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowCredentials: cfg.AllowCredentials,
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.HasPrefix(origin, "regex:"):
+			re, err := regexp.Compile(strings.TrimPrefix(origin, "regex:"))
+			if err != nil {
+				return nil, fmt.Errorf("allowedOrigins: %w", err)
+			}
+			c.patterns = append(c.patterns, re)
+		case strings.Contains(origin, "*"):
+			c.wildcards = append(c.wildcards, wildcardPattern(strings.ToLower(origin)))
+		case origin != "":
+			c.origins[strings.ToLower(origin)] = true
+		}
+	}
+
+	methods := make([]string, 0, len(cfg.AllowedMethods))
+	for _, method := range cfg.AllowedMethods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method != "" && !c.methods[method] {
+			c.methods[method] = true
+			methods = append(methods, method)
+		}
+	}
+	c.allowedMethods = strings.Join(methods, ", ")
+
+	headers := make([]string, 0, len(cfg.AllowedHeaders))
+	for _, header := range cfg.AllowedHeaders {
+		header = strings.TrimSpace(header)
+		if header == "*" {
+			c.anyHeader = true
+		} else if header != "" {
+			c.headers[strings.ToLower(header)] = true
+			headers = append(headers, header)
+		}
+	}
+	c.allowedHeaders = strings.Join(headers, ", ")
+
+	exposed := make([]string, 0, len(cfg.ExposedHeaders))
+	for _, header := range cfg.ExposedHeaders {
+		if header = strings.TrimSpace(header); header != "" {
+			exposed = append(exposed, header)
+		}
+	}
+	c.exposedHeaders = strings.Join(exposed, ", ")
+
+	if cfg.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(cfg.MaxAge)
+	}
+	return c, nil
+}
+
+// wildcardPattern turns "https://*.example.com" into a regex where each
+// "*" stands for one or more host characters
+func wildcardPattern(origin string) *regexp.Regexp {
+	parts := strings.Split(strings.ToLower(origin), "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, "[a-z0-9.-]+") + "$")
+}
+
+// Name returns the filter name
+func (c *Cors) Name() string {
+	return "Cors"
+}
+
+// PreHandle answers preflight requests and arranges for CORS headers to be
+// added to the response of actual requests
+func (c *Cors) PreHandle(ctx *middleware.GatewayContext) bool {
+	r := ctx.Request
+	origin := r.Header.Get("Origin")
+
+	if r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != "" {
+		c.handlePreflight(ctx, origin)
+		return false
+	}
+
+	// gRPC-Web clients read the call status from response headers
+	exposed := c.exposedHeaders
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		exposed = strings.TrimPrefix(exposed+", Grpc-Status, Grpc-Message", ", ")
+	}
+
+	rw := middleware.WrapResponse(ctx)
+	rw.OnWriteHeader(func(status int, header http.Header) {
+		// The gateway owns the CORS policy: drop whatever the backend sent
+		for name := range header {
+			if strings.HasPrefix(name, "Access-Control-") {
+				header.Del(name)
+			}
+		}
+		if c.varyOnOrigin() {
+			addVary(header, "Origin")
+		}
+		if origin == "" || !c.AllowedOrigin(origin) {
+			return
+		}
+		c.setAllowOrigin(header, origin)
+		if exposed != "" {
+			header.Set("Access-Control-Expose-Headers", exposed)
+		}
+	})
+	return true
+}
+
+// handlePreflight answers a preflight request without calling the backend
+func (c *Cors) handlePreflight(ctx *middleware.GatewayContext, origin string) {
+	r := ctx.Request
+	header := ctx.Response.Header()
+	addVary(header, "Origin")
+	addVary(header, "Access-Control-Request-Method")
+	addVary(header, "Access-Control-Request-Headers")
+
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := requestedHeaders(r)
+	if !c.AllowedOrigin(origin) || !c.methods[method] || !c.allowedRequestHeaders(requested) {
+		http.Error(ctx.Response, "Invalid CORS request", http.StatusForbidden)
+		return
+	}
+
+	c.setAllowOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", c.allowedMethods)
+	if c.anyHeader {
+		if len(requested) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		}
+	} else if c.allowedHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", c.allowedHeaders)
+	}
+	if c.maxAge != "" {
+		header.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	ctx.Response.WriteHeader(http.StatusNoContent)
+}
+
+// PostHandle does nothing
+func (c *Cors) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (c *Cors) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// AllowedOrigin reports whether origin may access the route
+func (c *Cors) AllowedOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	// Scheme and host are case-insensitive, but regexes decide on case
+	// themselves, e.g. with (?i)
+	lower := strings.ToLower(origin)
+	if c.origins[lower] {
+		return true
+	}
+	for _, re := range c.wildcards {
+		if re.MatchString(lower) {
+			return true
+		}
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// varyOnOrigin reports whether responses depend on the Origin header.
+// Only a "*" policy without credentials answers every origin the same way.
+func (c *Cors) varyOnOrigin() bool {
+	return !c.anyOrigin || c.allowCredentials
+}
+
+func (c *Cors) setAllowOrigin(header http.Header, origin string) {
+	if c.varyOnOrigin() {
+		// Browsers reject "*" together with credentials, so echo the origin
+		header.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", "*")
+	}
+	if c.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *Cors) allowedRequestHeaders(requested []string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for _, header := range requested {
+		if !c.headers[strings.ToLower(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestedHeaders returns the headers listed in Access-Control-Request-Headers
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				headers = append(headers, header)
+			}
+		}
+	}
+	return headers
+}
+
+// addVary adds value to the Vary header unless it is already listed
+func addVary(header http.Header, value string) {
+	for _, existing := range header.Values("Vary") {
+		for _, v := range strings.Split(existing, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.EqualFold(v, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
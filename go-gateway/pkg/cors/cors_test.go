@@ -0,0 +1,177 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-gateway/pkg/middleware"
+)
+
+func newCors(t *testing.T, args map[string]interface{}) *Cors {
+	t.Helper()
+	m, err := NewCors(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	return m.(*Cors)
+}
+
+// TestAllowedOrigin tests exact, wildcard and regex origins
+func TestAllowedOrigin(t *testing.T) {
+	c := newCors(t, map[string]interface{}{
+		"allowedOrigins": []interface{}{
+			"https://app.example.com", "https://*.Example.net",
+			`regex:^https://[a-z]+\.example\.org$`, `regex:^https://[A-Z]+\.example\.io$`,
+		},
+	})
+
+	for origin, expected := range map[string]bool{
+		"https://app.example.com":       true,
+		"HTTPS://APP.EXAMPLE.COM":       true,
+		"http://app.example.com":        false,
+		"https://a.b.example.net":       true,
+		"https://example.net":           false,
+		"https://evil.com/.example.net": false,
+		"https://shop.example.org":      true,
+		"https://shop1.example.org":     false,
+		"https://A.EXAMPLE.NET":         true,
+		"https://SHOP.example.org":      false,
+		"https://SHOP.example.io":       true,
+		"https://shop.example.io":       false,
+	} {
+		if got := c.AllowedOrigin(origin); got != expected {
+			t.Errorf("Expected AllowedOrigin(%s) = %v, got %v", origin, expected, got)
+		}
+	}
+
+	if _, err := NewCors(map[string]interface{}{}); err == nil {
+		t.Error("Expected error without allowedOrigins")
+	}
+	if _, err := NewCors(map[string]interface{}{"allowedOrigins": "regex:("}); err == nil {
+		t.Error("Expected error for invalid regex")
+	}
+}
+
+// TestPreflight tests that preflight requests are answered by the gateway
+func TestPreflight(t *testing.T) {
+	c := newCors(t, map[string]interface{}{
+		"allowedOrigins":   "https://app.example.com",
+		"allowedMethods":   "GET,PUT",
+		"allowedHeaders":   "Authorization,Content-Type",
+		"allowCredentials": true,
+		"maxAge":           600,
+	})
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "http://gateway/api", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rec := httptest.NewRecorder()
+		if c.PreHandle(&middleware.GatewayContext{Request: req, Response: rec}) {
+			t.Fatal("Expected preflight not to reach the backend")
+		}
+		return rec
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		rec := preflight("https://app.example.com", "PUT", "content-type, authorization")
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", rec.Code)
+		}
+		expected := map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Methods":     "GET, PUT",
+			"Access-Control-Allow-Headers":     "Authorization, Content-Type",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Max-Age":           "600",
+		}
+		for name, value := range expected {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("Expected %s %q, got %q", name, value, got)
+			}
+		}
+		if vary := rec.Header().Values("Vary"); len(vary) != 3 || vary[0] != "Origin" {
+			t.Errorf("Expected Vary on Origin and request headers, got %v", vary)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		for name, rec := range map[string]*httptest.ResponseRecorder{
+			"Origin": preflight("https://evil.com", "GET", ""),
+			"Method": preflight("https://app.example.com", "DELETE", ""),
+			"Header": preflight("https://app.example.com", "GET", "X-Debug"),
+		} {
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("%s: expected status 403, got %d", name, rec.Code)
+			}
+			if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+				t.Errorf("%s: expected no Access-Control-Allow-Origin", name)
+			}
+		}
+	})
+}
+
+// TestActualRequest tests CORS headers on proxied responses
+func TestActualRequest(t *testing.T) {
+	backend := func(ctx *middleware.GatewayContext) {
+		ctx.Response.Header().Set("Access-Control-Allow-Origin", "*")
+		ctx.Response.Write([]byte("ok"))
+	}
+	serve := func(c *Cors, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/api", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		ctx := &middleware.GatewayContext{Request: req, Response: rec}
+		if !middleware.NewMiddlewareChain([]middleware.Middleware{c}).Handle(ctx, backend) {
+			t.Fatal("Expected request to reach the backend")
+		}
+		return rec
+	}
+
+	c := newCors(t, map[string]interface{}{
+		"allowedOrigins": "https://app.example.com",
+		"exposedHeaders": "X-Request-Id",
+	})
+
+	t.Run("AllowedOrigin", func(t *testing.T) {
+		rec := serve(c, "https://app.example.com")
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("Expected origin to override the backend header, got %v", rec.Header().Values("Access-Control-Allow-Origin"))
+		}
+		if got := rec.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-Id" {
+			t.Errorf("Expected exposed headers, got %q", got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("Expected Vary: Origin, got %q", got)
+		}
+	})
+
+	t.Run("DisallowedOrigin", func(t *testing.T) {
+		rec := serve(c, "https://evil.com")
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Errorf("Expected request to be proxied, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Expected no Access-Control-Allow-Origin, got %q", got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("Expected Vary: Origin, got %q", got)
+		}
+	})
+
+	t.Run("AnyOrigin", func(t *testing.T) {
+		rec := serve(newCors(t, map[string]interface{}{"allowedOrigins": "*"}), "https://app.example.com")
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("Expected *, got %q", got)
+		}
+		if got := rec.Header().Get("Vary"); got != "" {
+			t.Errorf("Expected no Vary for a static policy, got %q", got)
+		}
+	})
+}
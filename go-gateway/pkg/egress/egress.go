@@ -0,0 +1,240 @@
+package egress
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/l4"
+	"go-gateway/pkg/monitoring"
+	"go-gateway/pkg/route"
+)
+
+const dialTimeout = 10 * time.Second
+
+// Proxy is a forward proxy for outbound traffic. It serves absolute-URI
+// requests and CONNECT tunnels to the destinations of its allow-list.
+type Proxy struct {
+	idleTimeout time.Duration
+	transport   *http.Transport
+	allow       atomic.Pointer[route.Router]
+
+	mutex   sync.Mutex
+	tunnels map[net.Conn]struct{}
+	wg      sync.WaitGroup
+}
+
+// NewProxy creates the egress proxy
+func NewProxy(cfg config.EgressConfig) *Proxy {
+	p := &Proxy{
+		idleTimeout: cfg.IdleTimeout,
+		transport: &http.Transport{
+			// Never chain to the proxy of the environment
+			Proxy:                 nil,
+			DialContext:           (&net.Dialer{Timeout: dialTimeout}).DialContext,
+			TLSHandshakeTimeout:   dialTimeout,
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		tunnels: make(map[net.Conn]struct{}),
+	}
+	p.SetAllow(cfg.Allow)
+	return p
+}
+
+// SetAllow replaces the allow-list. Requests in flight keep the one they were
+// checked against. Rules whose predicates cannot be built allow nothing.
+func (p *Proxy) SetAllow(routes []common.Route) {
+	router := route.NewRouter()
+	for i := range routes {
+		r := routes[i]
+		if err := router.AddRoute(&r); err != nil {
+			log.Printf("Skipping egress rule: %v", err)
+		}
+	}
+	p.allow.Store(router)
+}
+
+// ServeHTTP proxies an absolute-URI request or opens a CONNECT tunnel
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kind := "forward"
+	if r.Method == http.MethodConnect {
+		kind = "connect"
+	}
+
+	destination, ok := destinationOf(r)
+	if !ok {
+		monitoring.EgressRequestsTotal.WithLabelValues("", kind, "bad_request").Inc()
+		http.Error(w, "Egress proxy requires absolute-URI or CONNECT requests", http.StatusBadRequest)
+		return
+	}
+	rule := p.allow.Load().MatchRequest(r)
+	if rule == nil {
+		// Denied destinations are not labelled, so that clients cannot
+		// grow the metrics at will
+		monitoring.EgressRequestsTotal.WithLabelValues("", kind, "denied").Inc()
+		log.Printf("Egress %s %s from %s denied", r.Method, destination, r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if kind == "connect" {
+		p.tunnel(w, r, destination, rule.ID)
+		return
+	}
+	p.forward(w, r, destination)
+}
+
+// destinationOf returns the host:port a request goes to
+func destinationOf(r *http.Request) (string, bool) {
+	if r.Method == http.MethodConnect {
+		host, port, err := net.SplitHostPort(r.Host)
+		if err != nil || host == "" || port == "" {
+			return "", false
+		}
+		return net.JoinHostPort(strings.ToLower(host), port), true
+	}
+
+	if r.URL.Host == "" || (r.URL.Scheme != "http" && r.URL.Scheme != "https") {
+		return "", false
+	}
+	port := r.URL.Port()
+	if port == "" {
+		port = "80"
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(r.URL.Hostname()), port), true
+}
+
+// forward proxies an absolute-URI request
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, destination string) {
+	start := time.Now()
+	result := "ok"
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.Host = ""
+		},
+		Transport: p.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			result = "error"
+			log.Printf("Egress %s %s from %s failed: %v", r.Method, r.URL, r.RemoteAddr, err)
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+
+	monitoring.EgressRequestsTotal.WithLabelValues(destination, "forward", result).Inc()
+	monitoring.EgressDuration.WithLabelValues(destination, "forward").Observe(time.Since(start).Seconds())
+}
+
+// tunnel connects the client to destination and relays bytes both ways
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request, destination, rule string) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		monitoring.EgressRequestsTotal.WithLabelValues(destination, "connect", "bad_request").Inc()
+		http.Error(w, "CONNECT requires HTTP/1.1", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", destination, dialTimeout)
+	if err != nil {
+		monitoring.EgressRequestsTotal.WithLabelValues(destination, "connect", "dial_error").Inc()
+		log.Printf("Egress tunnel %s -> %s failed: %v", r.RemoteAddr, destination, err)
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		monitoring.EgressRequestsTotal.WithLabelValues(destination, "connect", "error").Inc()
+		return
+	}
+	defer client.Close()
+	if !p.track(client) {
+		return
+	}
+	defer p.untrack(client)
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		monitoring.EgressRequestsTotal.WithLabelValues(destination, "connect", "error").Inc()
+		return
+	}
+	// Clients may send data right behind the request, e.g. a TLS ClientHello
+	if n := buffered.Reader.Buffered(); n > 0 {
+		data, _ := buffered.Reader.Peek(n)
+		if _, err := upstream.Write(data); err != nil {
+			monitoring.EgressRequestsTotal.WithLabelValues(destination, "connect", "error").Inc()
+			return
+		}
+		monitoring.EgressBytesTotal.WithLabelValues(destination, "out").Add(float64(n))
+	}
+	monitoring.EgressRequestsTotal.WithLabelValues(destination, "connect", "ok").Inc()
+
+	start := time.Now()
+	monitoring.EgressTunnels.WithLabelValues(destination).Inc()
+	sent, received := l4.Pipe(client, upstream, p.idleTimeout,
+		monitoring.EgressBytesTotal.WithLabelValues(destination, "out"),
+		monitoring.EgressBytesTotal.WithLabelValues(destination, "in"))
+	monitoring.EgressTunnels.WithLabelValues(destination).Dec()
+	monitoring.EgressDuration.WithLabelValues(destination, "connect").Observe(time.Since(start).Seconds())
+
+	log.Printf("Egress tunnel %s -> %s (rule %s) closed after %v: %d bytes sent, %d bytes received",
+		r.RemoteAddr, destination, rule, time.Since(start).Round(time.Millisecond), sent, received)
+}
+
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.tunnels == nil {
+		return false
+	}
+	p.tunnels[conn] = struct{}{}
+	p.wg.Add(1)
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mutex.Lock()
+	delete(p.tunnels, conn)
+	p.mutex.Unlock()
+	p.wg.Done()
+}
+
+// Shutdown refuses new tunnels and waits for the open ones to end. Tunnels
+// still open when ctx is done are closed.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	tunnels := p.tunnels
+	p.tunnels = nil
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mutex.Lock()
+		for conn := range tunnels {
+			conn.Close()
+		}
+		p.mutex.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,244 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/monitoring"
+)
+
+func allowHost(id, pattern string) common.Route {
+	return common.Route{
+		ID:         id,
+		Predicates: []common.Predicate{{Name: "Host", Args: map[string]interface{}{"pattern": pattern}}},
+	}
+}
+
+// startProxy serves an egress proxy allowing the given routes
+func startProxy(t *testing.T, allow ...common.Route) (*Proxy, *url.URL) {
+	t.Helper()
+	proxy := NewProxy(config.EgressConfig{Allow: allow})
+	server := httptest.NewServer(proxy)
+	t.Cleanup(server.Close)
+	proxyURL, _ := url.Parse(server.URL)
+	return proxy, proxyURL
+}
+
+func proxyClient(proxyURL *url.URL, transport *http.Transport) *http.Client {
+	if transport == nil {
+		transport = &http.Transport{}
+	} else {
+		transport = transport.Clone()
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+// connect opens a CONNECT tunnel to destination and returns the connection
+// and the response status
+func connect(t *testing.T, proxyURL *url.URL, destination string) (net.Conn, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", destination, destination)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn, resp.StatusCode
+}
+
+// TestForwardProxy tests absolute-URI requests
+func TestForwardProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s proxy-auth=%q", r.Host, r.URL.Path, r.Header.Get("Proxy-Authorization"))
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	destination := backendURL.Host
+
+	t.Run("Allowed", func(t *testing.T) {
+		_, proxyURL := startProxy(t, allowHost("backend", "127.0.0.1"))
+		before := testutil.ToFloat64(monitoring.EgressRequestsTotal.WithLabelValues(destination, "forward", "ok"))
+
+		req, _ := http.NewRequest("GET", backend.URL+"/status", nil)
+		req.Header.Set("Proxy-Authorization", "Basic c2VjcmV0")
+		resp, err := proxyClient(proxyURL, nil).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if expected := destination + ` /status proxy-auth=""`; string(body) != expected {
+			t.Errorf("Expected %q, got %q", expected, body)
+		}
+		if got := testutil.ToFloat64(monitoring.EgressRequestsTotal.WithLabelValues(destination, "forward", "ok")); got != before+1 {
+			t.Errorf("Expected request to be counted for %s, got %v", destination, got-before)
+		}
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		_, proxyURL := startProxy(t, allowHost("github", "**.github.com"))
+		before := testutil.ToFloat64(monitoring.EgressRequestsTotal.WithLabelValues("", "forward", "denied"))
+		resp, err := proxyClient(proxyURL, nil).Get(backend.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", resp.StatusCode)
+		}
+		if got := testutil.ToFloat64(monitoring.EgressRequestsTotal.WithLabelValues("", "forward", "denied")); got != before+1 {
+			t.Errorf("Expected denial to be counted, got %v", got-before)
+		}
+	})
+
+	t.Run("NotAbsolute", func(t *testing.T) {
+		_, proxyURL := startProxy(t, allowHost("all", "**"))
+		resp, err := http.Get(proxyURL.String() + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for origin-form request, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("SetAllow", func(t *testing.T) {
+		proxy, proxyURL := startProxy(t)
+		client := proxyClient(proxyURL, nil)
+		for _, tt := range []struct {
+			allow    []common.Route
+			expected int
+		}{
+			{nil, http.StatusForbidden},
+			{[]common.Route{allowHost("backend", "127.0.0.1:"+backendURL.Port())}, http.StatusOK},
+			{[]common.Route{allowHost("other-port", "127.0.0.1:1")}, http.StatusForbidden},
+		} {
+			proxy.SetAllow(tt.allow)
+			resp, err := client.Get(backend.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.expected {
+				t.Errorf("Allow %v: expected %d, got %d", tt.allow, tt.expected, resp.StatusCode)
+			}
+		}
+	})
+}
+
+// TestConnectTunnel tests CONNECT tunnels
+func TestConnectTunnel(t *testing.T) {
+	t.Run("HTTPS", func(t *testing.T) {
+		backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "secret")
+		}))
+		defer backend.Close()
+		destination := strings.TrimPrefix(backend.URL, "https://")
+
+		_, proxyURL := startProxy(t, allowHost("backend", "127.0.0.1"))
+		client := proxyClient(proxyURL, backend.Client().Transport.(*http.Transport))
+		resp, err := client.Get(backend.URL)
+		if err != nil {
+			t.Fatalf("Request through tunnel failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "secret" {
+			t.Errorf("Expected response through tunnel, got %q", body)
+		}
+		if got := testutil.ToFloat64(monitoring.EgressRequestsTotal.WithLabelValues(destination, "connect", "ok")); got != 1 {
+			t.Errorf("Expected tunnel to be counted, got %v", got)
+		}
+
+		client.CloseIdleConnections()
+		deadline := time.Now().Add(2 * time.Second)
+		for testutil.ToFloat64(monitoring.EgressTunnels.WithLabelValues(destination)) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected tunnel to be closed")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if got := testutil.ToFloat64(monitoring.EgressBytesTotal.WithLabelValues(destination, "in")); got == 0 {
+			t.Error("Expected tunnel bytes to be counted")
+		}
+	})
+
+	t.Run("Denied", func(t *testing.T) {
+		_, proxyURL := startProxy(t, allowHost("https", "**:443"))
+		if _, status := connect(t, proxyURL, "127.0.0.1:22"); status != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", status)
+		}
+	})
+
+	t.Run("DialError", func(t *testing.T) {
+		ln, _ := net.Listen("tcp", "127.0.0.1:0")
+		destination := ln.Addr().String()
+		ln.Close()
+		_, proxyURL := startProxy(t, allowHost("all", "**"))
+		if _, status := connect(t, proxyURL, destination); status != http.StatusBadGateway {
+			t.Errorf("Expected 502, got %d", status)
+		}
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(conn, conn)
+				}()
+			}
+		}()
+
+		proxy, proxyURL := startProxy(t, allowHost("all", "**"))
+		conn, status := connect(t, proxyURL, ln.Addr().String())
+		if status != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", status)
+		}
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("Expected echo through tunnel, got %q %v", buf, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := proxy.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected open tunnel to outlast the deadline, got %v", err)
+		}
+		if _, err := conn.Read(buf); err != io.EOF {
+			t.Errorf("Expected tunnel to be closed, got %v", err)
+		}
+	})
+}
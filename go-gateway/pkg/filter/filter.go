@@ -0,0 +1,121 @@
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/go-viper/mapstructure/v2"
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+// Factory builds a route filter from the args configured for it
+type Factory func(args interface{}) (middleware.Middleware, error)
+
+// registration is a registered filter
+type registration struct {
+	factory Factory
+	args    reflect.Type
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]registration)
+)
+
+// Register registers a filter factory under the given name. args is a value
+// of the typed struct the factory decodes its args into, or nil for filters
+// without args; the JSON Schema of the config is generated from it.
+// Registering the same name twice replaces the previous factory.
+func Register(name string, factory Factory, args interface{}) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[name] = registration{factory: factory, args: reflect.TypeOf(args)}
+}
+
+// Lookup returns the factory registered under name
+func Lookup(name string) (Factory, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	r, ok := registry[name]
+	return r.factory, ok
+}
+
+// ArgsType returns the type of the args of the filter registered under name,
+// or nil when it takes none
+func ArgsType(name string) reflect.Type {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	return registry[name].args
+}
+
+// Names returns the names of all registered filters in sorted order
+func Names() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build builds a single filter from its configuration
+func Build(f common.Filter) (middleware.Middleware, error) {
+	factory, ok := Lookup(f.Name)
+	if !ok {
+		return nil, fmt.Errorf("unknown filter %q", f.Name)
+	}
+
+	m, err := factory(f.Args)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %w", f.Name, err)
+	}
+	return m, nil
+}
+
+// BuildChain builds the filters of a route in their configured order
+func BuildChain(filters []common.Filter) ([]middleware.Middleware, error) {
+	chain := make([]middleware.Middleware, 0, len(filters))
+	for _, f := range filters {
+		m, err := Build(f)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, m)
+	}
+	return chain, nil
+}
+
+// DecodeArgs decodes loosely typed filter args (as produced by JSON or viper)
+// into the typed struct pointed to by out. Fields preset in out act as defaults
+// and are replaced, not merged, when present in args. Unknown keys are rejected
+// so that typos surface at load time instead of silently disabling an option.
+func DecodeArgs(args interface{}, out interface{}) error {
+	if args == nil {
+		return nil
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		ZeroFields:       true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := decoder.Decode(args); err != nil {
+		return fmt.Errorf("invalid args: %w", err)
+	}
+	return nil
+}
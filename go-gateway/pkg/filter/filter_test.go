@@ -0,0 +1,103 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+type noopFilter struct {
+	middleware.Middleware
+	limit int
+}
+
+type noopArgs struct {
+	Limit int `mapstructure:"limit"`
+}
+
+// TestRegistry tests filter registration and building
+func TestRegistry(t *testing.T) {
+	Register("TestNoop", func(args interface{}) (middleware.Middleware, error) {
+		var cfg noopArgs
+		if err := DecodeArgs(args, &cfg); err != nil {
+			return nil, err
+		}
+		return &noopFilter{limit: cfg.Limit}, nil
+	}, noopArgs{})
+
+	t.Run("TestArgsType", func(t *testing.T) {
+		if got := ArgsType("TestNoop"); got != reflect.TypeOf(noopArgs{}) {
+			t.Errorf("Expected noopArgs, got %v", got)
+		}
+		if got := ArgsType("DoesNotExist"); got != nil {
+			t.Errorf("Expected no args type for unknown filter, got %v", got)
+		}
+	})
+
+	t.Run("TestBuildChain", func(t *testing.T) {
+		chain, err := BuildChain([]common.Filter{
+			{Name: "TestNoop", Args: map[string]string{"limit": "5"}},
+			{Name: "TestNoop"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to build chain: %v", err)
+		}
+		if len(chain) != 2 {
+			t.Fatalf("Expected 2 filters, got %d", len(chain))
+		}
+		if limit := chain[0].(*noopFilter).limit; limit != 5 {
+			t.Errorf("Expected limit 5, got %d", limit)
+		}
+	})
+
+	t.Run("TestUnknownFilter", func(t *testing.T) {
+		if _, err := BuildChain([]common.Filter{{Name: "DoesNotExist"}}); err == nil {
+			t.Error("Expected error for unknown filter")
+		}
+	})
+}
+
+// TestDecodeArgs tests decoding of loosely typed args
+func TestDecodeArgs(t *testing.T) {
+	var cfg struct {
+		Timeout time.Duration `mapstructure:"timeout"`
+		Methods []string      `mapstructure:"methods"`
+		Enabled bool          `mapstructure:"enabled"`
+	}
+
+	err := DecodeArgs(map[string]interface{}{
+		"timeout": "250ms",
+		"methods": "GET,HEAD",
+		"enabled": "true",
+	}, &cfg)
+	if err != nil {
+		t.Fatalf("Failed to decode args: %v", err)
+	}
+	if cfg.Timeout != 250*time.Millisecond {
+		t.Errorf("Expected timeout 250ms, got %v", cfg.Timeout)
+	}
+	if len(cfg.Methods) != 2 || cfg.Methods[1] != "HEAD" {
+		t.Errorf("Expected methods [GET HEAD], got %v", cfg.Methods)
+	}
+	if !cfg.Enabled {
+		t.Error("Expected enabled to be true")
+	}
+
+	cfg.Methods = []string{"GET", "HEAD", "OPTIONS"}
+	if err := DecodeArgs(map[string]interface{}{"methods": []string{"POST"}}, &cfg); err != nil {
+		t.Fatalf("Failed to decode args: %v", err)
+	}
+	if len(cfg.Methods) != 1 || cfg.Methods[0] != "POST" {
+		t.Errorf("Expected default methods to be replaced by [POST], got %v", cfg.Methods)
+	}
+	if cfg.Timeout != 250*time.Millisecond {
+		t.Errorf("Expected timeout to keep its value, got %v", cfg.Timeout)
+	}
+
+	if err := DecodeArgs(map[string]interface{}{"unknown": 1}, &cfg); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+}
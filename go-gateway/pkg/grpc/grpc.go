@@ -0,0 +1,212 @@
+package grpc
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/route"
+)
+
+func init() {
+	route.RegisterPredicate("GrpcService", newServicePredicate, GrpcServiceArgs{})
+	route.RegisterPredicate("GrpcMethod", newMethodPredicate, GrpcMethodArgs{})
+}
+
+// Code is a gRPC status code
+type Code int
+
+// gRPC status codes
+const (
+	OK Code = iota
+	Canceled
+	Unknown
+	InvalidArgument
+	DeadlineExceeded
+	NotFound
+	AlreadyExists
+	PermissionDenied
+	ResourceExhausted
+	FailedPrecondition
+	Aborted
+	OutOfRange
+	Unimplemented
+	Internal
+	Unavailable
+	DataLoss
+	Unauthenticated
+)
+
+var codeNames = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// String returns the canonical name of the code, e.g. UNAVAILABLE
+func (c Code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return fmt.Sprintf("CODE(%d)", int(c))
+}
+
+// HTTPStatus returns the HTTP status equivalent to the code
+func (c Code) HTTPStatus() int {
+	switch c {
+	case OK:
+		return http.StatusOK
+	case Canceled:
+		return 499
+	case InvalidArgument, FailedPrecondition, OutOfRange:
+		return http.StatusBadRequest
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case NotFound:
+		return http.StatusNotFound
+	case AlreadyExists, Aborted:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case Unimplemented:
+		return http.StatusNotImplemented
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// CodeFromHTTPStatus returns the code a gRPC client sees for an HTTP status.
+// It follows the gRPC HTTP status mapping, except that 429 and 504, which
+// the gateway itself returns for rate limits and timeouts, keep their meaning.
+func CodeFromHTTPStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return Internal
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return Unimplemented
+	case http.StatusTooManyRequests:
+		return ResourceExhausted
+	case http.StatusGatewayTimeout:
+		return DeadlineExceeded
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return Unavailable
+	default:
+		return Unknown
+	}
+}
+
+// IsGrpc reports whether r is a native gRPC request
+func IsGrpc(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") ||
+		strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// IsGrpcWeb reports whether r is a gRPC-Web request, binary or text
+func IsGrpcWeb(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc-web")
+}
+
+// Method returns the service and method called by a gRPC request, whose path
+// is /<package>.<Service>/<Method>
+func Method(r *http.Request) (service, method string, ok bool) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		return "", "", false
+	}
+	return splitPath(r.URL.Path)
+}
+
+func splitPath(p string) (service, method string, ok bool) {
+	service, method, ok = strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", false
+	}
+	return service, method, true
+}
+
+// predicateMethod returns the method a route predicate matches on. CORS
+// preflights of gRPC-Web calls carry no content type and match on the path.
+func predicateMethod(r *http.Request) (service, method string, ok bool) {
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		return splitPath(r.URL.Path)
+	}
+	return Method(r)
+}
+
+// GrpcServiceArgs are the args of the GrpcService predicate
+type GrpcServiceArgs struct {
+	// Service is a path.Match pattern, e.g. "billing.v1.Invoices" or
+	// "billing.v1.*"
+	Service string `mapstructure:"service" json:"service"`
+}
+
+// newServicePredicate creates a GrpcService predicate, which matches the
+// service of gRPC (and gRPC-Web) requests
+func newServicePredicate(args interface{}) (route.RequestPredicate, error) {
+	var cfg GrpcServiceArgs
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if err := checkPattern("service", cfg.Service); err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) bool {
+		service, _, ok := predicateMethod(r)
+		if !ok {
+			return false
+		}
+		matched, _ := path.Match(cfg.Service, service)
+		return matched
+	}, nil
+}
+
+// GrpcMethodArgs are the args of the GrpcMethod predicate
+type GrpcMethodArgs struct {
+	// Method is a path.Match pattern of the full method name, e.g.
+	// "billing.v1.Invoices/Get" or "billing.v1.Invoices/List*"
+	Method string `mapstructure:"method" json:"method"`
+}
+
+// newMethodPredicate creates a GrpcMethod predicate, which matches the full
+// method name of gRPC (and gRPC-Web) requests
+func newMethodPredicate(args interface{}) (route.RequestPredicate, error) {
+	var cfg GrpcMethodArgs
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if err := checkPattern("method", cfg.Method); err != nil {
+		return nil, err
+	}
+	return func(r *http.Request) bool {
+		service, method, ok := predicateMethod(r)
+		if !ok {
+			return false
+		}
+		matched, _ := path.Match(cfg.Method, service+"/"+method)
+		return matched
+	}, nil
+}
+
+// checkPattern reports a missing or malformed pattern arg
+func checkPattern(name, pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%s %q: %w", name, pattern, err)
+	}
+	return nil
+}
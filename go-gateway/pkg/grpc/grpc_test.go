@@ -0,0 +1,690 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/cors"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+	"go-gateway/pkg/route"
+	"go-gateway/pkg/streaming"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// TestCodes tests the mapping between gRPC codes and HTTP statuses
+func TestCodes(t *testing.T) {
+	if Unavailable.String() != "UNAVAILABLE" || Code(42).String() != "CODE(42)" {
+		t.Errorf("Unexpected code names %s %s", Unavailable, Code(42))
+	}
+
+	toHTTP := map[Code]int{OK: 200, NotFound: 404, PermissionDenied: 403, Unavailable: 503, DeadlineExceeded: 504, Internal: 500}
+	for code, status := range toHTTP {
+		if got := code.HTTPStatus(); got != status {
+			t.Errorf("%s.HTTPStatus() = %d, expected %d", code, got, status)
+		}
+	}
+
+	fromHTTP := map[int]Code{400: Internal, 401: Unauthenticated, 403: PermissionDenied, 404: Unimplemented,
+		429: ResourceExhausted, 502: Unavailable, 503: Unavailable, 504: DeadlineExceeded, 500: Unknown}
+	for status, code := range fromHTTP {
+		if got := CodeFromHTTPStatus(status); got != code {
+			t.Errorf("CodeFromHTTPStatus(%d) = %s, expected %s", status, got, code)
+		}
+	}
+}
+
+// TestPredicates tests the GrpcService and GrpcMethod predicates
+func TestPredicates(t *testing.T) {
+	router := route.NewRouter()
+	router.AddRoute(&common.Route{ID: "get", Order: 0, Predicates: []common.Predicate{
+		{Name: "GrpcMethod", Args: map[string]interface{}{"method": "billing.v1.Invoices/Get*"}},
+	}})
+	router.AddRoute(&common.Route{ID: "billing", Order: 1, Predicates: []common.Predicate{
+		{Name: "GrpcService", Args: map[string]string{"service": "billing.v1.*"}},
+	}})
+
+	tests := []struct {
+		path        string
+		contentType string
+		expected    string
+	}{
+		{"/billing.v1.Invoices/GetInvoice", "application/grpc", "get"},
+		{"/billing.v1.Invoices/ListInvoices", "application/grpc+proto", "billing"},
+		{"/billing.v1.Payments/Pay", "application/grpc-web", "billing"},
+		{"/shipping.v1.Parcels/Get", "application/grpc", ""},
+		{"/billing.v1.Invoices/GetInvoice", "application/json", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, nil)
+		req.Header.Set("Content-Type", tt.contentType)
+		got := ""
+		if matched := router.MatchRequest(req); matched != nil {
+			got = matched.ID
+		}
+		if got != tt.expected {
+			t.Errorf("%s (%s): expected route %q, got %q", tt.path, tt.contentType, tt.expected, got)
+		}
+	}
+
+	// CORS preflights of gRPC-Web calls have no content type
+	preflight := httptest.NewRequest("OPTIONS", "/billing.v1.Invoices/GetInvoice", nil)
+	preflight.Header.Set("Access-Control-Request-Method", "POST")
+	if matched := router.MatchRequest(preflight); matched == nil || matched.ID != "get" {
+		t.Errorf("Expected preflight to match route get, got %v", matched)
+	}
+
+	for _, predicate := range []common.Predicate{
+		{Name: "GrpcService"},
+		{Name: "GrpcService", Args: map[string]interface{}{"service": "billing.v1.["}},
+		{Name: "GrpcMethod", Args: map[string]interface{}{"service": "billing.v1.*"}},
+	} {
+		if err := route.CheckPredicate(predicate); err == nil {
+			t.Errorf("Expected %+v to be rejected", predicate)
+		}
+	}
+}
+
+func frame(message string) []byte {
+	b := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(message)))
+	return append(b, message...)
+}
+
+func readFrame(r io.Reader) (string, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return "", err
+	}
+	message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	_, err := io.ReadFull(r, message)
+	return string(message), err
+}
+
+func h2c() *http.Protocols {
+	p := new(http.Protocols)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+// echoBackend echoes every message of the request as it arrives and ends
+// the call with the status from the X-Status request header
+func echoBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			message, err := readFrame(r.Body)
+			if err != nil {
+				break
+			}
+			w.Write(frame(message))
+			w.(http.Flusher).Flush()
+		}
+		status := r.Header.Get("X-Status")
+		if status == "" {
+			status = "0"
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", status)
+		w.Header().Set(http.TrailerPrefix+"X-Echo-Count", "done")
+	}))
+	backend.Config.Protocols = h2c()
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// gateway proxies to upstream like the gateway's forward and reports the
+// context of every request
+func gateway(t *testing.T, upstream string, deny bool) (*httptest.Server, chan *middleware.GatewayContext) {
+	contexts := make(chan *middleware.GatewayContext, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, finish := WrapErrors(w, r)
+		defer finish()
+		ctx := &middleware.GatewayContext{Request: r, Response: w, Route: &common.Route{ID: "grpc"}, Attributes: map[string]interface{}{}}
+		defer streaming.Guard(ctx, streaming.Timeouts{})()
+		if deny {
+			http.Error(ctx.Response, "missing scope billing:read", http.StatusForbidden)
+			return
+		}
+
+		target, _ := url.Parse(upstream)
+		target, transport := Upstream(target)
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.Transport = transport
+		proxy.ServeHTTP(ctx.Response, ctx.Request)
+		Observe(ctx)
+		contexts <- ctx
+	}))
+	server.Config.Protocols = h2c()
+	server.Start()
+	t.Cleanup(server.Close)
+	return server, contexts
+}
+
+func client() *http.Client {
+	return &http.Client{Transport: &http.Transport{Protocols: h2c()}}
+}
+
+func call(t *testing.T, server *httptest.Server, body io.Reader, status string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("POST", server.URL+"/echo.v1.Echo/Chat", body)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("X-Status", status)
+	resp, err := client().Do(req)
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// TestProxy tests gRPC calls through the proxy
+func TestProxy(t *testing.T) {
+	backend := echoBackend(t)
+	upstream := "grpc://" + backend.Listener.Addr().String()
+
+	t.Run("Trailers", func(t *testing.T) {
+		server, contexts := gateway(t, upstream, false)
+		resp := call(t, server, bytes.NewReader(frame("hello")), "0")
+		if message, err := readFrame(resp.Body); err != nil || message != "hello" {
+			t.Fatalf("Expected echo, got %q %v", message, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		if resp.ProtoMajor != 2 || resp.Trailer.Get("Grpc-Status") != "0" || resp.Trailer.Get("X-Echo-Count") != "done" {
+			t.Errorf("Expected HTTP/2 response with trailers, got %s %v", resp.Proto, resp.Trailer)
+		}
+		if ctx := <-contexts; ctx.Attributes[middleware.StatusAttribute] != http.StatusOK {
+			t.Errorf("Expected status 200 for metrics, got %v", ctx.Attributes[middleware.StatusAttribute])
+		}
+	})
+
+	t.Run("BidiStreaming", func(t *testing.T) {
+		server, contexts := gateway(t, upstream, false)
+		requestBody, writer := io.Pipe()
+		resp := call(t, server, requestBody, "0")
+		for _, message := range []string{"one", "two"} {
+			writer.Write(frame(message))
+			if echoed, err := readFrame(resp.Body); err != nil || echoed != message {
+				t.Fatalf("Expected %q echoed before the request ends, got %q %v", message, echoed, err)
+			}
+		}
+		writer.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.Trailer.Get("Grpc-Status") != "0" {
+			t.Errorf("Expected OK status, got %v", resp.Trailer)
+		}
+		<-contexts
+	})
+
+	t.Run("StatusMetrics", func(t *testing.T) {
+		server, contexts := gateway(t, upstream, false)
+		before := testutil.ToFloat64(monitoring.ErrorTotal.WithLabelValues("grpc_unavailable", "grpc"))
+		resp := call(t, server, bytes.NewReader(frame("x")), "14")
+		io.Copy(io.Discard, resp.Body)
+		if ctx := <-contexts; ctx.Attributes[middleware.StatusAttribute] != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503 for metrics, got %v", ctx.Attributes[middleware.StatusAttribute])
+		}
+		if got := testutil.ToFloat64(monitoring.ErrorTotal.WithLabelValues("grpc_unavailable", "grpc")); got != before+1 {
+			t.Errorf("Expected grpc_unavailable to be counted, got %v", got-before)
+		}
+	})
+}
+
+// TestGatewayErrors tests that gateway errors reach clients as gRPC status
+func TestGatewayErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		deny     bool
+		status   string
+		message  string
+	}{
+		{"Denied", "grpc://127.0.0.1:1", true, "7", "missing scope billing:read"},
+		{"UpstreamDown", "grpc://127.0.0.1:1", false, "14", "Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := gateway(t, tt.upstream, tt.deny)
+			resp := call(t, server, bytes.NewReader(frame("x")), "0")
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" || len(body) != 0 {
+				t.Errorf("Expected trailers-only gRPC response, got %d %q %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+			}
+			if resp.Header.Get("Grpc-Status") != tt.status || resp.Header.Get("Grpc-Message") != tt.message {
+				t.Errorf("Expected status %s %q, got %s %q", tt.status, tt.message, resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message"))
+			}
+		})
+	}
+
+	if got := encodeMessage("50% done\n"); got != "50%25 done%0A" {
+		t.Errorf("Unexpected encoded message %q", got)
+	}
+}
+
+// webGateway runs the filters before proxying like the gateway's ServeHTTP
+func webGateway(t *testing.T, upstream string, filters ...middleware.Middleware) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w, finish := WrapErrors(w, r)
+		defer finish()
+		ctx := &middleware.GatewayContext{Request: r, Response: w, Route: &common.Route{ID: "grpc-web"}, Attributes: map[string]interface{}{}}
+		defer streaming.Guard(ctx, streaming.Timeouts{})()
+
+		middleware.NewMiddlewareChain(filters).Handle(ctx, func(ctx *middleware.GatewayContext) {
+			target, _ := url.Parse(upstream)
+			target, transport := Upstream(target)
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			proxy.Transport = transport
+			proxy.ServeHTTP(ctx.Response, ctx.Request)
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newFilter(t *testing.T, f func(interface{}) (middleware.Middleware, error), args interface{}) middleware.Middleware {
+	t.Helper()
+	m, err := f(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	return m
+}
+
+func webCall(t *testing.T, server *httptest.Server, contentType string, body []byte, header http.Header) (*http.Response, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", server.URL+"/echo.v1.Echo/Chat", bytes.NewReader(body))
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return resp, responseBody
+}
+
+// TestGrpcWeb tests gRPC-Web calls translated to gRPC
+func TestGrpcWeb(t *testing.T) {
+	backend := echoBackend(t)
+	upstream := "grpc://" + backend.Listener.Addr().String()
+	server := webGateway(t, upstream, newFilter(t, NewGrpcWeb, nil))
+	trailers := "grpc-status: 0\r\nx-echo-count: done\r\n"
+
+	t.Run("Binary", func(t *testing.T) {
+		resp, body := webCall(t, server, "application/grpc-web+proto", frame("hello"), http.Header{"X-Status": {"0"}})
+		if resp.Header.Get("Content-Type") != "application/grpc-web+proto" {
+			t.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+		}
+		r := bytes.NewReader(body)
+		if message, err := readFrame(r); err != nil || message != "hello" {
+			t.Fatalf("Expected echo, got %q %v", message, err)
+		}
+		if r.Len() < 1 || body[len(body)-r.Len()] != trailerFlag {
+			t.Fatalf("Expected trailer frame, got %q", body)
+		}
+		if block, err := readFrame(r); err != nil || block != trailers {
+			t.Errorf("Unexpected trailers %q %v", block, err)
+		}
+		if len(resp.Trailer) != 0 {
+			t.Errorf("Expected no HTTP trailers, got %v", resp.Trailer)
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		// Two messages sent as separately padded chunks
+		request := base64.StdEncoding.EncodeToString(frame("one")) + base64.StdEncoding.EncodeToString(frame("two"))
+		resp, body := webCall(t, server, "application/grpc-web-text", []byte(request), http.Header{"X-Status": {"5"}})
+		if resp.Header.Get("Content-Type") != "application/grpc-web-text" {
+			t.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+		}
+		decoded, err := io.ReadAll(&textReader{ReadCloser: io.NopCloser(bytes.NewReader(body))})
+		if err != nil {
+			t.Fatalf("Failed to decode response %q: %v", body, err)
+		}
+		r := bytes.NewReader(decoded)
+		for _, expected := range []string{"one", "two", "grpc-status: 5\r\nx-echo-count: done\r\n"} {
+			if message, err := readFrame(r); err != nil || message != expected {
+				t.Errorf("Expected %q, got %q %v", expected, message, err)
+			}
+		}
+	})
+
+	t.Run("GatewayError", func(t *testing.T) {
+		server := webGateway(t, "grpc://127.0.0.1:1", newFilter(t, NewGrpcWeb, nil))
+		resp, body := webCall(t, server, "application/grpc-web-text", nil, http.Header{})
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc-web-text" || len(body) != 0 {
+			t.Errorf("Expected trailers-only response, got %d %q %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+		if resp.Header.Get("Grpc-Status") != "14" {
+			t.Errorf("Expected status 14, got %q", resp.Header.Get("Grpc-Status"))
+		}
+	})
+
+	t.Run("Cors", func(t *testing.T) {
+		corsFilter := newFilter(t, cors.NewCors, map[string]interface{}{
+			"allowedOrigins": "https://app.example.com",
+			"allowedHeaders": "content-type,x-grpc-web,x-user-agent,grpc-timeout",
+		})
+		server := webGateway(t, upstream, corsFilter, newFilter(t, NewGrpcWeb, nil))
+
+		preflight, _ := http.NewRequest("OPTIONS", server.URL+"/echo.v1.Echo/Chat", nil)
+		preflight.Header.Set("Origin", "https://app.example.com")
+		preflight.Header.Set("Access-Control-Request-Method", "POST")
+		preflight.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+		resp, err := http.DefaultClient.Do(preflight)
+		if err != nil {
+			t.Fatalf("Preflight failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Fatalf("Expected preflight to be allowed, got %d %v", resp.StatusCode, resp.Header)
+		}
+
+		resp, _ = webCall(t, server, "application/grpc-web", frame("x"), http.Header{"Origin": {"https://app.example.com"}})
+		if resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+			!strings.Contains(resp.Header.Get("Access-Control-Expose-Headers"), "Grpc-Status") {
+			t.Errorf("Expected CORS headers exposing the status, got %v", resp.Header)
+		}
+	})
+}
+
+// TestTextReader tests decoding of grpc-web-text bodies
+func TestTextReader(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+		fails    bool
+	}{
+		{"Single", "aGVsbG8=", "hello", false},
+		{"Concatenated", "aGk=dGhlcmU=", "hithere", false},
+		{"LineBreaks", "aGVs\r\nbG8=", "hello", false},
+		{"Truncated", "aGVsbG", "", true},
+		{"Invalid", "a$==", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(&textReader{ReadCloser: io.NopCloser(strings.NewReader(tt.body))})
+			if tt.fails {
+				if err == nil {
+					t.Errorf("Expected %q to fail, got %q", tt.body, got)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.expected {
+				t.Errorf("Expected %q, got %q %v", tt.expected, got, err)
+			}
+		})
+	}
+
+	if nativeContentType("application/grpc-web-text+proto") != "application/grpc+proto" {
+		t.Errorf("Unexpected native content type %q", nativeContentType("application/grpc-web-text+proto"))
+	}
+}
+
+// TestPathTemplate tests parsing and matching of google.api.http path templates
+func TestPathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		expected map[string]string
+	}{
+		{"/v1/shelves/{shelf}", "/v1/shelves/1", map[string]string{"shelf": "1"}},
+		{"/v1/shelves/{shelf}", "/v1/shelves/1/books", nil},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", map[string]string{"name": "shelves/1/books/2"}},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books", nil},
+		{"/v1/books/{book.id}", "/v1/books/a%20b", map[string]string{"book.id": "a b"}},
+		{"/v1/{path=files/**}", "/v1/files/a/b/c", map[string]string{"path": "files/a/b/c"}},
+		{"/v1/{name=books/*}:publish", "/v1/books/2:publish", map[string]string{"name": "books/2"}},
+		{"/v1/{name=books/*}:publish", "/v1/books/2", nil},
+		{"/v1/static", "/v1/static", map[string]string{}},
+	}
+	for _, tt := range tests {
+		template, err := parseTemplate(tt.template)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tt.template, err)
+		}
+		got, ok := template.match(tt.path)
+		if ok != (tt.expected != nil) || ok && !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s matching %s: expected %v, got %v %v", tt.template, tt.path, tt.expected, got, ok)
+		}
+	}
+
+	for _, invalid := range []string{"v1/books", "/v1/{name", "/v1/**/books", "/v1//books", "/v1/{=books/*}"} {
+		if _, err := parseTemplate(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// httpOption encodes a google.api.http option: kind is the HttpRule field of
+// the method (2 get, 4 post, 6 patch)
+func httpOption(kind protowire.Number, pattern, body string) *descriptorpb.MethodOptions {
+	var rule []byte
+	rule = protowire.AppendTag(rule, kind, protowire.BytesType)
+	rule = protowire.AppendString(rule, pattern)
+	if body != "" {
+		rule = protowire.AppendTag(rule, 7, protowire.BytesType)
+		rule = protowire.AppendString(rule, body)
+	}
+	var raw []byte
+	raw = protowire.AppendTag(raw, httpExtension, protowire.BytesType)
+	raw = protowire.AppendBytes(raw, rule)
+	options := &descriptorpb.MethodOptions{}
+	options.ProtoReflect().SetUnknown(raw)
+	return options
+}
+
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, repeated bool, typeName string) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Type: typ.Enum(), Label: label.Enum()}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+// libraryDescriptors writes the descriptor set of a small library service
+func libraryDescriptors(t *testing.T) (string, protoreflect.FileDescriptor) {
+	const (
+		str = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		i32 = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		msg = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+		bln = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	)
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	method := func(name, input, output string, options *descriptorpb.MethodOptions) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{Name: proto.String(name), InputType: proto.String(".library.v1." + input),
+			OutputType: proto.String(".library.v1." + output), Options: options}
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("library.proto"),
+		Package: proto.String("library.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("Author", field("id", 1, str, false, ""), field("name", 2, str, false, "")),
+			message("Book", field("name", 1, str, false, ""), field("title", 2, str, false, ""), field("pages", 3, i32, false, ""),
+				field("tags", 4, str, true, ""), field("author", 5, msg, false, ".library.v1.Author")),
+			message("GetBookRequest", field("name", 1, str, false, ""), field("full", 2, bln, false, ""),
+				field("author", 3, msg, false, ".library.v1.Author")),
+			message("CreateBookRequest", field("shelf", 1, str, false, ""), field("book", 2, msg, false, ".library.v1.Book")),
+			message("ListBooksRequest", field("shelf", 1, str, false, ""), field("page_size", 2, i32, false, ""), field("tags", 3, str, true, "")),
+			message("ListBooksResponse", field("books", 1, msg, true, ".library.v1.Book")),
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Library"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("GetBook", "GetBookRequest", "Book", httpOption(2, "/v1/{name=shelves/*/books/*}", "")),
+				method("CreateBook", "CreateBookRequest", "Book", httpOption(4, "/v1/shelves/{shelf}/books", "book")),
+				method("UpdateBook", "Book", "Book", httpOption(6, "/v1/books/{name}", "*")),
+				method("ListBooks", "ListBooksRequest", "ListBooksResponse", httpOption(2, "/v1/shelves/{shelf}/books", "")),
+				method("PublishBook", "GetBookRequest", "Book", httpOption(4, "/v1/{name=shelves/*/books/*}:publish", "*")),
+				method("Internal", "Book", "Book", nil),
+			},
+		}},
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("Invalid descriptor: %v", err)
+	}
+	data, _ := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	path := filepath.Join(t.TempDir(), "library.pb")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write descriptor set: %v", err)
+	}
+	return path, fd
+}
+
+// libraryBackend answers every call with a fixed book and reports the
+// request messages as JSON with proto names
+func libraryBackend(t *testing.T, file protoreflect.FileDescriptor) (*httptest.Server, chan string) {
+	requests := make(chan string, 16)
+	service := file.Services().ByName("Library")
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rpc := service.Methods().ByName(protoreflect.Name(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]))
+		data, _ := readFrame(r.Body)
+		request := dynamicpb.NewMessage(rpc.Input())
+		if err := proto.Unmarshal([]byte(data), request); err != nil {
+			t.Errorf("Invalid request message: %v", err)
+		}
+		encoded, _ := protojson.MarshalOptions{UseProtoNames: true}.Marshal(request)
+		requests <- string(encoded)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		if fd := rpc.Input().Fields().ByName("name"); fd != nil && strings.HasSuffix(request.Get(fd).String(), "/404") {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "book not found")
+			return
+		}
+		book := `{"name": "shelves/1/books/2", "title": "Go"}`
+		if rpc.Name() == "ListBooks" {
+			book = `{"books": [` + book + `]}`
+		}
+		response := dynamicpb.NewMessage(rpc.Output())
+		protojson.Unmarshal([]byte(book), response)
+		encodedResponse, _ := proto.Marshal(response)
+		w.Write(frame(string(encodedResponse)))
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	backend.Config.Protocols = h2c()
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return backend, requests
+}
+
+func jsonEqual(a, b string) bool {
+	var x, y interface{}
+	return json.Unmarshal([]byte(a), &x) == nil && json.Unmarshal([]byte(b), &y) == nil && reflect.DeepEqual(x, y)
+}
+
+// TestTranscoding tests REST calls transcoded to unary gRPC calls
+func TestTranscoding(t *testing.T) {
+	descriptorSet, file := libraryDescriptors(t)
+	backend, requests := libraryBackend(t, file)
+	transcoding := newFilter(t, NewGrpcTranscoding, map[string]interface{}{"descriptorSet": descriptorSet})
+	server := webGateway(t, "grpc://"+backend.Listener.Addr().String(), transcoding)
+	book := `{"name": "shelves/1/books/2", "title": "Go"}`
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		request  string
+		response string
+	}{
+		{"PathAndQuery", "GET", "/v1/shelves/1/books/2?full=true&author.id=7", "", 200,
+			`{"name": "shelves/1/books/2", "full": true, "author": {"id": "7"}}`, book},
+		{"BodyField", "POST", "/v1/shelves/1/books", `{"title": "Go", "pages": 300, "tags": ["a"]}`, 200,
+			`{"shelf": "1", "book": {"title": "Go", "pages": 300, "tags": ["a"]}}`, book},
+		{"WholeBody", "PATCH", "/v1/books/go%20book", `{"title": "Go", "author": {"name": "Alan"}}`, 200,
+			`{"name": "go book", "title": "Go", "author": {"name": "Alan"}}`, book},
+		{"RepeatedQuery", "GET", "/v1/shelves/1/books?pageSize=10&tags=a&tags=b", "", 200,
+			`{"shelf": "1", "page_size": 10, "tags": ["a", "b"]}`, `{"books": [` + book + `]}`},
+		{"CustomVerb", "POST", "/v1/shelves/1/books/2:publish", "", 200, `{"name": "shelves/1/books/2"}`, book},
+		{"GrpcError", "GET", "/v1/shelves/1/books/404", "", 404, `{"name": "shelves/1/books/404"}`, `{"code": 5, "message": "book not found"}`},
+		{"InvalidQuery", "GET", "/v1/shelves/1/books?pageSize=ten", "", 400, "", ""},
+		{"UnknownQuery", "GET", "/v1/shelves/1/books?color=red", "", 400, "", ""},
+		{"InvalidBody", "POST", "/v1/shelves/1/books", `{"title": 5}`, 400, "", ""},
+		{"NoBinding", "DELETE", "/v1/shelves/1", "", 404, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status || resp.Header.Get("Content-Type") != "application/json" {
+				t.Fatalf("Expected %d JSON, got %d %q %s", tt.status, resp.StatusCode, resp.Header.Get("Content-Type"), body)
+			}
+			if resp.Header.Get("Grpc-Status") != "" || len(resp.Trailer) != 0 {
+				t.Errorf("Expected gRPC metadata to be removed, got %v %v", resp.Header, resp.Trailer)
+			}
+			if tt.request != "" {
+				select {
+				case request := <-requests:
+					if !jsonEqual(request, tt.request) {
+						t.Errorf("Expected upstream request %s, got %s", tt.request, request)
+					}
+				case <-time.After(time.Second):
+					t.Error("Expected an upstream call")
+				}
+			}
+			if tt.response != "" && !jsonEqual(string(body), tt.response) {
+				t.Errorf("Expected response %s, got %s", tt.response, body)
+			}
+		})
+	}
+
+	t.Run("InvalidArgs", func(t *testing.T) {
+		for _, args := range []map[string]interface{}{
+			{},
+			{"descriptorSet": filepath.Join(t.TempDir(), "missing.pb")},
+			{"descriptorSet": descriptorSet, "services": "library.v1.Missing"},
+		} {
+			if _, err := NewGrpcTranscoding(args); err == nil {
+				t.Errorf("Expected args %v to be rejected", args)
+			}
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package grpc
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// httpExtension is the field number of the google.api.http method option
+const httpExtension = 72295728
+
+// httpRule is one HTTP binding of a method, as declared by google.api.http
+type httpRule struct {
+	method       string
+	pattern      string
+	body         string
+	responseBody string
+}
+
+// httpRules returns the HTTP bindings of a method, additional bindings included.
+// The option is read from the raw options so that the annotations package does
+// not have to be linked in.
+func httpRules(method protoreflect.MethodDescriptor) ([]httpRule, error) {
+	options := method.Options()
+	if options == nil {
+		return nil, nil
+	}
+	b, err := proto.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []httpRule
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == httpExtension && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			parsed, err := parseHTTPRule(value)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, parsed...)
+			b = b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return rules, nil
+}
+
+// parseHTTPRule decodes a google.api.HttpRule into the rule and its
+// additional bindings
+func parseHTTPRule(b []byte) ([]httpRule, error) {
+	var rule httpRule
+	var additional []httpRule
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch num {
+		case 2, 3, 4, 5, 6:
+			rule.method = [...]string{"GET", "PUT", "POST", "DELETE", "PATCH"}[num-2]
+			rule.pattern = string(value)
+		case 7:
+			rule.body = string(value)
+		case 8:
+			kind, pattern, err := parseCustomPattern(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.method, rule.pattern = kind, pattern
+		case 11:
+			bindings, err := parseHTTPRule(value)
+			if err != nil {
+				return nil, err
+			}
+			additional = append(additional, bindings...)
+		case 12:
+			rule.responseBody = string(value)
+		}
+	}
+
+	var rules []httpRule
+	if rule.method != "" {
+		rules = append(rules, rule)
+	}
+	return append(rules, additional...), nil
+}
+
+// parseCustomPattern decodes a google.api.CustomHttpPattern
+func parseCustomPattern(b []byte) (kind, pattern string, err error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		} else {
+			var value []byte
+			value, n = protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				kind = strings.ToUpper(string(value))
+			case 2:
+				pattern = string(value)
+			}
+		}
+		if n < 0 {
+			return "", "", protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return kind, pattern, nil
+}
+
+// segment kinds of a path template
+const (
+	literalSegment = iota
+	singleSegment  // *
+	multiSegment   // **
+)
+
+type segment struct {
+	kind    int
+	literal string
+}
+
+// pathVariable binds the segments [start, end) of a path to a field
+type pathVariable struct {
+	field      string
+	start, end int
+}
+
+// pathTemplate is a compiled google.api.http path template such as
+// /v1/{name=shelves/*/books/*}:publish
+type pathTemplate struct {
+	segments  []segment
+	variables []pathVariable
+	verb      string
+}
+
+// parseTemplate compiles a path template
+func parseTemplate(pattern string) (*pathTemplate, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("path template %q must start with /", pattern)
+	}
+	t := &pathTemplate{}
+	rest := pattern[1:]
+	if i := strings.LastIndex(rest, ":"); i >= 0 && i > strings.LastIndex(rest, "/") && i > strings.LastIndex(rest, "}") {
+		rest, t.verb = rest[:i], rest[i+1:]
+	}
+
+	parts, err := splitTemplate(rest)
+	if err != nil {
+		return nil, fmt.Errorf("path template %q: %w", pattern, err)
+	}
+	for _, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			seg, err := parseSegment(part)
+			if err != nil {
+				return nil, fmt.Errorf("path template %q: %w", pattern, err)
+			}
+			t.segments = append(t.segments, seg)
+			continue
+		}
+		field, sub, ok := strings.Cut(part[1:len(part)-1], "=")
+		if !ok {
+			sub = "*"
+		}
+		if field == "" {
+			return nil, fmt.Errorf("path template %q: empty variable", pattern)
+		}
+		start := len(t.segments)
+		for _, s := range strings.Split(sub, "/") {
+			seg, err := parseSegment(s)
+			if err != nil {
+				return nil, fmt.Errorf("path template %q: %w", pattern, err)
+			}
+			t.segments = append(t.segments, seg)
+		}
+		t.variables = append(t.variables, pathVariable{field: field, start: start, end: len(t.segments)})
+	}
+
+	for i, seg := range t.segments {
+		if seg.kind == multiSegment && i != len(t.segments)-1 {
+			return nil, fmt.Errorf("path template %q: ** must be the last segment", pattern)
+		}
+	}
+	return t, nil
+}
+
+// splitTemplate splits a template at the slashes outside variables
+func splitTemplate(s string) ([]string, error) {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			if depth > 0 || i != start {
+				return nil, fmt.Errorf("unexpected {")
+			}
+			depth++
+		case '}':
+			if depth == 0 || (i+1 < len(s) && s[i+1] != '/') {
+				return nil, fmt.Errorf("unexpected }")
+			}
+			depth--
+		case '/':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unclosed {")
+	}
+	return append(parts, s[start:]), nil
+}
+
+func parseSegment(s string) (segment, error) {
+	switch {
+	case s == "*":
+		return segment{kind: singleSegment}, nil
+	case s == "**":
+		return segment{kind: multiSegment}, nil
+	case s == "" || strings.ContainsAny(s, "{}=*"):
+		return segment{}, fmt.Errorf("invalid segment %q", s)
+	}
+	return segment{kind: literalSegment, literal: s}, nil
+}
+
+// literals counts the literal segments; more literals make a template more
+// specific
+func (t *pathTemplate) literals() int {
+	n := 0
+	for _, seg := range t.segments {
+		if seg.kind == literalSegment {
+			n++
+		}
+	}
+	return n
+}
+
+// match matches an escaped request path and returns the variable values
+func (t *pathTemplate) match(escapedPath string) (map[string]string, bool) {
+	p := strings.TrimPrefix(escapedPath, "/")
+	if t.verb != "" {
+		var ok bool
+		if p, ok = strings.CutSuffix(p, ":"+t.verb); !ok {
+			return nil, false
+		}
+	}
+	parts := strings.Split(p, "/")
+	values := make([]string, len(parts))
+	for i, part := range parts {
+		value, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, false
+		}
+		values[i] = value
+	}
+
+	end := len(t.segments)
+	for i, seg := range t.segments {
+		if seg.kind == multiSegment {
+			end = len(values)
+			break
+		}
+		if i >= len(values) || values[i] == "" || seg.kind == literalSegment && values[i] != seg.literal {
+			return nil, false
+		}
+	}
+	if end != len(values) {
+		return nil, false
+	}
+
+	bound := make(map[string]string, len(t.variables))
+	for _, v := range t.variables {
+		vEnd := v.end
+		if v.end == len(t.segments) {
+			vEnd = end
+		}
+		bound[v.field] = strings.Join(values[v.start:vEnd], "/")
+	}
+	return bound, true
+}
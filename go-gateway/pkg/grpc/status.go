@@ -0,0 +1,162 @@
+package grpc
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+// maxMessageSize limits the error text kept for grpc-message
+const maxMessageSize = 1024
+
+// Status returns the gRPC status of a response from its header or trailers
+func Status(header http.Header) (Code, bool) {
+	value := header.Get("Grpc-Status")
+	if value == "" {
+		// Trailers not announced before the body
+		if values := header[http.TrailerPrefix+"Grpc-Status"]; len(values) > 0 {
+			value = values[0]
+		}
+	}
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return Code(code), true
+}
+
+// Observe records the gRPC status of the upstream response of ctx. Failed
+// calls are counted in ErrorTotal as grpc_<code> and the request metrics get
+// the HTTP status equivalent to the code.
+func Observe(ctx *middleware.GatewayContext) {
+	code, ok := Status(ctx.Response.Header())
+	if !ok {
+		return
+	}
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	ctx.Attributes[middleware.StatusAttribute] = code.HTTPStatus()
+	if code != OK {
+		routeID := "unknown"
+		if ctx.Route != nil {
+			routeID = ctx.Route.ID
+		}
+		monitoring.ErrorTotal.WithLabelValues("grpc_"+strings.ToLower(code.String()), routeID).Inc()
+	}
+}
+
+// WrapErrors makes responses that are not gRPC, such as errors generated by
+// the gateway or a misbehaving upstream, reach gRPC clients as a gRPC status.
+// Other requests get w back unchanged. finish writes the converted response
+// and must be called once the request is handled.
+func WrapErrors(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	switch {
+	case IsGrpc(r):
+		ew := &errorWriter{ResponseWriter: w, contentType: "application/grpc"}
+		return ew, ew.finish
+	case IsGrpcWeb(r):
+		// gRPC-Web clients take a status from the headers just as well
+		ew := &errorWriter{ResponseWriter: w, contentType: r.Header.Get("Content-Type")}
+		return ew, ew.finish
+	}
+	return w, func() {}
+}
+
+// errorWriter passes gRPC responses through and holds back any other
+// response to send it as a trailers-only gRPC response
+type errorWriter struct {
+	http.ResponseWriter
+	// contentType is the content type of converted responses
+	contentType string
+	status      int
+	converted   bool
+	message     []byte
+}
+
+// WriteHeader passes gRPC responses on and holds back anything else
+func (w *errorWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/grpc") {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.converted = true
+}
+
+// Write passes gRPC bodies on and keeps the start of other bodies as message
+func (w *errorWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.converted {
+		return w.ResponseWriter.Write(data)
+	}
+	if room := maxMessageSize - len(w.message); room > 0 {
+		w.message = append(w.message, data[:min(room, len(data))]...)
+	}
+	return len(data), nil
+}
+
+// Flush flushes gRPC responses
+func (w *errorWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.converted {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *errorWriter) finish() {
+	if !w.converted {
+		return
+	}
+	w.converted = false
+
+	code := CodeFromHTTPStatus(w.status)
+	message := strings.TrimSpace(string(w.message))
+	if w.status < 300 {
+		message = fmt.Sprintf("unexpected content type %q", w.Header().Get("Content-Type"))
+	} else if message == "" {
+		message = http.StatusText(w.status)
+	}
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Del("X-Content-Type-Options")
+	header.Set("Content-Type", w.contentType)
+	header.Set("Grpc-Status", strconv.Itoa(int(code)))
+	header.Set("Grpc-Message", encodeMessage(message))
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+// encodeMessage percent-encodes a grpc-message value
+func encodeMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
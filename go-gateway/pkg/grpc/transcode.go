@@ -0,0 +1,582 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func init() {
+	filter.Register("GrpcTranscoding", NewGrpcTranscoding, GrpcTranscodingArgs{})
+}
+
+// transcodeWriterAttribute holds the transcodeWriter of a request until PostHandle
+const transcodeWriterAttribute = "grpc.transcode.writer"
+
+// GrpcTranscodingArgs configures the GrpcTranscoding filter
+type GrpcTranscodingArgs struct {
+	// DescriptorSet is the path of a compiled FileDescriptorSet, built with
+	// protoc --include_imports --descriptor_set_out
+	DescriptorSet string `mapstructure:"descriptorSet" json:"descriptorSet"`
+	// Services limits the transcoded services to these full names
+	Services []string `mapstructure:"services" json:"services,omitempty"`
+	// UseProtoNames writes response fields with their proto names instead of lowerCamelCase
+	UseProtoNames bool `mapstructure:"useProtoNames" json:"useProtoNames,omitempty"`
+	// EmitUnpopulated writes fields that have their default value
+	EmitUnpopulated bool `mapstructure:"emitUnpopulated" json:"emitUnpopulated,omitempty"`
+	// MaxBodySize limits the size of JSON request bodies in bytes
+	MaxBodySize int64 `mapstructure:"maxBodySize" json:"maxBodySize,omitempty"`
+}
+
+// binding is a compiled HTTP binding of a unary method
+type binding struct {
+	method       string
+	template     *pathTemplate
+	rpc          protoreflect.MethodDescriptor
+	path         string
+	body         string
+	responseBody protoreflect.FieldDescriptor
+}
+
+// GrpcTranscoding turns JSON REST calls into unary gRPC calls following the
+// google.api.http annotations of the services, and the responses back to JSON
+type GrpcTranscoding struct {
+	bindings    []*binding
+	types       *dynamicpb.Types
+	marshal     protojson.MarshalOptions
+	maxBodySize int64
+}
+
+// NewGrpcTranscoding creates a GrpcTranscoding filter from its args
+func NewGrpcTranscoding(args interface{}) (middleware.Middleware, error) {
+	cfg := GrpcTranscodingArgs{MaxBodySize: 4 << 20}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.DescriptorSet == "" {
+		return nil, fmt.Errorf("descriptorSet is required")
+	}
+	if cfg.MaxBodySize <= 0 {
+		return nil, fmt.Errorf("maxBodySize must be positive")
+	}
+
+	data, err := os.ReadFile(cfg.DescriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("reading descriptorSet: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing descriptorSet: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("descriptorSet: %w", err)
+	}
+
+	t := &GrpcTranscoding{
+		types:       dynamicpb.NewTypes(files),
+		marshal:     protojson.MarshalOptions{UseProtoNames: cfg.UseProtoNames, EmitUnpopulated: cfg.EmitUnpopulated},
+		maxBodySize: cfg.MaxBodySize,
+	}
+	t.bindings, err = compileBindings(files, cfg.Services)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.bindings) == 0 {
+		return nil, fmt.Errorf("descriptorSet has no google.api.http bindings")
+	}
+	return t, nil
+}
+
+// compileBindings compiles the HTTP bindings of the unary methods of the
+// services, or of all services when none are listed
+func compileBindings(files *protoregistry.Files, services []string) ([]*binding, error) {
+	wanted := make(map[string]bool)
+	for _, service := range services {
+		wanted[strings.TrimSpace(service)] = true
+	}
+
+	var bindings []*binding
+	var err error
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			service := file.Services().Get(i)
+			if len(wanted) > 0 && !wanted[string(service.FullName())] {
+				continue
+			}
+			delete(wanted, string(service.FullName()))
+			for j := 0; j < service.Methods().Len(); j++ {
+				var methodBindings []*binding
+				if methodBindings, err = compileMethod(service.Methods().Get(j)); err != nil {
+					return false
+				}
+				bindings = append(bindings, methodBindings...)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(wanted) > 0 {
+		missing := make([]string, 0, len(wanted))
+		for service := range wanted {
+			missing = append(missing, service)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("services not found in descriptorSet: %s", strings.Join(missing, ", "))
+	}
+
+	// The most specific template wins when several match
+	sort.SliceStable(bindings, func(i, j int) bool {
+		return bindings[i].template.literals() > bindings[j].template.literals()
+	})
+	return bindings, nil
+}
+
+func compileMethod(rpc protoreflect.MethodDescriptor) ([]*binding, error) {
+	rules, err := httpRules(rpc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: google.api.http: %w", rpc.FullName(), err)
+	}
+	if len(rules) > 0 && (rpc.IsStreamingClient() || rpc.IsStreamingServer()) {
+		return nil, fmt.Errorf("%s: only unary methods can be transcoded", rpc.FullName())
+	}
+
+	var bindings []*binding
+	for _, rule := range rules {
+		template, err := parseTemplate(rule.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", rpc.FullName(), err)
+		}
+		b := &binding{
+			method:   rule.method,
+			template: template,
+			rpc:      rpc,
+			path:     "/" + string(rpc.Parent().FullName()) + "/" + string(rpc.Name()),
+			body:     rule.body,
+		}
+		if b.body != "" && b.body != "*" && rpc.Input().Fields().ByName(protoreflect.Name(b.body)) == nil {
+			return nil, fmt.Errorf("%s: body field %q not found", rpc.FullName(), b.body)
+		}
+		for _, v := range template.variables {
+			if _, err := fieldPath(rpc.Input(), v.field); err != nil {
+				return nil, fmt.Errorf("%s: path variable: %w", rpc.FullName(), err)
+			}
+		}
+		if rule.responseBody != "" {
+			b.responseBody = rpc.Output().Fields().ByName(protoreflect.Name(rule.responseBody))
+			if b.responseBody == nil || b.responseBody.Message() == nil || b.responseBody.IsList() || b.responseBody.IsMap() {
+				return nil, fmt.Errorf("%s: response_body must name a message field", rpc.FullName())
+			}
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, nil
+}
+
+// Name returns the filter name
+func (t *GrpcTranscoding) Name() string {
+	return "GrpcTranscoding"
+}
+
+// PreHandle turns the REST call into a gRPC call and wraps the response
+func (t *GrpcTranscoding) PreHandle(ctx *middleware.GatewayContext) bool {
+	r := ctx.Request
+	b, variables := t.match(r)
+	if b == nil {
+		writeJSONStatus(ctx.Response, NotFound, fmt.Sprintf("no binding for %s %s", r.Method, r.URL.Path))
+		return false
+	}
+
+	message, err := t.decodeRequest(ctx, b, variables)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONStatus(ctx.Response, ResourceExhausted, "request body too large")
+			return false
+		}
+		writeJSONStatus(ctx.Response, InvalidArgument, err.Error())
+		return false
+	}
+	data, err := proto.Marshal(message.Interface())
+	if err != nil {
+		writeJSONStatus(ctx.Response, Internal, err.Error())
+		return false
+	}
+	body := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(data)))
+	body = append(body, data...)
+
+	r.Method = http.MethodPost
+	r.URL.Path, r.URL.RawPath, r.URL.RawQuery = b.path, "", ""
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Type", "application/grpc+proto")
+	r.Header.Set("Te", "trailers")
+	r.Header.Del("Content-Length")
+	r.Header.Del("Accept-Encoding")
+
+	w := &transcodeWriter{ResponseWriter: ctx.Response, filter: t, binding: b}
+	ctx.Response = w
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	ctx.Attributes[transcodeWriterAttribute] = w
+	return true
+}
+
+// PostHandle writes the JSON response
+func (t *GrpcTranscoding) PostHandle(ctx *middleware.GatewayContext) error {
+	w, ok := ctx.Attributes[transcodeWriterAttribute].(*transcodeWriter)
+	if !ok || ctx.Aborted() {
+		return nil
+	}
+	return w.finish()
+}
+
+// HandleError does nothing; the client has already received the response
+func (t *GrpcTranscoding) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// match returns the binding of the request and the values of its path variables
+func (t *GrpcTranscoding) match(r *http.Request) (*binding, map[string]string) {
+	for _, b := range t.bindings {
+		if b.method != r.Method && b.method != "*" {
+			continue
+		}
+		if variables, ok := b.template.match(r.URL.EscapedPath()); ok {
+			return b, variables
+		}
+	}
+	return nil, nil
+}
+
+// decodeRequest builds the request message from the body, the path variables
+// and the query parameters, in this order of precedence
+func (t *GrpcTranscoding) decodeRequest(ctx *middleware.GatewayContext, b *binding, variables map[string]string) (protoreflect.Message, error) {
+	message := dynamicpb.NewMessage(b.rpc.Input())
+	unmarshal := protojson.UnmarshalOptions{Resolver: t.types}
+
+	if b.body != "" && ctx.Request.Body != nil {
+		data, err := io.ReadAll(http.MaxBytesReader(ctx.Response, ctx.Request.Body, t.maxBodySize))
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if b.body != "*" {
+				// The body is the value of a single field
+				data, err = json.Marshal(map[string]json.RawMessage{b.body: data})
+				if err != nil {
+					return nil, fmt.Errorf("invalid JSON body: %w", err)
+				}
+			}
+			if err := unmarshal.Unmarshal(data, message.Interface()); err != nil {
+				return nil, fmt.Errorf("invalid body: %w", err)
+			}
+		}
+	}
+
+	bound := make(map[string]bool)
+	for field, value := range variables {
+		if err := setField(message, field, []string{value}); err != nil {
+			return nil, err
+		}
+		bound[field] = true
+	}
+
+	// Query parameters fill the fields not bound by the path or the body
+	if b.body == "*" {
+		return message, nil
+	}
+	for key, values := range ctx.Request.URL.Query() {
+		if bound[key] || b.body != "" && (key == b.body || strings.HasPrefix(key, b.body+".")) {
+			continue
+		}
+		if err := setField(message, key, values); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// fieldPath resolves a dotted field path such as "book.id" against a message.
+// Parts may be proto or JSON names.
+func fieldPath(md protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	var fields []protoreflect.FieldDescriptor
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		if md == nil {
+			return nil, fmt.Errorf("field %q: %s is not a message", path, strings.Join(parts[:i], "."))
+		}
+		fd := md.Fields().ByName(protoreflect.Name(part))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(part)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("unknown field %q", path)
+		}
+		if i < len(parts)-1 && (fd.IsList() || fd.IsMap()) {
+			return nil, fmt.Errorf("field %q: %s is repeated", path, part)
+		}
+		fields = append(fields, fd)
+		md = fd.Message()
+	}
+	return fields, nil
+}
+
+// setField sets the field at path from string values, appending them to
+// repeated fields
+func setField(message protoreflect.Message, path string, values []string) error {
+	fields, err := fieldPath(message.Descriptor(), path)
+	if err != nil {
+		return err
+	}
+	for _, fd := range fields[:len(fields)-1] {
+		message = message.Mutable(fd).Message()
+	}
+
+	fd := fields[len(fields)-1]
+	switch {
+	case fd.IsMap():
+		return fmt.Errorf("field %q: maps cannot be set from strings", path)
+	case fd.IsList():
+		list := message.Mutable(fd).List()
+		for _, s := range values {
+			element := list.NewElement()
+			value, err := parseValue(fd, s, element)
+			if err != nil {
+				return fmt.Errorf("field %q: %w", path, err)
+			}
+			list.Append(value)
+		}
+	default:
+		if len(values) == 0 {
+			return nil
+		}
+		value, err := parseValue(fd, values[len(values)-1], message.NewField(fd))
+		if err != nil {
+			return fmt.Errorf("field %q: %w", path, err)
+		}
+		message.Set(fd, value)
+	}
+	return nil
+}
+
+// parseValue parses s as a value of the kind of fd. Messages, such as
+// timestamps and wrappers, are parsed from their JSON string form into
+// element.
+func parseValue(fd protoreflect.FieldDescriptor, s string, element protoreflect.Value) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if value := fd.Enum().Values().ByName(protoreflect.Name(s)); value != nil {
+			return protoreflect.ValueOfEnum(value.Number()), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid enum value %q", s)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		quoted, _ := json.Marshal(s)
+		if err := protojson.Unmarshal(quoted, element.Message().Interface()); err != nil {
+			return protoreflect.Value{}, err
+		}
+		return element, nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported kind %s", fd.Kind())
+}
+
+// writeJSONStatus writes a gRPC status as a JSON error response
+func writeJSONStatus(w http.ResponseWriter, code Code, message string) {
+	body, _ := json.Marshal(struct {
+		Code    Code   `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code.HTTPStatus())
+	w.Write(body)
+}
+
+// transcodeWriter buffers the gRPC response of a transcoded call until the
+// status is known. Responses that are not gRPC, such as gateway errors, are
+// passed through.
+type transcodeWriter struct {
+	http.ResponseWriter
+	filter     *GrpcTranscoding
+	binding    *binding
+	status     int
+	translated bool
+	buf        bytes.Buffer
+}
+
+// WriteHeader holds back the header of gRPC responses
+func (w *transcodeWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "application/grpc") {
+		w.translated = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write buffers gRPC messages
+func (w *transcodeWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.translated {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+// Flush flushes responses that are passed through
+func (w *transcodeWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.translated {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *transcodeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the response message or the gRPC status as JSON
+func (w *transcodeWriter) finish() error {
+	if !w.translated {
+		return nil
+	}
+	w.translated = false
+
+	header := w.Header()
+	code, ok := Status(header)
+	message := header.Get("Grpc-Message")
+	if values := header[http.TrailerPrefix+"Grpc-Message"]; message == "" && len(values) > 0 {
+		message = values[0]
+	}
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+	for _, key := range header.Values("Trailer") {
+		for _, name := range strings.Split(key, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); strings.HasPrefix(name, "Grpc-") {
+				header.Del(name)
+			}
+		}
+	}
+	for key := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) || strings.HasPrefix(key, "Grpc-") {
+			delete(header, key)
+		}
+	}
+	header.Del("Trailer")
+	header.Del("Content-Length")
+
+	if !ok {
+		code, message = Internal, "missing grpc-status"
+	}
+	if code != OK {
+		writeJSONStatus(w.ResponseWriter, code, message)
+		return nil
+	}
+
+	body, err := w.decodeResponse()
+	if err != nil {
+		writeJSONStatus(w.ResponseWriter, Internal, err.Error())
+		return err
+	}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	_, err = w.ResponseWriter.Write(body)
+	return err
+}
+
+// decodeResponse converts the response message to JSON
+func (w *transcodeWriter) decodeResponse() ([]byte, error) {
+	data := w.buf.Bytes()
+	if len(data) < 5 {
+		return nil, fmt.Errorf("missing response message")
+	}
+	if data[0] != 0 {
+		return nil, fmt.Errorf("compressed response messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(data[1:5])
+	if uint64(len(data)-5) < uint64(size) {
+		return nil, fmt.Errorf("truncated response message")
+	}
+
+	message := dynamicpb.NewMessage(w.binding.rpc.Output())
+	if err := proto.Unmarshal(data[5:5+size], message); err != nil {
+		return nil, fmt.Errorf("invalid response message: %w", err)
+	}
+	var out proto.Message = message
+	if fd := w.binding.responseBody; fd != nil {
+		out = message.Get(fd).Message().Interface()
+	}
+	marshal := w.filter.marshal
+	marshal.Resolver = w.filter.types
+	return marshal.Marshal(out)
+}
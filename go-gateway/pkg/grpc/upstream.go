@@ -0,0 +1,39 @@
+package grpc
+
+import (
+	"net/http"
+	"net/url"
+)
+
+var (
+	// h2cTransport reaches grpc:// upstreams over HTTP/2 without TLS
+	h2cTransport = newTransport(false)
+	// h2Transport reaches grpcs:// upstreams over HTTP/2 with TLS
+	h2Transport = newTransport(true)
+)
+
+func newTransport(secure bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Protocols = new(http.Protocols)
+	if secure {
+		transport.Protocols.SetHTTP2(true)
+	} else {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return transport
+}
+
+// Upstream returns the URL and transport for a grpc:// (h2c) or grpcs://
+// (TLS) route URI. Other URIs are returned as they are with a nil transport.
+func Upstream(target *url.URL) (*url.URL, http.RoundTripper) {
+	u := *target
+	switch target.Scheme {
+	case "grpc":
+		u.Scheme = "http"
+		return &u, h2cTransport
+	case "grpcs":
+		u.Scheme = "https"
+		return &u, h2Transport
+	}
+	return target, nil
+}
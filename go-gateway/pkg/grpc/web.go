@@ -0,0 +1,249 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+)
+
+func init() {
+	filter.Register("GrpcWeb", NewGrpcWeb, nil)
+}
+
+// webWriterAttribute holds the webWriter of a request until PostHandle
+const webWriterAttribute = "grpc.web.writer"
+
+// trailerFlag marks the frame carrying the trailers of a gRPC-Web response
+const trailerFlag = 0x80
+
+// GrpcWeb translates gRPC-Web calls from browsers into native gRPC calls to
+// the upstream and the responses back, trailers included
+type GrpcWeb struct{}
+
+// NewGrpcWeb creates a GrpcWeb filter; it takes no args
+func NewGrpcWeb(args interface{}) (middleware.Middleware, error) {
+	var cfg struct{}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	return &GrpcWeb{}, nil
+}
+
+// Name returns the filter name
+func (g *GrpcWeb) Name() string {
+	return "GrpcWeb"
+}
+
+// PreHandle turns gRPC-Web requests into gRPC requests and wraps the response
+func (g *GrpcWeb) PreHandle(ctx *middleware.GatewayContext) bool {
+	r := ctx.Request
+	if !IsGrpcWeb(r) {
+		return true
+	}
+	contentType := r.Header.Get("Content-Type")
+	text := isText(contentType)
+
+	r.Header.Set("Content-Type", nativeContentType(contentType))
+	r.Header.Set("Te", "trailers")
+	r.Header.Del("Content-Length")
+	r.Header.Del("X-Grpc-Web")
+	r.ContentLength = -1
+	if text && r.Body != nil {
+		r.Body = &textReader{ReadCloser: r.Body}
+	}
+
+	// The response mirrors the request format
+	w := &webWriter{ResponseWriter: ctx.Response, contentType: contentType, text: text}
+	ctx.Response = w
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	ctx.Attributes[webWriterAttribute] = w
+	return true
+}
+
+// PostHandle ends the response with the trailer frame
+func (g *GrpcWeb) PostHandle(ctx *middleware.GatewayContext) error {
+	w, ok := ctx.Attributes[webWriterAttribute].(*webWriter)
+	if !ok || ctx.Aborted() {
+		return nil
+	}
+	return w.finish()
+}
+
+// HandleError does nothing; the client has already received the response
+func (g *GrpcWeb) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// isText reports whether a gRPC-Web content type is the base64 text format
+func isText(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/grpc-web-text")
+}
+
+// nativeContentType returns the gRPC content type of a gRPC-Web request,
+// keeping the message format: application/grpc-web-text+proto becomes
+// application/grpc+proto
+func nativeContentType(contentType string) string {
+	suffix := strings.TrimPrefix(contentType, "application/grpc-web")
+	suffix = strings.TrimPrefix(suffix, "-text")
+	return "application/grpc" + suffix
+}
+
+// webWriter turns a gRPC response into a gRPC-Web response. Trailers, which
+// browsers cannot read, are sent as a final frame of the body.
+type webWriter struct {
+	http.ResponseWriter
+	contentType  string
+	text         bool
+	status       int
+	translated   bool
+	trailersOnly bool
+	announced    []string
+}
+
+// WriteHeader translates the header of gRPC responses; other responses are
+// left to the gateway error handling
+func (w *webWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	header := w.Header()
+	if strings.HasPrefix(header.Get("Content-Type"), "application/grpc") {
+		w.translated = true
+		header.Set("Content-Type", w.contentType)
+		header.Del("Content-Length")
+		for _, value := range header.Values("Trailer") {
+			for _, key := range strings.Split(value, ",") {
+				if key = strings.TrimSpace(key); key != "" {
+					w.announced = append(w.announced, key)
+				}
+			}
+		}
+		header.Del("Trailer")
+		// A status in the header leaves nothing to send at the end
+		w.trailersOnly = header.Get("Grpc-Status") != ""
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write passes messages on, base64 encoded for the text format
+func (w *webWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.translated || !w.text {
+		return w.ResponseWriter.Write(data)
+	}
+	if _, err := w.ResponseWriter.Write([]byte(base64.StdEncoding.EncodeToString(data))); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Flush flushes the wrapped writer
+func (w *webWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *webWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish moves the trailers from the header map into the trailer frame
+func (w *webWriter) finish() error {
+	if !w.translated || w.trailersOnly {
+		return nil
+	}
+	header := w.Header()
+	trailers := make(http.Header)
+	for _, key := range w.announced {
+		key = http.CanonicalHeaderKey(key)
+		if values, ok := header[key]; ok {
+			trailers[key] = append(trailers[key], values...)
+			delete(header, key)
+		}
+	}
+	for key, values := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			name := http.CanonicalHeaderKey(strings.TrimPrefix(key, http.TrailerPrefix))
+			trailers[name] = append(trailers[name], values...)
+			delete(header, key)
+		}
+	}
+
+	keys := make([]string, 0, len(trailers))
+	for key := range trailers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var block strings.Builder
+	for _, key := range keys {
+		for _, value := range trailers[key] {
+			block.WriteString(strings.ToLower(key) + ": " + value + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	frame = append(frame, block.String()...)
+	_, err := w.Write(frame)
+	return err
+}
+
+// textReader decodes a grpc-web-text body. Clients may send the body as
+// several base64 chunks, each padded, so it is decoded quantum by quantum.
+type textReader struct {
+	io.ReadCloser
+	encoded []byte
+	decoded []byte
+	err     error
+}
+
+func (r *textReader) Read(p []byte) (int, error) {
+	for len(r.decoded) == 0 {
+		if r.err != nil {
+			if r.err == io.EOF && len(r.encoded) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, r.err
+		}
+		var buf [4096]byte
+		n, err := r.ReadCloser.Read(buf[:])
+		r.err = err
+		for _, c := range buf[:n] {
+			if c != '\r' && c != '\n' {
+				r.encoded = append(r.encoded, c)
+			}
+		}
+
+		var quantum [3]byte
+		for len(r.encoded) >= 4 {
+			m, err := base64.StdEncoding.Decode(quantum[:], r.encoded[:4])
+			if err != nil {
+				r.err = err
+				break
+			}
+			r.decoded = append(r.decoded, quantum[:m]...)
+			r.encoded = r.encoded[4:]
+		}
+	}
+	n := copy(p, r.decoded)
+	r.decoded = r.decoded[n:]
+	return n, nil
+}
@@ -0,0 +1,95 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("IpFilter", NewIpFilter, IpFilterArgs{})
+}
+
+// IpFilterArgs configures the IpFilter filter
+type IpFilterArgs struct {
+	// Allow lists the CIDRs allowed; when empty every address not denied is allowed
+	Allow []string `mapstructure:"allow" json:"allow,omitempty"`
+	// Deny lists the CIDRs denied; deny rules win over allow rules
+	Deny []string `mapstructure:"deny" json:"deny,omitempty"`
+	// Status is the status of rejected requests, 403 by default
+	Status int `mapstructure:"status" json:"status,omitempty"`
+}
+
+// IpFilter allows or denies clients by address
+type IpFilter struct {
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+	status int
+}
+
+// NewIpFilter creates an IpFilter from its args
+func NewIpFilter(args interface{}) (middleware.Middleware, error) {
+	cfg := IpFilterArgs{Status: http.StatusForbidden}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+
+	allow, err := ParseCIDRs(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	deny, err := ParseCIDRs(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	if cfg.Status < 400 || cfg.Status > 599 {
+		return nil, fmt.Errorf("status must be an error status")
+	}
+
+	return &IpFilter{allow: allow, deny: deny, status: cfg.Status}, nil
+}
+
+// Name returns the filter name
+func (f *IpFilter) Name() string {
+	return "IpFilter"
+}
+
+// PreHandle rejects clients that are denied or not allowed
+func (f *IpFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	if f.Allowed(net.ParseIP(ctx.ClientIP())) {
+		return true
+	}
+
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	monitoring.ErrorTotal.WithLabelValues("ip_rejected", routeID).Inc()
+	http.Error(ctx.Response, http.StatusText(f.status), f.status)
+	return false
+}
+
+// PostHandle does nothing
+func (f *IpFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *IpFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// Allowed reports whether ip passes the rules. Unparseable addresses are
+// only allowed when no rules are configured.
+func (f *IpFilter) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
@@ -0,0 +1,90 @@
+package ipfilter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+// TestResolver tests real client IP resolution behind trusted proxies
+func TestResolver(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("Failed to create resolver: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"DirectClient", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"UntrustedPeerIgnoresHeaders", "203.0.113.7:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "203.0.113.7"},
+		{"TrustedProxy", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "198.51.100.9"}, "198.51.100.9"},
+		{"SpoofedEntryBeforeProxies", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9, 192.168.1.1"}, "198.51.100.9"},
+		{"AllHopsTrusted", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "10.1.1.1"}, "10.1.1.1"},
+		{"ForwardedHeader", "10.0.0.1:5000", map[string]string{"Forwarded": `for=198.51.100.9;proto=https, for="[2001:db8::1]:4711"`}, "2001:db8::1"},
+		{"ObfuscatedHop", "10.0.0.1:5000", map[string]string{"Forwarded": "for=_hidden"}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://gateway/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := resolver.ClientIP(req); got != tt.expected {
+				t.Errorf("Expected client IP %s, got %s", tt.expected, got)
+			}
+		})
+	}
+
+	if _, err := NewResolver([]string{"not-a-cidr"}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+}
+
+// TestIpFilter tests CIDR allow and deny rules
+func TestIpFilter(t *testing.T) {
+	f, err := NewIpFilter(map[string]interface{}{
+		"allow": []interface{}{"198.51.100.0/24", "2001:db8::/32"},
+		"deny":  []interface{}{"198.51.100.66"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"198.51.100.9":  true,
+		"198.51.100.66": false,
+		"203.0.113.7":   false,
+		"2001:db8::1":   true,
+	} {
+		if got := f.(*IpFilter).Allowed(net.ParseIP(ip)); got != expected {
+			t.Errorf("Expected Allowed(%s) = %v, got %v", ip, expected, got)
+		}
+	}
+
+	ctx := &middleware.GatewayContext{
+		Request:    httptest.NewRequest("GET", "http://gateway/", nil),
+		Response:   httptest.NewRecorder(),
+		Route:      &common.Route{ID: "internal"},
+		Attributes: map[string]interface{}{middleware.ClientIPAttribute: "203.0.113.7"},
+	}
+	if f.PreHandle(ctx) {
+		t.Fatal("Expected client outside allow list to be rejected")
+	}
+	if code := ctx.Response.(*httptest.ResponseRecorder).Code; code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", code)
+	}
+
+	if _, err := NewIpFilter(map[string]interface{}{"deny": []interface{}{"10.0.0.0/33"}}); err == nil {
+		t.Error("Expected error for invalid CIDR")
+	}
+}
@@ -0,0 +1,45 @@
+package l4
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+var errHelloRead = errors.New("client hello read")
+
+// PeekServerName reads the TLS ClientHello from r and returns its server
+// name, along with a reader replaying everything read before the rest of r,
+// so that the connection can be passed on untouched
+func PeekServerName(r io.Reader) (string, io.Reader, error) {
+	var peeked bytes.Buffer
+	var serverName string
+	var seen bool
+	err := tls.Server(readOnlyConn{reader: io.TeeReader(r, &peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, seen = hello.ServerName, true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	if !seen {
+		return "", nil, err
+	}
+	return serverName, io.MultiReader(&peeked, r), nil
+}
+
+// readOnlyConn lets a TLS server read a ClientHello without answering it
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)         { return c.reader.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,478 @@
+package l4
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/listener"
+	"go-gateway/pkg/loadbalancer"
+	"go-gateway/pkg/monitoring"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrClosed is returned by Serve once the proxy is shut down
+var ErrClosed = errors.New("l4: proxy closed")
+
+var errNoUpstream = errors.New("no healthy upstream")
+
+const (
+	dialTimeout      = 5 * time.Second
+	handshakeTimeout = 10 * time.Second
+)
+
+// Resolver returns the pool of a service, or nil when there is none. It is
+// called for every connection so that reloaded services take effect.
+type Resolver func(service string) *loadbalancer.Pool
+
+// sniRoute routes TLS connections for some server names
+type sniRoute struct {
+	serverNames []string
+	uri         string
+}
+
+// TCPProxy proxies the TCP connections of a listener to services
+type TCPProxy struct {
+	name        string
+	uri         string
+	idleTimeout time.Duration
+	tlsConfig   *tls.Config
+	sni         []sniRoute
+	pools       Resolver
+	// proxyProtocol reads the headers of trusted load balancers;
+	// sendProxyProtocol is the header version sent to upstreams, or 0
+	proxyProtocol     *listener.ProxyProtocol
+	sendProxyProtocol int
+
+	mutex    sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closing  bool
+	wg       sync.WaitGroup
+}
+
+// NewTCPProxy creates the proxy of a TCP listener
+func NewTCPProxy(cfg config.TCPListenerConfig, pools Resolver) (*TCPProxy, error) {
+	p := &TCPProxy{
+		name:        cfg.Name,
+		uri:         cfg.URI,
+		idleTimeout: cfg.IdleTimeout,
+		pools:       pools,
+		conns:       make(map[net.Conn]struct{}),
+	}
+	if p.name == "" {
+		p.name = fmt.Sprintf("tcp-%d", cfg.Port)
+	}
+	if p.idleTimeout < 0 {
+		return nil, fmt.Errorf("tcp listener %s: idle_timeout must not be negative", p.name)
+	}
+	if p.uri == "" && len(cfg.SNI) == 0 {
+		return nil, fmt.Errorf("tcp listener %s: uri or sni is required", p.name)
+	}
+	if p.uri != "" {
+		if err := validateURI(p.uri, "tcp"); err != nil {
+			return nil, fmt.Errorf("tcp listener %s: %w", p.name, err)
+		}
+	}
+	for _, route := range cfg.SNI {
+		if len(route.ServerNames) == 0 {
+			return nil, fmt.Errorf("tcp listener %s: sni route without server_names", p.name)
+		}
+		if err := validateURI(route.URI, "tcp"); err != nil {
+			return nil, fmt.Errorf("tcp listener %s: %w", p.name, err)
+		}
+		names := make([]string, len(route.ServerNames))
+		for i, name := range route.ServerNames {
+			names[i] = strings.ToLower(name)
+		}
+		p.sni = append(p.sni, sniRoute{serverNames: names, uri: route.URI})
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := listener.NewServerTLSConfig(*cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("tcp listener %s: %w", p.name, err)
+		}
+		p.tlsConfig = tlsConfig
+	}
+	if cfg.ProxyProtocol != nil {
+		pp, err := listener.NewProxyProtocol(*cfg.ProxyProtocol)
+		if err != nil {
+			return nil, fmt.Errorf("tcp listener %s: %w", p.name, err)
+		}
+		p.proxyProtocol = pp
+	}
+	if cfg.SendProxyProtocol != "" {
+		version, err := listener.ParseProxyProtocolVersion(cfg.SendProxyProtocol)
+		if err != nil {
+			return nil, fmt.Errorf("tcp listener %s: send_proxy_protocol: %w", p.name, err)
+		}
+		p.sendProxyProtocol = version
+	}
+	return p, nil
+}
+
+// validateURI checks an upstream URI: lb://<service> or <scheme>://host:port
+func validateURI(uri, scheme string) error {
+	switch {
+	case strings.HasPrefix(uri, "lb://") && len(uri) > len("lb://"):
+		return nil
+	case strings.HasPrefix(uri, scheme+"://"):
+		if _, _, err := net.SplitHostPort(strings.TrimPrefix(uri, scheme+"://")); err != nil {
+			return fmt.Errorf("invalid uri %q: %w", uri, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid uri %q: expected lb://<service> or %s://host:port", uri, scheme)
+}
+
+// Name returns the listener name used in metrics
+func (p *TCPProxy) Name() string {
+	return p.name
+}
+
+// ListenAndServe listens on the TCP port and serves connections
+func (p *TCPProxy) ListenAndServe(port int) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return p.Serve(ln)
+}
+
+// Serve proxies the connections accepted on ln until Shutdown
+func (p *TCPProxy) Serve(ln net.Listener) error {
+	p.mutex.Lock()
+	if p.closing {
+		p.mutex.Unlock()
+		ln.Close()
+		return ErrClosed
+	}
+	if p.proxyProtocol != nil {
+		ln = p.proxyProtocol.Listen(ln)
+	}
+	p.listener = ln
+	p.mutex.Unlock()
+
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			p.mutex.Lock()
+			closing := p.closing
+			p.mutex.Unlock()
+			if closing {
+				return ErrClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		if !p.track(conn) {
+			conn.Close()
+			continue
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *TCPProxy) track(conn net.Conn) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closing {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	p.wg.Add(1)
+	return true
+}
+
+func (p *TCPProxy) untrack(conn net.Conn) {
+	p.mutex.Lock()
+	delete(p.conns, conn)
+	p.mutex.Unlock()
+	p.wg.Done()
+}
+
+// Shutdown stops accepting connections and waits for the open ones to end.
+// Connections still open when ctx is done are closed.
+func (p *TCPProxy) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	p.closing = true
+	if p.listener != nil {
+		p.listener.Close()
+	}
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.mutex.Lock()
+		for conn := range p.conns {
+			conn.Close()
+		}
+		p.mutex.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// handle proxies one client connection
+func (p *TCPProxy) handle(conn net.Conn) {
+	start := time.Now()
+	monitoring.TCPConnections.WithLabelValues(p.name).Inc()
+	defer func() {
+		conn.Close()
+		monitoring.TCPConnections.WithLabelValues(p.name).Dec()
+		monitoring.TCPConnectionDuration.WithLabelValues(p.name).Observe(time.Since(start).Seconds())
+		p.untrack(conn)
+	}()
+
+	if pc, ok := conn.(*listener.ProxyConn); ok {
+		if _, err := pc.ProxyHeader(); err != nil {
+			p.count("proxy_protocol_error")
+			return
+		}
+	}
+	client, serverName, err := p.accept(conn)
+	if err != nil {
+		p.count("tls_error")
+		return
+	}
+	uri := p.route(serverName)
+	if uri == "" {
+		p.count("no_route")
+		return
+	}
+	upstream, err := p.dial(uri, client.RemoteAddr())
+	if err != nil {
+		if errors.Is(err, errNoUpstream) {
+			p.count("no_upstream")
+		} else {
+			p.count("dial_error")
+		}
+		log.Printf("TCP listener %s: %s: %v", p.name, uri, err)
+		return
+	}
+	defer upstream.Close()
+	if p.sendProxyProtocol != 0 {
+		header := &listener.ProxyHeader{Source: client.RemoteAddr(), Destination: client.LocalAddr()}
+		data, _ := header.Format(p.sendProxyProtocol)
+		if _, err := upstream.Write(data); err != nil {
+			p.count("dial_error")
+			log.Printf("TCP listener %s: %s: %v", p.name, uri, err)
+			return
+		}
+	}
+	p.count("ok")
+
+	Pipe(client, upstream, p.idleTimeout,
+		monitoring.TCPBytesTotal.WithLabelValues(p.name, "in"),
+		monitoring.TCPBytesTotal.WithLabelValues(p.name, "out"))
+}
+
+func (p *TCPProxy) count(result string) {
+	monitoring.TCPConnectionsTotal.WithLabelValues(p.name, result).Inc()
+}
+
+// accept terminates TLS or peeks at the server name of passed through TLS
+// connections as configured, returning the connection to proxy
+func (p *TCPProxy) accept(conn net.Conn) (net.Conn, string, error) {
+	if p.tlsConfig == nil && len(p.sni) == 0 {
+		return conn, "", nil
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if p.tlsConfig != nil {
+		tlsConn := tls.Server(conn, p.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, "", err
+		}
+		return tlsConn, tlsConn.ConnectionState().ServerName, nil
+	}
+
+	serverName, replay, err := PeekServerName(conn)
+	if err != nil {
+		return nil, "", err
+	}
+	return &replayConn{Conn: conn, reader: replay}, serverName, nil
+}
+
+// route returns the upstream URI of a connection
+func (p *TCPProxy) route(serverName string) string {
+	serverName = strings.ToLower(serverName)
+	for _, route := range p.sni {
+		for _, pattern := range route.serverNames {
+			if matchServerName(pattern, serverName) {
+				return route.uri
+			}
+		}
+	}
+	return p.uri
+}
+
+// matchServerName matches exact names and wildcards covering one label,
+// e.g. *.example.com matches db.example.com
+func matchServerName(pattern, serverName string) bool {
+	if serverName == "" {
+		return false
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		label, found := strings.CutSuffix(serverName, suffix)
+		return found && label != "" && !strings.Contains(label, ".")
+	}
+	return pattern == serverName
+}
+
+// dial connects to the upstream of uri, trying the other servers of the pool
+// when one cannot be reached. Services choose the server by the client IP, so
+// consistent_hash keeps a client on one server.
+func (p *TCPProxy) dial(uri string, client net.Addr) (net.Conn, error) {
+	if address, ok := strings.CutPrefix(uri, "tcp://"); ok {
+		return net.DialTimeout("tcp", address, dialTimeout)
+	}
+
+	pool := p.pools(strings.TrimPrefix(uri, "lb://"))
+	if pool == nil {
+		return nil, errNoUpstream
+	}
+	clientIP := client.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	var lastErr error = errNoUpstream
+	tried := make(map[string]bool)
+	for {
+		server := pool.ChooseForExcept(clientIP, tried)
+		if server == nil {
+			return nil, lastErr
+		}
+		conn, err := net.DialTimeout("tcp", HostPort(server.URL), dialTimeout)
+		if err == nil {
+			return conn, nil
+		}
+		tried[server.URL] = true
+		lastErr = err
+	}
+}
+
+// HostPort returns the address of a server URL such as tcp://10.0.0.1:5432;
+// bare host:port addresses are returned unchanged
+func HostPort(serverURL string) string {
+	if u, err := url.Parse(serverURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return serverURL
+}
+
+// Pipe copies data both ways between client and upstream until both sides
+// are done, counting the bytes sent by the client in in and those sent to it
+// in out. A side closing its write half is passed on. With an idle timeout
+// the connections are closed when no data passed either way for that long.
+// It returns the bytes copied each way.
+func Pipe(client, upstream net.Conn, idleTimeout time.Duration, in, out prometheus.Counter) (inBytes, outBytes int64) {
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+
+	errs := make(chan error, 2)
+	go func() {
+		var err error
+		inBytes, err = copyConn(upstream, client, idleTimeout, &lastActive, in)
+		errs <- err
+	}()
+	go func() {
+		var err error
+		outBytes, err = copyConn(client, upstream, idleTimeout, &lastActive, out)
+		errs <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			// Unblock the other direction
+			client.Close()
+			upstream.Close()
+		}
+	}
+	return inBytes, outBytes
+}
+
+// copyConn copies src to dst, returning the bytes copied. The error is nil
+// when src ended cleanly.
+func copyConn(dst, src net.Conn, idleTimeout time.Duration, lastActive *atomic.Int64, counter prometheus.Counter) (int64, error) {
+	var written int64
+	buf := make([]byte, 32*1024)
+	for {
+		if idleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			lastActive.Store(time.Now().UnixNano())
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return written, werr
+			}
+			written += int64(n)
+			counter.Add(float64(n))
+		}
+		if err == nil {
+			continue
+		}
+
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() && idleTimeout > 0 {
+			// The other direction may have been active meanwhile
+			if time.Since(time.Unix(0, lastActive.Load())) < idleTimeout {
+				continue
+			}
+			return written, err
+		}
+		if err == io.EOF {
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+				return written, nil
+			}
+		}
+		return written, err
+	}
+}
+
+// replayConn is a connection whose first bytes were already read
+type replayConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// CloseWrite closes the write half of the underlying connection
+func (c *replayConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
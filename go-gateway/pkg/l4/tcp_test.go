@@ -0,0 +1,445 @@
+package l4
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/listener"
+	"go-gateway/pkg/loadbalancer"
+	"go-gateway/pkg/monitoring"
+)
+
+// writeCert writes a self-signed certificate for names and returns the TLS
+// config using it
+func writeCert(t *testing.T, dir string, names ...string) *config.TLSConfig {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	cfg := &config.TLSConfig{CertFile: filepath.Join(dir, names[0]+".crt"), KeyFile: filepath.Join(dir, names[0]+".key")}
+	os.WriteFile(cfg.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return cfg
+}
+
+// backend serves every connection with handle until the test ends
+func backend(t *testing.T, ln net.Listener, handle func(conn net.Conn)) string {
+	t.Helper()
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return "tcp://" + ln.Addr().String()
+}
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+// echo echoes the connection until the client closes its write half
+func echo(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+// greet answers every connection with a line naming the backend
+func greet(name string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		conn.Write([]byte(name + "\n"))
+		io.Copy(io.Discard, conn)
+	}
+}
+
+// start serves a TCP proxy for cfg with the given services
+func start(t *testing.T, cfg config.TCPListenerConfig, pools map[string]*loadbalancer.Pool) (*TCPProxy, string) {
+	t.Helper()
+	proxy, err := NewTCPProxy(cfg, func(service string) *loadbalancer.Pool { return pools[service] })
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	ln := listen(t)
+	go proxy.Serve(ln)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		proxy.Shutdown(ctx)
+	})
+	return proxy, ln.Addr().String()
+}
+
+func pool(name string, urls ...string) map[string]*loadbalancer.Pool {
+	servers := make([]loadbalancer.Server, len(urls))
+	for i, u := range urls {
+		servers[i] = loadbalancer.Server{URL: u}
+	}
+	return map[string]*loadbalancer.Pool{name: loadbalancer.NewPool(name, loadbalancer.NewRoundRobinBalancer(), servers, nil)}
+}
+
+func readLine(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	return line[:len(line)-1]
+}
+
+// TestTCPProxy tests proxying TCP connections to service pools
+func TestTCPProxy(t *testing.T) {
+	t.Run("EchoAndMetrics", func(t *testing.T) {
+		upstream := backend(t, listen(t), echo)
+		_, addr := start(t, config.TCPListenerConfig{Name: "echo", URI: "lb://echo"}, pool("echo", upstream))
+		before := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("echo", "ok"))
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello"))
+		conn.(*net.TCPConn).CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if got, err := io.ReadAll(conn); err != nil || string(got) != "hello" {
+			t.Fatalf("Expected echo until half close, got %q %v", got, err)
+		}
+		if got := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("echo", "ok")); got != before+1 {
+			t.Errorf("Expected connection to be counted, got %v", got-before)
+		}
+		if got := testutil.ToFloat64(monitoring.TCPBytesTotal.WithLabelValues("echo", "in")); got < 5 {
+			t.Errorf("Expected bytes to be counted, got %v", got)
+		}
+	})
+
+	t.Run("Failover", func(t *testing.T) {
+		down := listen(t)
+		downURL := "tcp://" + down.Addr().String()
+		down.Close()
+		upstream := backend(t, listen(t), greet("up"))
+		_, addr := start(t, config.TCPListenerConfig{Name: "failover", URI: "lb://db"}, pool("db", downURL, upstream))
+
+		for i := 0; i < 2; i++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := readLine(t, conn); got != "up" {
+				t.Errorf("Expected the reachable server, got %q", got)
+			}
+			conn.Close()
+		}
+	})
+
+	t.Run("ConsistentHash", func(t *testing.T) {
+		servers := []loadbalancer.Server{
+			{URL: backend(t, listen(t), greet("a"))}, {URL: backend(t, listen(t), greet("b"))}, {URL: backend(t, listen(t), greet("c"))},
+		}
+		pools := map[string]*loadbalancer.Pool{
+			"db": loadbalancer.NewPool("db", loadbalancer.NewConsistentHashBalancer(), servers, nil),
+		}
+		_, addr := start(t, config.TCPListenerConfig{Name: "hash", URI: "lb://db"}, pools)
+
+		// Every connection of a client IP goes to the same server
+		var first string
+		for i := 0; i < 5; i++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := readLine(t, conn)
+			conn.Close()
+			if i == 0 {
+				first = got
+			} else if got != first {
+				t.Errorf("Expected server %s for the same client IP, got %s", first, got)
+			}
+		}
+	})
+
+	t.Run("FailoverSkipsTried", func(t *testing.T) {
+		// A hash keeps choosing the same server, so failover has to leave out
+		// the servers already tried
+		var servers []loadbalancer.Server
+		for i := 0; i < 5; i++ {
+			down := listen(t)
+			down.Close()
+			servers = append(servers, loadbalancer.Server{URL: "tcp://" + down.Addr().String()})
+		}
+		servers = append(servers, loadbalancer.Server{URL: backend(t, listen(t), greet("up"))})
+		pools := map[string]*loadbalancer.Pool{
+			"db": loadbalancer.NewPool("db", loadbalancer.NewConsistentHashBalancer(), servers, nil),
+		}
+		_, addr := start(t, config.TCPListenerConfig{Name: "hash-failover", URI: "lb://db"}, pools)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if got := readLine(t, conn); got != "up" {
+			t.Errorf("Expected the reachable server, got %q", got)
+		}
+	})
+
+	t.Run("NoUpstream", func(t *testing.T) {
+		_, addr := start(t, config.TCPListenerConfig{Name: "missing", URI: "lb://missing"}, nil)
+		before := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("missing", "no_upstream"))
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("Expected connection to be closed, got %v", err)
+		}
+		if got := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("missing", "no_upstream")); got != before+1 {
+			t.Errorf("Expected no_upstream to be counted, got %v", got-before)
+		}
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		upstream := backend(t, listen(t), echo)
+		_, addr := start(t, config.TCPListenerConfig{Name: "idle", URI: upstream, IdleTimeout: 100 * time.Millisecond}, nil)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// Traffic keeps the connection open past the idle timeout
+		for i := 0; i < 4; i++ {
+			conn.Write([]byte("ping\n"))
+			if got := readLine(t, conn); got != "ping" {
+				t.Fatalf("Expected echo, got %q", got)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		start := time.Now()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("Expected idle connection to be closed, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected close after the idle timeout, took %v", elapsed)
+		}
+	})
+
+	t.Run("TLSTermination", func(t *testing.T) {
+		dir := t.TempDir()
+		db := backend(t, listen(t), greet("db"))
+		fallback := backend(t, listen(t), greet("fallback"))
+		_, addr := start(t, config.TCPListenerConfig{
+			Name: "tls",
+			URI:  fallback,
+			TLS:  writeCert(t, dir, "gateway.example.com"),
+			SNI:  []config.SNIRouteConfig{{ServerNames: []string{"*.db.example.com"}, URI: "lb://db"}},
+		}, pool("db", db))
+
+		for serverName, expected := range map[string]string{"eu.db.example.com": "db", "other.example.com": "fallback"} {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+			if err != nil {
+				t.Fatalf("Handshake failed: %v", err)
+			}
+			if got := readLine(t, conn); got != expected {
+				t.Errorf("%s: expected %s, got %s", serverName, expected, got)
+			}
+			conn.Close()
+		}
+	})
+
+	t.Run("SNIPassthrough", func(t *testing.T) {
+		dir := t.TempDir()
+		var upstreams []string
+		var roots = x509.NewCertPool()
+		for _, name := range []string{"a.example.com", "b.example.com"} {
+			tlsCfg := writeCert(t, dir, name)
+			cert, _ := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+			leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+			roots.AddCert(leaf)
+			ln := tls.NewListener(listen(t), &tls.Config{Certificates: []tls.Certificate{cert}})
+			upstreams = append(upstreams, backend(t, ln, greet(name)))
+		}
+		_, addr := start(t, config.TCPListenerConfig{
+			Name: "passthrough",
+			SNI: []config.SNIRouteConfig{
+				{ServerNames: []string{"a.example.com"}, URI: upstreams[0]},
+				{ServerNames: []string{"b.example.com"}, URI: upstreams[1]},
+			},
+		}, nil)
+
+		for _, name := range []string{"a.example.com", "b.example.com"} {
+			// The backend's own certificate verifies: the TLS session is end to end
+			conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: name, RootCAs: roots})
+			if err != nil {
+				t.Fatalf("%s: handshake failed: %v", name, err)
+			}
+			if got := readLine(t, conn); got != name {
+				t.Errorf("Expected backend %s, got %s", name, got)
+			}
+			conn.Close()
+		}
+
+		before := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("passthrough", "no_route"))
+		if conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "c.example.com", RootCAs: roots}); err == nil {
+			conn.Close()
+			t.Error("Expected unrouted server name to be refused")
+		}
+		if got := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("passthrough", "no_route")); got != before+1 {
+			t.Errorf("Expected no_route to be counted, got %v", got-before)
+		}
+	})
+
+	t.Run("ProxyProtocol", func(t *testing.T) {
+		// The backend answers with the PROXY protocol header it received
+		upstream := backend(t, listen(t), func(conn net.Conn) {
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte(line))
+		})
+		_, addr := start(t, config.TCPListenerConfig{
+			Name:              "proxy-protocol",
+			URI:               upstream,
+			ProxyProtocol:     &config.ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}},
+			SendProxyProtocol: "v1",
+		}, nil)
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		header, _ := (&listener.ProxyHeader{
+			Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000},
+			Destination: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5432},
+		}).Format(2)
+		conn.Write(header)
+		if got := readLine(t, conn); got != "PROXY TCP4 203.0.113.7 192.0.2.2 40000 5432\r" {
+			t.Errorf("Expected the client address to be passed on, got %q", got)
+		}
+
+		before := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("proxy-protocol", "proxy_protocol_error"))
+		conn, err = net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("hello\n"))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("Expected connection without header to be closed, got %v", err)
+		}
+		if got := testutil.ToFloat64(monitoring.TCPConnectionsTotal.WithLabelValues("proxy-protocol", "proxy_protocol_error")); got != before+1 {
+			t.Errorf("Expected proxy_protocol_error to be counted, got %v", got-before)
+		}
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		upstream := backend(t, listen(t), echo)
+		proxy, err := NewTCPProxy(config.TCPListenerConfig{Name: "shutdown", URI: upstream}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln := listen(t)
+		served := make(chan error, 1)
+		go func() { served <- proxy.Serve(ln) }()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("x\n"))
+		readLine(t, conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := proxy.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected open connection to outlast the deadline, got %v", err)
+		}
+		if err := <-served; !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("Expected connection to be closed, got %v", err)
+		}
+	})
+}
+
+// TestNewTCPProxy tests listener validation and server name matching
+func TestNewTCPProxy(t *testing.T) {
+	invalid := []config.TCPListenerConfig{
+		{Name: "none"},
+		{Name: "scheme", URI: "http://db:5432"},
+		{Name: "port", URI: "tcp://db"},
+		{Name: "names", SNI: []config.SNIRouteConfig{{URI: "lb://db"}}},
+		{Name: "idle", URI: "lb://db", IdleTimeout: -time.Second},
+		{Name: "trusted", URI: "lb://db", ProxyProtocol: &config.ProxyProtocolConfig{}},
+		{Name: "send", URI: "lb://db", SendProxyProtocol: "v3"},
+	}
+	for _, cfg := range invalid {
+		if _, err := NewTCPProxy(cfg, nil); err == nil {
+			t.Errorf("Expected listener %s to be rejected", cfg.Name)
+		}
+	}
+
+	tests := []struct {
+		pattern, serverName string
+		expected            bool
+	}{
+		{"db.example.com", "db.example.com", true},
+		{"*.example.com", "db.example.com", true},
+		{"*.example.com", "eu.db.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"db.example.com", "", false},
+	}
+	for _, tt := range tests {
+		if got := matchServerName(tt.pattern, tt.serverName); got != tt.expected {
+			t.Errorf("matchServerName(%q, %q) = %v", tt.pattern, tt.serverName, got)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package l4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/monitoring"
+)
+
+const (
+	// defaultUDPIdleTimeout ends sessions when the listener sets no timeout
+	defaultUDPIdleTimeout = 30 * time.Second
+	// defaultUDPMaxSessions bounds the sessions, each holding an upstream
+	// socket, when the listener sets no limit
+	defaultUDPMaxSessions = 10000
+	// maxDatagramSize is the largest UDP payload
+	maxDatagramSize = 64 * 1024
+)
+
+// udpSession relays the datagrams of one client address through its own
+// upstream socket, so that replies can be told apart
+type udpSession struct {
+	key        string
+	client     net.Addr
+	upstream   net.Conn
+	lastActive atomic.Int64
+}
+
+func (s *udpSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// UDPProxy proxies the datagrams of a UDP listener to a service
+type UDPProxy struct {
+	name        string
+	uri         string
+	idleTimeout time.Duration
+	maxSessions int
+	pools       Resolver
+
+	mutex    sync.Mutex
+	conn     net.PacketConn
+	sessions map[string]*udpSession
+	closing  bool
+	wg       sync.WaitGroup
+}
+
+// NewUDPProxy creates the proxy of a UDP listener
+func NewUDPProxy(cfg config.UDPListenerConfig, pools Resolver) (*UDPProxy, error) {
+	p := &UDPProxy{
+		name:        cfg.Name,
+		uri:         cfg.URI,
+		idleTimeout: cfg.IdleTimeout,
+		maxSessions: cfg.MaxSessions,
+		pools:       pools,
+		sessions:    make(map[string]*udpSession),
+	}
+	if p.name == "" {
+		p.name = fmt.Sprintf("udp-%d", cfg.Port)
+	}
+	if p.idleTimeout < 0 {
+		return nil, fmt.Errorf("udp listener %s: idle_timeout must not be negative", p.name)
+	}
+	if p.idleTimeout == 0 {
+		p.idleTimeout = defaultUDPIdleTimeout
+	}
+	if p.maxSessions < 0 {
+		return nil, fmt.Errorf("udp listener %s: max_sessions must not be negative", p.name)
+	}
+	if p.maxSessions == 0 {
+		p.maxSessions = defaultUDPMaxSessions
+	}
+	if err := validateURI(p.uri, "udp"); err != nil {
+		return nil, fmt.Errorf("udp listener %s: %w", p.name, err)
+	}
+	return p, nil
+}
+
+// Name returns the listener name used in metrics
+func (p *UDPProxy) Name() string {
+	return p.name
+}
+
+// ListenAndServe listens on the UDP port and serves datagrams
+func (p *UDPProxy) ListenAndServe(port int) error {
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	return p.Serve(conn)
+}
+
+// Serve proxies the datagrams received on conn until Shutdown
+func (p *UDPProxy) Serve(conn net.PacketConn) error {
+	p.mutex.Lock()
+	if p.closing {
+		p.mutex.Unlock()
+		conn.Close()
+		return ErrClosed
+	}
+	p.conn = conn
+	p.mutex.Unlock()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			p.mutex.Lock()
+			closing := p.closing
+			p.mutex.Unlock()
+			if closing {
+				return ErrClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+
+		session := p.session(addr)
+		if session == nil {
+			// Dropped; the next datagram of the client tries again
+			continue
+		}
+		session.touch()
+		if _, err := session.upstream.Write(buf[:n]); err != nil {
+			// Ends the session through its reply loop
+			session.upstream.Close()
+			continue
+		}
+		monitoring.UDPPacketsTotal.WithLabelValues(p.name, "in").Inc()
+		monitoring.UDPBytesTotal.WithLabelValues(p.name, "in").Add(float64(n))
+	}
+}
+
+// session returns the session of a client address, starting one for new
+// clients. It returns nil when no upstream is available or the listener has
+// reached its session limit.
+func (p *UDPProxy) session(client net.Addr) *udpSession {
+	key := client.String()
+	p.mutex.Lock()
+	session := p.sessions[key]
+	full := len(p.sessions) >= p.maxSessions
+	p.mutex.Unlock()
+	if session != nil {
+		return session
+	}
+	if full {
+		// Sessions are only started by Serve, so the limit cannot be passed
+		// before this one is added
+		p.count("session_limit")
+		return nil
+	}
+
+	upstream, err := p.dial(client)
+	if err != nil {
+		if errors.Is(err, errNoUpstream) {
+			p.count("no_upstream")
+		} else {
+			p.count("dial_error")
+		}
+		log.Printf("UDP listener %s: %s: %v", p.name, p.uri, err)
+		return nil
+	}
+
+	session = &udpSession{key: key, client: client, upstream: upstream}
+	session.touch()
+	p.mutex.Lock()
+	if p.closing {
+		p.mutex.Unlock()
+		upstream.Close()
+		return nil
+	}
+	p.sessions[key] = session
+	p.wg.Add(1)
+	p.mutex.Unlock()
+
+	p.count("ok")
+	monitoring.UDPSessions.WithLabelValues(p.name).Inc()
+	go p.reply(session)
+	return session
+}
+
+func (p *UDPProxy) count(result string) {
+	monitoring.UDPSessionsTotal.WithLabelValues(p.name, result).Inc()
+}
+
+// dial opens the upstream socket of a client. Services choose the server by
+// the client IP, so consistent_hash keeps a client on one server.
+func (p *UDPProxy) dial(client net.Addr) (net.Conn, error) {
+	if address, ok := strings.CutPrefix(p.uri, "udp://"); ok {
+		return net.DialTimeout("udp", address, dialTimeout)
+	}
+
+	pool := p.pools(strings.TrimPrefix(p.uri, "lb://"))
+	if pool == nil {
+		return nil, errNoUpstream
+	}
+	clientIP := client.String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	server := pool.ChooseFor(clientIP)
+	if server == nil {
+		return nil, errNoUpstream
+	}
+	return net.DialTimeout("udp", HostPort(server.URL), dialTimeout)
+}
+
+// reply relays the upstream datagrams of a session back to its client until
+// the session is idle or its upstream fails
+func (p *UDPProxy) reply(session *udpSession) {
+	defer p.end(session)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		deadline := time.Unix(0, session.lastActive.Load()).Add(p.idleTimeout)
+		session.upstream.SetReadDeadline(deadline)
+		n, err := session.upstream.Read(buf)
+		if n > 0 {
+			session.touch()
+			if _, werr := p.conn.WriteTo(buf[:n], session.client); werr != nil {
+				return
+			}
+			monitoring.UDPPacketsTotal.WithLabelValues(p.name, "out").Inc()
+			monitoring.UDPBytesTotal.WithLabelValues(p.name, "out").Add(float64(n))
+		}
+		if err == nil {
+			continue
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			// The client may have sent datagrams meanwhile
+			if time.Since(time.Unix(0, session.lastActive.Load())) < p.idleTimeout {
+				continue
+			}
+		}
+		return
+	}
+}
+
+// end removes a finished session
+func (p *UDPProxy) end(session *udpSession) {
+	p.mutex.Lock()
+	if p.sessions[session.key] == session {
+		delete(p.sessions, session.key)
+	}
+	p.mutex.Unlock()
+
+	session.upstream.Close()
+	monitoring.UDPSessions.WithLabelValues(p.name).Dec()
+	p.wg.Done()
+}
+
+// Shutdown stops receiving datagrams and ends all sessions. Replies still
+// on their way are dropped, as they could no longer be sent to the clients.
+func (p *UDPProxy) Shutdown(ctx context.Context) error {
+	p.mutex.Lock()
+	p.closing = true
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	for _, session := range p.sessions {
+		session.upstream.Close()
+	}
+	p.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,237 @@
+package l4
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/loadbalancer"
+	"go-gateway/pkg/monitoring"
+)
+
+// udpBackend answers every datagram with its name, the client address it saw
+// and the payload
+func udpBackend(t *testing.T, name string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte(name+" "+addr.String()+" "+string(buf[:n])), addr)
+		}
+	}()
+	return "udp://" + conn.LocalAddr().String()
+}
+
+// startUDP serves a UDP proxy for cfg with the given services
+func startUDP(t *testing.T, cfg config.UDPListenerConfig, pools map[string]*loadbalancer.Pool) (*UDPProxy, string) {
+	t.Helper()
+	proxy, err := NewUDPProxy(cfg, func(service string) *loadbalancer.Pool { return pools[service] })
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %v", err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve(conn)
+	t.Cleanup(func() { proxy.Shutdown(context.Background()) })
+	return proxy, conn.LocalAddr().String()
+}
+
+// exchange sends payload from client and returns the reply split into backend
+// name, upstream client address and payload
+func exchange(t *testing.T, client net.Conn, payload string) []string {
+	t.Helper()
+	if _, err := client.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1024)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	return strings.SplitN(string(buf[:n]), " ", 3)
+}
+
+func dialUDP(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func waitSessions(t *testing.T, proxy *UDPProxy, expected int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		proxy.mutex.Lock()
+		n := len(proxy.sessions)
+		proxy.mutex.Unlock()
+		if n == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d sessions, got %d", expected, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestUDPProxy tests proxying UDP datagrams with sessions
+func TestUDPProxy(t *testing.T) {
+	t.Run("Sessions", func(t *testing.T) {
+		proxy, addr := startUDP(t, config.UDPListenerConfig{Name: "sessions", URI: udpBackend(t, "dns")}, nil)
+		packetsIn := testutil.ToFloat64(monitoring.UDPPacketsTotal.WithLabelValues("sessions", "in"))
+		packetsOut := testutil.ToFloat64(monitoring.UDPPacketsTotal.WithLabelValues("sessions", "out"))
+
+		a, b := dialUDP(t, addr), dialUDP(t, addr)
+		first := exchange(t, a, "a1")
+		if first[0] != "dns" || first[2] != "a1" {
+			t.Fatalf("Unexpected reply %q", first)
+		}
+		if reply := exchange(t, b, "b1"); reply[2] != "b1" || reply[1] == first[1] {
+			t.Errorf("Expected own reply through its own session, got %q", reply)
+		}
+		if reply := exchange(t, a, "a2"); reply[2] != "a2" || reply[1] != first[1] {
+			t.Errorf("Expected the session to be reused, got %q", reply)
+		}
+
+		waitSessions(t, proxy, 2)
+		if got := testutil.ToFloat64(monitoring.UDPSessions.WithLabelValues("sessions")); got != 2 {
+			t.Errorf("Expected 2 open sessions, got %v", got)
+		}
+		if got := testutil.ToFloat64(monitoring.UDPPacketsTotal.WithLabelValues("sessions", "in")); got != packetsIn+3 {
+			t.Errorf("Expected 3 packets in, got %v", got-packetsIn)
+		}
+		if got := testutil.ToFloat64(monitoring.UDPPacketsTotal.WithLabelValues("sessions", "out")); got != packetsOut+3 {
+			t.Errorf("Expected 3 packets out, got %v", got-packetsOut)
+		}
+	})
+
+	t.Run("ConsistentHash", func(t *testing.T) {
+		servers := []loadbalancer.Server{
+			{URL: udpBackend(t, "a")}, {URL: udpBackend(t, "b")}, {URL: udpBackend(t, "c")},
+		}
+		pools := map[string]*loadbalancer.Pool{
+			"syslog": loadbalancer.NewPool("syslog", loadbalancer.NewConsistentHashBalancer(), servers, nil),
+		}
+		_, addr := startUDP(t, config.UDPListenerConfig{Name: "hash", URI: "lb://syslog"}, pools)
+
+		// Every session of a client IP goes to the same server
+		backend := exchange(t, dialUDP(t, addr), "x")[0]
+		for i := 0; i < 5; i++ {
+			if got := exchange(t, dialUDP(t, addr), "x")[0]; got != backend {
+				t.Errorf("Expected server %s for the same client IP, got %s", backend, got)
+			}
+		}
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		proxy, addr := startUDP(t, config.UDPListenerConfig{
+			Name: "idle", URI: udpBackend(t, "dns"), IdleTimeout: 100 * time.Millisecond,
+		}, nil)
+		client := dialUDP(t, addr)
+		first := exchange(t, client, "1")
+		waitSessions(t, proxy, 1)
+		waitSessions(t, proxy, 0)
+
+		// A new session with a new upstream socket
+		if reply := exchange(t, client, "2"); reply[1] == first[1] {
+			t.Errorf("Expected a new session after the idle timeout, got %q", reply)
+		}
+	})
+
+	t.Run("NoUpstream", func(t *testing.T) {
+		proxy, addr := startUDP(t, config.UDPListenerConfig{Name: "missing", URI: "lb://missing"}, nil)
+		before := testutil.ToFloat64(monitoring.UDPSessionsTotal.WithLabelValues("missing", "no_upstream"))
+		dialUDP(t, addr).Write([]byte("x"))
+
+		deadline := time.Now().Add(2 * time.Second)
+		for testutil.ToFloat64(monitoring.UDPSessionsTotal.WithLabelValues("missing", "no_upstream")) != before+1 {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected no_upstream to be counted")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		waitSessions(t, proxy, 0)
+	})
+
+	t.Run("MaxSessions", func(t *testing.T) {
+		proxy, addr := startUDP(t, config.UDPListenerConfig{Name: "limited", URI: udpBackend(t, "dns"), MaxSessions: 1}, nil)
+		before := testutil.ToFloat64(monitoring.UDPSessionsTotal.WithLabelValues("limited", "session_limit"))
+
+		a := dialUDP(t, addr)
+		exchange(t, a, "a1")
+		dialUDP(t, addr).Write([]byte("b1"))
+		deadline := time.Now().Add(2 * time.Second)
+		for testutil.ToFloat64(monitoring.UDPSessionsTotal.WithLabelValues("limited", "session_limit")) != before+1 {
+			if time.Now().After(deadline) {
+				t.Fatal("Expected session_limit to be counted")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		waitSessions(t, proxy, 1)
+
+		// The existing session keeps working
+		if reply := exchange(t, a, "a2"); reply[2] != "a2" {
+			t.Errorf("Expected reply of the existing session, got %q", reply)
+		}
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		proxy, err := NewUDPProxy(config.UDPListenerConfig{Name: "shutdown", URI: udpBackend(t, "dns")}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := make(chan error, 1)
+		go func() { served <- proxy.Serve(conn) }()
+		exchange(t, dialUDP(t, conn.LocalAddr().String()), "x")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := proxy.Shutdown(ctx); err != nil {
+			t.Errorf("Expected sessions to end, got %v", err)
+		}
+		if err := <-served; !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected ErrClosed, got %v", err)
+		}
+		waitSessions(t, proxy, 0)
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		invalid := []config.UDPListenerConfig{
+			{Name: "none"},
+			{Name: "scheme", URI: "tcp://10.0.0.1:53"},
+			{Name: "port", URI: "udp://10.0.0.1"},
+			{Name: "idle", URI: "lb://dns", IdleTimeout: -time.Second},
+			{Name: "sessions", URI: "lb://dns", MaxSessions: -1},
+		}
+		for _, cfg := range invalid {
+			if _, err := NewUDPProxy(cfg, nil); err == nil {
+				t.Errorf("Expected listener %s to be rejected", cfg.Name)
+			}
+		}
+	})
+}
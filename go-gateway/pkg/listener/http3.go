@@ -0,0 +1,29 @@
+package listener
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// NewHTTP3Server creates the HTTP/3 server of a TLS listener. It listens on
+// the same port over UDP and shares the certificates of tlsConfig.
+func NewHTTP3Server(port int, tlsConfig *tls.Config, handler http.Handler) *http3.Server {
+	return &http3.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig.Clone()),
+	}
+}
+
+// AdvertiseHTTP3 announces server in the Alt-Svc header of the responses of
+// handler, so that clients switch to HTTP/3 for later requests
+func AdvertiseHTTP3(server *http3.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fails only while the server is not listening; nothing is announced then
+		server.SetQUICHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,91 @@
+package listener
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// selfSigned creates a certificate for 127.0.0.1 and a pool trusting it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+// TestHTTP3 tests serving HTTP/3 and advertising it over HTTPS
+func TestHTTP3(t *testing.T) {
+	cert, roots := selfSigned(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	h3 := NewHTTP3Server(port, tlsConfig, handler)
+	go h3.Serve(conn)
+	defer h3.Close()
+
+	t.Run("Request", func(t *testing.T) {
+		transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}
+		defer transport.Close()
+		client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+		resp, err := client.Get(fmt.Sprintf("https://127.0.0.1:%d/", port))
+		if err != nil {
+			t.Fatalf("HTTP/3 request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if body, _ := io.ReadAll(resp.Body); string(body) != "HTTP/3.0" {
+			t.Errorf("Expected request over HTTP/3, got %s", body)
+		}
+	})
+
+	t.Run("AltSvc", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(AdvertiseHTTP3(h3, handler))
+		server.TLS = tlsConfig
+		server.StartTLS()
+		defer server.Close()
+
+		resp, err := server.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if expected := fmt.Sprintf(`h3=":%d"; ma=2592000`, port); resp.Header.Get("Alt-Svc") != expected {
+			t.Errorf("Expected Alt-Svc %q, got %q", expected, resp.Header.Get("Alt-Svc"))
+		}
+	})
+}
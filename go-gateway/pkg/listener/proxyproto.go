@@ -0,0 +1,341 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/config"
+	"go-gateway/pkg/ipfilter"
+	"go-gateway/pkg/monitoring"
+)
+
+// proxyHeaderTimeout bounds the time a trusted peer has to send its header
+const proxyHeaderTimeout = 10 * time.Second
+
+// maxV1HeaderLength is the longest version 1 header, including CRLF
+const maxV1HeaderLength = 107
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ErrNoProxyHeader is returned for connections of trusted peers not starting
+// with a PROXY protocol header
+var ErrNoProxyHeader = errors.New("missing PROXY protocol header")
+
+// ProxyHeader is a PROXY protocol header. Source and Destination are nil when
+// the sender does not know them, e.g. for the health checks of a load
+// balancer; the connection's own addresses apply then.
+type ProxyHeader struct {
+	Version     int
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// ReadProxyHeader reads a version 1 or 2 PROXY protocol header from r
+func ReadProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	prefix, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+	if !bytes.HasPrefix(proxyV2Signature, prefix) {
+		return nil, ErrNoProxyHeader
+	}
+	if signature, err := r.Peek(len(proxyV2Signature)); err != nil {
+		return nil, err
+	} else if !bytes.Equal(signature, proxyV2Signature) {
+		return nil, ErrNoProxyHeader
+	}
+	return readProxyV2(r)
+}
+
+// readProxyV1 reads a header such as "PROXY TCP4 192.0.2.1 192.0.2.2 5000 80\r\n"
+func readProxyV1(r *bufio.Reader) (*ProxyHeader, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	if len(line) > maxV1HeaderLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("invalid PROXY protocol v1 header: line too long or not terminated by CRLF")
+	}
+
+	fields := strings.Split(string(line[len(proxyV1Prefix):len(line)-2]), " ")
+	header := &ProxyHeader{Version: 1}
+	switch fields[0] {
+	case "UNKNOWN":
+		return header, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header: unknown protocol %q", fields[0])
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header: expected 5 fields, got %d", len(fields))
+	}
+
+	var addrs [2]*net.TCPAddr
+	for i := range addrs {
+		ip := net.ParseIP(fields[1+i])
+		if ip == nil || (ip.To4() != nil) != (fields[0] == "TCP4") {
+			return nil, fmt.Errorf("invalid PROXY protocol v1 header: invalid %s address %q", fields[0], fields[1+i])
+		}
+		port, err := strconv.ParseUint(fields[3+i], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROXY protocol v1 header: invalid port %q", fields[3+i])
+		}
+		addrs[i] = &net.TCPAddr{IP: ip, Port: int(port)}
+	}
+	header.Source, header.Destination = addrs[0], addrs[1]
+	return header, nil
+}
+
+// readProxyV2 reads a binary header
+func readProxyV2(r *bufio.Reader) (*ProxyHeader, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: version %d", fixed[12]>>4)
+	}
+	command, family := fixed[12]&0x0f, fixed[13]
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &ProxyHeader{Version: 2}
+	switch command {
+	case 0x0:
+		// LOCAL: the connection is the peer's own
+		return header, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: command %d", command)
+	}
+
+	var size int
+	switch family >> 4 {
+	case 0x1:
+		size = net.IPv4len
+	case 0x2:
+		size = net.IPv6len
+	default:
+		// Unspecified or unix addresses: keep the connection's own
+		return header, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, errors.New("invalid PROXY protocol v2 header: address block too short")
+	}
+	srcIP, dstIP := net.IP(payload[:size]), net.IP(payload[size:2*size])
+	srcPort := int(binary.BigEndian.Uint16(payload[2*size:]))
+	dstPort := int(binary.BigEndian.Uint16(payload[2*size+2:]))
+	switch family & 0x0f {
+	case 0x1:
+		header.Source = &net.TCPAddr{IP: srcIP, Port: srcPort}
+		header.Destination = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	case 0x2:
+		header.Source = &net.UDPAddr{IP: srcIP, Port: srcPort}
+		header.Destination = &net.UDPAddr{IP: dstIP, Port: dstPort}
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: transport %d", family&0x0f)
+	}
+	return header, nil
+}
+
+// Format encodes the header in PROXY protocol version 1 or 2. Headers
+// without TCP addresses of one family are sent as UNKNOWN.
+func (h *ProxyHeader) Format(version int) ([]byte, error) {
+	src, srcOK := h.Source.(*net.TCPAddr)
+	dst, dstOK := h.Destination.(*net.TCPAddr)
+	known := srcOK && dstOK && (src.IP.To4() != nil) == (dst.IP.To4() != nil)
+
+	switch version {
+	case 1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		protocol := "TCP6"
+		if src.IP.To4() != nil {
+			protocol = "TCP4"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", protocol, src.IP, dst.IP, src.Port, dst.Port), nil
+	case 2:
+		buf := append([]byte(nil), proxyV2Signature...)
+		if !known {
+			return append(buf, 0x21, 0x00, 0, 0), nil
+		}
+		srcIP, dstIP, family := src.IP.To4(), dst.IP.To4(), byte(0x11)
+		if srcIP == nil {
+			srcIP, dstIP, family = src.IP.To16(), dst.IP.To16(), 0x21
+		}
+		buf = append(buf, 0x21, family)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(2*len(srcIP)+4))
+		buf = append(buf, srcIP...)
+		buf = append(buf, dstIP...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(src.Port))
+		return binary.BigEndian.AppendUint16(buf, uint16(dst.Port)), nil
+	default:
+		return nil, fmt.Errorf("unknown PROXY protocol version %d", version)
+	}
+}
+
+// ParseProxyProtocolVersion parses a configured version: v1 or v2
+func ParseProxyProtocolVersion(value string) (int, error) {
+	switch value {
+	case "v1":
+		return 1, nil
+	case "v2":
+		return 2, nil
+	default:
+		return 0, fmt.Errorf("unknown PROXY protocol version %q: expected v1 or v2", value)
+	}
+}
+
+// ProxyProtocol reads the PROXY protocol header of connections from trusted
+// peers
+type ProxyProtocol struct {
+	trusted []*net.IPNet
+}
+
+// NewProxyProtocol creates the PROXY protocol support of a listener
+func NewProxyProtocol(cfg config.ProxyProtocolConfig) (*ProxyProtocol, error) {
+	trusted, err := ipfilter.ParseCIDRs(cfg.Trusted)
+	if err != nil {
+		return nil, fmt.Errorf("proxy_protocol: %w", err)
+	}
+	if len(trusted) == 0 {
+		return nil, errors.New("proxy_protocol: trusted sources are required")
+	}
+	return &ProxyProtocol{trusted: trusted}, nil
+}
+
+// Listen wraps ln so that the connections of trusted peers report the
+// addresses of their header. Their header is read on first use of the
+// connection, outside of the accept loop.
+func (pp *ProxyProtocol) Listen(ln net.Listener) net.Listener {
+	return &proxyListener{Listener: ln, pp: pp}
+}
+
+func (pp *ProxyProtocol) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range pp.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+type proxyListener struct {
+	net.Listener
+	pp *ProxyProtocol
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || !l.pp.isTrusted(conn.RemoteAddr()) {
+		return conn, err
+	}
+	return &ProxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// ProxyConn is a connection starting with a PROXY protocol header. It reports
+// the client addresses of the header as its own. A missing or invalid header
+// fails all reads.
+type ProxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once   sync.Once
+	header *ProxyHeader
+	err    error
+
+	mutex        sync.Mutex
+	readDeadline time.Time
+}
+
+// ProxyHeader returns the header of the connection, reading it if needed
+func (c *ProxyConn) ProxyHeader() (*ProxyHeader, error) {
+	c.once.Do(func() {
+		c.mutex.Lock()
+		deadline := c.readDeadline
+		c.mutex.Unlock()
+		if limit := time.Now().Add(proxyHeaderTimeout); deadline.IsZero() || limit.Before(deadline) {
+			c.Conn.SetReadDeadline(limit)
+		}
+
+		c.header, c.err = ReadProxyHeader(c.reader)
+
+		c.mutex.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.mutex.Unlock()
+		if c.err != nil {
+			log.Printf("PROXY protocol from %s: %v", c.Conn.RemoteAddr(), c.err)
+			monitoring.ErrorTotal.WithLabelValues("invalid_proxy_protocol", "").Inc()
+		}
+	})
+	return c.header, c.err
+}
+
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	if _, err := c.ProxyHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address of the header
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if header, _ := c.ProxyHeader(); header != nil && header.Source != nil {
+		return header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to according to the
+// header
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if header, _ := c.ProxyHeader(); header != nil && header.Destination != nil {
+		return header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *ProxyConn) SetDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *ProxyConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// CloseWrite closes the write half of the underlying connection
+func (c *ProxyConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
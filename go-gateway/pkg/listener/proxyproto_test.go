@@ -0,0 +1,254 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/config"
+)
+
+func v2Header(command, family byte, addresses ...byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, byte(len(addresses)))
+	return append(header, addresses...)
+}
+
+// TestReadProxyHeader tests parsing version 1 and 2 headers
+func TestReadProxyHeader(t *testing.T) {
+	ipv6 := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	valid := []struct {
+		name        string
+		header      []byte
+		source      string
+		destination string
+	}{
+		{"V1TCP4", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 5000 80\r\n"), "192.0.2.1:5000", "192.0.2.2:80"},
+		{"V1TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 5000 443\r\n"), "[2001:db8::1]:5000", "[2001:db8::2]:443"},
+		{"V1Unknown", []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"), "", ""},
+		{"V2TCP4", v2Header(1, 0x11, 192, 0, 2, 1, 192, 0, 2, 2, 0x13, 0x88, 0, 80), "192.0.2.1:5000", "192.0.2.2:80"},
+		{"V2TCP6", v2Header(1, 0x21, append(ipv6, 0x13, 0x88, 0x01, 0xbb)...), "[2001:db8::1]:5000", "[2001:db8::2]:443"},
+		{"V2UDP4", v2Header(1, 0x12, 192, 0, 2, 1, 192, 0, 2, 2, 0x13, 0x88, 0, 53), "192.0.2.1:5000", "192.0.2.2:53"},
+		// TLVs after the addresses are skipped
+		{"V2TLV", v2Header(1, 0x11, 192, 0, 2, 1, 192, 0, 2, 2, 0x13, 0x88, 0, 80, 0x04, 0, 1, 0), "192.0.2.1:5000", "192.0.2.2:80"},
+		{"V2Local", v2Header(0, 0x00), "", ""},
+		{"V2Unspec", v2Header(1, 0x00), "", ""},
+	}
+	for _, tt := range valid {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(tt.header), strings.NewReader("GET / HTTP/1.1")))
+			header, err := ReadProxyHeader(r)
+			if err != nil {
+				t.Fatalf("Failed to read header: %v", err)
+			}
+			if header.Source == nil {
+				if tt.source != "" {
+					t.Errorf("Expected source %s, got none", tt.source)
+				}
+			} else if header.Source.String() != tt.source || header.Destination.String() != tt.destination {
+				t.Errorf("Expected %s -> %s, got %s -> %s", tt.source, tt.destination, header.Source, header.Destination)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "GET / HTTP/1.1" {
+				t.Errorf("Expected the data after the header to be kept, got %q", rest)
+			}
+		})
+	}
+
+	invalid := map[string][]byte{
+		"NoHeader":       []byte("GET / HTTP/1.1\r\n\r\n"),
+		"V1BadAddress":   []byte("PROXY TCP4 192.0.2.1 example.com 5000 80\r\n"),
+		"V1FamilyClash":  []byte("PROXY TCP4 2001:db8::1 192.0.2.2 5000 80\r\n"),
+		"V1BadPort":      []byte("PROXY TCP4 192.0.2.1 192.0.2.2 70000 80\r\n"),
+		"V1MissingField": []byte("PROXY TCP4 192.0.2.1 192.0.2.2 5000\r\n"),
+		"V1NoCRLF":       []byte("PROXY TCP4 192.0.2.1 192.0.2.2 5000 80\n"),
+		"V1TooLong":      []byte("PROXY UNKNOWN " + strings.Repeat("x", 100) + "\r\n"),
+		"V2BadVersion":   append(append([]byte(nil), proxyV2Signature...), 0x11, 0x11, 0, 0),
+		"V2ShortAddress": v2Header(1, 0x11, 192, 0, 2, 1),
+		"V2Truncated":    v2Header(1, 0x11, 192, 0, 2, 1)[:18],
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if header, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(data))); err == nil {
+				t.Errorf("Expected error, got %+v", header)
+			}
+		})
+	}
+}
+
+// TestFormatProxyHeader tests that formatted headers read back
+func TestFormatProxyHeader(t *testing.T) {
+	headers := []*ProxyHeader{
+		{Source: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}, Destination: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 80}},
+		{Source: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}, Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}},
+		{Source: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}, Destination: &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}},
+		{},
+	}
+	for _, version := range []int{1, 2} {
+		for _, h := range headers {
+			data, err := h.Format(version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(data)))
+			if err != nil {
+				t.Fatalf("v%d: failed to read %q: %v", version, data, err)
+			}
+			known := h.Source != nil && (h.Source.(*net.TCPAddr).IP.To4() != nil) == (h.Destination.(*net.TCPAddr).IP.To4() != nil)
+			if known && (got.Source.String() != h.Source.String() || got.Destination.String() != h.Destination.String()) {
+				t.Errorf("v%d: expected %s -> %s, got %s -> %s", version, h.Source, h.Destination, got.Source, got.Destination)
+			}
+			if !known && got.Source != nil {
+				t.Errorf("v%d: expected unknown addresses, got %s", version, got.Source)
+			}
+		}
+	}
+	if _, err := (&ProxyHeader{}).Format(3); err == nil {
+		t.Error("Expected unknown version to be rejected")
+	}
+}
+
+// listenProxy serves accepted connections of a PROXY protocol listener on
+// a channel
+func listenProxy(t *testing.T, trusted ...string) (string, <-chan net.Conn) {
+	t.Helper()
+	pp, err := NewProxyProtocol(config.ProxyProtocolConfig{Trusted: trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = pp.Listen(ln)
+	t.Cleanup(func() { ln.Close() })
+	conns := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return ln.Addr().String(), conns
+}
+
+func send(t *testing.T, addr string, data string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.Write([]byte(data))
+}
+
+// TestProxyProtocolListener tests that only trusted peers set the address
+func TestProxyProtocolListener(t *testing.T) {
+	if _, err := NewProxyProtocol(config.ProxyProtocolConfig{}); err == nil {
+		t.Error("Expected trusted sources to be required")
+	}
+	if _, err := NewProxyProtocol(config.ProxyProtocolConfig{Trusted: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("Expected invalid CIDR to be rejected")
+	}
+
+	t.Run("Trusted", func(t *testing.T) {
+		addr, conns := listenProxy(t, "127.0.0.0/8")
+		send(t, addr, "PROXY TCP4 192.0.2.1 192.0.2.2 5000 80\r\nhello")
+		conn := <-conns
+		defer conn.Close()
+		if got := conn.RemoteAddr().String(); got != "192.0.2.1:5000" {
+			t.Errorf("Expected client address from header, got %s", got)
+		}
+		if got := conn.LocalAddr().String(); got != "192.0.2.2:80" {
+			t.Errorf("Expected destination address from header, got %s", got)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Errorf("Expected data after the header, got %q %v", buf, err)
+		}
+	})
+
+	t.Run("Untrusted", func(t *testing.T) {
+		addr, conns := listenProxy(t, "10.0.0.0/8")
+		send(t, addr, "PROXY TCP4 192.0.2.1 192.0.2.2 5000 80\r\n")
+		conn := <-conns
+		defer conn.Close()
+		if _, ok := conn.(*ProxyConn); ok {
+			t.Fatal("Expected untrusted peer to be taken as is")
+		}
+		if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+			t.Errorf("Expected the peer address, got %s", conn.RemoteAddr())
+		}
+	})
+
+	t.Run("MissingHeader", func(t *testing.T) {
+		addr, conns := listenProxy(t, "127.0.0.1")
+		send(t, addr, "GET / HTTP/1.1\r\n\r\n")
+		conn := <-conns
+		defer conn.Close()
+		if _, err := conn.Read(make([]byte, 16)); !errors.Is(err, ErrNoProxyHeader) {
+			t.Errorf("Expected ErrNoProxyHeader, got %v", err)
+		}
+		if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+			t.Errorf("Expected the peer address, got %s", conn.RemoteAddr())
+		}
+	})
+
+	t.Run("HeaderTimeoutKeepsDeadline", func(t *testing.T) {
+		addr, conns := listenProxy(t, "127.0.0.1")
+		send(t, addr, "")
+		conn := <-conns
+		defer conn.Close()
+		// A deadline set before the header arrives still applies
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		start := time.Now()
+		var ne net.Error
+		if _, err := conn.Read(make([]byte, 1)); !errors.As(err, &ne) || !ne.Timeout() {
+			t.Errorf("Expected timeout, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the caller's deadline, took %v", elapsed)
+		}
+	})
+
+	t.Run("HTTP", func(t *testing.T) {
+		pp, _ := NewProxyProtocol(config.ProxyProtocolConfig{Trusted: []string{"127.0.0.1"}})
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.RemoteAddr)
+		})}
+		go server.Serve(pp.Listen(ln))
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		header, _ := (&ProxyHeader{
+			Source:      &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000},
+			Destination: &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 80},
+		}).Format(2)
+		conn.Write(append(header, "GET / HTTP/1.1\r\nHost: gateway\r\nConnection: close\r\n\r\n"...))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "203.0.113.7:40000" {
+			t.Errorf("Expected request from the client address, got %s", body)
+		}
+	})
+}
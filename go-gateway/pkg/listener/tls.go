@@ -0,0 +1,71 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"go-gateway/pkg/config"
+)
+
+// NewServerTLSConfig builds the server side TLS configuration of a listener
+func NewServerTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.ClientCAFile != "" {
+		pemData, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("client CA file %s contains no certificates", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	}
+
+	clientAuth, err := parseClientAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientAuth = clientAuth
+
+	return tlsConfig, nil
+}
+
+// parseClientAuth maps the configured client auth mode to its tls constant
+func parseClientAuth(cfg config.TLSConfig) (tls.ClientAuthType, error) {
+	mode := cfg.ClientAuth
+	if mode == "" {
+		if cfg.ClientCAFile == "" {
+			return tls.NoClientCert, nil
+		}
+		mode = "verify_if_given"
+	}
+
+	switch mode {
+	case "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "verify_if_given", "require":
+		if cfg.ClientCAFile == "" {
+			return 0, fmt.Errorf("client_auth %s requires client_ca_file", mode)
+		}
+		if mode == "require" {
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return 0, fmt.Errorf("unknown client_auth %q", mode)
+	}
+}
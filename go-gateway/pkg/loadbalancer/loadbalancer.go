@@ -1,7 +1,12 @@
 package loadbalancer
 
 import (
+	"fmt"
+	"hash/crc32"
 	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -322,3 +327,142 @@ func (wrr *WeightedRoundRobinBalancer) ChooseServer(servers []Server) *Server {
 	wrr.currentIndex = (wrr.currentIndex + 1) % totalWeight
 	return server
 }
+
+// KeyedBalancer is a load balancer that can choose by a key, so that the same
+// key keeps going to the same server
+type KeyedBalancer interface {
+	LoadBalancer
+	ChooseServerFor(key string, servers []Server) *Server
+}
+
+// hashReplicas is the number of points of a server of weight 1 on the ring
+const hashReplicas = 100
+
+// ConsistentHashBalancer consistent hash load balancer. Keys are mapped onto
+// a ring of server points, so that servers leaving or joining only move the
+// keys of their own share of the ring.
+type ConsistentHashBalancer struct {
+	mutex        sync.RWMutex
+	servers      []Server
+	currentIndex int
+
+	// ring is cached for the server set it was built from
+	ring        []ringPoint
+	ringServers string
+}
+
+// ringPoint is a point of a server on the hash ring
+type ringPoint struct {
+	hash   uint32
+	server Server
+}
+
+// NewConsistentHashBalancer creates a new consistent hash load balancer
+func NewConsistentHashBalancer() *ConsistentHashBalancer {
+	return &ConsistentHashBalancer{
+		servers: make([]Server, 0),
+	}
+}
+
+// AddServer adds a server
+func (ch *ConsistentHashBalancer) AddServer(server Server) {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	ch.servers = append(ch.servers, server)
+}
+
+// RemoveServer removes a server
+func (ch *ConsistentHashBalancer) RemoveServer(url string) {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	for i, server := range ch.servers {
+		if server.URL == url {
+			ch.servers = append(ch.servers[:i], ch.servers[i+1:]...)
+			break
+		}
+	}
+}
+
+// UpdateServer updates a server
+func (ch *ConsistentHashBalancer) UpdateServer(server Server) {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	for i, s := range ch.servers {
+		if s.URL == server.URL {
+			ch.servers[i] = server
+			break
+		}
+	}
+}
+
+// GetServers gets all servers
+func (ch *ConsistentHashBalancer) GetServers() []Server {
+	ch.mutex.RLock()
+	defer ch.mutex.RUnlock()
+
+	result := make([]Server, len(ch.servers))
+	copy(result, ch.servers)
+	return result
+}
+
+// ChooseServer chooses a server without a key, in round-robin order
+func (ch *ConsistentHashBalancer) ChooseServer(servers []Server) *Server {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if len(servers) == 0 {
+		return nil
+	}
+	server := servers[ch.currentIndex%len(servers)]
+	ch.currentIndex++
+	return &server
+}
+
+// ChooseServerFor chooses the server of key on the ring. Servers get points in
+// proportion to their weight, a weight of 0 counting as 1.
+func (ch *ConsistentHashBalancer) ChooseServerFor(key string, servers []Server) *Server {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+
+	if len(servers) == 0 {
+		return nil
+	}
+
+	var signature strings.Builder
+	for _, server := range servers {
+		fmt.Fprintf(&signature, "%s=%d,", server.URL, server.Weight)
+	}
+	if ch.ring == nil || ch.ringServers != signature.String() {
+		ch.ring = buildRing(servers)
+		ch.ringServers = signature.String()
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i].hash >= hash })
+	if i == len(ch.ring) {
+		i = 0
+	}
+	server := ch.ring[i].server
+	return &server
+}
+
+// buildRing places the points of servers on a hash ring
+func buildRing(servers []Server) []ringPoint {
+	ring := make([]ringPoint, 0, len(servers)*hashReplicas)
+	seen := make(map[string]bool)
+	for _, server := range servers {
+		if seen[server.URL] {
+			continue
+		}
+		seen[server.URL] = true
+		points := hashReplicas * max(server.Weight, 1)
+		for i := 0; i < points; i++ {
+			hash := crc32.ChecksumIEEE([]byte(server.URL + "#" + strconv.Itoa(i)))
+			ring = append(ring, ringPoint{hash: hash, server: server})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
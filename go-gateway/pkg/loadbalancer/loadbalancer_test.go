@@ -1,7 +1,16 @@
 package loadbalancer
 
 import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/monitoring"
 )
 
 // TestRoundRobinLoadBalancer 测试轮询负载均衡器
@@ -229,3 +238,192 @@ func TestHealthAwareLoadBalancer(t *testing.T) {
 		}
 	})
 }
+
+// TestConsistentHashLoadBalancer 测试一致性哈希负载均衡器
+func TestConsistentHashLoadBalancer(t *testing.T) {
+	servers := []Server{
+		{URL: "udp://10.0.0.1:53"},
+		{URL: "udp://10.0.0.2:53"},
+		{URL: "udp://10.0.0.3:53"},
+	}
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("192.168.%d.%d", i/250, i%250)
+	}
+
+	t.Run("TestSameKeySameServer", func(t *testing.T) {
+		lb := NewConsistentHashBalancer()
+		counts := make(map[string]int)
+		for _, key := range keys {
+			first := lb.ChooseServerFor(key, servers)
+			if again := lb.ChooseServerFor(key, servers); again.URL != first.URL {
+				t.Fatalf("Key %s moved from %s to %s", key, first.URL, again.URL)
+			}
+			counts[first.URL]++
+		}
+		// 每台服务器都应分到相当份额的键
+		for _, server := range servers {
+			if counts[server.URL] < 200 {
+				t.Errorf("Server %s got only %d of %d keys", server.URL, counts[server.URL], len(keys))
+			}
+		}
+	})
+
+	t.Run("TestServerRemoved", func(t *testing.T) {
+		lb := NewConsistentHashBalancer()
+		before := make(map[string]string)
+		for _, key := range keys {
+			before[key] = lb.ChooseServerFor(key, servers).URL
+		}
+		// 移除一台服务器只会移动它自己的键
+		for _, key := range keys {
+			after := lb.ChooseServerFor(key, servers[1:]).URL
+			if before[key] != servers[0].URL && after != before[key] {
+				t.Fatalf("Key %s moved from %s to %s", key, before[key], after)
+			}
+		}
+	})
+
+	t.Run("TestWithoutKey", func(t *testing.T) {
+		lb := NewConsistentHashBalancer()
+		if lb.ChooseServerFor("key", nil) != nil {
+			t.Error("Expected nil for empty server list")
+		}
+		for i := 0; i < 6; i++ {
+			if server := lb.ChooseServer(servers); server.URL != servers[i%3].URL {
+				t.Errorf("Expected round-robin order, got %s", server.URL)
+			}
+		}
+	})
+
+	t.Run("TestPoolChooseFor", func(t *testing.T) {
+		pool := NewPool("dns", NewConsistentHashBalancer(), servers, nil)
+		first := pool.ChooseFor("192.168.1.1")
+		for i := 0; i < 3; i++ {
+			if server := pool.ChooseFor("192.168.1.1"); server.URL != first.URL {
+				t.Errorf("Expected %s, got %s", first.URL, server.URL)
+			}
+		}
+		// 其他负载均衡器忽略键
+		if NewPool("web", NewRoundRobinBalancer(), servers, nil).ChooseFor("192.168.1.1") == nil {
+			t.Error("Expected a server")
+		}
+	})
+}
+
+// TestPool 测试服务池的健康检查
+func TestPool(t *testing.T) {
+	t.Run("TestNewBalancer", func(t *testing.T) {
+		for _, strategy := range []string{"", "round_robin", "random", "weighted_round_robin", "consistent_hash"} {
+			if _, err := NewBalancer(strategy); err != nil {
+				t.Errorf("Strategy %q: %v", strategy, err)
+			}
+		}
+		if _, err := NewBalancer("least_loaded"); err == nil {
+			t.Error("Expected unknown strategy to be rejected")
+		}
+	})
+
+	t.Run("TestHealthChecks", func(t *testing.T) {
+		healthy := true
+		var mutex sync.Mutex
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if r.URL.Path != "/healthz" || !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		defer backend.Close()
+
+		// 未监听的端口，TCP检查失败
+		down, _ := net.Listen("tcp", "127.0.0.1:0")
+		downURL := "tcp://" + down.Addr().String()
+		down.Close()
+
+		pool := NewPool("test", NewRoundRobinBalancer(), []Server{{URL: backend.URL}, {URL: downURL}}, &HealthCheck{
+			Interval: 10 * time.Millisecond, Timeout: time.Second, Path: "/healthz", HealthyThreshold: 2, UnhealthyThreshold: 1,
+		})
+		if len(pool.Servers()) != 2 {
+			t.Fatalf("Expected servers to start healthy, got %v", pool.Servers())
+		}
+		pool.Start()
+		defer pool.Close()
+
+		waitServers := func(expected int) {
+			t.Helper()
+			deadline := time.Now().Add(2 * time.Second)
+			for len(pool.Servers()) != expected {
+				if time.Now().After(deadline) {
+					t.Fatalf("Expected %d healthy servers, got %v", expected, pool.Servers())
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+		waitServers(1)
+		for i := 0; i < 3; i++ {
+			if server := pool.Choose(); server == nil || server.URL != backend.URL {
+				t.Errorf("Expected the healthy server, got %v", server)
+			}
+		}
+		if testutil.ToFloat64(monitoring.BackendHealthy.WithLabelValues("test", downURL)) != 0 {
+			t.Error("Expected the down server to be reported unhealthy")
+		}
+
+		mutex.Lock()
+		healthy = false
+		mutex.Unlock()
+		waitServers(0)
+		if server := pool.Choose(); server != nil {
+			t.Errorf("Expected no server, got %v", server)
+		}
+
+		mutex.Lock()
+		healthy = true
+		mutex.Unlock()
+		waitServers(1)
+	})
+
+	t.Run("TestInheritHealth", func(t *testing.T) {
+		check := &HealthCheck{Interval: time.Hour, HealthyThreshold: 2, UnhealthyThreshold: 1}
+		previous := NewPool("inherit", NewRoundRobinBalancer(), []Server{{URL: "tcp://a:1"}, {URL: "tcp://b:1"}}, check)
+		previous.record("tcp://b:1", false)
+
+		// The rebuilt pool keeps b out until it passes its checks again
+		pool := NewPool("inherit", NewRoundRobinBalancer(), []Server{{URL: "tcp://a:1"}, {URL: "tcp://b:1"}, {URL: "tcp://c:1"}}, check)
+		pool.InheritHealth(previous)
+		if servers := pool.Servers(); len(servers) != 2 || servers[0].URL != "tcp://a:1" || servers[1].URL != "tcp://c:1" {
+			t.Errorf("Expected a and c, got %v", servers)
+		}
+		pool.record("tcp://b:1", true)
+		if len(pool.Servers()) != 2 {
+			t.Error("Expected b to need two passed checks")
+		}
+		pool.record("tcp://b:1", true)
+		if len(pool.Servers()) != 3 {
+			t.Error("Expected b to be back after two passed checks")
+		}
+
+		unchecked := NewPool("inherit", NewRoundRobinBalancer(), []Server{{URL: "tcp://a:1"}, {URL: "tcp://b:1"}}, nil)
+		unchecked.InheritHealth(previous)
+		if len(unchecked.Servers()) != 2 {
+			t.Error("Expected a pool without health checks to keep every server")
+		}
+	})
+
+	t.Run("TestChooseForExcept", func(t *testing.T) {
+		servers := []Server{{URL: "tcp://a:1"}, {URL: "tcp://b:1"}, {URL: "tcp://c:1"}}
+		pool := NewPool("except", NewConsistentHashBalancer(), servers, nil)
+		tried := make(map[string]bool)
+		for range servers {
+			server := pool.ChooseForExcept("10.0.0.1", tried)
+			if server == nil || tried[server.URL] {
+				t.Fatalf("Expected a server not tried yet, got %v", server)
+			}
+			tried[server.URL] = true
+		}
+		if server := pool.ChooseForExcept("10.0.0.1", tried); server != nil {
+			t.Errorf("Expected no server once all were tried, got %v", server)
+		}
+	})
+}
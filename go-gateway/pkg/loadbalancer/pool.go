@@ -0,0 +1,276 @@
+package loadbalancer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/monitoring"
+)
+
+// NewBalancer creates the load balancer of a strategy: round_robin (the
+// default), random, weighted_round_robin or consistent_hash
+func NewBalancer(strategy string) (LoadBalancer, error) {
+	switch strategy {
+	case "", "round_robin":
+		return NewRoundRobinBalancer(), nil
+	case "random":
+		return NewRandomBalancer(), nil
+	case "weighted_round_robin":
+		return NewWeightedRoundRobinBalancer(), nil
+	case "consistent_hash":
+		return NewConsistentHashBalancer(), nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", strategy)
+	}
+}
+
+// HealthCheck configures the active health checks of a pool
+type HealthCheck struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	// Path makes the check an HTTP GET of http(s) servers; any status below
+	// 400 is healthy. Without it the check opens a TCP connection.
+	Path               string
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// serverHealth is the health state of one server
+type serverHealth struct {
+	healthy   bool
+	successes int
+	failures  int
+}
+
+// Pool is a named set of servers. Servers failing their health checks are
+// left out when choosing until they pass again.
+type Pool struct {
+	Name     string
+	balancer LoadBalancer
+	check    *HealthCheck
+	client   *http.Client
+
+	mutex  sync.RWMutex
+	health map[string]*serverHealth
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+// NewPool creates a pool of servers balanced by balancer. Servers start
+// healthy. check may be nil to disable health checks.
+func NewPool(name string, balancer LoadBalancer, servers []Server, check *HealthCheck) *Pool {
+	p := &Pool{
+		Name:     name,
+		balancer: balancer,
+		health:   make(map[string]*serverHealth),
+		stop:     make(chan struct{}),
+	}
+	for _, server := range servers {
+		balancer.AddServer(server)
+		p.health[server.URL] = &serverHealth{healthy: true}
+		monitoring.BackendHealthy.WithLabelValues(name, server.URL).Set(1)
+	}
+
+	if check != nil {
+		c := *check
+		if c.Interval <= 0 {
+			c.Interval = 10 * time.Second
+		}
+		if c.Timeout <= 0 {
+			c.Timeout = 2 * time.Second
+		}
+		if c.HealthyThreshold <= 0 {
+			c.HealthyThreshold = 1
+		}
+		if c.UnhealthyThreshold <= 0 {
+			c.UnhealthyThreshold = 1
+		}
+		p.check = &c
+		p.client = &http.Client{
+			Timeout: c.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return p
+}
+
+// Servers returns the healthy servers of the pool
+func (p *Pool) Servers() []Server {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	servers := p.balancer.GetServers()
+	healthy := servers[:0]
+	for _, server := range servers {
+		if state := p.health[server.URL]; state == nil || state.healthy {
+			healthy = append(healthy, server)
+		}
+	}
+	return healthy
+}
+
+// Choose chooses a healthy server, or returns nil when there is none
+func (p *Pool) Choose() *Server {
+	return p.balancer.ChooseServer(p.Servers())
+}
+
+// ChooseFor chooses a healthy server for key, e.g. a client address. With a
+// KeyedBalancer the same key keeps going to the same server while it stays
+// healthy; other balancers ignore the key.
+func (p *Pool) ChooseFor(key string) *Server {
+	return p.ChooseForExcept(key, nil)
+}
+
+// ChooseForExcept chooses like ChooseFor among the healthy servers whose URL
+// is not in excluded, e.g. the servers a connection already failed to reach
+func (p *Pool) ChooseForExcept(key string, excluded map[string]bool) *Server {
+	servers := p.Servers()
+	if len(excluded) > 0 {
+		remaining := make([]Server, 0, len(servers))
+		for _, server := range servers {
+			if !excluded[server.URL] {
+				remaining = append(remaining, server)
+			}
+		}
+		servers = remaining
+	}
+	if keyed, ok := p.balancer.(KeyedBalancer); ok {
+		return keyed.ChooseServerFor(key, servers)
+	}
+	return p.balancer.ChooseServer(servers)
+}
+
+// InheritHealth takes over the health state of the servers previous also
+// has, so that a pool rebuilt on a reload does not send traffic to servers
+// known to be down. It must be called before Start. Without health checks
+// the servers stay healthy, since nothing would bring them back.
+func (p *Pool) InheritHealth(previous *Pool) {
+	if p.check == nil || previous == nil {
+		return
+	}
+	previous.mutex.RLock()
+	defer previous.mutex.RUnlock()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for serverURL, state := range p.health {
+		old, ok := previous.health[serverURL]
+		if !ok {
+			continue
+		}
+		*state = *old
+		if !state.healthy {
+			monitoring.BackendHealthy.WithLabelValues(p.Name, serverURL).Set(0)
+		}
+	}
+}
+
+// Start runs the health checks until Close
+func (p *Pool) Start() {
+	if p.check == nil {
+		return
+	}
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(p.check.Interval)
+		defer ticker.Stop()
+		for {
+			p.checkAll()
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the health checks
+func (p *Pool) Close() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.done.Wait()
+}
+
+// checkAll checks every server concurrently
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, server := range p.balancer.GetServers() {
+		wg.Add(1)
+		go func(server Server) {
+			defer wg.Done()
+			p.record(server.URL, p.probe(server.URL))
+		}(server)
+	}
+	wg.Wait()
+}
+
+// probe checks one server
+func (p *Pool) probe(serverURL string) bool {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		// host:port without a scheme
+		u = &url.URL{Host: serverURL}
+	}
+
+	if p.check.Path != "" && (u.Scheme == "http" || u.Scheme == "https") {
+		resp, err := p.client.Get(u.Scheme + "://" + u.Host + p.check.Path)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode < 400
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, p.check.Timeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// record applies a check result to the state of a server
+func (p *Pool) record(serverURL string, ok bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	state := p.health[serverURL]
+	if state == nil {
+		return
+	}
+	if ok {
+		state.successes++
+		state.failures = 0
+		if !state.healthy && state.successes >= p.check.HealthyThreshold {
+			state.healthy = true
+			log.Printf("Service %s: server %s is healthy", p.Name, serverURL)
+			monitoring.BackendHealthy.WithLabelValues(p.Name, serverURL).Set(1)
+		}
+		return
+	}
+	state.failures++
+	state.successes = 0
+	if state.healthy && state.failures >= p.check.UnhealthyThreshold {
+		state.healthy = false
+		log.Printf("Service %s: server %s is unhealthy", p.Name, serverURL)
+		monitoring.BackendHealthy.WithLabelValues(p.Name, serverURL).Set(0)
+	}
+}
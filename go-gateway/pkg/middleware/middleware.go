@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"net"
 	"net/http"
 
 	"go-gateway/pkg/common"
@@ -30,24 +31,56 @@ func NewMiddlewareChain(handlers []Middleware) *MiddlewareChain {
 
 // Execute executes the middleware chain
 func (mc *MiddlewareChain) Execute(ctx *GatewayContext) {
+	mc.Handle(ctx, nil)
+}
+
+// Handle executes the pre-processing of every middleware, then next, then the
+// post-processing in reverse order. next is skipped when a middleware stops the
+// chain; only middlewares whose PreHandle ran get their PostHandle called.
+// It reports whether next was invoked.
+//
+// If a middleware or next panics (the reverse proxy aborts broken responses
+// with http.ErrAbortHandler), the request is marked aborted, post-processing
+// still runs and the panic is then re-raised.
+func (mc *MiddlewareChain) Handle(ctx *GatewayContext, next func(ctx *GatewayContext)) bool {
+	defer func() {
+		if p := recover(); p != nil {
+			if ctx.Attributes == nil {
+				ctx.Attributes = make(map[string]interface{})
+			}
+			ctx.Attributes[AbortedAttribute] = true
+			mc.postHandle(ctx)
+			panic(p)
+		}
+	}()
+
+	proceed := true
 	for mc.index < len(mc.handlers) {
 		handler := mc.handlers[mc.index]
 		mc.index++
 
 		if !handler.PreHandle(ctx) {
 			// If PreHandle returns false, stop executing subsequent middlewares
+			proceed = false
 			break
 		}
 	}
 
-	// Execute post-processing (in reverse order)
-	// Ensure not accessing negative index
-	startIndex := mc.index - 1
-	if startIndex < 0 {
-		startIndex = 0
+	if proceed && next != nil {
+		next(ctx)
 	}
-	for i := len(mc.handlers) - 1; i >= startIndex; i-- {
-		handler := mc.handlers[i]
+
+	mc.postHandle(ctx)
+
+	return proceed && next != nil
+}
+
+// postHandle executes the post-processing of the middlewares whose PreHandle
+// ran, in reverse order
+func (mc *MiddlewareChain) postHandle(ctx *GatewayContext) {
+	for mc.index > 0 {
+		mc.index--
+		handler := mc.handlers[mc.index]
 		if err := handler.PostHandle(ctx); err != nil {
 			handler.HandleError(ctx, err)
 		}
@@ -85,6 +118,23 @@ func (mc *MiddlewareChain) ExecuteNext(ctx *GatewayContext) bool {
 	return result
 }
 
+// ConsumerAttribute is the GatewayContext attribute naming the consumer the
+// request is made on behalf of. Rate limiting and metrics key on it.
+const ConsumerAttribute = "consumer"
+
+// ClientIPAttribute is the GatewayContext attribute holding the real client IP
+// as resolved from trusted proxy headers
+const ClientIPAttribute = "client_ip"
+
+// AbortedAttribute is the GatewayContext attribute set when the handling of
+// a request was aborted, e.g. because the upstream response broke off
+const AbortedAttribute = "aborted"
+
+// StatusAttribute is the GatewayContext attribute holding the HTTP status
+// reported to metrics when the response status does not tell the outcome,
+// e.g. the equivalent of a gRPC status carried in trailers
+const StatusAttribute = "status"
+
 // GatewayContext defines the gateway request context
 type GatewayContext struct {
 	Request     *http.Request
@@ -96,3 +146,29 @@ type GatewayContext struct {
 	Index       int // Current executing middleware index
 	Handlers    []Middleware
 }
+
+// Consumer returns the consumer of the request, if one was identified
+func (ctx *GatewayContext) Consumer() (string, bool) {
+	consumer, ok := ctx.Attributes[ConsumerAttribute].(string)
+	return consumer, ok && consumer != ""
+}
+
+// Aborted reports whether the handling of the request was aborted and the
+// response is incomplete
+func (ctx *GatewayContext) Aborted() bool {
+	aborted, _ := ctx.Attributes[AbortedAttribute].(bool)
+	return aborted
+}
+
+// ClientIP returns the real client IP of the request, falling back to the
+// address of the direct peer when it was not resolved
+func (ctx *GatewayContext) ClientIP() string {
+	if ip, ok := ctx.Attributes[ClientIPAttribute].(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if err != nil {
+		return ctx.Request.RemoteAddr
+	}
+	return host
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"net/http"
 	"net/http/httptest"
 	"testing"
 )
@@ -141,6 +142,72 @@ func TestMiddlewareChain(t *testing.T) {
 	})
 }
 
+// TestMiddlewareChainHandle 测试中间件链包裹处理函数
+func TestMiddlewareChainHandle(t *testing.T) {
+	newRecorder := func(name string, proceed bool, order *[]string) *testMiddleware {
+		return &testMiddleware{
+			name: name,
+			onPreHandle: func(ctx *GatewayContext) bool {
+				*order = append(*order, "pre-"+name)
+				return proceed
+			},
+			onPostHandle: func(ctx *GatewayContext) error {
+				*order = append(*order, "post-"+name)
+				return nil
+			},
+		}
+	}
+	next := func(order *[]string) func(ctx *GatewayContext) {
+		return func(ctx *GatewayContext) {
+			*order = append(*order, "next")
+		}
+	}
+
+	t.Run("TestNextRunsBetweenPreAndPost", func(t *testing.T) {
+		var order []string
+		chain := NewMiddlewareChain([]Middleware{newRecorder("m1", true, &order), newRecorder("m2", true, &order)})
+		ctx := &GatewayContext{Request: httptest.NewRequest("GET", "http://localhost/test", nil)}
+
+		if !chain.Handle(ctx, next(&order)) {
+			t.Error("Expected next to be invoked")
+		}
+
+		expected := []string{"pre-m1", "pre-m2", "next", "post-m2", "post-m1"}
+		if len(order) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, order)
+		}
+		for i := range expected {
+			if order[i] != expected[i] {
+				t.Errorf("At index %d, expected '%s', got '%s'", i, expected[i], order[i])
+			}
+		}
+	})
+
+	t.Run("TestStoppedChainSkipsNext", func(t *testing.T) {
+		var order []string
+		chain := NewMiddlewareChain([]Middleware{
+			newRecorder("m1", true, &order),
+			newRecorder("m2", false, &order),
+			newRecorder("m3", true, &order),
+		})
+		ctx := &GatewayContext{Request: httptest.NewRequest("GET", "http://localhost/test", nil)}
+
+		if chain.Handle(ctx, next(&order)) {
+			t.Error("Expected next to be skipped")
+		}
+
+		expected := []string{"pre-m1", "pre-m2", "post-m2", "post-m1"}
+		if len(order) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, order)
+		}
+		for i := range expected {
+			if order[i] != expected[i] {
+				t.Errorf("At index %d, expected '%s', got '%s'", i, expected[i], order[i])
+			}
+		}
+	})
+}
+
 // testMiddleware 实现中间件接口的测试中间件
 type testMiddleware struct {
 	name         string
@@ -170,6 +237,68 @@ func (tm *testMiddleware) HandleError(ctx *GatewayContext, err error) {
 	// 测试用的空实现
 }
 
+// TestMiddlewareChainAbort 测试处理过程 panic 时仍执行后置处理器
+func TestMiddlewareChainAbort(t *testing.T) {
+	var post []string
+	handlers := []Middleware{
+		&testMiddleware{name: "outer", onPostHandle: func(ctx *GatewayContext) error {
+			post = append(post, "outer")
+			return nil
+		}},
+		&testMiddleware{name: "inner", onPostHandle: func(ctx *GatewayContext) error {
+			post = append(post, "inner")
+			return nil
+		}},
+	}
+	ctx := &GatewayContext{Request: httptest.NewRequest("GET", "/", nil), Response: httptest.NewRecorder()}
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Fatalf("Expected the panic to be re-raised, got %v", p)
+		}
+		if len(post) != 2 || post[0] != "inner" || post[1] != "outer" {
+			t.Errorf("Expected post handlers in reverse order, got %v", post)
+		}
+		if !ctx.Aborted() {
+			t.Error("Expected the request to be marked aborted")
+		}
+	}()
+	NewMiddlewareChain(handlers).Handle(ctx, func(ctx *GatewayContext) {
+		panic(http.ErrAbortHandler)
+	})
+}
+
+// TestResponseWriter 测试响应包装器在写入响应头前执行钩子
+func TestResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ctx := &GatewayContext{Response: rec}
+
+	rw := WrapResponse(ctx)
+	if WrapResponse(ctx) != rw {
+		t.Fatal("Expected WrapResponse to reuse the existing wrapper")
+	}
+
+	var seen []int
+	rw.OnWriteHeader(func(status int, header http.Header) {
+		seen = append(seen, status)
+		header.Set("X-Hook", "1")
+	})
+
+	ctx.Response.Header().Set("X-Backend", "1")
+	ctx.Response.Write([]byte("hello"))
+	ctx.Response.WriteHeader(http.StatusTeapot)
+
+	if len(seen) != 1 || seen[0] != http.StatusOK {
+		t.Errorf("Expected hook to run once with 200, got %v", seen)
+	}
+	if rec.Header().Get("X-Hook") != "1" || rec.Header().Get("X-Backend") != "1" {
+		t.Errorf("Expected both headers, got %v", rec.Header())
+	}
+	if rw.Status() != http.StatusOK || rw.Written() != 5 {
+		t.Errorf("Expected status 200 and 5 bytes, got %d and %d", rw.Status(), rw.Written())
+	}
+}
+
 // executeMiddlewareChain 执行中间件链的辅助函数
 func executeMiddlewareChain(ctx *GatewayContext) {
 	// 执行前置处理器
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// ResponseWriter wraps the response of a GatewayContext. It records the
+// status and size of the response and lets middlewares adjust the headers
+// right before they are sent, after the backend response has been copied.
+type ResponseWriter struct {
+	http.ResponseWriter
+	status            int
+	written           int64
+	beforeWriteHeader []func(status int, header http.Header)
+}
+
+// WrapResponse wraps ctx.Response in a ResponseWriter unless it already is one
+func WrapResponse(ctx *GatewayContext) *ResponseWriter {
+	if rw, ok := ctx.Response.(*ResponseWriter); ok {
+		return rw
+	}
+	rw := &ResponseWriter{ResponseWriter: ctx.Response}
+	ctx.Response = rw
+	return rw
+}
+
+// OnWriteHeader registers a hook that runs before the header is written.
+// Hooks run in registration order.
+func (rw *ResponseWriter) OnWriteHeader(hook func(status int, header http.Header)) {
+	rw.beforeWriteHeader = append(rw.beforeWriteHeader, hook)
+}
+
+// WriteHeader runs the hooks and writes the header
+func (rw *ResponseWriter) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	// Informational responses do not fix the final status
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		rw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	rw.status = status
+	for _, hook := range rw.beforeWriteHeader {
+		hook(status, rw.Header())
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the body, writing a 200 header first if needed
+func (rw *ResponseWriter) Write(data []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(data)
+	rw.written += int64(n)
+	return n, err
+}
+
+// Flush flushes the underlying writer if it supports flushing
+func (rw *ResponseWriter) Flush() {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Status returns the status written so far, or 0 if none was written
+func (rw *ResponseWriter) Status() int {
+	return rw.status
+}
+
+// Written returns the number of body bytes written
+func (rw *ResponseWriter) Written() int64 {
+	return rw.written
+}
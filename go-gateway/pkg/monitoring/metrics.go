@@ -25,6 +25,81 @@ var (
 
 	// ErrorTotal 错误计数器
 	ErrorTotal *prometheus.CounterVec
+
+	// ConsumerRequestTotal 按消费者统计的请求计数器
+	ConsumerRequestTotal *prometheus.CounterVec
+
+	// RateLimitedTotal 被限流的请求计数器
+	RateLimitedTotal *prometheus.CounterVec
+
+	// AuthzDecisionTotal 外部授权决策计数器
+	AuthzDecisionTotal *prometheus.CounterVec
+
+	// CacheRequestTotal 响应缓存查询结果计数器
+	CacheRequestTotal *prometheus.CounterVec
+
+	// CacheEvictionTotal 响应缓存淘汰计数器
+	CacheEvictionTotal *prometheus.CounterVec
+
+	// CoalescedRequestTotal 合并到进行中上游请求的请求计数器
+	CoalescedRequestTotal *prometheus.CounterVec
+
+	// WebSocketConnections 当前打开的WebSocket连接数
+	WebSocketConnections *prometheus.GaugeVec
+
+	// WebSocketFramesTotal WebSocket帧计数器
+	WebSocketFramesTotal *prometheus.CounterVec
+
+	// WebSocketBytesTotal WebSocket字节计数器
+	WebSocketBytesTotal *prometheus.CounterVec
+
+	// BackendHealthy 服务后端健康状态（1健康，0不健康）
+	BackendHealthy *prometheus.GaugeVec
+
+	// TCPConnections 当前打开的TCP代理连接数
+	TCPConnections *prometheus.GaugeVec
+
+	// TCPConnectionsTotal TCP代理连接计数器
+	TCPConnectionsTotal *prometheus.CounterVec
+
+	// TCPBytesTotal TCP代理字节计数器
+	TCPBytesTotal *prometheus.CounterVec
+
+	// TCPConnectionDuration TCP代理连接持续时间直方图
+	TCPConnectionDuration *prometheus.HistogramVec
+
+	// UDPSessions UDP代理当前会话数
+	UDPSessions *prometheus.GaugeVec
+
+	// UDPSessionsTotal UDP代理会话计数器
+	UDPSessionsTotal *prometheus.CounterVec
+
+	// UDPPacketsTotal UDP代理数据包计数器
+	UDPPacketsTotal *prometheus.CounterVec
+
+	// UDPBytesTotal UDP代理字节计数器
+	UDPBytesTotal *prometheus.CounterVec
+
+	// EgressRequestsTotal 出口代理请求计数器
+	EgressRequestsTotal *prometheus.CounterVec
+
+	// EgressTunnels 出口代理当前打开的CONNECT隧道数
+	EgressTunnels *prometheus.GaugeVec
+
+	// EgressBytesTotal 出口代理隧道字节计数器
+	EgressBytesTotal *prometheus.CounterVec
+
+	// EgressDuration 出口代理请求和隧道持续时间直方图
+	EgressDuration *prometheus.HistogramVec
+
+	// AdminChangesTotal 管理接口配置变更计数器
+	AdminChangesTotal *prometheus.CounterVec
+
+	// ConfigReloadsTotal 配置加载计数器，按结果分类
+	ConfigReloadsTotal *prometheus.CounterVec
+
+	// ConfigGeneration 当前生效配置的代数，每次成功加载加一
+	ConfigGeneration prometheus.Gauge
 )
 
 // 初始化监控指标
@@ -82,6 +157,232 @@ func init() {
 		[]string{"type", "route_id"},
 	)
 	prometheus.MustRegister(ErrorTotal)
+
+	ConsumerRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_consumer_requests_total",
+			Help: "Total number of requests per identified consumer",
+		},
+		[]string{"consumer", "route_id"},
+	)
+	prometheus.MustRegister(ConsumerRequestTotal)
+
+	RateLimitedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_rate_limited_total",
+			Help: "Total number of requests rejected by rate limiting",
+		},
+		[]string{"route_id"},
+	)
+	prometheus.MustRegister(RateLimitedTotal)
+
+	AuthzDecisionTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_authz_decisions_total",
+			Help: "Total number of external authorization decisions",
+		},
+		[]string{"route_id", "decision"},
+	)
+	prometheus.MustRegister(AuthzDecisionTotal)
+
+	CacheRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_cache_requests_total",
+			Help: "Total number of cache lookups by result",
+		},
+		[]string{"route_id", "result"},
+	)
+	prometheus.MustRegister(CacheRequestTotal)
+
+	CacheEvictionTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_cache_evictions_total",
+			Help: "Total number of entries evicted from the response cache",
+		},
+		[]string{"store", "tier"},
+	)
+	prometheus.MustRegister(CacheEvictionTotal)
+
+	CoalescedRequestTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_coalesced_requests_total",
+			Help: "Total number of requests served from an identical in-flight upstream request",
+		},
+		[]string{"route_id"},
+	)
+	prometheus.MustRegister(CoalescedRequestTotal)
+
+	WebSocketConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_websocket_connections",
+			Help: "Current number of open WebSocket connections",
+		},
+		[]string{"route_id"},
+	)
+	prometheus.MustRegister(WebSocketConnections)
+
+	WebSocketFramesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_websocket_frames_total",
+			Help: "Total number of WebSocket frames proxied",
+		},
+		[]string{"route_id", "direction"},
+	)
+	prometheus.MustRegister(WebSocketFramesTotal)
+
+	WebSocketBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_websocket_bytes_total",
+			Help: "Total number of WebSocket bytes proxied",
+		},
+		[]string{"route_id", "direction"},
+	)
+	prometheus.MustRegister(WebSocketBytesTotal)
+
+	BackendHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_backend_healthy",
+			Help: "Health of service backends as seen by health checks (1 healthy, 0 unhealthy)",
+		},
+		[]string{"service", "server"},
+	)
+	prometheus.MustRegister(BackendHealthy)
+
+	TCPConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_tcp_connections",
+			Help: "Current number of open proxied TCP connections",
+		},
+		[]string{"listener"},
+	)
+	prometheus.MustRegister(TCPConnections)
+
+	TCPConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_tcp_connections_total",
+			Help: "Total number of accepted TCP connections by result",
+		},
+		[]string{"listener", "result"},
+	)
+	prometheus.MustRegister(TCPConnectionsTotal)
+
+	TCPBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_tcp_bytes_total",
+			Help: "Total number of TCP bytes proxied",
+		},
+		[]string{"listener", "direction"},
+	)
+	prometheus.MustRegister(TCPBytesTotal)
+
+	TCPConnectionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_tcp_connection_duration_seconds",
+			Help:    "Duration of proxied TCP connections in seconds",
+			Buckets: []float64{0.01, 0.1, 1, 10, 60, 300, 1800, 3600, 14400},
+		},
+		[]string{"listener"},
+	)
+	prometheus.MustRegister(TCPConnectionDuration)
+
+	UDPSessions = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_udp_sessions",
+			Help: "Number of open UDP sessions",
+		},
+		[]string{"listener"},
+	)
+	prometheus.MustRegister(UDPSessions)
+
+	UDPSessionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_udp_sessions_total",
+			Help: "Total number of UDP sessions by result",
+		},
+		[]string{"listener", "result"},
+	)
+	prometheus.MustRegister(UDPSessionsTotal)
+
+	UDPPacketsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_udp_packets_total",
+			Help: "Total number of proxied UDP datagrams",
+		},
+		[]string{"listener", "direction"},
+	)
+	prometheus.MustRegister(UDPPacketsTotal)
+
+	UDPBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_udp_bytes_total",
+			Help: "Total number of proxied UDP payload bytes",
+		},
+		[]string{"listener", "direction"},
+	)
+	prometheus.MustRegister(UDPBytesTotal)
+
+	EgressRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_egress_requests_total",
+			Help: "Total number of egress proxy requests and CONNECT tunnels by destination",
+		},
+		[]string{"destination", "type", "result"},
+	)
+	prometheus.MustRegister(EgressRequestsTotal)
+
+	EgressTunnels = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_egress_tunnels",
+			Help: "Number of open egress CONNECT tunnels",
+		},
+		[]string{"destination"},
+	)
+	prometheus.MustRegister(EgressTunnels)
+
+	EgressBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_egress_bytes_total",
+			Help: "Total number of bytes through egress CONNECT tunnels",
+		},
+		[]string{"destination", "direction"},
+	)
+	prometheus.MustRegister(EgressBytesTotal)
+
+	EgressDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_egress_duration_seconds",
+			Help:    "Duration of egress proxy requests and CONNECT tunnels in seconds",
+			Buckets: []float64{0.01, 0.1, 1, 10, 60, 300, 1800, 3600},
+		},
+		[]string{"destination", "type"},
+	)
+	prometheus.MustRegister(EgressDuration)
+
+	AdminChangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_admin_changes_total",
+			Help: "Total number of config changes requested through the admin API",
+		},
+		[]string{"resource", "action", "result"},
+	)
+	prometheus.MustRegister(AdminChangesTotal)
+
+	ConfigReloadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_config_reloads_total",
+			Help: "Total number of config loads and reloads by result",
+		},
+		[]string{"result"},
+	)
+	prometheus.MustRegister(ConfigReloadsTotal)
+
+	ConfigGeneration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "gateway_config_generation",
+			Help: "Generation of the config being served, incremented on every successful reload",
+		},
+	)
+	prometheus.MustRegister(ConfigGeneration)
 }
 
 // MetricsHandler 返回Prometheus指标处理器
@@ -1,11 +1,16 @@
 package monitoring
 
 import (
+	"net/http"
+	"strconv"
 	"time"
 
 	"go-gateway/pkg/middleware"
 )
 
+// responseAttribute 保存监控中间件包装的响应，用于读取状态码
+const responseAttribute = "metrics.response"
+
 // MetricsMiddleware 监控中间件，用于收集请求指标
 type MetricsMiddleware struct{}
 
@@ -27,6 +32,9 @@ func (mm *MetricsMiddleware) PreHandle(ctx *middleware.GatewayContext) bool {
 	// 记录活跃连接数增加
 	ActiveConnections.Inc()
 
+	// 记录响应状态码
+	ctx.Attributes[responseAttribute] = middleware.WrapResponse(ctx)
+
 	return true // 继续执行后续中间件
 }
 
@@ -38,6 +46,9 @@ func (mm *MetricsMiddleware) PostHandle(ctx *middleware.GatewayContext) error {
 		startTime = time.Now()
 	}
 
+	// 请求（包括流式响应）结束，活跃连接数减少
+	ActiveConnections.Dec()
+
 	// 计算请求持续时间
 	duration := time.Since(startTime).Seconds()
 
@@ -57,12 +68,17 @@ func (mm *MetricsMiddleware) PostHandle(ctx *middleware.GatewayContext) error {
 	RequestTotal.WithLabelValues(
 		ctx.Request.Method,
 		ctx.Request.URL.Path,
-		"200",
+		strconv.Itoa(responseStatus(ctx)),
 	).Inc()
 
 	// 记录路由命中
 	RouteHitTotal.WithLabelValues(routeID).Inc()
 
+	// 记录消费者请求
+	if consumer, ok := ctx.Consumer(); ok {
+		ConsumerRequestTotal.WithLabelValues(consumer, routeID).Inc()
+	}
+
 	return nil
 }
 
@@ -76,3 +92,15 @@ func (mm *MetricsMiddleware) HandleError(ctx *middleware.GatewayContext, err err
 
 	ErrorTotal.WithLabelValues("middleware_error", routeID).Inc()
 }
+
+// responseStatus 返回请求的状态码；gRPC 等协议的结果以 StatusAttribute 为准，
+// 未写出响应头时按 200 计
+func responseStatus(ctx *middleware.GatewayContext) int {
+	if status, ok := ctx.Attributes[middleware.StatusAttribute].(int); ok {
+		return status
+	}
+	if rw, ok := ctx.Attributes[responseAttribute].(*middleware.ResponseWriter); ok && rw.Status() != 0 {
+		return rw.Status()
+	}
+	return http.StatusOK
+}
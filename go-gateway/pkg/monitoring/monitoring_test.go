@@ -1,7 +1,11 @@
 package monitoring
 
 import (
+	"net/http/httptest"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/middleware"
 )
 
 // TestMetricsInitialization 测试指标初始化
@@ -44,3 +48,52 @@ func TestMetricsMiddlewareCreation(t *testing.T) {
 		t.Errorf("Expected middleware name 'MetricsMiddleware', got '%s'", middleware.Name())
 	}
 }
+
+// TestActiveConnections 测试活跃连接数在请求结束后减少
+func TestActiveConnections(t *testing.T) {
+	mm := NewMetricsMiddleware()
+	before := testutil.ToFloat64(ActiveConnections)
+
+	ctx := &middleware.GatewayContext{
+		Request:    httptest.NewRequest("GET", "/events", nil),
+		Response:   httptest.NewRecorder(),
+		Attributes: make(map[string]interface{}),
+	}
+	mm.PreHandle(ctx)
+	if got := testutil.ToFloat64(ActiveConnections); got != before+1 {
+		t.Errorf("Expected %v active connections during the request, got %v", before+1, got)
+	}
+	mm.PostHandle(ctx)
+	if got := testutil.ToFloat64(ActiveConnections); got != before {
+		t.Errorf("Expected %v active connections after the request, got %v", before, got)
+	}
+}
+
+// TestRequestStatus 测试请求计数使用实际的响应状态码
+func TestRequestStatus(t *testing.T) {
+	mm := NewMetricsMiddleware()
+	serve := func(path string, status int, reported interface{}) {
+		ctx := &middleware.GatewayContext{
+			Request:    httptest.NewRequest("POST", path, nil),
+			Response:   httptest.NewRecorder(),
+			Attributes: make(map[string]interface{}),
+		}
+		mm.PreHandle(ctx)
+		ctx.Response.WriteHeader(status)
+		if reported != nil {
+			ctx.Attributes[middleware.StatusAttribute] = reported
+		}
+		mm.PostHandle(ctx)
+	}
+
+	serve("/status/denied", 403, nil)
+	if got := testutil.ToFloat64(RequestTotal.WithLabelValues("POST", "/status/denied", "403")); got != 1 {
+		t.Errorf("Expected 1 request with status 403, got %v", got)
+	}
+
+	// gRPC 响应的 HTTP 状态码总是 200，实际结果由 StatusAttribute 给出
+	serve("/status/grpc", 200, 503)
+	if got := testutil.ToFloat64(RequestTotal.WithLabelValues("POST", "/status/grpc", "503")); got != 1 {
+		t.Errorf("Expected 1 request with status 503, got %v", got)
+	}
+}
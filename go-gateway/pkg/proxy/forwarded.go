@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+)
+
+func init() {
+	filter.Register("ForwardedHeaders", NewForwardedHeadersFilter, ForwardedPolicy{})
+}
+
+// ForwardedPolicyAttribute is the GatewayContext attribute holding the
+// ForwardedPolicy of the request
+const ForwardedPolicyAttribute = "proxy.forwarded_policy"
+
+// Host modes of a ForwardedPolicy
+const (
+	// HostPreserve sends the Host requested by the client upstream
+	HostPreserve = "preserve"
+	// HostUpstream sends the host of the upstream URL
+	HostUpstream = "upstream"
+)
+
+// forwardingHeaders are the headers describing earlier hops. They are only
+// kept when the request comes from a trusted proxy.
+var forwardingHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Forwarded-Port",
+	"X-Forwarded-Prefix",
+	"X-Real-IP",
+}
+
+// ForwardedPolicy controls the Host and forwarding headers sent upstream
+type ForwardedPolicy struct {
+	// Host is HostPreserve or HostUpstream
+	Host string `mapstructure:"host" json:"host,omitempty"`
+	// XForwarded emits X-Forwarded-For, -Proto, -Host and -Port
+	XForwarded bool `mapstructure:"xForwarded" json:"xForwarded"`
+	// Forwarded emits the RFC 7239 Forwarded header
+	Forwarded bool `mapstructure:"forwarded" json:"forwarded"`
+}
+
+// DefaultForwardedPolicy preserves the Host and emits X-Forwarded-* headers
+func DefaultForwardedPolicy() ForwardedPolicy {
+	return ForwardedPolicy{Host: HostPreserve, XForwarded: true}
+}
+
+// PolicyFromContext returns the policy set by a ForwardedHeaders filter or the default
+func PolicyFromContext(ctx *middleware.GatewayContext) ForwardedPolicy {
+	if policy, ok := ctx.Attributes[ForwardedPolicyAttribute].(ForwardedPolicy); ok {
+		return policy
+	}
+	return DefaultForwardedPolicy()
+}
+
+// NewReverseProxy creates a reverse proxy to target applying policy.
+// trustedPeer tells whether the direct peer is a trusted proxy whose
+// forwarding headers are extended rather than replaced.
+func NewReverseProxy(target *url.URL, policy ForwardedPolicy, trustedPeer bool) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			policy.Apply(pr.In, pr.Out, trustedPeer)
+		},
+	}
+}
+
+// Apply sets the Host and forwarding headers of the outbound request
+func (p ForwardedPolicy) Apply(in *http.Request, out *http.Request, trustedPeer bool) {
+	if p.Host != HostUpstream {
+		out.Host = in.Host
+	}
+
+	for _, name := range forwardingHeaders {
+		out.Header.Del(name)
+	}
+
+	peer, _, err := net.SplitHostPort(in.RemoteAddr)
+	if err != nil {
+		peer = in.RemoteAddr
+	}
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	if p.XForwarded {
+		xff := peer
+		if prior := strings.Join(in.Header.Values("X-Forwarded-For"), ", "); trustedPeer && prior != "" {
+			xff = prior + ", " + peer
+		}
+		out.Header.Set("X-Forwarded-For", xff)
+		out.Header.Set("X-Forwarded-Proto", firstTrusted(in, trustedPeer, "X-Forwarded-Proto", proto))
+		out.Header.Set("X-Forwarded-Host", firstTrusted(in, trustedPeer, "X-Forwarded-Host", in.Host))
+		out.Header.Set("X-Forwarded-Port", firstTrusted(in, trustedPeer, "X-Forwarded-Port", localPort(in, proto)))
+	}
+
+	if p.Forwarded {
+		element := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedValue(peer, true), forwardedValue(in.Host, false), proto)
+		if prior := strings.Join(in.Header.Values("Forwarded"), ", "); trustedPeer && prior != "" {
+			element = prior + ", " + element
+		}
+		out.Header.Set("Forwarded", element)
+	}
+}
+
+// firstTrusted returns the value a trusted proxy set for header, or fallback
+func firstTrusted(in *http.Request, trustedPeer bool, header string, fallback string) string {
+	if trustedPeer {
+		if value := in.Header.Get(header); value != "" {
+			value, _, _ = strings.Cut(value, ",")
+			return strings.TrimSpace(value)
+		}
+	}
+	return fallback
+}
+
+// localPort returns the port the request was received on
+func localPort(in *http.Request, proto string) string {
+	if addr, ok := in.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			return port
+		}
+	}
+	if _, port, err := net.SplitHostPort(in.Host); err == nil {
+		return port
+	}
+	if proto == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// forwardedValue formats a Forwarded parameter value, quoting it when it is
+// not a token. IPv6 nodes are bracketed.
+func forwardedValue(value string, node bool) string {
+	if node && strings.Contains(value, ":") {
+		return `"[` + value + `]"`
+	}
+	for _, c := range value {
+		if !isTokenChar(c) {
+			return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+		}
+	}
+	return value
+}
+
+func isTokenChar(c rune) bool {
+	return c < 127 && c > 32 && !strings.ContainsRune(`()<>@,;:\"/[]?={} `, c)
+}
+
+// ForwardedHeadersFilter sets the ForwardedPolicy of a route
+type ForwardedHeadersFilter struct {
+	policy ForwardedPolicy
+}
+
+// NewForwardedHeadersFilter creates a ForwardedHeaders filter from its args
+func NewForwardedHeadersFilter(args interface{}) (middleware.Middleware, error) {
+	policy := DefaultForwardedPolicy()
+	if err := filter.DecodeArgs(args, &policy); err != nil {
+		return nil, err
+	}
+	if policy.Host != HostPreserve && policy.Host != HostUpstream {
+		return nil, fmt.Errorf("host must be %q or %q", HostPreserve, HostUpstream)
+	}
+	return &ForwardedHeadersFilter{policy: policy}, nil
+}
+
+// Name returns the filter name
+func (f *ForwardedHeadersFilter) Name() string {
+	return "ForwardedHeaders"
+}
+
+// PreHandle records the policy; a route filter overrides a global one
+func (f *ForwardedHeadersFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	ctx.Attributes[ForwardedPolicyAttribute] = f.policy
+	return true
+}
+
+// PostHandle does nothing
+func (f *ForwardedHeadersFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *ForwardedHeadersFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
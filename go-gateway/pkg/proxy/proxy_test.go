@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"go-gateway/pkg/middleware"
+)
+
+// proxyOnce proxies req through a reverse proxy to an echo backend and returns
+// the request the backend received
+func proxyOnce(t *testing.T, req *http.Request, policy ForwardedPolicy, trustedPeer bool) *http.Request {
+	t.Helper()
+	var received *http.Request
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+	defer backend.Close()
+
+	target, _ := url.Parse(backend.URL)
+	NewReverseProxy(target, policy, trustedPeer).ServeHTTP(httptest.NewRecorder(), req)
+	if received == nil {
+		t.Fatal("Backend was not called")
+	}
+	return received
+}
+
+// TestForwardedPolicy tests Host and forwarding header handling
+func TestForwardedPolicy(t *testing.T) {
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://api.example:8080/orders", nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		req.Header.Set("X-Forwarded-Host", "evil.example")
+		req.Header.Set("Forwarded", "for=1.2.3.4")
+		return req
+	}
+
+	t.Run("TestUntrustedPeerDefaultPolicy", func(t *testing.T) {
+		received := proxyOnce(t, newRequest(), DefaultForwardedPolicy(), false)
+		if received.Host != "api.example:8080" {
+			t.Errorf("Expected preserved host, got %s", received.Host)
+		}
+		expected := map[string]string{
+			"X-Forwarded-For":   "203.0.113.7",
+			"X-Forwarded-Host":  "api.example:8080",
+			"X-Forwarded-Proto": "http",
+			"X-Forwarded-Port":  "8080",
+			"Forwarded":         "",
+		}
+		for name, value := range expected {
+			if got := received.Header.Get(name); got != value {
+				t.Errorf("Expected %s %q, got %q", name, value, got)
+			}
+		}
+	})
+
+	t.Run("TestTrustedPeerAppends", func(t *testing.T) {
+		policy := ForwardedPolicy{Host: HostUpstream, XForwarded: true, Forwarded: true}
+		received := proxyOnce(t, newRequest(), policy, true)
+		if received.Host == "api.example:8080" {
+			t.Error("Expected upstream host")
+		}
+		if got := received.Header.Get("X-Forwarded-For"); got != "1.2.3.4, 203.0.113.7" {
+			t.Errorf("Expected appended X-Forwarded-For, got %q", got)
+		}
+		if got := received.Header.Get("X-Forwarded-Host"); got != "evil.example" {
+			t.Errorf("Expected X-Forwarded-Host of trusted proxy, got %q", got)
+		}
+		if got := received.Header.Get("Forwarded"); got != `for=1.2.3.4, for=203.0.113.7;host="api.example:8080";proto=http` {
+			t.Errorf("Unexpected Forwarded header %q", got)
+		}
+	})
+
+	t.Run("TestForwardedOnly", func(t *testing.T) {
+		req := newRequest()
+		req.RemoteAddr = "[2001:db8::1]:5000"
+		received := proxyOnce(t, req, ForwardedPolicy{Host: HostPreserve, Forwarded: true}, false)
+		if got := received.Header.Get("Forwarded"); got != `for="[2001:db8::1]";host="api.example:8080";proto=http` {
+			t.Errorf("Unexpected Forwarded header %q", got)
+		}
+		if got := received.Header.Get("X-Forwarded-For"); got != "" {
+			t.Errorf("Expected no X-Forwarded-For, got %q", got)
+		}
+	})
+}
+
+// TestForwardedHeadersFilter tests the per route policy filter
+func TestForwardedHeadersFilter(t *testing.T) {
+	f, err := NewForwardedHeadersFilter(map[string]interface{}{"host": "upstream", "forwarded": true})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	ctx := &middleware.GatewayContext{
+		Request:    httptest.NewRequest("GET", "http://gateway/", nil),
+		Attributes: make(map[string]interface{}),
+	}
+	if policy := PolicyFromContext(ctx); policy != DefaultForwardedPolicy() {
+		t.Errorf("Expected default policy, got %+v", policy)
+	}
+	f.PreHandle(ctx)
+	expected := ForwardedPolicy{Host: HostUpstream, XForwarded: true, Forwarded: true}
+	if policy := PolicyFromContext(ctx); policy != expected {
+		t.Errorf("Expected %+v, got %+v", expected, policy)
+	}
+
+	if _, err := NewForwardedHeadersFilter(map[string]interface{}{"host": "other"}); err == nil {
+		t.Error("Expected error for invalid host mode")
+	}
+}
@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+func init() {
+	filter.Register("RateLimiter", NewRateLimiterFilter, RateLimiterArgs{})
+}
+
+// idleBucketTTL is how long an unused bucket is kept before being swept
+const idleBucketTTL = 10 * time.Minute
+
+// Limiter is a token bucket rate limiter with one bucket per key
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter refilling rate tokens per second up to burst
+func NewLimiter(rate float64, burst float64) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key. It returns whether the request
+// is allowed, the tokens remaining and, when denied, how long until a token is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, int, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// sweep drops buckets that have been idle long enough to be full again
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idleBucketTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimiterArgs configures the RateLimiter filter
+type RateLimiterArgs struct {
+	PermitsPerSecond float64 `mapstructure:"permitsPerSecond" json:"permitsPerSecond"`
+	// BurstCapacity defaults to PermitsPerSecond
+	BurstCapacity float64 `mapstructure:"burstCapacity" json:"burstCapacity,omitempty"`
+	// KeyResolver is "consumer" (falling back to the client IP), "ip" or "route"
+	KeyResolver string `mapstructure:"keyResolver" json:"keyResolver,omitempty"`
+}
+
+// RateLimiterFilter limits the request rate of a route
+type RateLimiterFilter struct {
+	rate        float64
+	burst       float64
+	keyResolver string
+	now         func() time.Time
+}
+
+// limiterKey identifies the limiter of a route and filter settings
+type limiterKey struct {
+	routeID     string
+	rate        float64
+	burst       float64
+	keyResolver string
+}
+
+var (
+	limitersMutex sync.Mutex
+	// limiters are kept across config reloads, which rebuild every filter,
+	// so that reloads do not reset the quotas of the clients
+	limiters = make(map[limiterKey]*Limiter)
+)
+
+// NewRateLimiterFilter creates a RateLimiter filter from its args
+func NewRateLimiterFilter(args interface{}) (middleware.Middleware, error) {
+	cfg := RateLimiterArgs{KeyResolver: "consumer"}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.PermitsPerSecond <= 0 {
+		return nil, fmt.Errorf("permitsPerSecond must be positive")
+	}
+	if cfg.BurstCapacity == 0 {
+		cfg.BurstCapacity = cfg.PermitsPerSecond
+	}
+	if cfg.BurstCapacity < 1 {
+		return nil, fmt.Errorf("burstCapacity must be at least 1")
+	}
+	switch cfg.KeyResolver {
+	case "consumer", "ip", "route":
+	default:
+		return nil, fmt.Errorf("unknown keyResolver %q", cfg.KeyResolver)
+	}
+
+	return &RateLimiterFilter{
+		rate:        cfg.PermitsPerSecond,
+		burst:       cfg.BurstCapacity,
+		keyResolver: cfg.KeyResolver,
+		now:         time.Now,
+	}, nil
+}
+
+// Name returns the filter name
+func (f *RateLimiterFilter) Name() string {
+	return "RateLimiter"
+}
+
+// PreHandle rejects the request with 429 when its bucket is empty
+func (f *RateLimiterFilter) PreHandle(ctx *middleware.GatewayContext) bool {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	allowed, remaining, wait := f.limiter(routeID).Allow(f.resolveKey(ctx), f.now())
+
+	header := ctx.Response.Header()
+	header.Set("X-RateLimit-Replenish-Rate", strconv.FormatFloat(f.rate, 'f', -1, 64))
+	header.Set("X-RateLimit-Burst-Capacity", strconv.FormatFloat(f.burst, 'f', -1, 64))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+	if !allowed {
+		monitoring.RateLimitedTotal.WithLabelValues(routeID).Inc()
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(ctx.Response, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// limiter returns the limiter of the route for the settings of f
+func (f *RateLimiterFilter) limiter(routeID string) *Limiter {
+	key := limiterKey{routeID: routeID, rate: f.rate, burst: f.burst, keyResolver: f.keyResolver}
+	limitersMutex.Lock()
+	defer limitersMutex.Unlock()
+	l, ok := limiters[key]
+	if !ok {
+		l = NewLimiter(f.rate, f.burst)
+		limiters[key] = l
+	}
+	return l
+}
+
+// PostHandle does nothing
+func (f *RateLimiterFilter) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (f *RateLimiterFilter) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// resolveKey returns the bucket key of the request
+func (f *RateLimiterFilter) resolveKey(ctx *middleware.GatewayContext) string {
+	switch f.keyResolver {
+	case "route":
+		return "route"
+	case "consumer":
+		if consumer, ok := ctx.Consumer(); ok {
+			return "consumer:" + consumer
+		}
+	}
+	return "ip:" + ctx.ClientIP()
+}
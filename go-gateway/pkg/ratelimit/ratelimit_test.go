@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+)
+
+// TestLimiter tests the token bucket
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(1, 2)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := limiter.Allow("a", now); !allowed {
+			t.Fatalf("Expected request %d to be allowed", i)
+		}
+	}
+	allowed, _, wait := limiter.Allow("a", now)
+	if allowed {
+		t.Fatal("Expected burst to be exhausted")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("Expected wait within 1s, got %v", wait)
+	}
+
+	if allowed, _, _ := limiter.Allow("b", now); !allowed {
+		t.Error("Expected other key to have its own bucket")
+	}
+	if allowed, _, _ := limiter.Allow("a", now.Add(time.Second)); !allowed {
+		t.Error("Expected bucket to refill after 1s")
+	}
+}
+
+// TestRateLimiterFilter tests per consumer limiting
+func TestRateLimiterFilter(t *testing.T) {
+	t.Cleanup(func() {
+		limitersMutex.Lock()
+		defer limitersMutex.Unlock()
+		for key := range limiters {
+			if key.routeID == t.Name() {
+				delete(limiters, key)
+			}
+		}
+	})
+	f, err := NewRateLimiterFilter(map[string]interface{}{"permitsPerSecond": 1, "burstCapacity": 1})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+
+	newContext := func(consumer string) *middleware.GatewayContext {
+		ctx := &middleware.GatewayContext{
+			Request:    httptest.NewRequest("GET", "http://gateway/api", nil),
+			Response:   httptest.NewRecorder(),
+			Route:      &common.Route{ID: t.Name()},
+			Attributes: make(map[string]interface{}),
+		}
+		if consumer != "" {
+			ctx.Attributes[middleware.ConsumerAttribute] = consumer
+		}
+		return ctx
+	}
+
+	if !f.PreHandle(newContext("acme")) {
+		t.Fatal("Expected first request of acme to be allowed")
+	}
+	ctx := newContext("acme")
+	if f.PreHandle(ctx) {
+		t.Fatal("Expected second request of acme to be limited")
+	}
+	recorder := ctx.Response.(*httptest.ResponseRecorder)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d", recorder.Code)
+	}
+
+	if !f.PreHandle(newContext("globex")) {
+		t.Error("Expected other consumer to be allowed")
+	}
+	if !f.PreHandle(newContext("")) {
+		t.Error("Expected anonymous request to fall back to the client IP bucket")
+	}
+
+	// A reloaded config rebuilds the filter without resetting the quota
+	rebuilt, _ := NewRateLimiterFilter(map[string]interface{}{"permitsPerSecond": 1, "burstCapacity": 1})
+	if rebuilt.PreHandle(newContext("acme")) {
+		t.Error("Expected acme to stay limited after the filter was rebuilt")
+	}
+	changed, _ := NewRateLimiterFilter(map[string]interface{}{"permitsPerSecond": 1, "burstCapacity": 2})
+	if !changed.PreHandle(newContext("acme")) {
+		t.Error("Expected changed limits to start with a full bucket")
+	}
+
+	if _, err := NewRateLimiterFilter(map[string]interface{}{"permitsPerSecond": 0}); err == nil {
+		t.Error("Expected error for non positive rate")
+	}
+}
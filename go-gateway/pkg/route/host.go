@@ -0,0 +1,96 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go-gateway/pkg/filter"
+)
+
+func init() {
+	RegisterPredicate("Host", newHostPredicate, HostArgs{})
+}
+
+// HostArgs are the args of the Host predicate
+type HostArgs struct {
+	// Pattern is a comma separated list of host patterns
+	Pattern string `mapstructure:"pattern" json:"pattern"`
+}
+
+// hostPattern is a host pattern split from its optional port
+type hostPattern struct {
+	host, port string
+}
+
+// newHostPredicate creates a Host predicate, which matches the host of the
+// request against the pattern arg. As with Path, * stands for one label and a
+// leading ** for any number of them, e.g. "*.example.com" or
+// "**.example.com"; "**" alone matches any host. A pattern with a port, e.g.
+// "api.example.com:443", also requires that port.
+func newHostPredicate(args interface{}) (RequestPredicate, error) {
+	var cfg HostArgs
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	var patterns []hostPattern
+	for _, p := range strings.Split(cfg.Pattern, ",") {
+		host, port := splitHost(strings.TrimSpace(p))
+		if host == "" {
+			return nil, fmt.Errorf("pattern %q has an empty entry", cfg.Pattern)
+		}
+		patterns = append(patterns, hostPattern{host: host, port: port})
+	}
+
+	return func(r *http.Request) bool {
+		host, port := splitHost(r.Host)
+		if host == "" {
+			return false
+		}
+		for _, pattern := range patterns {
+			if pattern.port != "" && pattern.port != port {
+				continue
+			}
+			if hostMatch(pattern.host, host) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// splitHost splits a host with an optional port, lowercasing the host and
+// dropping a trailing dot
+func splitHost(hostport string) (host, port string) {
+	host = hostport
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		host, port = h, p
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return strings.ToLower(host), port
+}
+
+// hostMatch matches a host against a pattern label by label
+func hostMatch(pattern, host string) bool {
+	if pattern == "**" {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "**."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	patternLabels, hostLabels := strings.Split(pattern, "."), strings.Split(host, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i, label := range patternLabels {
+		if label != "*" && label != hostLabels[i] {
+			return false
+		}
+	}
+	return true
+}
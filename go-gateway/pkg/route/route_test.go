@@ -1,6 +1,12 @@
 package route
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 
 	"go-gateway/pkg/common"
@@ -129,3 +135,165 @@ func TestRoutePriority(t *testing.T) {
 		}
 	})
 }
+
+func TestRequestPredicate(t *testing.T) {
+	RegisterPredicate("TestHeader", func(args interface{}) (RequestPredicate, error) {
+		return func(r *http.Request) bool { return r.Header.Get("X-Test") != "" }, nil
+	}, nil)
+
+	router := NewRouter()
+	router.AddRoute(&common.Route{
+		ID:    "header",
+		Order: 0,
+		Predicates: []common.Predicate{
+			{Name: "Path", Args: map[string]string{"pattern": "/api/**"}},
+			{Name: "TestHeader"},
+		},
+	})
+	router.AddRoute(&common.Route{
+		ID:         "path",
+		Order:      1,
+		Predicates: []common.Predicate{{Name: "Path", Args: map[string]string{"pattern": "/api/**"}}},
+	})
+
+	t.Run("TestPredicateHolds", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users", nil)
+		req.Header.Set("X-Test", "1")
+		if matched := router.MatchRequest(req); matched == nil || matched.ID != "header" {
+			t.Errorf("Expected route 'header', got %v", matched)
+		}
+	})
+
+	t.Run("TestPredicateFails", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/users", nil)
+		if matched := router.MatchRequest(req); matched == nil || matched.ID != "path" {
+			t.Errorf("Expected route 'path', got %v", matched)
+		}
+	})
+}
+
+// TestHostPredicate 测试Host谓词
+func TestHostPredicate(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		expected      bool
+	}{
+		{"api.example.com", "api.example.com", true},
+		{"api.example.com", "API.Example.com.", true},
+		{"api.example.com", "api.example.com:8443", true},
+		{"api.example.com:443", "api.example.com:443", true},
+		{"api.example.com:443", "api.example.com:80", false},
+		{"api.example.com:443", "api.example.com", false},
+		{"*.example.com", "api.example.com", true},
+		{"*.example.com", "eu.api.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"**.example.com", "eu.api.example.com", true},
+		{"**.example.com", "example.com", false},
+		{"**.example.com", "badexample.com", false},
+		{"**", "anything.example.org:25", true},
+		{"github.com, *.github.com", "api.github.com", true},
+		{"[2001:db8::1]:443", "[2001:db8::1]:443", true},
+	}
+	for _, tt := range tests {
+		match, err := newHostPredicate(map[string]interface{}{"pattern": tt.pattern})
+		if err != nil {
+			t.Fatalf("Pattern %q: %v", tt.pattern, err)
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = tt.host
+		if got := match(req); got != tt.expected {
+			t.Errorf("Host %q against %q: expected %v, got %v", tt.host, tt.pattern, tt.expected, got)
+		}
+	}
+
+	t.Run("TestHostRoute", func(t *testing.T) {
+		router := NewRouter()
+		router.AddRoute(&common.Route{
+			ID:         "api",
+			Predicates: []common.Predicate{{Name: "Host", Args: map[string]string{"pattern": "api.example.com"}}},
+		})
+		req := httptest.NewRequest("GET", "http://api.example.com/users", nil)
+		if matched := router.MatchRequest(req); matched == nil || matched.ID != "api" {
+			t.Errorf("Expected route 'api', got %v", matched)
+		}
+		req = httptest.NewRequest("GET", "http://www.example.com/users", nil)
+		if matched := router.MatchRequest(req); matched != nil {
+			t.Errorf("Expected no route, got %s", matched.ID)
+		}
+	})
+}
+
+// TestCheckPredicate 测试谓词校验
+func TestCheckPredicate(t *testing.T) {
+	RegisterPredicate("Checked", func(args interface{}) (RequestPredicate, error) {
+		return func(r *http.Request) bool { return true }, nil
+	}, nil)
+	tests := []struct {
+		predicate common.Predicate
+		valid     bool
+	}{
+		{common.Predicate{Name: "Path", Args: map[string]string{"pattern": "/api/**"}}, true},
+		{common.Predicate{Name: "Path", Args: map[string]interface{}{"pattern": "/api/**"}}, true},
+		{common.Predicate{Name: "Path", Args: map[string]interface{}{"pattern": "api"}}, false},
+		{common.Predicate{Name: "Path", Args: map[string]interface{}{"pattern": 1}}, false},
+		{common.Predicate{Name: "Path"}, false},
+		{common.Predicate{Name: "Path", Args: map[string]interface{}{"pattern": "/api/**", "patern": "/x"}}, false},
+		{common.Predicate{Name: "Path", Args: []string{"/api/**"}}, false},
+		{common.Predicate{Name: "Host", Args: map[string]interface{}{"pattern": "*.example.com:443"}}, true},
+		{common.Predicate{Name: "Host", Args: map[string]interface{}{"pattern": "example.com,"}}, false},
+		{common.Predicate{Name: "Host"}, false},
+		{common.Predicate{Name: "Checked"}, true},
+		{common.Predicate{Name: "Unknown"}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckPredicate(tt.predicate); (err == nil) != tt.valid {
+			t.Errorf("Predicate %+v: expected valid=%v, got %v", tt.predicate, tt.valid, err)
+		}
+	}
+}
+
+// TestLoadedArgs 测试从配置文件加载的谓词参数
+func TestLoadedArgs(t *testing.T) {
+	t.Run("TestLooselyTypedArgs", func(t *testing.T) {
+		// JSON and viper decode args as map[string]interface{}
+		router := NewRouter()
+		err := router.AddRoute(&common.Route{
+			ID: "loaded",
+			Predicates: []common.Predicate{
+				{Name: "Path", Args: map[string]interface{}{"pattern": "/api/**"}},
+				{Name: "Host", Args: map[string]interface{}{"pattern": "api.example.com"}},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to add route: %v", err)
+		}
+		req := httptest.NewRequest("GET", "http://api.example.com/api/users", nil)
+		if matched := router.MatchRequest(req); matched == nil || matched.ID != "loaded" {
+			t.Errorf("Expected route 'loaded', got %v", matched)
+		}
+	})
+
+	t.Run("TestInvalidArgs", func(t *testing.T) {
+		router := NewRouter()
+		err := router.AddRoute(&common.Route{
+			ID:         "bad",
+			Predicates: []common.Predicate{{Name: "Path", Args: map[string]interface{}{"pattern": []int{1}}}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "route bad: predicates[0]: Path: invalid args") {
+			t.Errorf("Expected invalid args error, got %v", err)
+		}
+		if matched := router.Match("/"); matched != nil {
+			t.Errorf("Expected invalid route not to be added, got %s", matched.ID)
+		}
+	})
+
+	t.Run("TestArgsType", func(t *testing.T) {
+		if got := PredicateArgsType("Path"); got != reflect.TypeOf(PathArgs{}) {
+			t.Errorf("Expected PathArgs, got %v", got)
+		}
+		names := PredicateNames()
+		if !sort.StringsAreSorted(names) || !slices.Contains(names, "Host") {
+			t.Errorf("Expected sorted names with Host, got %v", names)
+		}
+	})
+}
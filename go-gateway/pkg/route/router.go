@@ -1,56 +1,194 @@
 package route
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 
 	"go-gateway/pkg/common"
+	"go-gateway/pkg/filter"
 )
 
+// RequestPredicate reports whether a request satisfies a route predicate
+type RequestPredicate func(r *http.Request) bool
+
+// PredicateFactory builds a request predicate from its args. Args are decoded
+// into a typed struct and checked once, when the route is added, so that bad
+// args are reported at load time instead of never matching.
+type PredicateFactory func(args interface{}) (RequestPredicate, error)
+
+// registration is a registered predicate
+type registration struct {
+	factory PredicateFactory
+	args    reflect.Type
+}
+
+var (
+	predicatesMutex sync.RWMutex
+	predicates      = make(map[string]registration)
+)
+
+func init() {
+	RegisterPredicate("Path", newPathPredicate, PathArgs{})
+}
+
+// RegisterPredicate registers a predicate factory under the given name. args
+// is a value of the typed struct the factory decodes its args into, or nil
+// for predicates without args; the JSON Schema of the config is generated
+// from it. Path predicates are OR'ed by the router, all others are AND'ed.
+func RegisterPredicate(name string, factory PredicateFactory, args interface{}) {
+	predicatesMutex.Lock()
+	defer predicatesMutex.Unlock()
+	predicates[name] = registration{factory: factory, args: reflect.TypeOf(args)}
+}
+
+// PredicateNames returns the names of the registered predicates, sorted
+func PredicateNames() []string {
+	predicatesMutex.RLock()
+	defer predicatesMutex.RUnlock()
+	names := make([]string, 0, len(predicates))
+	for name := range predicates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PredicateArgsType returns the type of the args of the predicate registered
+// under name, or nil when it takes none
+func PredicateArgsType(name string) reflect.Type {
+	predicatesMutex.RLock()
+	defer predicatesMutex.RUnlock()
+	return predicates[name].args
+}
+
+// buildPredicate builds a request predicate from its config
+func buildPredicate(predicate common.Predicate) (RequestPredicate, error) {
+	predicatesMutex.RLock()
+	r, ok := predicates[predicate.Name]
+	predicatesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown predicate %q", predicate.Name)
+	}
+	match, err := r.factory(predicate.Args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", predicate.Name, err)
+	}
+	return match, nil
+}
+
+// CheckPredicate reports an unknown predicate or unusable args
+func CheckPredicate(predicate common.Predicate) error {
+	_, err := buildPredicate(predicate)
+	return err
+}
+
+// PathArgs are the args of the Path predicate
+type PathArgs struct {
+	// Pattern is a path starting with /. * matches within a path segment and
+	// a trailing /** any sub-path.
+	Pattern string `mapstructure:"pattern" json:"pattern"`
+}
+
+// newPathPredicate creates a Path predicate
+func newPathPredicate(args interface{}) (RequestPredicate, error) {
+	var cfg PathArgs
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	if !strings.HasPrefix(cfg.Pattern, "/") {
+		return nil, fmt.Errorf("pattern %q must start with /", cfg.Pattern)
+	}
+	return func(r *http.Request) bool {
+		return pathMatch(cfg.Pattern, r.URL.Path)
+	}, nil
+}
+
+// compiledRoute is a route with its predicates built
+type compiledRoute struct {
+	route *common.Route
+	// paths are the Path predicates, one of which must hold
+	paths []RequestPredicate
+	// predicates are the other predicates, all of which must hold
+	predicates []RequestPredicate
+}
+
 // Router manages routing
 type Router struct {
-	routes []*common.Route
+	routes []*compiledRoute
 }
 
 // NewRouter creates a new router instance
 func NewRouter() *Router {
 	return &Router{
-		routes: make([]*common.Route, 0),
+		routes: make([]*compiledRoute, 0),
 	}
 }
 
-// AddRoute adds a route
-func (r *Router) AddRoute(route *common.Route) {
-	r.routes = append(r.routes, route)
+// AddRoute builds the predicates of a route and adds it. A route with an
+// unknown predicate or invalid args is not added.
+func (r *Router) AddRoute(route *common.Route) error {
+	compiled := &compiledRoute{route: route}
+	for i, predicate := range route.Predicates {
+		match, err := buildPredicate(predicate)
+		if err != nil {
+			return fmt.Errorf("route %s: predicates[%d]: %w", route.ID, i, err)
+		}
+		if predicate.Name == "Path" {
+			compiled.paths = append(compiled.paths, match)
+		} else {
+			compiled.predicates = append(compiled.predicates, match)
+		}
+	}
+
+	r.routes = append(r.routes, compiled)
 	// 按照优先级排序
-	sort.Slice(r.routes, func(i, j int) bool {
-		return r.routes[i].Order < r.routes[j].Order
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].route.Order < r.routes[j].route.Order
 	})
+	return nil
 }
 
 // Match matches a route by path
 func (r *Router) Match(path string) *common.Route {
+	return r.MatchRequest(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: path}, Header: http.Header{}})
+}
+
+// MatchRequest matches a route for a request
+func (r *Router) MatchRequest(req *http.Request) *common.Route {
 	for _, route := range r.routes {
-		if matchRoute(route, path) {
-			return route
+		if route.match(req) {
+			return route.route
 		}
 	}
 	return nil
 }
 
-// matchRoute checks if a route matches the given path
-func matchRoute(route *common.Route, path string) bool {
-	for _, predicate := range route.Predicates {
-		if predicate.Name == "Path" {
-			pattern, ok := predicate.Args.(map[string]string)["pattern"]
-			if !ok {
-				continue
-			}
-
-			if pathMatch(pattern, path) {
-				return true
-			}
+// match checks if the route matches the given request. One of the Path
+// predicates must match the path, and every other predicate must hold. A
+// route without Path predicates matches any path as long as it has another
+// predicate.
+func (c *compiledRoute) match(req *http.Request) bool {
+	for _, match := range c.predicates {
+		if !match(req) {
+			return false
+		}
+	}
+	if len(c.paths) == 0 {
+		return len(c.predicates) > 0
+	}
+	for _, match := range c.paths {
+		if match(req) {
+			return true
 		}
 	}
 	return false
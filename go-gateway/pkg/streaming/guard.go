@@ -0,0 +1,161 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+// Causes of requests cancelled by Guard, see context.Cause
+var (
+	ErrRequestTimeout = errors.New("request timeout")
+	ErrIdleTimeout    = errors.New("stream idle timeout")
+)
+
+// Timeouts bound the time spent on a request
+type Timeouts struct {
+	// Request limits the total time of a request; streams are exempt
+	Request time.Duration
+	// Idle limits the time a stream may go without data
+	Idle time.Duration
+}
+
+// Guard applies t to the request of ctx. The request is cancelled when it
+// takes longer than t.Request. Once the response turns out to be a stream
+// the request timeout no longer applies and the stream is cancelled instead
+// when no data passes for the idle timeout. Upgraded connections are left to
+// their own limits. The returned function stops the timers.
+func Guard(ctx *middleware.GatewayContext, t Timeouts) (release func()) {
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	reqCtx, cancel := context.WithCancelCause(ctx.Request.Context())
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+
+	g := &guard{ctx: ctx, cancel: cancel, idle: t.Idle}
+	if t.Request > 0 && ctx.Request.Header.Get("Upgrade") == "" {
+		g.requestTimer = time.AfterFunc(t.Request, func() { g.expire(ErrRequestTimeout, "request_timeout") })
+	}
+	ctx.Response = &streamWriter{ResponseWriter: ctx.Response, guard: g}
+
+	return func() {
+		g.stop()
+		cancel(nil)
+	}
+}
+
+type guard struct {
+	ctx    *middleware.GatewayContext
+	cancel context.CancelCauseFunc
+	idle   time.Duration
+
+	mutex        sync.Mutex
+	stopped      bool
+	requestTimer *time.Timer
+	idleTimer    *time.Timer
+}
+
+// expire cancels the request with cause
+func (g *guard) expire(cause error, errorType string) {
+	routeID := "unknown"
+	if g.ctx.Route != nil {
+		routeID = g.ctx.Route.ID
+	}
+	monitoring.ErrorTotal.WithLabelValues(errorType, routeID).Inc()
+	g.cancel(cause)
+}
+
+// startStream swaps the request timeout for the idle timeout
+func (g *guard) startStream() {
+	g.ctx.Attributes[StreamAttribute] = true
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.stopped {
+		return
+	}
+	if g.requestTimer != nil {
+		g.requestTimer.Stop()
+	}
+	idle := g.idle
+	if policy := PolicyFromContext(g.ctx); policy.idleTimeout > 0 {
+		idle = policy.idleTimeout
+	}
+	if idle > 0 {
+		g.idle = idle
+		g.idleTimer = time.AfterFunc(idle, func() { g.expire(ErrIdleTimeout, "stream_idle_timeout") })
+	}
+}
+
+// active restarts the idle timeout of a stream
+func (g *guard) active() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.idleTimer != nil && !g.stopped {
+		g.idleTimer.Reset(g.idle)
+	}
+}
+
+func (g *guard) stop() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.stopped = true
+	if g.requestTimer != nil {
+		g.requestTimer.Stop()
+	}
+	if g.idleTimer != nil {
+		g.idleTimer.Stop()
+	}
+}
+
+// streamWriter detects streams and observes their activity
+type streamWriter struct {
+	http.ResponseWriter
+	guard  *guard
+	status int
+}
+
+// WriteHeader detects whether the response is a stream. The header of a
+// stream is sent right away, before any data is available.
+func (w *streamWriter) WriteHeader(status int) {
+	if w.status != 0 || status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+	stream := IsStream(w.guard.ctx, w.Header())
+	if stream {
+		w.guard.startStream()
+	}
+	w.ResponseWriter.WriteHeader(status)
+	if stream {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+// Write passes data on, restarting the idle timeout
+func (w *streamWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.guard.active()
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush flushes the wrapped writer
+func (w *streamWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,121 @@
+package streaming
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+)
+
+func init() {
+	filter.Register("Streaming", NewStreaming, StreamingArgs{})
+}
+
+// PolicyAttribute is the GatewayContext attribute holding the Policy of the request
+const PolicyAttribute = "streaming.policy"
+
+// StreamAttribute is set on the GatewayContext once the response is a stream
+const StreamAttribute = "streaming.stream"
+
+// DefaultContentTypes are the media types of responses treated as streams
+var DefaultContentTypes = []string{"text/event-stream", "application/x-ndjson", "application/stream+json"}
+
+var defaultPolicy = newPolicy(false, DefaultContentTypes, 0)
+
+// StreamingArgs configures the Streaming filter
+type StreamingArgs struct {
+	// Always treats every response of the route as a stream
+	Always bool `mapstructure:"always" json:"always,omitempty"`
+	// ContentTypes lists the media types detected as streams
+	ContentTypes []string `mapstructure:"contentTypes" json:"contentTypes,omitempty"`
+	// IdleTimeout overrides the global idle timeout of streams on the route
+	IdleTimeout time.Duration `mapstructure:"idleTimeout" json:"idleTimeout,omitempty"`
+}
+
+// Policy decides which responses are streams
+type Policy struct {
+	always       bool
+	contentTypes map[string]bool
+	idleTimeout  time.Duration
+}
+
+func newPolicy(always bool, contentTypes []string, idleTimeout time.Duration) Policy {
+	p := Policy{always: always, contentTypes: make(map[string]bool), idleTimeout: idleTimeout}
+	for _, contentType := range contentTypes {
+		p.contentTypes[strings.ToLower(strings.TrimSpace(contentType))] = true
+	}
+	return p
+}
+
+// Streaming sets the streaming policy of a route
+type Streaming struct {
+	policy Policy
+}
+
+// NewStreaming creates a Streaming filter from its args
+func NewStreaming(args interface{}) (middleware.Middleware, error) {
+	cfg := StreamingArgs{ContentTypes: DefaultContentTypes}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.IdleTimeout < 0 {
+		return nil, fmt.Errorf("idleTimeout must not be negative")
+	}
+	return &Streaming{policy: newPolicy(cfg.Always, cfg.ContentTypes, cfg.IdleTimeout)}, nil
+}
+
+// Name returns the filter name
+func (s *Streaming) Name() string {
+	return "Streaming"
+}
+
+// PreHandle sets the policy of the request
+func (s *Streaming) PreHandle(ctx *middleware.GatewayContext) bool {
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+	ctx.Attributes[PolicyAttribute] = s.policy
+	return true
+}
+
+// PostHandle does nothing
+func (s *Streaming) PostHandle(ctx *middleware.GatewayContext) error {
+	return nil
+}
+
+// HandleError does nothing
+func (s *Streaming) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// PolicyFromContext returns the policy set by a Streaming filter or the default
+func PolicyFromContext(ctx *middleware.GatewayContext) Policy {
+	if policy, ok := ctx.Attributes[PolicyAttribute].(Policy); ok {
+		return policy
+	}
+	return defaultPolicy
+}
+
+// IsStream reports whether a response with this header is a stream that has
+// to be flushed as it arrives rather than buffered
+func IsStream(ctx *middleware.GatewayContext, header http.Header) bool {
+	policy := PolicyFromContext(ctx)
+	if policy.always {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	// gRPC responses are message streams whatever the route says
+	return policy.contentTypes[mediaType] || strings.HasPrefix(mediaType, "application/grpc")
+}
+
+// Streamed reports whether the response of ctx turned out to be a stream
+func Streamed(ctx *middleware.GatewayContext) bool {
+	streamed, _ := ctx.Attributes[StreamAttribute].(bool)
+	return streamed
+}
@@ -0,0 +1,179 @@
+package streaming
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+)
+
+// TestIsStream tests stream detection from the content type and route policy
+func TestIsStream(t *testing.T) {
+	newCtx := func(args map[string]interface{}) *middleware.GatewayContext {
+		ctx := &middleware.GatewayContext{Attributes: map[string]interface{}{}}
+		if args != nil {
+			f, err := NewStreaming(args)
+			if err != nil {
+				t.Fatalf("Failed to create filter: %v", err)
+			}
+			f.PreHandle(ctx)
+		}
+		return ctx
+	}
+	header := func(contentType string) http.Header {
+		return http.Header{"Content-Type": {contentType}}
+	}
+
+	tests := []struct {
+		name        string
+		args        map[string]interface{}
+		contentType string
+		expected    bool
+	}{
+		{"EventStream", nil, "text/event-stream; charset=utf-8", true},
+		{"NDJSON", nil, "application/x-ndjson", true},
+		{"JSON", nil, "application/json", false},
+		{"Configured", map[string]interface{}{"contentTypes": "application/json-seq"}, "application/json-seq", true},
+		{"ConfiguredReplacesDefaults", map[string]interface{}{"contentTypes": "application/json-seq"}, "text/event-stream", false},
+		{"Always", map[string]interface{}{"always": true}, "application/octet-stream", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsStream(newCtx(tt.args), header(tt.contentType)); got != tt.expected {
+				t.Errorf("IsStream(%q) = %v, expected %v", tt.contentType, got, tt.expected)
+			}
+		})
+	}
+
+	if _, err := NewStreaming(map[string]interface{}{"idleTimeout": "-1s"}); err == nil {
+		t.Error("Expected negative idleTimeout to be rejected")
+	}
+}
+
+// gateway serves requests through Guard and a reverse proxy to backend,
+// wired like the gateway's forward
+func gateway(t *testing.T, routeID string, timeouts Timeouts, backend http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(backend)
+	t.Cleanup(origin.Close)
+	target, _ := url.Parse(origin.URL)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := &middleware.GatewayContext{Request: r, Response: w, Route: &common.Route{ID: routeID}, Attributes: map[string]interface{}{}}
+		release := Guard(ctx, timeouts)
+		defer release()
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			if IsStream(ctx, resp.Header) {
+				proxy.FlushInterval = -1
+			}
+			return nil
+		}
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(context.Cause(r.Context()), ErrRequestTimeout) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		proxy.ServeHTTP(ctx.Response, ctx.Request)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// events writes n server-sent events interval apart
+func events(n int, interval time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// TestGuard tests the request and idle timeouts
+func TestGuard(t *testing.T) {
+	timeouts := Timeouts{Request: 100 * time.Millisecond, Idle: 150 * time.Millisecond}
+
+	t.Run("RequestTimeout", func(t *testing.T) {
+		server := gateway(t, "slow", timeouts, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+			}
+		})
+		before := testutil.ToFloat64(monitoring.ErrorTotal.WithLabelValues("request_timeout", "slow"))
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("Expected 504, got %d", resp.StatusCode)
+		}
+		if got := testutil.ToFloat64(monitoring.ErrorTotal.WithLabelValues("request_timeout", "slow")); got != before+1 {
+			t.Errorf("Expected request_timeout to be counted, got %v", got-before)
+		}
+	})
+
+	t.Run("StreamExemptFromRequestTimeout", func(t *testing.T) {
+		// Runs for about 300ms, longer than the request timeout but with
+		// pauses shorter than the idle timeout
+		server := gateway(t, "sse", timeouts, events(6, 50*time.Millisecond))
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil || len(body) != 6*len("data: 1\n\n") {
+			t.Errorf("Expected all events, got %q %v", body, err)
+		}
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		server := gateway(t, "stalled", timeouts, events(2, 5*time.Second))
+		before := testutil.ToFloat64(monitoring.ErrorTotal.WithLabelValues("stream_idle_timeout", "stalled"))
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		// The first event arrives right away, then the stalled stream is cut
+		line, err := bufio.NewReader(resp.Body).ReadString('\n')
+		if err != nil || line != "data: 1\n" {
+			t.Fatalf("Expected the first event to be flushed, got %q %v", line, err)
+		}
+		start := time.Now()
+		if _, err := io.ReadAll(resp.Body); err == nil {
+			t.Error("Expected the stalled stream to be aborted")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the stream to be cut after the idle timeout, took %v", elapsed)
+		}
+		if got := testutil.ToFloat64(monitoring.ErrorTotal.WithLabelValues("stream_idle_timeout", "stalled")); got != before+1 {
+			t.Errorf("Expected stream_idle_timeout to be counted, got %v", got-before)
+		}
+	})
+}
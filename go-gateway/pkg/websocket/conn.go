@@ -0,0 +1,322 @@
+package websocket
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go-gateway/pkg/monitoring"
+)
+
+// Close codes (RFC 6455 7.4.1)
+const (
+	CloseNormal         = 1000
+	CloseGoingAway      = 1001
+	CloseMessageTooBig  = 1009
+	opcodeContinuation  = 0x0
+	opcodeClose         = 0x8
+	closeGracePeriod    = time.Second
+	maxFrameHeaderBytes = 14
+)
+
+var errMessageTooBig = errors.New("websocket: message too big")
+
+// frame is a parsed frame header
+type frame struct {
+	opcode byte
+	fin    bool
+	length int64
+}
+
+// frameParser follows the frame boundaries of one direction of a connection
+type frameParser struct {
+	header    [maxFrameHeaderBytes]byte
+	headerLen int
+	remaining int64
+}
+
+// headerSize returns the size of the header collected so far, or 0 while it
+// is not known yet
+func (p *frameParser) headerSize() int {
+	if p.headerLen < 2 {
+		return 0
+	}
+	size := 2
+	switch p.header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if p.header[1]&0x80 != 0 {
+		size += 4
+	}
+	return size
+}
+
+// advance consumes data up to the end of the next frame header or frame
+// payload. It returns how many bytes it consumed and the header if one was
+// completed.
+func (p *frameParser) advance(data []byte) (int, *frame) {
+	if p.remaining > 0 {
+		n := int64(len(data))
+		if n > p.remaining {
+			n = p.remaining
+		}
+		p.remaining -= n
+		return int(n), nil
+	}
+
+	for n := 0; n < len(data); {
+		p.header[p.headerLen] = data[n]
+		p.headerLen++
+		n++
+		if p.headerLen == p.headerSize() {
+			f := &frame{opcode: p.header[0] & 0x0f, fin: p.header[0]&0x80 != 0}
+			switch length := p.header[1] & 0x7f; length {
+			case 126:
+				f.length = int64(binary.BigEndian.Uint16(p.header[2:4]))
+			case 127:
+				f.length = int64(binary.BigEndian.Uint64(p.header[2:10]) &^ (1 << 63))
+			default:
+				f.length = int64(length)
+			}
+			p.headerLen = 0
+			p.remaining = f.length
+			return n, f
+		}
+	}
+	return len(data), nil
+}
+
+// atBoundary reports whether the stream is between two frames
+func (p *frameParser) atBoundary() bool {
+	return p.headerLen == 0 && p.remaining == 0
+}
+
+// closeFrame builds an unmasked close frame
+func closeFrame(code int, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return append([]byte{0x80 | opcodeClose, byte(len(payload))}, payload...)
+}
+
+var (
+	connsMutex sync.Mutex
+	conns      = make(map[*conn]struct{})
+)
+
+// conn is a hijacked client connection. Reads carry client frames to the
+// backend and writes carry backend frames to the client.
+type conn struct {
+	net.Conn
+	routeID string
+	limits  Limits
+
+	in      frameParser
+	message int64
+
+	writeMutex   sync.Mutex
+	out          frameParser
+	pendingClose []byte
+	closeSent    bool
+
+	lastActivity atomic.Int64
+	done         chan struct{}
+	closeOnce    sync.Once
+
+	framesIn, framesOut, bytesIn, bytesOut prometheus.Counter
+}
+
+func newConn(netConn net.Conn, routeID string, limits Limits) *conn {
+	c := &conn{
+		Conn:      netConn,
+		routeID:   routeID,
+		limits:    limits,
+		done:      make(chan struct{}),
+		framesIn:  monitoring.WebSocketFramesTotal.WithLabelValues(routeID, "in"),
+		framesOut: monitoring.WebSocketFramesTotal.WithLabelValues(routeID, "out"),
+		bytesIn:   monitoring.WebSocketBytesTotal.WithLabelValues(routeID, "in"),
+		bytesOut:  monitoring.WebSocketBytesTotal.WithLabelValues(routeID, "out"),
+	}
+	c.touch()
+
+	connsMutex.Lock()
+	conns[c] = struct{}{}
+	connsMutex.Unlock()
+	monitoring.WebSocketConnections.WithLabelValues(routeID).Inc()
+
+	if limits.IdleTimeout > 0 {
+		go c.watchIdle()
+	}
+	return c
+}
+
+func (c *conn) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *conn) watchIdle() {
+	ticker := time.NewTicker(c.limits.IdleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, c.lastActivity.Load())) >= c.limits.IdleTimeout {
+				c.closeWith(CloseNormal, "idle timeout")
+				return
+			}
+		}
+	}
+}
+
+// Read reads client data, counting frames and enforcing the message size
+func (c *conn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n == 0 {
+		return n, err
+	}
+	c.touch()
+	c.bytesIn.Add(float64(n))
+
+	for data := p[:n]; len(data) > 0; {
+		k, f := c.in.advance(data)
+		data = data[k:]
+		if f == nil {
+			continue
+		}
+		c.framesIn.Inc()
+		if f.opcode >= opcodeClose {
+			continue
+		}
+		if f.opcode != opcodeContinuation {
+			c.message = 0
+		}
+		c.message += f.length
+		if c.limits.MaxMessageSize > 0 && c.message > c.limits.MaxMessageSize {
+			c.closeWith(CloseMessageTooBig, "message too big")
+			return 0, errMessageTooBig
+		}
+	}
+	return n, err
+}
+
+// Write writes backend data to the client, counting frames. Once a close
+// frame is pending it is sent at the next frame boundary.
+func (c *conn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		if c.closeSent {
+			return written, net.ErrClosed
+		}
+		k, f := c.out.advance(p)
+		if f != nil {
+			c.framesOut.Inc()
+		}
+		n, err := c.Conn.Write(p[:k])
+		written += n
+		c.bytesOut.Add(float64(n))
+		if err != nil {
+			return written, err
+		}
+		p = p[k:]
+		if c.pendingClose != nil && c.out.atBoundary() {
+			c.sendClose()
+		}
+	}
+	c.touch()
+	return written, nil
+}
+
+// closeWith sends a close frame to the client as soon as no backend frame is
+// in the middle of being written, and closes the connection if that does
+// not happen within the grace period
+func (c *conn) closeWith(code int, reason string) {
+	c.writeMutex.Lock()
+	if c.closeSent || c.pendingClose != nil {
+		c.writeMutex.Unlock()
+		return
+	}
+	c.pendingClose = closeFrame(code, reason)
+	if c.out.atBoundary() {
+		c.sendClose()
+		c.writeMutex.Unlock()
+		return
+	}
+	c.writeMutex.Unlock()
+
+	time.AfterFunc(closeGracePeriod, func() {
+		c.writeMutex.Lock()
+		sent := c.closeSent
+		c.writeMutex.Unlock()
+		if !sent {
+			c.Close()
+		}
+	})
+}
+
+// sendClose writes the pending close frame; the client gets the grace period
+// to answer with its own close frame. Callers hold writeMutex.
+func (c *conn) sendClose() {
+	c.closeSent = true
+	c.Conn.SetWriteDeadline(time.Now().Add(closeGracePeriod))
+	if _, err := c.Conn.Write(c.pendingClose); err != nil {
+		c.Conn.Close()
+		return
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(closeGracePeriod))
+}
+
+// Close closes the connection
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		connsMutex.Lock()
+		delete(conns, c)
+		connsMutex.Unlock()
+		monitoring.WebSocketConnections.WithLabelValues(c.routeID).Dec()
+	})
+	return c.Conn.Close()
+}
+
+// Shutdown sends a going-away close frame to every open connection and
+// waits until they are closed or ctx is done
+func Shutdown(ctx context.Context) error {
+	connsMutex.Lock()
+	open := make([]*conn, 0, len(conns))
+	for c := range conns {
+		open = append(open, c)
+	}
+	connsMutex.Unlock()
+
+	for _, c := range open {
+		c.closeWith(CloseGoingAway, "server shutting down")
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		connsMutex.Lock()
+		remaining := len(conns)
+		connsMutex.Unlock()
+		if remaining == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go-gateway/pkg/filter"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+	"go-gateway/pkg/route"
+)
+
+func init() {
+	route.RegisterPredicate("WebSocket", newPredicate, nil)
+	filter.Register("WebSocket", NewWebSocket, WebSocketArgs{})
+}
+
+// LimitsAttribute is the GatewayContext attribute holding the Limits of a
+// WebSocket request
+const LimitsAttribute = "websocket.limits"
+
+// newPredicate creates the WebSocket predicate, which matches upgrade
+// requests; it takes no args
+func newPredicate(args interface{}) (route.RequestPredicate, error) {
+	var cfg struct{}
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	return IsUpgrade, nil
+}
+
+// IsUpgrade reports whether r asks to upgrade to the WebSocket protocol
+func IsUpgrade(r *http.Request) bool {
+	if r.Method != http.MethodGet || !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Limits restricts the WebSocket connections of a route
+type Limits struct {
+	// MaxMessageSize is the largest message in bytes accepted from clients
+	MaxMessageSize int64
+	// IdleTimeout closes connections without traffic in either direction
+	IdleTimeout time.Duration
+}
+
+// LimitsFromContext returns the limits set for the request, if any
+func LimitsFromContext(ctx *middleware.GatewayContext) Limits {
+	limits, _ := ctx.Attributes[LimitsAttribute].(Limits)
+	return limits
+}
+
+// WebSocketArgs configures the WebSocket filter
+type WebSocketArgs struct {
+	// MaxConnections limits the concurrent connections of the route
+	MaxConnections int `mapstructure:"maxConnections" json:"maxConnections,omitempty"`
+	// MaxMessageSize is the largest message in bytes accepted from clients
+	MaxMessageSize int64 `mapstructure:"maxMessageSize" json:"maxMessageSize,omitempty"`
+	// IdleTimeout closes connections without traffic in either direction
+	IdleTimeout time.Duration `mapstructure:"idleTimeout" json:"idleTimeout,omitempty"`
+}
+
+// WebSocket enforces the WebSocket limits of a route
+type WebSocket struct {
+	maxConnections int
+	limits         Limits
+}
+
+// NewWebSocket creates a WebSocket filter from its args
+func NewWebSocket(args interface{}) (middleware.Middleware, error) {
+	var cfg WebSocketArgs
+	if err := filter.DecodeArgs(args, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.MaxConnections < 0 || cfg.MaxMessageSize < 0 || cfg.IdleTimeout < 0 {
+		return nil, fmt.Errorf("limits must not be negative")
+	}
+	return &WebSocket{
+		maxConnections: cfg.MaxConnections,
+		limits:         Limits{MaxMessageSize: cfg.MaxMessageSize, IdleTimeout: cfg.IdleTimeout},
+	}, nil
+}
+
+// Name returns the filter name
+func (f *WebSocket) Name() string {
+	return "WebSocket"
+}
+
+// slotAttribute marks requests holding a connection slot
+const slotAttribute = "websocket.slot"
+
+var (
+	slotsMutex sync.Mutex
+	// slots counts the connections per route; it is kept across config
+	// reloads since connections outlive the filters that admitted them
+	slots = make(map[string]int)
+)
+
+// PreHandle rejects upgrades beyond the connection limit and passes the
+// limits on to the proxy
+func (f *WebSocket) PreHandle(ctx *middleware.GatewayContext) bool {
+	if !IsUpgrade(ctx.Request) {
+		return true
+	}
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	if ctx.Attributes == nil {
+		ctx.Attributes = make(map[string]interface{})
+	}
+
+	if f.maxConnections > 0 {
+		slotsMutex.Lock()
+		full := slots[routeID] >= f.maxConnections
+		if !full {
+			slots[routeID]++
+		}
+		slotsMutex.Unlock()
+		if full {
+			monitoring.ErrorTotal.WithLabelValues("websocket_limit", routeID).Inc()
+			http.Error(ctx.Response, "Too many WebSocket connections", http.StatusServiceUnavailable)
+			return false
+		}
+		ctx.Attributes[slotAttribute] = routeID
+	}
+	ctx.Attributes[LimitsAttribute] = f.limits
+	return true
+}
+
+// PostHandle releases the connection slot once the connection is over
+func (f *WebSocket) PostHandle(ctx *middleware.GatewayContext) error {
+	if routeID, ok := ctx.Attributes[slotAttribute].(string); ok {
+		delete(ctx.Attributes, slotAttribute)
+		slotsMutex.Lock()
+		slots[routeID]--
+		slotsMutex.Unlock()
+	}
+	return nil
+}
+
+// HandleError does nothing
+func (f *WebSocket) HandleError(ctx *middleware.GatewayContext, err error) {
+}
+
+// Track wraps the response of a WebSocket upgrade so that the connection the
+// proxy hijacks is measured, limited and closed properly on shutdown. The
+// proxy only returns once the connection is over.
+func Track(ctx *middleware.GatewayContext) {
+	routeID := "unknown"
+	if ctx.Route != nil {
+		routeID = ctx.Route.ID
+	}
+	ctx.Response = &hijackWriter{ResponseWriter: ctx.Response, routeID: routeID, limits: LimitsFromContext(ctx)}
+}
+
+// hijackWriter hands out a tracked connection when the proxy hijacks it
+type hijackWriter struct {
+	http.ResponseWriter
+	routeID string
+	limits  Limits
+}
+
+// Hijack hijacks the client connection and wraps it
+func (w *hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return newConn(conn, w.routeID, w.limits), brw, nil
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *hijackWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,285 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go-gateway/pkg/common"
+	"go-gateway/pkg/middleware"
+	"go-gateway/pkg/monitoring"
+	"go-gateway/pkg/route"
+)
+
+func writeFrame(w io.Writer, opcode byte, payload []byte, masked bool) error {
+	header := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		header = append(header, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	data := append([]byte(nil), payload...)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		header = append(header, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	_, err := w.Write(append(header, data...))
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	var mask [4]byte
+	if head[1]&0x80 != 0 {
+		io.ReadFull(r, mask[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	if head[1]&0x80 != 0 {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return head[0] & 0x0f, payload, nil
+}
+
+// echoBackend upgrades every request and echoes frames back unmasked
+func echoBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		for {
+			opcode, payload, err := readFrame(brw)
+			if err != nil {
+				return
+			}
+			if err := writeFrame(conn, opcode, payload, false); err != nil || opcode == opcodeClose {
+				return
+			}
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// gateway proxies to backend through the WebSocket filter built from args
+func gateway(t *testing.T, routeID string, args map[string]interface{}, backend *httptest.Server) *httptest.Server {
+	f, err := NewWebSocket(args)
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	target, _ := url.Parse(backend.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := &middleware.GatewayContext{Request: r, Response: w, Route: &common.Route{ID: routeID}, Attributes: map[string]interface{}{}}
+		middleware.NewMiddlewareChain([]middleware.Middleware{f}).Handle(ctx, func(ctx *middleware.GatewayContext) {
+			if IsUpgrade(ctx.Request) {
+				Track(ctx)
+			}
+			proxy.ServeHTTP(ctx.Response, ctx.Request)
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dial opens a WebSocket connection and returns the connection and its reader
+func dial(t *testing.T, server *httptest.Server) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest("GET", server.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Write(conn)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %v", err)
+	}
+	return conn, reader, resp.StatusCode
+}
+
+func expectClose(t *testing.T, reader io.Reader, code int) {
+	t.Helper()
+	opcode, payload, err := readFrame(reader)
+	if err != nil {
+		t.Fatalf("Expected close frame, got error %v", err)
+	}
+	if opcode != opcodeClose || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		t.Fatalf("Expected close frame with code %d, got opcode %d payload %v", code, opcode, payload)
+	}
+}
+
+// TestPredicate tests the WebSocket route predicate
+func TestPredicate(t *testing.T) {
+	router := route.NewRouter()
+	router.AddRoute(&common.Route{ID: "ws", Order: 1, Predicates: []common.Predicate{
+		{Name: "Path", Args: map[string]string{"pattern": "/api/**"}},
+		{Name: "WebSocket"},
+	}})
+	router.AddRoute(&common.Route{ID: "http", Order: 2, Predicates: []common.Predicate{
+		{Name: "Path", Args: map[string]string{"pattern": "/api/**"}},
+	}})
+
+	req := httptest.NewRequest("GET", "http://gateway/api/chat", nil)
+	if got := router.MatchRequest(req); got == nil || got.ID != "http" {
+		t.Errorf("Expected plain request to match http route, got %v", got)
+	}
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "WebSocket")
+	if got := router.MatchRequest(req); got == nil || got.ID != "ws" {
+		t.Errorf("Expected upgrade to match ws route, got %v", got)
+	}
+}
+
+// TestFrameParser tests frame boundaries across arbitrary splits
+func TestFrameParser(t *testing.T) {
+	var stream []byte
+	buf := &byteWriter{&stream}
+	writeFrame(buf, 0x1, []byte("hello"), true)
+	writeFrame(buf, 0x2, make([]byte, 300), false)
+	writeFrame(buf, 0x1, make([]byte, 70000), true)
+
+	for _, chunk := range []int{1, 3, 7, 1000, len(stream)} {
+		var p frameParser
+		var lengths []int64
+		for data := stream; len(data) > 0; {
+			end := chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			part := data[:end]
+			for len(part) > 0 {
+				k, f := p.advance(part)
+				if f != nil {
+					lengths = append(lengths, f.length)
+				}
+				part = part[k:]
+			}
+			data = data[end:]
+		}
+		if len(lengths) != 3 || lengths[0] != 5 || lengths[1] != 300 || lengths[2] != 70000 || !p.atBoundary() {
+			t.Errorf("Chunk size %d: unexpected frames %v", chunk, lengths)
+		}
+	}
+}
+
+type byteWriter struct{ b *[]byte }
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	*w.b = append(*w.b, p...)
+	return len(p), nil
+}
+
+// TestProxy tests metrics and limits of proxied connections
+func TestProxy(t *testing.T) {
+	backend := echoBackend(t)
+
+	t.Run("EchoAndMetrics", func(t *testing.T) {
+		server := gateway(t, "ws-echo", map[string]interface{}{}, backend)
+		conn, reader, status := dial(t, server)
+		if status != http.StatusSwitchingProtocols {
+			t.Fatalf("Expected 101, got %d", status)
+		}
+		if got := testutil.ToFloat64(monitoring.WebSocketConnections.WithLabelValues("ws-echo")); got != 1 {
+			t.Errorf("Expected 1 open connection, got %v", got)
+		}
+		writeFrame(conn, 0x1, []byte("ping"), true)
+		if _, payload, err := readFrame(reader); err != nil || string(payload) != "ping" {
+			t.Fatalf("Expected echo, got %q %v", payload, err)
+		}
+		if got := testutil.ToFloat64(monitoring.WebSocketFramesTotal.WithLabelValues("ws-echo", "in")); got != 1 {
+			t.Errorf("Expected 1 inbound frame, got %v", got)
+		}
+		if got := testutil.ToFloat64(monitoring.WebSocketBytesTotal.WithLabelValues("ws-echo", "out")); got != 6 {
+			t.Errorf("Expected 6 outbound bytes, got %v", got)
+		}
+	})
+
+	t.Run("MaxMessageSize", func(t *testing.T) {
+		server := gateway(t, "ws-size", map[string]interface{}{"maxMessageSize": 10}, backend)
+		conn, reader, _ := dial(t, server)
+		writeFrame(conn, 0x1, []byte("small"), true)
+		readFrame(reader)
+		writeFrame(conn, 0x1, []byte("far too large"), true)
+		expectClose(t, reader, CloseMessageTooBig)
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		server := gateway(t, "ws-idle", map[string]interface{}{"idleTimeout": "100ms"}, backend)
+		_, reader, _ := dial(t, server)
+		expectClose(t, reader, CloseNormal)
+	})
+
+	t.Run("MaxConnections", func(t *testing.T) {
+		server := gateway(t, "ws-max", map[string]interface{}{"maxConnections": 1}, backend)
+		dial(t, server)
+		if _, _, status := dial(t, server); status != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503 beyond the limit, got %d", status)
+		}
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		server := gateway(t, "ws-shutdown", map[string]interface{}{}, backend)
+		_, reader, _ := dial(t, server)
+		done := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			done <- Shutdown(ctx)
+		}()
+		expectClose(t, reader, CloseGoingAway)
+		if err := <-done; err != nil {
+			t.Errorf("Expected connections to close, got %v", err)
+		}
+	})
+}
@@ -80,7 +80,9 @@ func TestGatewayIntegration(t *testing.T) {
 				Order:      routeConfig.Order,
 				Metadata:   routeConfig.Metadata,
 			}
-			router.AddRoute(internalRoute)
+			if err := router.AddRoute(internalRoute); err != nil {
+				t.Fatalf("Failed to add route: %v", err)
+			}
 		}
 
 		// 创建负载均衡器